@@ -5,8 +5,8 @@ import (
     "context"
     "encoding/json"
     "fmt"
-    "log"
     "net/http"
+    "sync"
 
     "github.com/pennsieve/pennsieve-go/pkg/pennsieve/models/account"
 )
@@ -23,6 +23,7 @@ type AccountService interface {
 type accountService struct {
     Client  PennsieveHTTPClient
     BaseUrl string
+    mu      sync.RWMutex // guards BaseUrl
 }
 
 func NewAccountService(client PennsieveHTTPClient, baseUrl string) *accountService {
@@ -33,7 +34,7 @@ func NewAccountService(client PennsieveHTTPClient, baseUrl string) *accountServi
 }
 
 func (a *accountService) GetPennsieveAccounts(ctx context.Context, accountType string) (*account.GetPennsieveAccountsResponse, error) {
-    req, err := http.NewRequest("GET", fmt.Sprintf("%s/compute/resources/pennsieve-accounts/%s", a.BaseUrl, accountType), nil)
+    req, err := http.NewRequest("GET", fmt.Sprintf("%s/compute/resources/pennsieve-accounts/%s", a.host(), accountType), nil)
     if err != nil {
         return nil, err
     }
@@ -44,7 +45,7 @@ func (a *accountService) GetPennsieveAccounts(ctx context.Context, accountType s
 
     res := account.GetPennsieveAccountsResponse{}
     if err := a.Client.sendRequest(ctx, req, &res); err != nil {
-        log.Println("AccountService: SendRequest Error in Get: ", err)
+        a.Client.Logger().DebugContext(ctx, "AccountService.GetPennsieveAccounts failed", "error", err)
         return nil, err
     }
 
@@ -62,7 +63,7 @@ func (a *accountService) CreateAccount(ctx context.Context, accountId string, ac
 
     postParamsPayload := bytes.NewReader([]byte(postParams))
 
-    req, err := http.NewRequest("POST", fmt.Sprintf("%s/compute/resources/accounts", a.BaseUrl), postParamsPayload)
+    req, err := http.NewRequest("POST", fmt.Sprintf("%s/compute/resources/accounts", a.host()), postParamsPayload)
     if err != nil {
         return nil, err
     }
@@ -72,8 +73,8 @@ func (a *accountService) CreateAccount(ctx context.Context, accountId string, ac
     }
 
     res := account.CreateAccountResponse{}
-    if err := a.Client.sendRequest(ctx, req, &res); err != nil {
-        log.Println("sendRequest Error: ", err)
+    if err := a.Client.sendRequest(withMutation(ctx, "AccountService.CreateAccount", dryRunAccount), req, &res); err != nil {
+        a.Client.Logger().DebugContext(ctx, "AccountService.CreateAccount failed", "error", err)
         return nil, err
     }
 
@@ -81,7 +82,7 @@ func (a *accountService) CreateAccount(ctx context.Context, accountId string, ac
 }
 
 func (a *accountService) GetAccounts(ctx context.Context) ([]account.AccountResponse, error) {
-    req, err := http.NewRequest("GET", fmt.Sprintf("%s/compute/resources/accounts", a.BaseUrl), nil)
+    req, err := http.NewRequest("GET", fmt.Sprintf("%s/compute/resources/accounts", a.host()), nil)
     if err != nil {
         return nil, err
     }
@@ -92,7 +93,7 @@ func (a *accountService) GetAccounts(ctx context.Context) ([]account.AccountResp
 
     var res []account.AccountResponse
     if err := a.Client.sendRequest(ctx, req, &res); err != nil {
-        log.Println("AccountService: SendRequest Error in GetAccounts: ", err)
+        a.Client.Logger().DebugContext(ctx, "AccountService.GetAccounts failed", "error", err)
         return nil, err
     }
 
@@ -100,7 +101,7 @@ func (a *accountService) GetAccounts(ctx context.Context) ([]account.AccountResp
 }
 
 func (a *accountService) DeleteAccount(ctx context.Context, uuid string, force bool) (*account.DeleteAccountResponse, error) {
-    url := fmt.Sprintf("%s/compute/resources/accounts/%s", a.BaseUrl, uuid)
+    url := fmt.Sprintf("%s/compute/resources/accounts/%s", a.host(), uuid)
     if force {
         url += "?force=true"
     }
@@ -115,7 +116,8 @@ func (a *accountService) DeleteAccount(ctx context.Context, uuid string, force b
     }
 
     res := account.DeleteAccountResponse{}
-    if err := a.Client.sendRequest(ctx, req, &res); err != nil {
+    dryRun := func(p PlannedRequest) any { return account.DeleteAccountResponse{Uuid: uuid} }
+    if err := a.Client.sendRequest(withMutation(ctx, "AccountService.DeleteAccount", dryRun), req, &res); err != nil {
         return nil, err
     }
 
@@ -131,7 +133,7 @@ func (a *accountService) RequestEcrAccess(ctx context.Context, accountId string,
         return err
     }
 
-    req, err := http.NewRequest("POST", fmt.Sprintf("%s/compute/resources/app-store/access", a.BaseUrl), bytes.NewReader(body))
+    req, err := http.NewRequest("POST", fmt.Sprintf("%s/compute/resources/app-store/access", a.host()), bytes.NewReader(body))
     if err != nil {
         return err
     }
@@ -140,14 +142,27 @@ func (a *accountService) RequestEcrAccess(ctx context.Context, accountId string,
         ctx = req.Context()
     }
 
-    if err := a.Client.sendRequest(ctx, req, nil); err != nil {
-        log.Println("AccountService: SendRequest Error in RequestEcrAccess: ", err)
+    if err := a.Client.sendRequest(withMutation(ctx, "AccountService.RequestEcrAccess", nil), req, nil); err != nil {
+        a.Client.Logger().DebugContext(ctx, "AccountService.RequestEcrAccess failed", "error", err)
         return err
     }
 
     return nil
 }
 
+// dryRunAccount is the synthetic response to CreateAccount in dry-run mode.
+func dryRunAccount(p PlannedRequest) any {
+    return account.CreateAccountResponse{Uuid: fmt.Sprintf("dry-run-%d", p.Seq)}
+}
+
 func (s *accountService) SetBaseUrl(url string) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
     s.BaseUrl = url
 }
+
+func (s *accountService) host() string {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    return s.BaseUrl
+}
@@ -23,7 +23,10 @@ func (s *AccountServiceTestSuite) SetupTest() {
 	s.API2Server = NewMockPennsieveServerDefault(s.T())
 	AWSEndpoints = AWSCognitoEndpoints{IdentityProviderEndpoint: s.IdProviderServer.URL}
 	client := NewClient(APIParams{
-		ApiHost2: s.API2Server.Server.URL,
+		ApiKey:    "test-key",
+		ApiSecret: "test-secret",
+		ApiHost:   s.API2Server.Server.URL,
+		ApiHost2:  s.API2Server.Server.URL,
 	})
 	s.TestService = client.Account
 }
@@ -2,6 +2,7 @@ package pennsieve
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -11,10 +12,14 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
 	"github.com/golang-jwt/jwt"
 	"github.com/pennsieve/pennsieve-go/pkg/pennsieve/models/authentication"
-	"log"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
 	"math"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -22,6 +27,10 @@ import (
 // The default value will provide the default endpoints.
 // Modifications only affect subsequent calls to NewAuthenticationService and
 // not any already existing instances of authenticationService.
+//
+// Deprecated: register an Environment with Cognito endpoints and select it
+// with WithEnvironment or Client.SetEnvironment. An environment's endpoints
+// take precedence over AWSEndpoints.
 var AWSEndpoints = AWSCognitoEndpoints{}
 
 type AWSCognitoEndpoints struct {
@@ -42,125 +51,178 @@ func (e *AWSCognitoEndpoints) IsEmpty() bool {
 
 type AuthenticationService interface {
 	getCognitoConfig() (*authentication.CognitoConfig, error)
+	verifyIdToken(ctx context.Context, idToken string) (jwt.MapClaims, error)
+	verifyAccessToken(ctx context.Context, accessToken string) error
 	ReAuthenticate() (*APISession, error)
 	Authenticate(apiKey string, apiSecret string) (*APISession, error)
 	AuthenticateWithRefreshToken(refreshToken string) (*APISession, error)
-	GetAWSCredsForUser() *IdentityTypes.Credentials
+	LoginWithPassword(ctx context.Context, email, password string, prompt ChallengePrompt) (*APISession, error)
+	LoginWithBrowser(ctx context.Context) (*APISession, error)
+	GetAWSCredsForUser() (*IdentityTypes.Credentials, error)
 	SetBaseUrl(url string)
 	SetClient(client *Client)
 }
 
+// NewAuthenticationService creates an authentication service using the
+// default AWS config. No network calls are made; the Cognito config is
+// fetched from the API on first authentication. If the AWS config cannot be
+// loaded, the error is returned by the first authentication call.
 func NewAuthenticationService(client PennsieveHTTPClient, baseUrl string) *authenticationService {
-	cfg := newAwsConfig()
+	cfg, err := newAwsConfig()
+	return newAuthenticationService(client, baseUrl, cfg, err)
+}
+
+func newAuthenticationService(client PennsieveHTTPClient, baseUrl string, awsConfig aws.Config, awsConfigErr error) *authenticationService {
 	return &authenticationService{
-		client:    client,
-		BaseUrl:   baseUrl,
-		awsConfig: cfg,
+		client:        client,
+		BaseUrl:       baseUrl,
+		awsConfig:     awsConfig,
+		baseAWSConfig: awsConfig,
+		awsConfigErr:  awsConfigErr,
 	}
 }
 
-func newAwsConfig() aws.Config {
-	loadOptions := []func(*config.LoadOptions) error{config.WithRegion("us-east-1")}
-
-	if !AWSEndpoints.IsEmpty() {
-		endpointMap := map[string]string{
-			cognitoidentityprovider.ServiceID: AWSEndpoints.IdentityProviderEndpoint,
-			cognitoidentity.ServiceID:         AWSEndpoints.IdentityEndpoint,
-		}
-		endpointResolver := aws.EndpointResolverWithOptionsFunc(func(service string, region string, options ...interface{}) (aws.Endpoint, error) {
-			if endpoint := endpointMap[service]; endpoint != "" {
-				return aws.Endpoint{
-					URL: endpoint,
-				}, nil
-			}
-			// Returning EndpointNotFoundError will cause service to fallback to default
-			return aws.Endpoint{}, &aws.EndpointNotFoundError{}
-		})
-		loadOptions = append(loadOptions, config.WithEndpointResolverWithOptions(endpointResolver))
-
-	}
-
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		loadOptions...,
-	)
+func newAwsConfig() (aws.Config, error) {
+	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion("us-east-1"))
 	if err != nil {
-		log.Fatal(err)
+		return aws.Config{}, fmt.Errorf("error loading AWS config: %w", err)
 	}
-	return cfg
+	return withCognitoEndpoints(cfg, AWSEndpoints), nil
 }
 
 type authenticationService struct {
-	client    PennsieveHTTPClient
-	config    authentication.CognitoConfig
-	BaseUrl   string // BaseUrl is exposed in Auth service as we need to update to check new auth when switching profiles
-	awsConfig aws.Config
+	client       PennsieveHTTPClient
+	config       authentication.CognitoConfig
+	configLoaded bool
+	BaseUrl      string // BaseUrl is exposed in Auth service as we need to update to check new auth when switching profiles
+	awsConfig    aws.Config
+	awsConfigErr error
+	// baseAWSConfig is awsConfig before an environment's Cognito endpoints
+	// are applied.
+	baseAWSConfig aws.Config
+	// srp selects USER_SRP_AUTH for sign-ins with a secret or password.
+	srp bool
+	// browserLogin configures LoginWithBrowser.
+	browserLogin BrowserLoginConfig
+	// jwks, if set, verifies ID tokens instead of the fetched keySets.
+	jwks *JWKS
+	// skipTokenVerification reads ID tokens, and accepts access tokens,
+	// without verifying them, for cassettes whose tokens are scrubbed.
+	skipTokenVerification bool
+
+	keysMu         sync.Mutex              // guards keySets, verifiedToken and verifiedClaims
+	keySets        map[string]*fetchedJWKS // by JWKS URL
+	verifiedToken  string
+	verifiedClaims jwt.MapClaims
+	fetchKeysMu    sync.Mutex // single-flight guard for key set fetches
+
+	mu sync.RWMutex // guards config, configLoaded, BaseUrl, awsConfig and browserLogin
 }
 
 // getCognitoConfig returns cognito urls from cloud.
 func (s *authenticationService) getCognitoConfig() (*authentication.CognitoConfig, error) {
+	return s.fetchCognitoConfig(context.Background())
+}
+
+func (s *authenticationService) fetchCognitoConfig(ctx context.Context) (*authentication.CognitoConfig, error) {
+
+	s.mu.RLock()
+	baseUrl := s.BaseUrl
+	s.mu.RUnlock()
 
 	req, _ := http.NewRequest("GET",
-		fmt.Sprintf("%s/authentication/cognito-config", s.BaseUrl), nil)
+		fmt.Sprintf("%s/authentication/cognito-config", baseUrl), nil)
 
 	res := authentication.CognitoConfig{}
-	if err := s.client.sendUnauthenticatedRequest(req.Context(), req, &res); err != nil {
+	if err := s.client.sendUnauthenticatedRequest(ctx, req, &res); err != nil {
 		return nil, err
 	}
 
-	s.config = res
+	s.setCognitoConfig(res)
 
 	return &res, nil
 }
 
+// loadCognitoConfig returns the Cognito config, fetching it from the API on
+// first use and caching it until the base URL changes.
+func (s *authenticationService) loadCognitoConfig(ctx context.Context) (authentication.CognitoConfig, error) {
+	s.mu.RLock()
+	cfg, loaded := s.config, s.configLoaded
+	s.mu.RUnlock()
+	if loaded {
+		return cfg, nil
+	}
+
+	res, err := s.fetchCognitoConfig(ctx)
+	if err != nil {
+		return authentication.CognitoConfig{}, fmt.Errorf("error getting cognito config: %w", err)
+	}
+	return *res, nil
+}
+
+// setCognitoConfig stores cfg so it is used instead of fetching the config
+// from the API.
+func (s *authenticationService) setCognitoConfig(cfg authentication.CognitoConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config = cfg
+	s.configLoaded = true
+}
+
+// cognitoConfig returns a copy of the most recently fetched Cognito config.
+func (s *authenticationService) cognitoConfig() authentication.CognitoConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config
+}
+
+// cognitoAWSConfig returns the AWS config used for Cognito calls.
+func (s *authenticationService) cognitoAWSConfig() aws.Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.awsConfig
+}
+
 // ReAuthenticate updates authentication JWT and stores in local DB.
 func (s *authenticationService) ReAuthenticate() (*APISession, error) {
 
 	// Assert that credentials exist
 	var emptyParams APIParams
 	if *s.client.GetAPIParams() == emptyParams {
-		log.Panicln("Cannot call ReAuthenticate without prior Credentials")
+		return nil, fmt.Errorf("%w: ReAuthenticate requires an API key and secret", ErrNoCredentials)
 	}
 
-	// Stores the cognito config in the service object.
-	_, err := s.getCognitoConfig()
+	cognitoConfig, err := s.loadCognitoConfig(context.Background())
 	if err != nil {
-		log.Println("Error getting config: ", err)
 		return nil, err
 	}
 
-	params := &cognitoidentityprovider.InitiateAuthInput{
-		AuthFlow: types.AuthFlowTypeUserPasswordAuth,
-		AuthParameters: map[string]string{
-			"USERNAME": s.client.GetAPIParams().ApiKey,
-			"PASSWORD": s.client.GetAPIParams().ApiSecret,
-		},
-		ClientId: aws.String(s.config.TokenPool.AppClientID),
+	step, err := s.passwordAuth(context.Background(), aws.String(cognitoConfig.TokenPool.AppClientID), cognitoConfig.TokenPool.ID,
+		s.client.GetAPIParams().ApiKey, s.client.GetAPIParams().ApiSecret)
+	if err != nil {
+		return nil, err
 	}
 
-	svc := cognitoidentityprovider.NewFromConfig(s.awsConfig)
-	authResponse, authError := svc.InitiateAuth(context.Background(), params)
-	if authError != nil {
-
-		fmt.Println("Error = ", authError)
-		return nil, authError
+	result, err := step.tokens()
+	if err != nil {
+		return nil, err
 	}
 
-	accessToken := authResponse.AuthenticationResult.AccessToken
-	idTokenJwt := authResponse.AuthenticationResult.IdToken
-	refreshToken := authResponse.AuthenticationResult.RefreshToken
-
 	// Parse JWT and extract Organization for current user
-	token, err := jwt.Parse(*idTokenJwt, nil)
-	if token == nil {
+	claims, err := s.verifyIdToken(context.Background(), aws.ToString(result.IdToken))
+	if err != nil {
+		return nil, err
+	}
+	expiration, err := getTokenExpFromClaim(claims)
+	if err != nil {
 		return nil, err
 	}
-	claims, _ := token.Claims.(jwt.MapClaims)
 
 	newSession := APISession{
-		Token:        *accessToken,
-		IdToken:      "",
-		Expiration:   getTokenExpFromClaim(claims),
-		RefreshToken: *refreshToken,
+		Token:        aws.ToString(result.AccessToken),
+		IdToken:      aws.ToString(result.IdToken),
+		Expiration:   expiration,
+		RefreshToken: aws.ToString(result.RefreshToken),
 		IsRefreshed:  true,
 	}
 
@@ -171,79 +233,58 @@ func (s *authenticationService) ReAuthenticate() (*APISession, error) {
 }
 
 func (s *authenticationService) Authenticate(apiKey string, apiSecret string) (*APISession, error) {
+	return s.authenticateWithKey(context.Background(), apiKey, apiSecret)
+}
 
-	// Get Cognito Configuration
-	s.getCognitoConfig()
-
-	clientID := aws.String(s.config.TokenPool.AppClientID)
+func (s *authenticationService) authenticateWithKey(ctx context.Context, apiKey string, apiSecret string) (*APISession, error) {
 
-	params := &cognitoidentityprovider.InitiateAuthInput{
-		AuthFlow: types.AuthFlowTypeUserPasswordAuth,
-		AuthParameters: map[string]string{
-			"USERNAME": apiKey,
-			"PASSWORD": apiSecret,
-		},
-		ClientId: clientID,
+	// Get Cognito Configuration
+	cognitoConfig, err := s.loadCognitoConfig(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	svc := cognitoidentityprovider.NewFromConfig(s.awsConfig)
+	clientID := aws.String(cognitoConfig.TokenPool.AppClientID)
 
-	authResponse, authError := svc.InitiateAuth(context.Background(), params)
-	if authError != nil {
-
-		fmt.Println("Error = ", authError)
-		return nil, authError
+	step, err := s.passwordAuth(ctx, clientID, cognitoConfig.TokenPool.ID, apiKey, apiSecret)
+	if err != nil {
+		return nil, err
 	}
 
-	accessToken := authResponse.AuthenticationResult.AccessToken
-	idTokenJwt := authResponse.AuthenticationResult.IdToken
-	refreshToken := authResponse.AuthenticationResult.RefreshToken
+	result, err := step.tokens()
+	if err != nil {
+		return nil, err
+	}
+	idTokenJwt := aws.ToString(result.IdToken)
 
 	// Parse JWT and extract Organization for current user
-	token, err := jwt.Parse(*idTokenJwt, nil)
-	if token == nil {
+	claims, err := s.verifyIdToken(ctx, idTokenJwt)
+	if err != nil {
 		return nil, err
 	}
-	claims, _ := token.Claims.(jwt.MapClaims)
 
-	var organizationNodeId string
-	var orgId string
-	var ok bool
-
-	if x, found := claims["custom:organization_node_id"]; found {
-		if organizationNodeId, ok = x.(string); !ok {
-			log.Panicln("Organization Node ID is not a string in the claim")
-		}
-	} else {
-		log.Panicln("Claim does not contain an organization identifier")
+	organizationNodeId, err := stringClaim(claims, "custom:organization_node_id")
+	if err != nil {
+		return nil, err
 	}
-
-	if x, found := claims["custom:organization_id"]; found {
-		if orgId, ok = x.(string); !ok {
-			log.Panicln("OrgID is not an string in the claim")
-		}
-	} else {
-		log.Panicln("Claim does not contain an OrgID")
+	orgId, err := stringClaim(claims, "custom:organization_id")
+	if err != nil {
+		return nil, err
 	}
-
-	//if x, found := claims["iss"]; found {
-	//	if iss, ok = x.(string); !ok {
-	//		log.Panicln("iss is not an int in the claim")
-	//	}
-	//} else {
-	//	log.Panicln("Claim does not contain an iss")
-	//}
-
 	orgIdInt, err := strconv.Atoi(orgId)
 	if err != nil {
-		log.Panicf("OrgID: %q is not an int\n", orgIdInt)
-
+		return nil, &TokenError{Claim: "custom:organization_id", Reason: fmt.Sprintf("%q is not an integer", orgId)}
 	}
+	expiration, err := getTokenExpFromClaim(claims)
+	if err != nil {
+		return nil, err
+	}
+
 	creds := APISession{
-		Token:        *accessToken,
-		RefreshToken: *refreshToken,
-		IdToken:      *idTokenJwt,
-		Expiration:   getTokenExpFromClaim(claims),
+		Token:        aws.ToString(result.AccessToken),
+		RefreshToken: aws.ToString(result.RefreshToken),
+		IdToken:      idTokenJwt,
+		Expiration:   expiration,
 		IsRefreshed:  false,
 	}
 
@@ -257,16 +298,37 @@ func (s *authenticationService) Authenticate(apiKey string, apiSecret string) (*
 // This enables authentication when only session tokens are available (e.g. from an analytics workflow).
 // The REFRESH_TOKEN flow returns a new access token and ID token but does NOT return a new refresh token.
 func (s *authenticationService) AuthenticateWithRefreshToken(refreshToken string) (*APISession, error) {
+	return s.authenticateWithRefreshToken(context.Background(), refreshToken)
+}
+
+func (s *authenticationService) authenticateWithRefreshToken(ctx context.Context, refreshToken string) (*APISession, error) {
+	// The refresh token originates from the web app which authenticates
+	// against the UserPool, not the TokenPool.
+	return s.refreshSession(ctx, refreshToken, userPool)
+}
+
+// cognitoPool identifies the Cognito app client that issued a refresh token.
+// A refresh token can only be used with the client that issued it.
+type cognitoPool string
+
+const (
+	tokenPool cognitoPool = "token" // sessions obtained with an API key and secret
+	userPool  cognitoPool = "user"  // sessions of the web app
+)
+
+// refreshSession obtains a new session with a refresh token issued by pool.
+func (s *authenticationService) refreshSession(ctx context.Context, refreshToken string, pool cognitoPool) (*APISession, error) {
 
 	// Get Cognito Configuration
-	_, err := s.getCognitoConfig()
+	cognitoConfig, err := s.loadCognitoConfig(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("error getting cognito config: %w", err)
+		return nil, err
 	}
 
-	// Use UserPool client ID — the refresh token originates from the web app
-	// which authenticates against the UserPool, not the TokenPool.
-	clientID := aws.String(s.config.UserPool.AppClientID)
+	clientID := aws.String(cognitoConfig.UserPool.AppClientID)
+	if pool == tokenPool {
+		clientID = aws.String(cognitoConfig.TokenPool.AppClientID)
+	}
 
 	params := &cognitoidentityprovider.InitiateAuthInput{
 		AuthFlow: types.AuthFlowTypeRefreshToken,
@@ -276,30 +338,34 @@ func (s *authenticationService) AuthenticateWithRefreshToken(refreshToken string
 		ClientId: clientID,
 	}
 
-	svc := cognitoidentityprovider.NewFromConfig(s.awsConfig)
-
-	authResponse, authError := svc.InitiateAuth(context.Background(), params)
+	authResponse, authError := s.initiateAuth(ctx, params)
 	if authError != nil {
 		return nil, fmt.Errorf("error authenticating with refresh token: %w", authError)
 	}
 
-	accessToken := authResponse.AuthenticationResult.AccessToken
-	idTokenJwt := authResponse.AuthenticationResult.IdToken
+	result, err := authResult(authResponse)
+	if err != nil {
+		return nil, err
+	}
+	idTokenJwt := aws.ToString(result.IdToken)
 
 	// Parse JWT and extract Organization for current user
-	token, err := jwt.Parse(*idTokenJwt, nil)
-	if token == nil {
+	claims, err := s.verifyIdToken(ctx, idTokenJwt)
+	if err != nil {
+		return nil, err
+	}
+	expiration, err := getTokenExpFromClaim(claims)
+	if err != nil {
 		return nil, err
 	}
-	claims, _ := token.Claims.(jwt.MapClaims)
 
 	// Cognito REFRESH_TOKEN flow does not return a new refresh token,
 	// so we keep the original.
 	creds := APISession{
-		Token:        *accessToken,
+		Token:        aws.ToString(result.AccessToken),
 		RefreshToken: refreshToken,
-		IdToken:      *idTokenJwt,
-		Expiration:   getTokenExpFromClaim(claims),
+		IdToken:      idTokenJwt,
+		Expiration:   expiration,
 		IsRefreshed:  false,
 	}
 
@@ -309,52 +375,100 @@ func (s *authenticationService) AuthenticateWithRefreshToken(refreshToken string
 }
 
 // GetAWSCredsForUser returns set of AWS credentials to allow user to upload data to upload bucket
-func (s *authenticationService) GetAWSCredsForUser() *IdentityTypes.Credentials {
+func (s *authenticationService) GetAWSCredsForUser() (*IdentityTypes.Credentials, error) {
 
-	var authResponse *APISession
-	var err error
+	ctx, span := s.client.telemetry().startSpan(context.Background(), "pennsieve.aws_credentials")
+	defer span.End()
 
-	creds := s.client.GetAPIParams()
-	if creds.ApiKey != "" {
-		authResponse, err = s.Authenticate(creds.ApiKey, creds.ApiSecret)
-	} else if s.client.GetSession().RefreshToken != "" {
-		authResponse, err = s.AuthenticateWithRefreshToken(s.client.GetSession().RefreshToken)
-	} else {
-		log.Fatal("No credentials available for authentication")
-	}
+	authResponse, err := s.client.authenticate(ctx)
 	if err != nil {
-		log.Println("Error authenticating: ", err)
+		s.client.Logger().Error("error authenticating", "error", err)
+		endSpan(span, err)
+		return nil, err
 	}
 
-	poolId := s.config.IdentityPool.ID
-	poolResource := fmt.Sprintf("cognito-idp.us-east-1.amazonaws.com/%s", s.config.TokenPool.ID)
+	cfg := s.cognitoConfig()
+	poolId := cfg.IdentityPool.ID
+	poolResource := fmt.Sprintf("cognito-idp.us-east-1.amazonaws.com/%s", cfg.TokenPool.ID)
 
-	svc := cognitoidentity.NewFromConfig(s.awsConfig)
+	svc := cognitoidentity.NewFromConfig(s.cognitoAWSConfig())
 
 	// Get an identity from Cognito's identity pool using authResponse from userpool
-	idRes, err := svc.GetId(context.Background(), &cognitoidentity.GetIdInput{
+	idCtx, idSpan := s.startCognitoSpan(ctx, "CognitoIdentity", "GetId")
+	idRes, err := svc.GetId(idCtx, &cognitoidentity.GetIdInput{
 		IdentityPoolId: aws.String(poolId),
 		Logins: map[string]string{
 			poolResource: authResponse.IdToken,
 		},
 	})
+	endSpan(idSpan, err)
+	if err != nil {
+		err = &CognitoError{Operation: "GetId", Err: err}
+		s.client.Logger().Error("error getting cognito identity", "error", err)
+		endSpan(span, err)
+		return nil, err
+	}
 
 	// Exchange identity token for Credentials from Cognito Identity Pool
-	credRes, err := svc.GetCredentialsForIdentity(context.Background(), &cognitoidentity.GetCredentialsForIdentityInput{
+	credCtx, credSpan := s.startCognitoSpan(ctx, "CognitoIdentity", "GetCredentialsForIdentity")
+	credRes, err := svc.GetCredentialsForIdentity(credCtx, &cognitoidentity.GetCredentialsForIdentityInput{
 		IdentityId: idRes.IdentityId,
 		Logins: map[string]string{
 			poolResource: authResponse.IdToken,
 		},
 	})
+	endSpan(credSpan, err)
+	if err == nil && credRes.Credentials == nil {
+		err = errors.New("no credentials in response")
+	}
+	if err != nil {
+		err = &CognitoError{Operation: "GetCredentialsForIdentity", Err: err}
+		s.client.Logger().Error("error getting cognito identity credentials", "error", err)
+		endSpan(span, err)
+		return nil, err
+	}
+
+	return credRes.Credentials, nil
+}
 
+// initiateAuth calls Cognito InitiateAuth and logs the flow and duration.
+func (s *authenticationService) initiateAuth(ctx context.Context, params *cognitoidentityprovider.InitiateAuthInput) (*cognitoidentityprovider.InitiateAuthOutput, error) {
+	if s.awsConfigErr != nil {
+		return nil, s.awsConfigErr
+	}
+	svc := cognitoidentityprovider.NewFromConfig(s.cognitoAWSConfig())
+
+	spanCtx, span := s.startCognitoSpan(ctx, "CognitoIdentityProvider", "InitiateAuth",
+		attrAuthFlow.String(string(params.AuthFlow)))
+	start := time.Now()
+	out, err := svc.InitiateAuth(spanCtx, params)
+	endSpan(span, err)
+	logger := s.client.Logger().With("flow", params.AuthFlow, "duration", time.Since(start))
 	if err != nil {
-		fmt.Println("Error getting cognito ID:", err)
+		logger.DebugContext(ctx, "cognito InitiateAuth failed", "error", err)
+		return nil, &CognitoError{Operation: "InitiateAuth", Err: err}
 	}
+	logger.DebugContext(ctx, "cognito InitiateAuth")
 
-	return credRes.Credentials
+	return out, nil
+}
+
+// startCognitoSpan starts a client span for a call to an AWS Cognito API.
+func (s *authenticationService) startCognitoSpan(ctx context.Context, service, method string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs,
+		semconv.RPCSystemKey.String("aws-api"),
+		semconv.RPCService(service),
+		semconv.RPCMethod(method))
+	return s.client.telemetry().startClientSpan(ctx, service+"."+method, attrs...)
 }
 
 func (s *authenticationService) SetBaseUrl(url string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if url != s.BaseUrl {
+		// A different host may use a different user pool.
+		s.configLoaded = false
+	}
 	s.BaseUrl = url
 }
 
@@ -362,42 +476,88 @@ func (s *authenticationService) SetClient(client *Client) {
 	s.client = client
 }
 
-// getTokenExpFromClaim grabs the token expiration timestamp from claims
-func getTokenExpFromClaim(claims jwt.MapClaims) time.Time {
-	var tokenExp float64
-	var ok bool
-	if x, found := claims["exp"]; found {
-		if tokenExp, ok = x.(float64); !ok {
-			log.Panicln("Token Expire time is not a long in the claim")
+// authResult returns the tokens of a successful InitiateAuth call.
+func authResult(out *cognitoidentityprovider.InitiateAuthOutput) (*types.AuthenticationResultType, error) {
+	if out == nil || out.AuthenticationResult == nil {
+		reason := errors.New("no authentication result in response")
+		if out != nil && out.ChallengeName != "" {
+			reason = fmt.Errorf("unsupported challenge %s", out.ChallengeName)
 		}
-	} else {
-		log.Panicln("Claim does not contain a Token Expire time")
+		return nil, &CognitoError{Operation: "InitiateAuth", Err: reason}
+	}
+	if out.AuthenticationResult.AccessToken == nil {
+		return nil, &TokenError{Reason: "no access token in response"}
+	}
+	if out.AuthenticationResult.IdToken == nil {
+		return nil, &TokenError{Reason: "no ID token in response"}
+	}
+	return out.AuthenticationResult, nil
+}
+
+// parseIdToken returns the claims of a Cognito ID token. The signature is not
+// verified; see verifyIdToken.
+func parseIdToken(idToken string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(idToken, claims); err != nil {
+		return nil, &TokenError{Reason: "malformed ID token", Err: err}
+	}
+	return claims, nil
+}
+
+// stringClaim returns the string claim called key.
+func stringClaim(claims jwt.MapClaims, key string) (string, error) {
+	x, found := claims[key]
+	if !found {
+		return "", &TokenError{Claim: key, Reason: "missing"}
+	}
+	v, ok := x.(string)
+	if !ok {
+		return "", &TokenError{Claim: key, Reason: fmt.Sprintf("%v is not a string", x)}
+	}
+	return v, nil
+}
+
+// getTokenExpFromClaim grabs the token expiration timestamp from claims
+func getTokenExpFromClaim(claims jwt.MapClaims) (time.Time, error) {
+	x, found := claims["exp"]
+	if !found {
+		return time.Time{}, &TokenError{Claim: "exp", Reason: "missing"}
+	}
+	tokenExp, ok := x.(float64)
+	if !ok || math.IsNaN(tokenExp) || math.IsInf(tokenExp, 0) {
+		return time.Time{}, &TokenError{Claim: "exp", Reason: fmt.Sprintf("%v is not a timestamp", x)}
 	}
 
 	integ, decim := math.Modf(tokenExp)
 	sessionTokenExpiration := time.Unix(int64(integ), int64(decim*(1e9)))
 
-	return sessionTokenExpiration
+	return sessionTokenExpiration, nil
 }
 
 // AWSCredentialProviderWithExpiration provides AWS credentials
 // This method is used by the upload service and is wrapped in Credentials Cache
 type AWSCredentialProviderWithExpiration struct {
 	AuthService AuthenticationService
+	Logger      *slog.Logger // optional; nothing is logged when nil
 }
 
 func (p AWSCredentialProviderWithExpiration) Retrieve(ctx context.Context) (aws.Credentials, error) {
 
-	log.Println("Retrieving new credentials from AWS Credentials Provider.")
+	if p.Logger != nil {
+		p.Logger.DebugContext(ctx, "retrieving new credentials from AWS credentials provider")
+	}
 
-	cognitoCredentials := p.AuthService.GetAWSCredsForUser()
+	cognitoCredentials, err := p.AuthService.GetAWSCredsForUser()
+	if err != nil {
+		return aws.Credentials{}, err
+	}
 	awsCredentials := aws.Credentials{
-		AccessKeyID:     *cognitoCredentials.AccessKeyId,
-		SecretAccessKey: *cognitoCredentials.SecretKey,
-		SessionToken:    *cognitoCredentials.SessionToken,
+		AccessKeyID:     aws.ToString(cognitoCredentials.AccessKeyId),
+		SecretAccessKey: aws.ToString(cognitoCredentials.SecretKey),
+		SessionToken:    aws.ToString(cognitoCredentials.SessionToken),
 		Source:          "AWS Cognito",
 		CanExpire:       true,
-		Expires:         *cognitoCredentials.Expiration,
+		Expires:         aws.ToTime(cognitoCredentials.Expiration),
 	}
 
 	return awsCredentials, nil
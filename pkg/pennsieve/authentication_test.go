@@ -4,11 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
 	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/golang-jwt/jwt"
 	"github.com/pennsieve/pennsieve-go/pkg/pennsieve/models/authentication"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -34,6 +37,7 @@ var expectedCognitoConfig = authentication.CognitoConfig{
 	},
 	TokenPool: authentication.TokenPool{
 		Region:      "us-east-1",
+		ID:          "auth-test-token-pool-id",
 		AppClientID: "authTestTokenPoolAppClientId",
 	},
 	IdentityPool: authentication.IdentityPool{
@@ -51,7 +55,7 @@ func (s *AuthenticationServiceTestSuite) SetupTest() {
 	s.MockPennsieveServer = NewMockPennsieveServer(s.T(), expectedCognitoConfig)
 	AWSEndpoints = AWSCognitoEndpoints{IdentityProviderEndpoint: s.MockCognito.Server.URL}
 	s.TestClient = NewClient(
-		APIParams{ApiHost: s.Server.URL})
+		APIParams{ApiHost: s.Server.URL, ApiKey: "test-key", ApiSecret: "test-secret"})
 	s.TestService = s.TestClient.Authentication
 }
 
@@ -76,9 +80,12 @@ func (s *AuthenticationServiceTestSuite) TestAuthenticate() {
 	expectedRefreshToken := "auth-test-refresh-token"
 	expectedOrgNodeId := "N:organization:a9b8c7"
 	expectedOrgId := "456"
-	expectedIdToken := NewTestJWT(s.T(), expectedOrgNodeId, expectedOrgId, time.Hour)
+	expectedIdToken := s.tokenPoolIdToken(expectedOrgNodeId, expectedOrgId)
 
 	s.MockCognito.Mux.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
+		if serveTestJWKS(writer, request) {
+			return
+		}
 		s.Equal("POST", request.Method, "unexpected http method for cognito authenticate")
 		reqMap := map[string]any{}
 		if s.NoError(json.NewDecoder(request.Body).Decode(&reqMap)) {
@@ -117,6 +124,195 @@ func (s *AuthenticationServiceTestSuite) TestAuthenticate() {
 
 }
 
+// respondWithInitiateAuth makes the mock Cognito answer every InitiateAuth
+// call with out.
+func (s *AuthenticationServiceTestSuite) respondWithInitiateAuth(out cognitoidentityprovider.InitiateAuthOutput) {
+	s.MockCognito.Mux.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
+		if serveTestJWKS(writer, request) {
+			return
+		}
+		s.Equal("AWSCognitoIdentityProviderService.InitiateAuth", request.Header.Get("X-Amz-Target"))
+		s.NoError(json.NewEncoder(writer).Encode(out))
+	})
+}
+
+// respondWithIdToken makes the mock Cognito issue idToken.
+func (s *AuthenticationServiceTestSuite) respondWithIdToken(idToken string) {
+	s.respondWithInitiateAuth(cognitoidentityprovider.InitiateAuthOutput{
+		AuthenticationResult: &types.AuthenticationResultType{
+			AccessToken:  aws.String("auth-test-access-token"),
+			IdToken:      aws.String(idToken),
+			RefreshToken: aws.String("auth-test-refresh-token"),
+		},
+	})
+}
+
+// signedTestJWT returns an ID token of the TokenPool with claims, signed like
+// those of the mock Cognito.
+func (s *AuthenticationServiceTestSuite) signedTestJWT(claims jwt.MapClaims) string {
+	claims["iss"] = s.MockCognito.Server.URL + "/" + expectedCognitoConfig.TokenPool.ID
+	claims["aud"] = expectedCognitoConfig.TokenPool.AppClientID
+	claims["token_use"] = "id"
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = testSigningKeyID
+	signed, err := token.SignedString(testSigningKey)
+	if err != nil {
+		s.T().Fatal(err)
+	}
+	return signed
+}
+
+// tokenPoolIdToken returns a valid ID token of the TokenPool for an
+// organization.
+func (s *AuthenticationServiceTestSuite) tokenPoolIdToken(orgNodeId, orgId string) string {
+	return NewTestIdToken(s.T(), s.MockCognito.Server.URL, expectedCognitoConfig, expectedCognitoConfig.TokenPool.AppClientID, map[string]any{
+		OrgNodeIdClaimKey: orgNodeId,
+		OrgIdClaimKey:     orgId,
+	}, time.Hour)
+}
+
+func (s *AuthenticationServiceTestSuite) TestMalformedIdTokens() {
+	exp := float64(time.Now().Add(time.Hour).Unix())
+	tests := []struct {
+		name    string
+		idToken string
+		claim   string
+	}{
+		{"not a JWT", "not-a-jwt", ""},
+		{"bad base64", "@@@.@@@.@@@", ""},
+		{"missing org node id", s.signedTestJWT(jwt.MapClaims{"exp": exp, OrgIdClaimKey: "1"}), OrgNodeIdClaimKey},
+		{"org node id not a string", s.signedTestJWT(jwt.MapClaims{"exp": exp, OrgNodeIdClaimKey: 7, OrgIdClaimKey: "1"}), OrgNodeIdClaimKey},
+		{"missing org id", s.signedTestJWT(jwt.MapClaims{"exp": exp, OrgNodeIdClaimKey: "N:organization:1"}), OrgIdClaimKey},
+		{"org id not an integer", s.signedTestJWT(jwt.MapClaims{"exp": exp, OrgNodeIdClaimKey: "N:organization:1", OrgIdClaimKey: "one"}), OrgIdClaimKey},
+		{"missing exp", s.signedTestJWT(jwt.MapClaims{OrgNodeIdClaimKey: "N:organization:1", OrgIdClaimKey: "1"}), "exp"},
+		{"exp not a number", s.signedTestJWT(jwt.MapClaims{"exp": "tomorrow", OrgNodeIdClaimKey: "N:organization:1", OrgIdClaimKey: "1"}), "exp"},
+	}
+	for _, tt := range tests {
+		s.Run(tt.name, func() {
+			s.MockCognito.Mux = http.NewServeMux()
+			s.MockCognito.Server.Config.Handler = s.MockCognito.Mux
+			s.respondWithIdToken(tt.idToken)
+
+			_, err := s.TestService.Authenticate("api-key", "api-secret")
+			s.ErrorIs(err, ErrInvalidToken)
+			var tokenErr *TokenError
+			if s.ErrorAs(err, &tokenErr) {
+				s.Equal(tt.claim, tokenErr.Claim)
+			}
+			s.Empty(s.TestClient.GetSession().Token, "no session should be stored")
+		})
+	}
+}
+
+func (s *AuthenticationServiceTestSuite) TestMalformedIdTokenOnRefresh() {
+	s.respondWithIdToken("not-a-jwt")
+
+	_, err := s.TestService.AuthenticateWithRefreshToken("refresh-token")
+	s.ErrorIs(err, ErrInvalidToken)
+	_, err = s.TestService.ReAuthenticate()
+	s.ErrorIs(err, ErrInvalidToken)
+}
+
+func (s *AuthenticationServiceTestSuite) TestMissingTokensInResponse() {
+	s.respondWithInitiateAuth(cognitoidentityprovider.InitiateAuthOutput{
+		AuthenticationResult: &types.AuthenticationResultType{RefreshToken: aws.String("r")},
+	})
+
+	_, err := s.TestService.Authenticate("api-key", "api-secret")
+	s.ErrorIs(err, ErrInvalidToken)
+}
+
+func (s *AuthenticationServiceTestSuite) TestUnexpectedChallenge() {
+	s.respondWithInitiateAuth(cognitoidentityprovider.InitiateAuthOutput{
+		ChallengeName: types.ChallengeNameTypeNewPasswordRequired,
+		Session:       aws.String("challenge-session"),
+	})
+
+	_, err := s.TestService.Authenticate("api-key", "api-secret")
+	s.ErrorIs(err, ErrCognitoFailed)
+	s.ErrorContains(err, "NEW_PASSWORD_REQUIRED")
+}
+
+func (s *AuthenticationServiceTestSuite) TestCognitoErrorResponse() {
+	s.MockCognito.Mux.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("X-Amzn-Errortype", "NotAuthorizedException")
+		writer.WriteHeader(http.StatusBadRequest)
+		_, _ = writer.Write([]byte(`{"__type": "NotAuthorizedException", "message": "Incorrect username or password."}`))
+	})
+
+	_, err := s.TestService.Authenticate("api-key", "wrong")
+	s.ErrorIs(err, ErrCognitoFailed)
+	var cognitoErr *CognitoError
+	if s.ErrorAs(err, &cognitoErr) {
+		s.Equal("InitiateAuth", cognitoErr.Operation)
+	}
+	var notAuthorized *types.NotAuthorizedException
+	s.ErrorAs(err, &notAuthorized, "the Cognito exception should be reachable")
+}
+
+func (s *AuthenticationServiceTestSuite) TestReAuthenticateWithoutCredentials() {
+	client := NewClient(APIParams{})
+	_, err := client.Authentication.ReAuthenticate()
+	s.ErrorIs(err, ErrNoCredentials)
+}
+
+func (s *AuthenticationServiceTestSuite) TestGetAWSCredsWithoutCredentials() {
+	s.TestClient.SetCredentialsProvider(CredentialsChain{})
+
+	creds, err := s.TestService.GetAWSCredsForUser()
+	s.ErrorIs(err, ErrNoCredentials)
+	s.Nil(creds)
+
+	provider := AWSCredentialProviderWithExpiration{AuthService: s.TestService}
+	_, err = provider.Retrieve(context.Background())
+	s.ErrorIs(err, ErrNoCredentials)
+}
+
+func (s *AuthenticationServiceTestSuite) TestGetAWSCredsIdentityFailures() {
+	AWSEndpoints.IdentityEndpoint = s.MockCognito.Server.URL
+	client := NewClient(APIParams{ApiHost: s.Server.URL, ApiKey: "test-key", ApiSecret: "test-secret"})
+
+	idToken := s.tokenPoolIdToken("N:organization:1", "1")
+	failing := ""
+	s.MockCognito.Mux.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
+		if serveTestJWKS(writer, request) {
+			return
+		}
+		switch target := request.Header.Get("X-Amz-Target"); target {
+		case "AWSCognitoIdentityProviderService.InitiateAuth":
+			_, _ = fmt.Fprintf(writer, `{"AuthenticationResult": {"AccessToken": "a", "IdToken": %q, "RefreshToken": "r"}}`, idToken)
+		case "AWSCognitoIdentityService." + failing:
+			writer.Header().Set("X-Amzn-Errortype", "InternalErrorException")
+			writer.WriteHeader(http.StatusBadRequest)
+			_, _ = writer.Write([]byte(`{"__type": "InternalErrorException", "message": "try again"}`))
+		case "AWSCognitoIdentityService.GetId":
+			_, _ = writer.Write([]byte(`{"IdentityId": "us-east-1:identity"}`))
+		case "AWSCognitoIdentityService.GetCredentialsForIdentity":
+			_, _ = writer.Write([]byte(`{"IdentityId": "us-east-1:identity"}`))
+		default:
+			s.Failf("unexpected Cognito call", "target %q", target)
+		}
+	})
+
+	for _, op := range []string{"GetId", "GetCredentialsForIdentity"} {
+		failing = op
+		creds, err := client.Authentication.GetAWSCredsForUser()
+		s.ErrorIs(err, ErrCognitoFailed, op)
+		var cognitoErr *CognitoError
+		if s.ErrorAs(err, &cognitoErr) {
+			s.Equal(op, cognitoErr.Operation)
+		}
+		s.Nil(creds)
+	}
+
+	// A response without credentials is an error too.
+	failing = ""
+	provider := AWSCredentialProviderWithExpiration{AuthService: client.Authentication}
+	_, err := provider.Retrieve(context.Background())
+	s.ErrorIs(err, ErrCognitoFailed)
+	s.ErrorContains(err, "no credentials")
+}
+
 func TestAuthenticationServiceSuite(t *testing.T) {
 	suite.Run(t, new(AuthenticationServiceTestSuite))
 }
@@ -160,3 +356,15 @@ func (noOpPennsieveClient) SetOrganization(orgId int, orgNodeId string) {
 func (noOpPennsieveClient) Updateparams(params APIParams) {
 	panic("implement me")
 }
+
+func (noOpPennsieveClient) Logger() *slog.Logger {
+	panic("implement me")
+}
+
+func (noOpPennsieveClient) authenticate(ctx context.Context) (*APISession, error) {
+	panic("implement me")
+}
+
+func (noOpPennsieveClient) telemetry() *telemetry {
+	return nil
+}
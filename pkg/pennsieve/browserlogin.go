@@ -0,0 +1,306 @@
+package pennsieve
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+)
+
+// DefaultBrowserLoginAddr is the address LoginWithBrowser listens on for the
+// redirect from the hosted UI. For localhost it listens on both 127.0.0.1
+// and ::1, as browsers may resolve localhost to either.
+const DefaultBrowserLoginAddr = "localhost:8976"
+
+// browserLoginCallbackPath is the path of the redirect URI.
+const browserLoginCallbackPath = "/callback"
+
+// ErrNoHostedUI is returned by LoginWithBrowser when the hosted UI domain is
+// neither in the Cognito config nor set with WithBrowserLogin.
+var ErrNoHostedUI = errors.New("no Cognito hosted UI domain configured")
+
+// BrowserLoginConfig configures LoginWithBrowser. The zero value uses the
+// defaults.
+type BrowserLoginConfig struct {
+	// Domain is the base URL of the Cognito hosted UI, e.g.
+	// "https://login.pennsieve.io". It defaults to UserPool.Domain of the
+	// Cognito config.
+	Domain string
+	// ListenAddr is the loopback address of the redirect listener, default
+	// DefaultBrowserLoginAddr. The redirect URI, http://<host>:<port>/callback
+	// with the host of ListenAddr, must be an allowed callback URL of the
+	// UserPool app client.
+	ListenAddr string
+	// IdentityProvider, if set, skips the hosted UI sign-in page and sends
+	// the user straight to this provider, e.g. an institution's SAML
+	// provider.
+	IdentityProvider string
+	// Scopes requested, default openid, email and profile.
+	Scopes []string
+	// OpenBrowser opens the authorization URL. It defaults to the system's
+	// browser; tools without one can print the URL instead.
+	OpenBrowser func(url string) error
+}
+
+// oauthTokenResponse is the response of the hosted UI's /oauth2/token.
+type oauthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	IdToken      string `json:"id_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int32  `json:"expires_in"`
+	TokenType    string `json:"token_type"`
+}
+
+// LoginWithBrowser signs a user in through the Cognito hosted UI of the
+// UserPool, which also offers institutional single sign-on. It opens the
+// authorization URL in the browser, receives the authorization code on a
+// loopback listener and exchanges it for tokens with PKCE, so the app client
+// needs no secret. It returns when the user completes the sign-in, it fails
+// or ctx is done. Callbacks whose state does not match the authorization
+// request are answered with 400 Bad Request and otherwise ignored.
+//
+// Like LoginWithPassword, the session is set on the client, along with the
+// organization in the ID token if it has one. See BrowserLoginConfig.
+func (s *authenticationService) LoginWithBrowser(ctx context.Context) (*APISession, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	cognitoConfig, err := s.loadCognitoConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cfg := s.browserLoginConfig()
+	domain := cfg.Domain
+	if domain == "" {
+		domain = cognitoConfig.UserPool.Domain
+	}
+	if domain == "" {
+		return nil, ErrNoHostedUI
+	}
+	domain = strings.TrimSuffix(domain, "/")
+
+	verifier, err := randomURLString(32)
+	if err != nil {
+		return nil, err
+	}
+	state, err := randomURLString(16)
+	if err != nil {
+		return nil, err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+
+	listeners, redirectHost, err := listenLoopback(cfg.ListenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("error starting login listener: %w", err)
+	}
+	redirectURI := "http://" + redirectHost + browserLoginCallbackPath
+
+	codes := make(chan oauthCallback, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc(browserLoginCallbackPath, func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Query().Get("state") != state {
+			// Not the redirect of this login, e.g. a forged or stale one.
+			http.Error(writer, "Login failed: state mismatch", http.StatusBadRequest)
+			return
+		}
+		cb := parseOAuthCallback(request.URL.Query())
+		if cb.err != nil {
+			http.Error(writer, "Login failed: "+cb.err.Error(), http.StatusBadRequest)
+		} else {
+			_, _ = fmt.Fprintln(writer, "Login complete. You can close this window.")
+		}
+		select {
+		case codes <- cb:
+		default:
+		}
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	for _, ln := range listeners {
+		go func() { _ = srv.Serve(ln) }()
+	}
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {cognitoConfig.UserPool.AppClientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {strings.Join(cfg.Scopes, " ")},
+		"state":                 {state},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	if cfg.IdentityProvider != "" {
+		query.Set("identity_provider", cfg.IdentityProvider)
+	}
+	authorizeURL := domain + "/oauth2/authorize?" + query.Encode()
+
+	s.client.Logger().DebugContext(ctx, "opening browser for login", "url", authorizeURL)
+	if err := cfg.OpenBrowser(authorizeURL); err != nil {
+		return nil, fmt.Errorf("error opening browser: %w", err)
+	}
+
+	var cb oauthCallback
+	select {
+	case cb = <-codes:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if cb.err != nil {
+		return nil, cb.err
+	}
+
+	tokens, err := s.exchangeAuthorizationCode(ctx, domain, cognitoConfig.UserPool.AppClientID, cb.code, redirectURI, verifier)
+	if err != nil {
+		return nil, err
+	}
+	result, err := authResult(&cognitoidentityprovider.InitiateAuthOutput{
+		AuthenticationResult: &types.AuthenticationResultType{
+			AccessToken:  aws.String(tokens.AccessToken),
+			IdToken:      aws.String(tokens.IdToken),
+			RefreshToken: aws.String(tokens.RefreshToken),
+			ExpiresIn:    tokens.ExpiresIn,
+			TokenType:    aws.String(tokens.TokenType),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.userPoolSession(ctx, result)
+}
+
+// listenLoopback listens on addr for the redirect and returns the
+// listeners and the host and port of the redirect URI. For localhost it
+// listens on 127.0.0.1 and, if the system has it, ::1 with the same port.
+func listenLoopback(addr string) ([]net.Listener, string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, "", err
+	}
+	if host != "localhost" {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, "", err
+		}
+		return []net.Listener{ln}, ln.Addr().String(), nil
+	}
+
+	ln, err := net.Listen("tcp4", net.JoinHostPort("127.0.0.1", port))
+	if err != nil {
+		return nil, "", err
+	}
+	port = strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+	listeners := []net.Listener{ln}
+	if ln6, err := net.Listen("tcp6", net.JoinHostPort("::1", port)); err == nil {
+		listeners = append(listeners, ln6)
+	}
+	return listeners, net.JoinHostPort(host, port), nil
+}
+
+// exchangeAuthorizationCode redeems code at the hosted UI's token endpoint.
+// The code is single-use, so the request is not retried.
+func (s *authenticationService) exchangeAuthorizationCode(ctx context.Context, domain, clientID, code, redirectURI, verifier string) (*oauthTokenResponse, error) {
+	ctx = withRetryPolicy(ctx, NoRetryPolicy)
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {clientID},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequest("POST", domain+"/oauth2/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res := oauthTokenResponse{}
+	if err := s.client.sendUnauthenticatedRequest(ctx, req, &res); err != nil {
+		s.client.Logger().DebugContext(ctx, "token exchange failed", "error", err)
+		return nil, &CognitoError{Operation: "Token", Err: err}
+	}
+	return &res, nil
+}
+
+// oauthCallback is the outcome of the redirect to the loopback listener.
+type oauthCallback struct {
+	code string
+	err  error
+}
+
+// parseOAuthCallback reads the query of a callback whose state matched.
+func parseOAuthCallback(query url.Values) oauthCallback {
+	if e := query.Get("error"); e != "" {
+		if desc := query.Get("error_description"); desc != "" {
+			e += ": " + desc
+		}
+		return oauthCallback{err: &CognitoError{Operation: "Authorize", Err: errors.New(e)}}
+	}
+	if query.Get("code") == "" {
+		return oauthCallback{err: &CognitoError{Operation: "Authorize", Err: errors.New("no authorization code")}}
+	}
+	return oauthCallback{code: query.Get("code")}
+}
+
+// browserLoginConfig returns the configuration of LoginWithBrowser with
+// defaults applied.
+func (s *authenticationService) browserLoginConfig() BrowserLoginConfig {
+	s.mu.RLock()
+	cfg := s.browserLogin
+	s.mu.RUnlock()
+	if cfg.ListenAddr == "" {
+		cfg.ListenAddr = DefaultBrowserLoginAddr
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	if cfg.OpenBrowser == nil {
+		cfg.OpenBrowser = openBrowser
+	}
+	return cfg
+}
+
+// randomURLString returns n random bytes, base64url-encoded.
+func randomURLString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// openBrowser opens url with the system's default browser.
+func openBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go func() { _ = cmd.Wait() }()
+	return nil
+}
@@ -0,0 +1,319 @@
+package pennsieve
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+// BrowserLoginTestSuite runs LoginWithBrowser against a stand-in for the
+// Cognito hosted UI. Its OpenBrowser follows the authorization URL the way a
+// browser would after the user signs in.
+type BrowserLoginTestSuite struct {
+	suite.Suite
+	OAuth   MockServer
+	idToken string
+
+	mu            sync.Mutex
+	authorize     url.Values // query of the last /oauth2/authorize
+	token         url.Values // form of the last /oauth2/token
+	denied        bool       // redirect with error=access_denied
+	tokenErr      bool       // reject the code at /oauth2/token
+	unavailable   bool       // answer /oauth2/token with 503
+	tokenCalls    int        // number of /oauth2/token requests
+	browserErrors chan error
+}
+
+func (s *BrowserLoginTestSuite) SetupTest() {
+	mux := http.NewServeMux()
+	s.OAuth = MockServer{Server: httptest.NewServer(mux), Mux: mux}
+	// The hosted UI is not a Cognito endpoint, so tokens are issued by the
+	// AWS issuer and verified with the test key set.
+	s.idToken = NewTestIdToken(s.T(), "https://cognito-idp.us-east-1.amazonaws.com", expectedCognitoConfig, expectedCognitoConfig.UserPool.AppClientID, map[string]any{
+		OrgNodeIdClaimKey: "N:organization:sso",
+		OrgIdClaimKey:     "9",
+	}, time.Hour)
+	s.authorize, s.token = nil, nil
+	s.denied, s.tokenErr, s.unavailable, s.tokenCalls = false, false, false, 0
+	s.browserErrors = make(chan error, 1)
+
+	mux.HandleFunc("/oauth2/authorize", s.handleAuthorize)
+	mux.HandleFunc("/oauth2/token", s.handleToken)
+}
+
+func (s *BrowserLoginTestSuite) TearDownTest() {
+	s.OAuth.Close()
+}
+
+func (s *BrowserLoginTestSuite) handleAuthorize(writer http.ResponseWriter, request *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := request.URL.Query()
+	s.authorize = q
+
+	redirect := url.Values{"state": {q.Get("state")}}
+	switch {
+	case s.denied:
+		redirect.Set("error", "access_denied")
+		redirect.Set("error_description", "User denied consent")
+	default:
+		redirect.Set("code", "auth-code")
+	}
+	http.Redirect(writer, request, q.Get("redirect_uri")+"?"+redirect.Encode(), http.StatusFound)
+}
+
+func (s *BrowserLoginTestSuite) handleToken(writer http.ResponseWriter, request *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Equal(http.MethodPost, request.Method)
+	s.Equal("application/x-www-form-urlencoded", request.Header.Get("Content-Type"))
+	s.Require().NoError(request.ParseForm())
+	s.token = request.PostForm
+	s.tokenCalls++
+	if s.unavailable {
+		writer.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	// PKCE: the verifier must hash to the challenge sent to /oauth2/authorize.
+	challenge := sha256.Sum256([]byte(request.PostForm.Get("code_verifier")))
+	valid := s.authorize != nil &&
+		base64.RawURLEncoding.EncodeToString(challenge[:]) == s.authorize.Get("code_challenge") &&
+		request.PostForm.Get("code") == "auth-code" &&
+		request.PostForm.Get("redirect_uri") == s.authorize.Get("redirect_uri")
+	if s.tokenErr || !valid {
+		writer.WriteHeader(http.StatusBadRequest)
+		_, _ = writer.Write([]byte(`{"error": "invalid_grant"}`))
+		return
+	}
+	_ = json.NewEncoder(writer).Encode(map[string]any{
+		"access_token":  "sso-access",
+		"id_token":      s.idToken,
+		"refresh_token": "sso-refresh",
+		"expires_in":    3600,
+		"token_type":    "Bearer",
+	})
+}
+
+// followInBrowser requests authorizeURL, following the redirect to the
+// loopback listener.
+func (s *BrowserLoginTestSuite) followInBrowser(authorizeURL string) error {
+	browserErrors := s.browserErrors
+	go func() {
+		res, err := http.Get(authorizeURL)
+		if err == nil {
+			res.Body.Close()
+		}
+		browserErrors <- err
+	}()
+	return nil
+}
+
+func (s *BrowserLoginTestSuite) newClient(cfg BrowserLoginConfig, opts ...ClientOption) *Client {
+	cognitoConfig := expectedCognitoConfig
+	cognitoConfig.UserPool.Domain = s.OAuth.Server.URL
+	if cfg.ListenAddr == "" {
+		cfg.ListenAddr = "localhost:0"
+	}
+	if cfg.OpenBrowser == nil {
+		cfg.OpenBrowser = s.followInBrowser
+	}
+	opts = append([]ClientOption{
+		WithCognitoConfig(cognitoConfig),
+		WithBrowserLogin(cfg),
+		WithRetryPolicy(NoRetryPolicy),
+		WithJWKS(testJWKS),
+	}, opts...)
+	client, err := NewClientWithOptions(opts...)
+	s.Require().NoError(err)
+	return client
+}
+
+func (s *BrowserLoginTestSuite) TestLogin() {
+	client := s.newClient(BrowserLoginConfig{IdentityProvider: "Penn"})
+
+	session, err := client.Authentication.LoginWithBrowser(context.Background())
+	s.Require().NoError(err)
+	s.NoError(<-s.browserErrors)
+	s.Equal("sso-access", session.Token)
+	s.Equal("sso-refresh", session.RefreshToken)
+	s.Equal(s.idToken, session.IdToken)
+	s.Equal(*session, client.GetSession())
+	orgId, orgNodeId := client.GetOrganization()
+	s.Equal(9, orgId)
+	s.Equal("N:organization:sso", orgNodeId)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Equal("code", s.authorize.Get("response_type"))
+	s.Equal(expectedCognitoConfig.UserPool.AppClientID, s.authorize.Get("client_id"))
+	s.Equal("S256", s.authorize.Get("code_challenge_method"))
+	s.Equal("openid email profile", s.authorize.Get("scope"))
+	s.Equal("Penn", s.authorize.Get("identity_provider"))
+	s.Regexp(`^http://localhost:\d+/callback$`, s.authorize.Get("redirect_uri"))
+	s.Equal("authorization_code", s.token.Get("grant_type"))
+	s.Equal(expectedCognitoConfig.UserPool.AppClientID, s.token.Get("client_id"))
+}
+
+func (s *BrowserLoginTestSuite) TestDomainOverride() {
+	client := s.newClient(BrowserLoginConfig{Domain: s.OAuth.Server.URL + "/"},
+		WithCognitoConfig(expectedCognitoConfig))
+
+	_, err := client.Authentication.LoginWithBrowser(context.Background())
+	s.NoError(err)
+	s.NoError(<-s.browserErrors)
+}
+
+func (s *BrowserLoginTestSuite) TestNoDomain() {
+	client := s.newClient(BrowserLoginConfig{OpenBrowser: func(string) error {
+		s.Fail("the browser should not be opened")
+		return nil
+	}}, WithCognitoConfig(expectedCognitoConfig))
+
+	_, err := client.Authentication.LoginWithBrowser(context.Background())
+	s.ErrorIs(err, ErrNoHostedUI)
+}
+
+func (s *BrowserLoginTestSuite) TestCallbackErrors() {
+	for name, set := range map[string]func(){
+		"denied":      func() { s.denied = true },
+		"token error": func() { s.tokenErr = true },
+	} {
+		s.Run(name, func() {
+			s.denied, s.tokenErr = false, false
+			set()
+			client := s.newClient(BrowserLoginConfig{})
+
+			_, err := client.Authentication.LoginWithBrowser(context.Background())
+			<-s.browserErrors
+			s.ErrorIs(err, ErrCognitoFailed)
+			s.Empty(client.GetSession().Token)
+		})
+	}
+}
+
+// TestWrongStateIgnored sends callbacks with a forged or missing state before
+// the real redirect. They are rejected without ending the login.
+func (s *BrowserLoginTestSuite) TestWrongStateIgnored() {
+	statuses := make(chan int, 2)
+	client := s.newClient(BrowserLoginConfig{OpenBrowser: func(authorizeURL string) error {
+		u, err := url.Parse(authorizeURL)
+		if err != nil {
+			return err
+		}
+		callback := u.Query().Get("redirect_uri")
+		for _, query := range []string{"?state=forged&code=forged-code", "?code=forged-code"} {
+			res, err := http.Get(callback + query)
+			if err != nil {
+				return err
+			}
+			res.Body.Close()
+			statuses <- res.StatusCode
+		}
+		return s.followInBrowser(authorizeURL)
+	}})
+
+	session, err := client.Authentication.LoginWithBrowser(context.Background())
+	s.Require().NoError(<-s.browserErrors)
+	s.Require().NoError(err)
+	s.Equal("sso-access", session.Token)
+	s.Equal("auth-code", s.token.Get("code"))
+	s.Equal(http.StatusBadRequest, <-statuses)
+	s.Equal(http.StatusBadRequest, <-statuses)
+}
+
+func (s *BrowserLoginTestSuite) TestDeniedMessage() {
+	s.denied = true
+	client := s.newClient(BrowserLoginConfig{})
+
+	_, err := client.Authentication.LoginWithBrowser(context.Background())
+	<-s.browserErrors
+	s.ErrorContains(err, "access_denied: User denied consent")
+}
+
+func (s *BrowserLoginTestSuite) TestOpenBrowserError() {
+	failed := errors.New("no display")
+	client := s.newClient(BrowserLoginConfig{OpenBrowser: func(string) error { return failed }})
+
+	_, err := client.Authentication.LoginWithBrowser(context.Background())
+	s.ErrorIs(err, failed)
+}
+
+func (s *BrowserLoginTestSuite) TestContextDone() {
+	client := s.newClient(BrowserLoginConfig{OpenBrowser: func(string) error { return nil }})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := client.Authentication.LoginWithBrowser(ctx)
+	s.ErrorIs(err, context.DeadlineExceeded)
+}
+
+// TestCodeExchangeNotRetried checks that a failed code exchange is not
+// resent, as the authorization code can only be redeemed once.
+func (s *BrowserLoginTestSuite) TestCodeExchangeNotRetried() {
+	s.unavailable = true
+	client := s.newClient(BrowserLoginConfig{},
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}))
+
+	_, err := client.Authentication.LoginWithBrowser(context.Background())
+	<-s.browserErrors
+	s.Error(err)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Equal(1, s.tokenCalls)
+}
+
+// TestCallbackOverIPv6 follows the redirect with a browser that resolves
+// localhost to ::1.
+func (s *BrowserLoginTestSuite) TestCallbackOverIPv6() {
+	ln, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		s.T().Skip("no IPv6 loopback")
+	}
+	ln.Close()
+
+	browser := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			host, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			if host == "localhost" {
+				network, addr = "tcp6", net.JoinHostPort("::1", port)
+			}
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+	client := s.newClient(BrowserLoginConfig{OpenBrowser: func(authorizeURL string) error {
+		go func() {
+			res, err := browser.Get(authorizeURL)
+			if err == nil {
+				res.Body.Close()
+			}
+			s.browserErrors <- err
+		}()
+		return nil
+	}})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	session, err := client.Authentication.LoginWithBrowser(ctx)
+	s.Require().NoError(<-s.browserErrors)
+	s.Require().NoError(err)
+	s.Equal("sso-access", session.Token)
+}
+
+func TestBrowserLoginSuite(t *testing.T) {
+	suite.Run(t, new(BrowserLoginTestSuite))
+}
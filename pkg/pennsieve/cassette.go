@@ -0,0 +1,457 @@
+package pennsieve
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CassetteMode selects whether a Cassette records real traffic or replays a
+// recording.
+type CassetteMode int
+
+const (
+	// CassetteReplay answers requests from the cassette file and never
+	// touches the network. Requests without a recorded match fail with
+	// ErrCassetteMiss.
+	CassetteReplay CassetteMode = iota
+	// CassetteRecord sends requests to the real services and records the
+	// scrubbed exchanges. Call Cassette.Save to write them out.
+	CassetteRecord
+)
+
+func (m CassetteMode) String() string {
+	if m == CassetteRecord {
+		return "record"
+	}
+	return "replay"
+}
+
+// CassetteModeEnvVar selects the mode returned by CassetteModeFromEnv.
+const CassetteModeEnvVar = "PENNSIEVE_CASSETTE_MODE"
+
+// CassetteModeFromEnv returns CassetteRecord when PENNSIEVE_CASSETTE_MODE is
+// "record" and CassetteReplay otherwise, so tests replay by default and are
+// re-recorded by setting the variable.
+func CassetteModeFromEnv() CassetteMode {
+	if strings.EqualFold(os.Getenv(CassetteModeEnvVar), "record") {
+		return CassetteRecord
+	}
+	return CassetteReplay
+}
+
+// ErrCassetteMiss is returned in replay mode for a request that has no
+// unused recorded interaction.
+var ErrCassetteMiss = errors.New("no recorded interaction matches request")
+
+// Interaction is one recorded request/response pair.
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest is the scrubbed request of an Interaction.
+type RecordedRequest struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    string      `json:"body,omitempty"`
+}
+
+// RecordedResponse is the scrubbed response of an Interaction.
+type RecordedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Headers    http.Header `json:"headers,omitempty"`
+	Body       string      `json:"body,omitempty"`
+}
+
+// cassetteFile is the on-disk format of a cassette.
+type cassetteFile struct {
+	Version      int            `json:"version"`
+	Interactions []*Interaction `json:"interactions"`
+}
+
+const cassetteVersion = 1
+
+// Cassette is an http.RoundTripper that records or replays the HTTP traffic
+// of a Client, including the Cognito calls made through the AWS SDK. Attach
+// it with WithCassette.
+//
+// Recorded exchanges are scrubbed before they are stored: API keys,
+// secrets, passwords, refresh tokens, AWS credentials and presigned URL
+// signatures are redacted. JWTs are replaced by unsigned tokens that keep
+// only the organization, audience and issuer claims, and token and
+// credential expirations are moved far into the future so that replayed
+// sessions never need refreshing. Replayed ID tokens are not verified, so
+// the user pool key sets fetched to verify them are not recorded.
+//
+// In replay mode each interaction is used at most once, in recorded order,
+// and requests are matched on method, URL and X-Amz-Target. When several
+// unused interactions match, one with the same scrubbed body is preferred.
+type Cassette struct {
+	// Path is the cassette file.
+	Path string
+	// Mode is fixed when the cassette is created.
+	Mode CassetteMode
+	// Transport sends requests in record mode. Defaults to
+	// http.DefaultTransport.
+	Transport http.RoundTripper
+
+	mu           sync.Mutex
+	interactions []*Interaction
+	used         []bool
+}
+
+// NewCassette creates a cassette backed by the file at path. In replay mode
+// the file is loaded and must exist; in record mode recording starts empty
+// and the file is written by Save.
+func NewCassette(path string, mode CassetteMode) (*Cassette, error) {
+	c := &Cassette{Path: path, Mode: mode}
+	if mode == CassetteRecord {
+		return c, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error loading cassette: %w", err)
+	}
+	var f cassetteFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("error parsing cassette %s: %w", path, err)
+	}
+	if f.Version != cassetteVersion {
+		return nil, fmt.Errorf("cassette %s has unsupported version %d", path, f.Version)
+	}
+	c.interactions = f.Interactions
+	c.used = make([]bool, len(f.Interactions))
+	return c, nil
+}
+
+// Interactions returns a copy of the recorded interactions.
+func (c *Cassette) Interactions() []Interaction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]Interaction, len(c.interactions))
+	for i, in := range c.interactions {
+		out[i] = *in
+	}
+	return out
+}
+
+// Unused returns the interactions that have not been replayed yet. Tests
+// can check it is empty to make sure a flow made every recorded call.
+func (c *Cassette) Unused() []Interaction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var out []Interaction
+	for i, in := range c.interactions {
+		if !c.used[i] {
+			out = append(out, *in)
+		}
+	}
+	return out
+}
+
+// Save writes the recorded interactions to Path, creating its directory if
+// needed. It does nothing in replay mode.
+func (c *Cassette) Save() error {
+	if c.Mode != CassetteRecord {
+		return nil
+	}
+	c.mu.Lock()
+	data, err := json.MarshalIndent(cassetteFile{Version: cassetteVersion, Interactions: c.interactions}, "", "  ")
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.Path), 0o755); err != nil {
+		return fmt.Errorf("error saving cassette: %w", err)
+	}
+	if err := os.WriteFile(c.Path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("error saving cassette: %w", err)
+	}
+	return nil
+}
+
+// RoundTrip records or replays req depending on the cassette's mode.
+func (c *Cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	recorded := scrubRequest(req, body)
+
+	if c.Mode == CassetteRecord {
+		return c.record(req, recorded)
+	}
+	return c.replay(req, recorded)
+}
+
+func (c *Cassette) record(req *http.Request, recorded RecordedRequest) (*http.Response, error) {
+	transport := c.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	res, err := transport.RoundTrip(req)
+	if err != nil || strings.HasSuffix(req.URL.Path, jwksPath) {
+		return res, err
+	}
+
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.interactions = append(c.interactions, &Interaction{
+		Request: recorded,
+		Response: RecordedResponse{
+			StatusCode: res.StatusCode,
+			Headers:    scrubResponseHeaders(res.Header),
+			Body:       scrubBody(body),
+		},
+	})
+	c.used = append(c.used, true)
+	return res, nil
+}
+
+func (c *Cassette) replay(req *http.Request, recorded RecordedRequest) (*http.Response, error) {
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	match := -1
+	for i, in := range c.interactions {
+		if c.used[i] || !in.Request.matches(recorded) {
+			continue
+		}
+		if match < 0 {
+			match = i
+		}
+		if in.Request.Body == recorded.Body {
+			match = i
+			break
+		}
+	}
+	if match < 0 {
+		return nil, fmt.Errorf("%w: %s %s in %s", ErrCassetteMiss, recorded.Method, recorded.URL, c.Path)
+	}
+	c.used[match] = true
+
+	in := c.interactions[match].Response
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", in.StatusCode, http.StatusText(in.StatusCode)),
+		StatusCode:    in.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        in.Headers.Clone(),
+		Body:          io.NopCloser(strings.NewReader(in.Body)),
+		ContentLength: int64(len(in.Body)),
+		Request:       req,
+	}, nil
+}
+
+func (r RecordedRequest) matches(other RecordedRequest) bool {
+	return r.Method == other.Method &&
+		r.URL == other.URL &&
+		r.Headers.Get("X-Amz-Target") == other.Headers.Get("X-Amz-Target")
+}
+
+// readRequestBody reads req's body and replaces it so the request can still
+// be sent.
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// recordedRequestHeaders are the only request headers stored in a cassette.
+// Everything else, notably Authorization, is dropped.
+var recordedRequestHeaders = []string{"Content-Type", "X-Amz-Target", "X-Organization-Id"}
+
+func scrubRequest(req *http.Request, body []byte) RecordedRequest {
+	headers := http.Header{}
+	for _, h := range recordedRequestHeaders {
+		if v := req.Header.Get(h); v != "" {
+			headers.Set(h, v)
+		}
+	}
+	if len(headers) == 0 {
+		headers = nil
+	}
+	return RecordedRequest{
+		Method:  req.Method,
+		URL:     redactURL(req.URL.String()),
+		Headers: headers,
+		Body:    scrubBody(body),
+	}
+}
+
+func scrubResponseHeaders(h http.Header) http.Header {
+	out := http.Header{}
+	for k, vs := range h {
+		switch {
+		case strings.EqualFold(k, "Set-Cookie"), strings.EqualFold(k, "Content-Length"):
+			// Scrubbing changes the body length.
+			continue
+		case isSensitiveKey(k):
+			out[k] = []string{redacted}
+		default:
+			for _, v := range vs {
+				out.Add(k, redactString(v))
+			}
+		}
+	}
+	return out
+}
+
+// cassetteSensitiveKeys are scrubbed from cassette bodies in addition to
+// the keys redacted from logs: the API key sent as the Cognito USERNAME and
+// the AWS credentials returned by Cognito Identity.
+var cassetteSensitiveKeys = map[string]struct{}{
+	"username":    {},
+	"accesskeyid": {},
+	"secretkey":   {},
+}
+
+func isCassetteSensitiveKey(key string) bool {
+	k := strings.ToLower(strings.NewReplacer("-", "", "_", "").Replace(key))
+	_, ok := cassetteSensitiveKeys[k]
+	return ok || isSensitiveKey(key)
+}
+
+// scrubBody scrubs a JSON body value by value, or redacts secrets from
+// free-form text when the body is not JSON.
+func scrubBody(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return redactString(string(body))
+	}
+	scrubbed, err := json.Marshal(scrubJSON("", v))
+	if err != nil {
+		return redactString(string(body))
+	}
+	return string(scrubbed)
+}
+
+// cassetteExpiration replaces token and credential expirations in
+// cassettes.
+var cassetteExpiration = time.Date(2100, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func scrubJSON(key string, v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, val := range v {
+			v[k] = scrubJSON(k, val)
+		}
+		return v
+	case []any:
+		for i, val := range v {
+			v[i] = scrubJSON(key, val)
+		}
+		return v
+	case json.Number:
+		if isExpirationKey(key) {
+			return cassetteExpiration.Unix()
+		}
+		return v
+	case string:
+		if v != "" && jwtPattern.FindString(v) == v {
+			return scrubJWT(v)
+		}
+		if isExpirationKey(key) {
+			if _, err := time.Parse(time.RFC3339, v); err == nil {
+				return cassetteExpiration.Format(time.RFC3339)
+			}
+		}
+		if isCassetteSensitiveKey(key) {
+			return redacted
+		}
+		return redactString(v)
+	}
+	return v
+}
+
+func isExpirationKey(key string) bool {
+	return strings.EqualFold(key, "exp") || strings.EqualFold(key, "expiration")
+}
+
+// jwtClaimsKept are the claims kept when a JWT is scrubbed. The client
+// reads the organization claims and exp; the rest identify the pool.
+var jwtClaimsKept = []string{
+	"custom:organization_node_id", "custom:organization_id",
+	"exp", "iat", "iss", "aud", "client_id", "token_use",
+}
+
+// scrubJWT replaces token with an unsigned JWT carrying only jwtClaimsKept,
+// with exp moved to cassetteExpiration. Tokens that cannot be decoded are
+// redacted.
+func scrubJWT(token string) string {
+	parts := strings.Split(token, ".")
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return redacted
+	}
+	var claims map[string]any
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return redacted
+	}
+
+	kept := map[string]any{}
+	for _, k := range jwtClaimsKept {
+		if v, ok := claims[k]; ok {
+			kept[k] = v
+		}
+	}
+	kept["exp"] = cassetteExpiration.Unix()
+
+	header, _ := json.Marshal(map[string]string{"alg": "none", "typ": "JWT"})
+	body, _ := json.Marshal(kept)
+	return base64.RawURLEncoding.EncodeToString(header) + "." +
+		base64.RawURLEncoding.EncodeToString(body) + ".scrubbed"
+}
+
+// applyCassette routes c's API traffic and the Cognito traffic of o's AWS
+// config through o.cassette.
+func (o *clientOptions) applyCassette(c *Client) {
+	httpClient := *c.HTTPClient
+	if o.cassette.Transport == nil && httpClient.Transport != nil {
+		o.cassette.Transport = httpClient.Transport
+	}
+	httpClient.Transport = o.cassette
+	c.HTTPClient = &httpClient
+
+	if o.awsConfig != nil {
+		cfg := *o.awsConfig
+		cfg.HTTPClient = &http.Client{Transport: o.cassette}
+		o.awsConfig = &cfg
+	}
+}
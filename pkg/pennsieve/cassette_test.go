@@ -0,0 +1,217 @@
+package pennsieve
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// cassetteFlowResult is what runCassetteFlow observed.
+type cassetteFlowResult struct {
+	DatasetName string
+	SourceName  string
+	Channel     string
+	Bucket      string
+	Expiration  time.Time
+	OrgNodeId   string
+}
+
+// runCassetteFlow makes one call to each of the Dataset, Package, Manifest
+// and Timeseries services.
+func runCassetteFlow(t *testing.T, client *Client) cassetteFlowResult {
+	t.Helper()
+	ctx := context.Background()
+	var res cassetteFlowResult
+
+	ds, err := client.Dataset.Get(ctx, "N:dataset:1")
+	require.NoError(t, err)
+	res.DatasetName = ds.Content.Name
+
+	sources, err := client.Package.GetPackageSources(ctx, "N:package:1")
+	require.NoError(t, err)
+	require.Len(t, sources.Results, 1)
+	res.SourceName = sources.Results[0].Content.Name
+
+	creds, err := client.Manifest.GetStorageCredentials(ctx, "N:dataset:1", "N:manifest:1")
+	require.NoError(t, err)
+	res.Bucket = creds.Bucket
+	res.Expiration = creds.Expiration
+
+	channels, err := client.Timeseries.GetChannels(ctx, "N:dataset:1", "N:package:1")
+	require.NoError(t, err)
+	require.Len(t, channels, 1)
+	res.Channel = channels[0].Name
+
+	_, res.OrgNodeId = client.GetOrganization()
+	return res
+}
+
+type CassetteTestSuite struct {
+	suite.Suite
+	MockCognitoServer
+	MockPennsieveServer
+	Path string
+}
+
+func (s *CassetteTestSuite) SetupTest() {
+	s.MockCognitoServer = NewMockCognitoServerDefault(s.T())
+	s.MockPennsieveServer = NewMockPennsieveServerDefault(s.T())
+	s.Path = filepath.Join(s.T().TempDir(), "cassettes", "flow.json")
+
+	s.Mux.HandleFunc("/datasets/N:dataset:1", func(writer http.ResponseWriter, request *http.Request) {
+		_, _ = writer.Write([]byte(`{"content": {"id": "N:dataset:1", "name": "Recorded Dataset"}}`))
+	})
+	s.Mux.HandleFunc("/packages/N:package:1/sources-paged", func(writer http.ResponseWriter, request *http.Request) {
+		_, _ = writer.Write([]byte(`{"limit": 100, "offset": 0, "totalCount": 1, "results": [{"content": {"packageId": "N:package:1", "name": "recording.edf"}}]}`))
+	})
+	s.Mux.HandleFunc("/upload/manifest/storage-credentials", func(writer http.ResponseWriter, request *http.Request) {
+		_, _ = writer.Write([]byte(`{"accessKeyId": "ASIAEXAMPLE", "secretAccessKey": "very-secret", "sessionToken": "sts-session-token",
+			"expiration": "` + time.Now().Add(time.Hour).UTC().Format(time.RFC3339) + `", "bucket": "upload-bucket", "keyPrefix": "N:manifest:1/", "region": "us-east-1"}`))
+	})
+	s.Mux.HandleFunc("/timeseries/package/N:package:1/channels", func(writer http.ResponseWriter, request *http.Request) {
+		_, _ = writer.Write([]byte(`[{"channelId": "N:channel:1", "name": "EEG 1", "rate": 256}]`))
+	})
+}
+
+func (s *CassetteTestSuite) TearDownTest() {
+	s.MockCognitoServer.Close()
+	s.MockPennsieveServer.Close()
+}
+
+func (s *CassetteTestSuite) newClient(cassette *Cassette) *Client {
+	client, err := NewClientWithOptions(
+		WithCredentials("test-key", "test-secret"),
+		WithBaseURLs(s.Server.URL, s.Server.URL),
+		WithAWSConfig(newTestAWSConfig(s.IdProviderServer.URL)),
+		WithRetryPolicy(NoRetryPolicy),
+		WithCassette(cassette),
+	)
+	s.Require().NoError(err)
+	return client
+}
+
+func (s *CassetteTestSuite) record() cassetteFlowResult {
+	cassette, err := NewCassette(s.Path, CassetteRecord)
+	s.Require().NoError(err)
+	recorded := runCassetteFlow(s.T(), s.newClient(cassette))
+	s.Require().NoError(cassette.Save())
+	return recorded
+}
+
+func (s *CassetteTestSuite) TestRecordThenReplayOffline() {
+	recorded := s.record()
+	s.Equal("Recorded Dataset", recorded.DatasetName)
+
+	// Nothing is listening any more: replay must not need the network.
+	s.MockCognitoServer.Close()
+	s.MockPennsieveServer.Close()
+
+	cassette, err := NewCassette(s.Path, CassetteReplay)
+	s.Require().NoError(err)
+	replayed := runCassetteFlow(s.T(), s.newClient(cassette))
+
+	s.Equal(recorded.DatasetName, replayed.DatasetName)
+	s.Equal(recorded.SourceName, replayed.SourceName)
+	s.Equal(recorded.Bucket, replayed.Bucket)
+	s.Equal(recorded.Channel, replayed.Channel)
+	s.Equal("N:Organization:abcd", replayed.OrgNodeId, "organization should come from the replayed ID token")
+	s.Equal(cassetteExpiration, replayed.Expiration.UTC())
+	s.Empty(cassette.Unused())
+}
+
+func (s *CassetteTestSuite) TestCassetteIsScrubbed() {
+	s.record()
+
+	data, err := os.ReadFile(s.Path)
+	s.Require().NoError(err)
+	content := string(data)
+	for _, secret := range []string{"test-key", "test-secret", "mock-refresh-token", "access-token-", "very-secret", "sts-session-token", "ASIAEXAMPLE"} {
+		s.NotContains(content, secret)
+	}
+
+	var initiateAuth *Interaction
+	cassette, err := NewCassette(s.Path, CassetteReplay)
+	s.Require().NoError(err)
+	for _, in := range cassette.Interactions() {
+		if in.Request.Headers.Get("X-Amz-Target") == "AWSCognitoIdentityProviderService.InitiateAuth" {
+			initiateAuth = &in
+		}
+	}
+	s.Require().NotNil(initiateAuth, "the Cognito exchange should be recorded")
+
+	var result struct {
+		AuthenticationResult struct{ IdToken string }
+	}
+	s.Require().NoError(json.Unmarshal([]byte(initiateAuth.Response.Body), &result))
+	parts := strings.Split(result.AuthenticationResult.IdToken, ".")
+	s.Require().Len(parts, 3)
+	s.Equal("scrubbed", parts[2])
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	s.Require().NoError(err)
+	var claims map[string]any
+	s.Require().NoError(json.Unmarshal(payload, &claims))
+	s.Equal("N:Organization:abcd", claims[OrgNodeIdClaimKey])
+	s.Equal("9999", claims[OrgIdClaimKey])
+	s.EqualValues(cassetteExpiration.Unix(), claims["exp"])
+}
+
+func (s *CassetteTestSuite) TestReplayMiss() {
+	s.record()
+
+	cassette, err := NewCassette(s.Path, CassetteReplay)
+	s.Require().NoError(err)
+	client := s.newClient(cassette)
+	runCassetteFlow(s.T(), client)
+
+	// Every interaction has been used once.
+	_, err = client.Dataset.Get(context.Background(), "N:dataset:1")
+	s.ErrorIs(err, ErrCassetteMiss)
+}
+
+func TestCassetteSuite(t *testing.T) {
+	suite.Run(t, new(CassetteTestSuite))
+}
+
+// TestReplayFixture replays a cassette checked into testdata against the
+// production hosts and Cognito endpoint without any network access.
+func TestReplayFixture(t *testing.T) {
+	cassette, err := NewCassette(filepath.Join("testdata", "cassettes", "service_flow.json"), CassetteReplay)
+	require.NoError(t, err)
+
+	client, err := NewClientWithOptions(
+		WithCredentials("api-key", "api-secret"),
+		WithRetryPolicy(NoRetryPolicy),
+		WithCassette(cassette),
+	)
+	require.NoError(t, err)
+
+	res := runCassetteFlow(t, client)
+	assert.Equal(t, "Recorded Dataset", res.DatasetName)
+	assert.Equal(t, "recording.edf", res.SourceName)
+	assert.Equal(t, "upload-bucket", res.Bucket)
+	assert.Equal(t, "EEG 1", res.Channel)
+	assert.Equal(t, "N:Organization:abcd", res.OrgNodeId)
+	assert.Empty(t, cassette.Unused())
+}
+
+func TestCassetteModeFromEnv(t *testing.T) {
+	t.Setenv(CassetteModeEnvVar, "")
+	assert.Equal(t, CassetteReplay, CassetteModeFromEnv())
+	t.Setenv(CassetteModeEnvVar, "RECORD")
+	assert.Equal(t, CassetteRecord, CassetteModeFromEnv())
+}
+
+func TestNewCassetteReplayRequiresFile(t *testing.T) {
+	_, err := NewCassette(filepath.Join(t.TempDir(), "missing.json"), CassetteReplay)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
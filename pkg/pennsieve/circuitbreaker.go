@@ -0,0 +1,261 @@
+package pennsieve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is matched by the *CircuitOpenError returned when a request
+// is refused because the circuit breaker for its endpoint is open.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// defaultOpenTimeout is how long a circuit stays open when
+// CircuitBreakerConfig.OpenTimeout is not set.
+const defaultOpenTimeout = 30 * time.Second
+
+// CircuitState is the state of a circuit breaker.
+type CircuitState int
+
+const (
+	// CircuitClosed lets requests through while the endpoint is healthy.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails requests fast after repeated failures.
+	CircuitOpen
+	// CircuitHalfOpen lets probe requests through to detect recovery.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("CircuitState(%d)", int(s))
+}
+
+// CircuitBreakerConfig configures the circuit breaker of a host.
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures (5xx responses,
+	// timeouts and connection errors) that opens the circuit.
+	FailureThreshold int
+	// OpenTimeout is how long the circuit stays open before letting a probe
+	// through. Defaults to 30 seconds.
+	OpenTimeout time.Duration
+	// HalfOpenProbes is the number of successful probes that close the
+	// circuit again. Probes are sent one at a time. Defaults to 1.
+	HalfOpenProbes int
+	// PerRoute keeps a separate circuit for each service of the host ("api",
+	// "api2", "discover" or "timeseries") instead of one for the whole host.
+	PerRoute bool
+	// OnStateChange, if set, is called after a circuit changes state. key is
+	// the host, or host/service with PerRoute. It is called synchronously from
+	// the request that caused the change and must not block.
+	OnStateChange func(key string, from, to CircuitState)
+}
+
+// CircuitOpenError is returned for requests refused by an open circuit. It
+// matches ErrCircuitOpen.
+type CircuitOpenError struct {
+	Key     string    // the host, or host/service with PerRoute
+	RetryAt time.Time // when the circuit lets a probe through
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker open for %s until %s", e.Key, e.RetryAt.Format(time.RFC3339))
+}
+
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// circuitBreaker holds the circuits of one host.
+type circuitBreaker struct {
+	config CircuitBreakerConfig
+
+	mu       sync.Mutex
+	circuits map[string]*circuit // keyed by host or host/service
+}
+
+type circuit struct {
+	state    CircuitState
+	failures int // consecutive failures while closed
+	openedAt time.Time
+	probing  bool   // a half-open probe is in flight
+	probe    uint64 // generation of the latest probe, see allow
+	probesOK int    // successful probes while half-open
+}
+
+func newCircuitBreaker(config CircuitBreakerConfig) *circuitBreaker {
+	if config.OpenTimeout <= 0 {
+		config.OpenTimeout = defaultOpenTimeout
+	}
+	if config.HalfOpenProbes < 1 {
+		config.HalfOpenProbes = 1
+	}
+	return &circuitBreaker{config: config, circuits: map[string]*circuit{}}
+}
+
+// key returns the circuit of a request to service on host.
+func (b *circuitBreaker) key(host, service string) string {
+	if b.config.PerRoute {
+		return host + "/" + service
+	}
+	return host
+}
+
+// allow reports whether a request for key may be sent. An open circuit whose
+// timeout has passed becomes half-open and lets one probe through. probe
+// identifies the probe, and is 0 for other requests; it must be passed to
+// done so that only the probe's outcome moves the circuit out of half-open,
+// not that of a request sent before the circuit opened.
+func (b *circuitBreaker) allow(key string, now time.Time) (probe uint64, err error) {
+	b.mu.Lock()
+	c := b.circuits[key]
+	if c == nil {
+		c = &circuit{}
+		b.circuits[key] = c
+	}
+	from := c.state
+	if c.state == CircuitOpen && now.Sub(c.openedAt) >= b.config.OpenTimeout {
+		c.state = CircuitHalfOpen
+		c.probesOK = 0
+	}
+	switch {
+	case c.state == CircuitOpen:
+		err = &CircuitOpenError{Key: key, RetryAt: c.openedAt.Add(b.config.OpenTimeout)}
+	case c.state == CircuitHalfOpen && c.probing:
+		err = &CircuitOpenError{Key: key, RetryAt: now}
+	case c.state == CircuitHalfOpen:
+		c.probing = true
+		c.probe++
+		probe = c.probe
+	}
+	to := c.state
+	b.mu.Unlock()
+
+	b.notify(key, from, to)
+	return probe, err
+}
+
+// done records the outcome of an attempt allowed for key: 5xx responses,
+// timeouts and connection errors are failures. An attempt cancelled by its
+// caller only frees the probe slot. probe is the value returned by allow.
+func (b *circuitBreaker) done(ctx context.Context, key string, probe uint64, res *http.Response, err error) {
+	if err != nil && ctx.Err() != nil {
+		b.mu.Lock()
+		if c := b.circuits[key]; probe != 0 && probe == c.probe {
+			c.probing = false
+		}
+		b.mu.Unlock()
+		return
+	}
+	b.record(key, probe, err != nil || res.StatusCode >= 500, time.Now())
+}
+
+// record updates the circuit for key with the outcome of a request. While
+// half-open, only the outcome of the current probe counts.
+func (b *circuitBreaker) record(key string, probe uint64, failed bool, now time.Time) {
+	b.mu.Lock()
+	c := b.circuits[key]
+	from := c.state
+	switch c.state {
+	case CircuitClosed:
+		if !failed {
+			c.failures = 0
+		} else if c.failures++; c.failures >= b.config.FailureThreshold {
+			c.state, c.openedAt = CircuitOpen, now
+		}
+	case CircuitHalfOpen:
+		if probe == 0 || probe != c.probe {
+			break
+		}
+		c.probing = false
+		if failed {
+			c.state, c.openedAt = CircuitOpen, now
+		} else if c.probesOK++; c.probesOK >= b.config.HalfOpenProbes {
+			c.state, c.failures = CircuitClosed, 0
+		}
+	}
+	to := c.state
+	b.mu.Unlock()
+
+	b.notify(key, from, to)
+}
+
+func (b *circuitBreaker) notify(key string, from, to CircuitState) {
+	if from != to && b.config.OnStateChange != nil {
+		b.config.OnStateChange(key, from, to)
+	}
+}
+
+func (b *circuitBreaker) states() map[string]CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	states := make(map[string]CircuitState, len(b.circuits))
+	for key, c := range b.circuits {
+		states[key] = c.state
+	}
+	return states
+}
+
+// SetCircuitBreaker puts a circuit breaker in front of the host of baseURL
+// (e.g. APIParams.ApiHost2). After config.FailureThreshold consecutive
+// failed attempts the circuit opens and requests fail fast with a
+// *CircuitOpenError, without being sent or retried. Once
+// config.OpenTimeout has passed, probe requests are let through one at a
+// time; enough successes close the circuit, a failure opens it again.
+//
+// Replacing a breaker resets its circuits. A FailureThreshold <= 0 removes
+// the breaker.
+func (c *Client) SetCircuitBreaker(baseURL string, config CircuitBreakerConfig) error {
+	host, err := rateLimitKey(baseURL)
+	if err != nil {
+		return err
+	}
+
+	c.breakersMu.Lock()
+	defer c.breakersMu.Unlock()
+	if config.FailureThreshold <= 0 {
+		delete(c.breakers, host)
+		return nil
+	}
+	if c.breakers == nil {
+		c.breakers = make(map[string]*circuitBreaker)
+	}
+	c.breakers[host] = newCircuitBreaker(config)
+	return nil
+}
+
+// CircuitStates returns the state of every circuit, keyed by host, or
+// host/service for breakers configured with PerRoute.
+func (c *Client) CircuitStates() map[string]CircuitState {
+	c.breakersMu.RLock()
+	defer c.breakersMu.RUnlock()
+	states := map[string]CircuitState{}
+	for _, b := range c.breakers {
+		for key, state := range b.states() {
+			states[key] = state
+		}
+	}
+	return states
+}
+
+// circuitFor returns the breaker for req's host and the key of its circuit,
+// or a nil breaker if there is none.
+func (c *Client) circuitFor(req *http.Request) (*circuitBreaker, string) {
+	c.breakersMu.RLock()
+	b := c.breakers[req.URL.Host]
+	c.breakersMu.RUnlock()
+	if b == nil {
+		return nil, ""
+	}
+	return b, b.key(req.URL.Host, c.serviceName(req))
+}
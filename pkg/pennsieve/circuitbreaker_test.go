@@ -0,0 +1,267 @@
+package pennsieve
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	var changes []string
+	b := newCircuitBreaker(CircuitBreakerConfig{
+		FailureThreshold: 2,
+		OpenTimeout:      time.Minute,
+		HalfOpenProbes:   2,
+		OnStateChange: func(key string, from, to CircuitState) {
+			changes = append(changes, key+": "+from.String()+" -> "+to.String())
+		},
+	})
+	allow := func(now time.Time) error {
+		_, err := b.allow("api", now)
+		return err
+	}
+	now := time.Now()
+
+	assert.NoError(t, allow(now))
+	b.record("api", 0, true, now)
+	assert.NoError(t, allow(now))
+	b.record("api", 0, false, now)
+	assert.NoError(t, allow(now))
+	b.record("api", 0, true, now)
+	assert.NoError(t, allow(now), "a success resets the failure count")
+	b.record("api", 0, true, now)
+
+	err := allow(now.Add(time.Second))
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	var openErr *CircuitOpenError
+	if assert.ErrorAs(t, err, &openErr) {
+		assert.Equal(t, "api", openErr.Key)
+		assert.Equal(t, now.Add(time.Minute), openErr.RetryAt)
+	}
+
+	// After the timeout one probe at a time is let through.
+	later := now.Add(time.Minute)
+	probe, err := b.allow("api", later)
+	assert.NoError(t, err)
+	assert.NotZero(t, probe)
+	assert.ErrorIs(t, allow(later), ErrCircuitOpen)
+	b.record("api", probe, true, later)
+	assert.ErrorIs(t, allow(later), ErrCircuitOpen, "a failed probe reopens the circuit")
+
+	later = later.Add(time.Minute)
+	probe, err = b.allow("api", later)
+	assert.NoError(t, err)
+	b.record("api", probe, false, later)
+	assert.Equal(t, CircuitHalfOpen, b.states()["api"])
+	probe, err = b.allow("api", later)
+	assert.NoError(t, err)
+	b.record("api", probe, false, later)
+	assert.Equal(t, CircuitClosed, b.states()["api"])
+
+	assert.Equal(t, []string{
+		"api: closed -> open",
+		"api: open -> half-open",
+		"api: half-open -> open",
+		"api: open -> half-open",
+		"api: half-open -> closed",
+	}, changes)
+}
+
+// TestCircuitBreakerStaleRequest finishes requests sent before the circuit
+// opened while it is half-open. Only the probe's outcome counts.
+func TestCircuitBreakerStaleRequest(t *testing.T) {
+	b := newCircuitBreaker(CircuitBreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute})
+	now := time.Now()
+
+	stale, err := b.allow("api", now)
+	assert.NoError(t, err)
+	assert.Zero(t, stale)
+	_, err = b.allow("api", now)
+	assert.NoError(t, err)
+	b.record("api", 0, true, now)
+	assert.Equal(t, CircuitOpen, b.states()["api"])
+
+	later := now.Add(time.Minute)
+	probe, err := b.allow("api", later)
+	assert.NoError(t, err)
+	b.record("api", stale, false, later)
+	assert.Equal(t, CircuitHalfOpen, b.states()["api"], "a stale success should not close the circuit")
+	b.record("api", stale, true, later)
+	assert.Equal(t, CircuitHalfOpen, b.states()["api"], "a stale failure should not reopen the circuit")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b.done(ctx, "api", stale, nil, context.Canceled)
+	_, err = b.allow("api", later)
+	assert.ErrorIs(t, err, ErrCircuitOpen, "a cancelled stale request should not free the probe slot")
+
+	b.record("api", probe, false, later)
+	assert.Equal(t, CircuitClosed, b.states()["api"])
+}
+
+func TestCircuitStateString(t *testing.T) {
+	assert.Equal(t, "closed", CircuitClosed.String())
+	assert.Equal(t, "open", CircuitOpen.String())
+	assert.Equal(t, "half-open", CircuitHalfOpen.String())
+	assert.Equal(t, "CircuitState(7)", CircuitState(7).String())
+}
+
+type CircuitBreakerTestSuite struct {
+	suite.Suite
+	MockCognitoServer
+	MockPennsieveServer
+	TestClient *Client
+	host       string
+
+	mu       sync.Mutex
+	requests map[string]int // requests received by path
+	status   int            // status returned by the handlers
+}
+
+func (s *CircuitBreakerTestSuite) SetupTest() {
+	s.MockCognitoServer = NewMockCognitoServerDefault(s.T())
+	s.MockPennsieveServer = NewMockPennsieveServerDefault(s.T())
+	AWSEndpoints = AWSCognitoEndpoints{IdentityProviderEndpoint: s.IdProviderServer.URL}
+	s.TestClient = NewClient(APIParams{ApiHost: s.Server.URL, ApiHost2: s.Server.URL, ApiKey: "test-key", ApiSecret: "test-secret"})
+	s.TestClient.RetryPolicy = NoRetryPolicy
+	s.requests, s.status = map[string]int{}, http.StatusServiceUnavailable
+
+	u, err := url.Parse(s.Server.URL)
+	s.Require().NoError(err)
+	s.host = u.Host
+
+	handler := func(writer http.ResponseWriter, request *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests[request.URL.Path]++
+		writer.WriteHeader(s.status)
+		_, _ = writer.Write([]byte(`{}`))
+	}
+	s.Mux.HandleFunc("/timeseries/status", handler)
+	s.Mux.HandleFunc("/datasets/status", handler)
+}
+
+func (s *CircuitBreakerTestSuite) TearDownTest() {
+	s.MockCognitoServer.Close()
+	s.MockPennsieveServer.Close()
+	AWSEndpoints.Reset()
+}
+
+func (s *CircuitBreakerTestSuite) setStatus(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+func (s *CircuitBreakerTestSuite) received(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+func (s *CircuitBreakerTestSuite) get(path string) error {
+	_, err := GetJSON[map[string]any](context.Background(), s.TestClient, path)
+	return err
+}
+
+func (s *CircuitBreakerTestSuite) TestOpensAndFailsFast() {
+	s.Require().NoError(s.TestClient.SetCircuitBreaker(s.Server.URL, CircuitBreakerConfig{FailureThreshold: 3}))
+
+	for i := 0; i < 3; i++ {
+		s.ErrorIs(s.get("/timeseries/status"), ErrServer)
+	}
+	err := s.get("/datasets/status")
+	s.ErrorIs(err, ErrCircuitOpen)
+	var openErr *CircuitOpenError
+	if s.ErrorAs(err, &openErr) {
+		s.Equal(s.host, openErr.Key)
+	}
+
+	s.Equal(3, s.received("/timeseries/status"))
+	s.Equal(0, s.received("/datasets/status"), "requests should not be sent while the circuit is open")
+	s.Equal(map[string]CircuitState{s.host: CircuitOpen}, s.TestClient.CircuitStates())
+}
+
+func (s *CircuitBreakerTestSuite) TestClientErrorsDoNotCount() {
+	s.Require().NoError(s.TestClient.SetCircuitBreaker(s.Server.URL, CircuitBreakerConfig{FailureThreshold: 2}))
+	s.setStatus(http.StatusNotFound)
+
+	for i := 0; i < 3; i++ {
+		s.ErrorIs(s.get("/datasets/status"), ErrNotFound)
+	}
+	s.Equal(CircuitClosed, s.TestClient.CircuitStates()[s.host])
+}
+
+func (s *CircuitBreakerTestSuite) TestHalfOpenProbe() {
+	var mu sync.Mutex
+	var changes []CircuitState
+	s.Require().NoError(s.TestClient.SetCircuitBreaker(s.Server.URL, CircuitBreakerConfig{
+		FailureThreshold: 1,
+		OpenTimeout:      20 * time.Millisecond,
+		OnStateChange: func(key string, from, to CircuitState) {
+			mu.Lock()
+			defer mu.Unlock()
+			s.Equal(s.host, key)
+			changes = append(changes, to)
+		},
+	}))
+
+	s.ErrorIs(s.get("/datasets/status"), ErrServer)
+	s.ErrorIs(s.get("/datasets/status"), ErrCircuitOpen)
+
+	time.Sleep(30 * time.Millisecond)
+	s.ErrorIs(s.get("/datasets/status"), ErrServer, "the probe should be sent")
+	s.ErrorIs(s.get("/datasets/status"), ErrCircuitOpen)
+
+	time.Sleep(30 * time.Millisecond)
+	s.setStatus(http.StatusOK)
+	s.NoError(s.get("/datasets/status"))
+	s.NoError(s.get("/datasets/status"))
+
+	s.Equal(4, s.received("/datasets/status"))
+	mu.Lock()
+	defer mu.Unlock()
+	s.Equal([]CircuitState{CircuitOpen, CircuitHalfOpen, CircuitOpen, CircuitHalfOpen, CircuitClosed}, changes)
+}
+
+func (s *CircuitBreakerTestSuite) TestPerRoute() {
+	s.Require().NoError(s.TestClient.SetCircuitBreaker(s.Server.URL, CircuitBreakerConfig{FailureThreshold: 1, PerRoute: true}))
+
+	s.ErrorIs(s.get("/timeseries/status"), ErrServer)
+	s.ErrorIs(s.get("/timeseries/status"), ErrCircuitOpen)
+	s.ErrorIs(s.get("/datasets/status"), ErrServer, "other routes should have their own circuit")
+
+	states := s.TestClient.CircuitStates()
+	s.Equal(CircuitOpen, states[s.host+"/timeseries"])
+	s.Equal(CircuitOpen, states[s.host+"/api"])
+}
+
+func (s *CircuitBreakerTestSuite) TestOpenCircuitStopsRetries() {
+	s.TestClient.RetryPolicy = RetryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	s.Require().NoError(s.TestClient.SetCircuitBreaker(s.Server.URL, CircuitBreakerConfig{FailureThreshold: 2}))
+
+	s.ErrorIs(s.get("/datasets/status"), ErrCircuitOpen)
+	s.Equal(2, s.received("/datasets/status"))
+}
+
+func (s *CircuitBreakerTestSuite) TestRemove() {
+	s.Require().NoError(s.TestClient.SetCircuitBreaker(s.Server.URL, CircuitBreakerConfig{FailureThreshold: 1}))
+	s.ErrorIs(s.get("/datasets/status"), ErrServer)
+	s.ErrorIs(s.get("/datasets/status"), ErrCircuitOpen)
+
+	s.Require().NoError(s.TestClient.SetCircuitBreaker(s.Server.URL, CircuitBreakerConfig{}))
+	s.ErrorIs(s.get("/datasets/status"), ErrServer)
+	s.Empty(s.TestClient.CircuitStates())
+
+	s.Error(s.TestClient.SetCircuitBreaker("://api.pennsieve.io", CircuitBreakerConfig{FailureThreshold: 1}))
+}
+
+func TestCircuitBreakerSuite(t *testing.T) {
+	suite.Run(t, new(CircuitBreakerTestSuite))
+}
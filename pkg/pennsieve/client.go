@@ -1,12 +1,18 @@
 package pennsieve
 
 import (
+	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -32,6 +38,9 @@ type APIParams struct {
 	UploadBucket  string
 	UseConfigFile bool
 	Profile       string
+	// UseSessionCache reuses sessions stored in the default SessionCache
+	// and stores new ones there.
+	UseSessionCache bool
 }
 
 type errorResponse struct {
@@ -39,29 +48,56 @@ type errorResponse struct {
 	Message string `json:"message"`
 }
 
-// HTTPError is returned by sendRequest when the server responds with a non-2xx
-// status. Callers that need to branch on the status (e.g. fall back on 404)
-// can type-assert on errors.As(err, &HTTPError{}).
-type HTTPError struct {
-	StatusCode int
-	Message    string
-}
-
-func (e *HTTPError) Error() string {
-	if e.Message == "" {
-		return fmt.Sprintf("http status %d", e.StatusCode)
-	}
-	return fmt.Sprintf("http status %d: %s", e.StatusCode, e.Message)
-}
-
+// Client is safe for concurrent use by multiple goroutines. The session and
+// organization fields are guarded by an internal lock: read and write them
+// through GetSession/SetSession and GetOrganization/SetOrganization rather
+// than directly when the client is shared.
 type Client struct {
 	APISession APISession
 	aPIParams  APIParams
 	HTTPClient *http.Client
 
+	mu        sync.RWMutex // guards APISession, aPIParams, organization fields, environment, journal, sessionCache, middleware and logger
+	refreshMu sync.Mutex   // single-flight guard for token refresh
+
+	// RetryPolicy controls automatic retries of transient failures for every
+	// service call. Set to NoRetryPolicy to disable.
+	RetryPolicy RetryPolicy
+
+	// MaxResponseBodySize is the largest response body a service call
+	// decodes; larger responses fail with a DecodeError. Zero means
+	// DefaultMaxResponseBodySize. Use Do to stream larger responses.
+	MaxResponseBodySize int64
+
+	middleware []Middleware
+	logger     *slog.Logger
+
+	credentials       CredentialsProvider // nil means defaultCredentialsChain
+	credentialsSource string
+
+	telemetryMu    sync.Mutex // guards tracerProvider and meterProvider
+	tracerProvider trace.TracerProvider
+	meterProvider  metric.MeterProvider
+	otel           atomic.Pointer[telemetry]
+
+	dryRun  atomic.Bool
+	planMu  sync.Mutex // guards plan and planSeq
+	plan    []PlannedRequest
+	planSeq int
+
+	limitersMu sync.RWMutex
+	limiters   map[string]*rateLimiter // keyed by host
+
+	breakersMu sync.RWMutex
+	breakers   map[string]*circuitBreaker // keyed by host
+
 	OrganizationNodeId string
 	OrganizationId     int
 
+	environment  string             // name of the selected Environment, if any
+	journal      IdempotencyJournal // nil disables deduping
+	sessionCache *SessionCache      // nil disables session caching
+
 	Organization   OrganizationService
 	Authentication AuthenticationService
 	User           UserService
@@ -74,17 +110,83 @@ type Client struct {
 }
 
 // NewClient creates a new Pennsieve HTTP client.
+//
+// When params.UseConfigFile is set, credentials and hosts that params does
+// not set are read from the selected profile of the Pennsieve config file
+// (see ConfigFile), with hosts neither sets defaulting to the production
+// API; errors loading it are logged. No network calls are made; the Cognito config is
+// fetched on first authentication. See NewClientWithOptions for more control
+// over how the client is set up.
 func NewClient(params APIParams) *Client {
+	resolved, err := resolveProfileParams(params)
+	o := clientOptions{params: resolved}
+	cacheErr := o.useDefaultSessionCache()
+	c := newClient(o)
+	if err != nil {
+		c.Logger().Error("error loading config profile", "profile", params.Profile, "error", err)
+	}
+	if cacheErr != nil {
+		c.Logger().Error("error opening session cache", "error", cacheErr)
+	}
+	return c
+}
 
+// newClient builds a Client and its services from o. Options that are not
+// set get their defaults.
+func newClient(o clientOptions) *Client {
 	c := &Client{
 		APISession:         APISession{},
-		aPIParams:          params,
-		HTTPClient:         &http.Client{Timeout: time.Minute},
+		aPIParams:          o.params,
+		HTTPClient:         o.httpClient,
+		RetryPolicy:        DefaultRetryPolicy,
+		middleware:         o.middleware,
+		credentials:        o.credentials,
+		journal:            o.journal,
+		sessionCache:       o.sessionCache,
 		OrganizationNodeId: "",
 		OrganizationId:     0,
 	}
+	if c.HTTPClient == nil {
+		c.HTTPClient = &http.Client{Timeout: time.Minute}
+	}
+	if o.retryPolicy != nil {
+		c.RetryPolicy = *o.retryPolicy
+	}
+	c.MaxResponseBodySize = o.maxResponseBody
+	if o.loggerSet {
+		c.SetLogger(o.logger)
+	}
+	if o.session != nil {
+		c.APISession = *o.session
+	}
+	if o.cassette != nil {
+		o.applyCassette(c)
+	}
+	c.dryRun.Store(o.dryRun)
+	c.tracerProvider, c.meterProvider = o.tracerProvider, o.meterProvider
+	c.otel.Store(newTelemetry(o.tracerProvider, o.meterProvider))
+
+	params := o.params
+	var auth *authenticationService
+	if o.awsConfig != nil {
+		auth = newAuthenticationService(c, params.ApiHost, *o.awsConfig, nil)
+	} else {
+		auth = NewAuthenticationService(c, params.ApiHost)
+	}
+	if o.environment != nil {
+		c.environment = o.environment.Name
+		auth.useEnvironment(*o.environment)
+	}
+	if o.cognitoConfig != nil {
+		auth.setCognitoConfig(*o.cognitoConfig)
+	}
+	auth.srp = o.srp
+	auth.browserLogin = o.browserLogin
+	auth.jwks = o.jwks
+	// Replayed cassettes hold scrubbed, unsigned tokens.
+	auth.skipTokenVerification = o.cassette != nil && o.cassette.Mode == CassetteReplay
 
-	c.Authentication = NewAuthenticationService(c, params.ApiHost)
+	c.Authentication = auth
 	c.Organization = NewOrganizationService(c, params.ApiHost)
 	c.User = NewUserService(c, params.ApiHost)
 	c.Dataset = NewDatasetService(c, params.ApiHost, params.ApiHost2)
@@ -94,7 +196,9 @@ func NewClient(params APIParams) *Client {
 	c.Package = NewPackageService(c, params.ApiHost, params.ApiHost2)
 	c.Timeseries = NewTimeseriesService(c, params.ApiHost2)
 
-	c.Authentication.getCognitoConfig()
+	if o.session == nil {
+		c.loadCachedSession()
+	}
 
 	return c
 }
@@ -107,121 +211,260 @@ type PennsieveHTTPClient interface {
 	SetSession(s APISession)
 	SetOrganization(orgId int, orgNodeId string)
 	Updateparams(params APIParams)
+	Logger() *slog.Logger
+	authenticate(ctx context.Context) (*APISession, error)
+	telemetry() *telemetry
 }
 
 // sendUnauthenticatedRequest sends a http request without authentication
-func (c *Client) sendUnauthenticatedRequest(ctx context.Context, req *http.Request, v interface{}) error {
-	req = req.WithContext(ctx)
+func (c *Client) sendUnauthenticatedRequest(ctx context.Context, req *http.Request, v interface{}) (err error) {
+	ctx, rt := c.telemetry().startRequest(ctx, req, c.serviceName(req))
+	defer func() { rt.end(err) }()
 
-	req.Header.Set("Content-Type", "application/json")
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
 	req.Header.Set("Accept", "application/json; charset=utf-8")
 
-	res, err := c.HTTPClient.Do(req)
+	res, err := c.doWithRetry(ctx, req)
 	if err != nil {
 		return err
 	}
 
 	defer res.Body.Close()
 
-	// Try to unmarshall into errorResponse
 	if res.StatusCode != http.StatusOK {
-		var errRes errorResponse
-		if err = json.NewDecoder(res.Body).Decode(&errRes); err == nil {
-			return &HTTPError{StatusCode: res.StatusCode, Message: errRes.Message}
+		return newHTTPError(res)
+	}
+
+	return decodeResponse(res, v, c.maxResponseBodySize())
+}
+
+// maxResponseBodySize returns c.MaxResponseBodySize, or its default.
+func (c *Client) maxResponseBodySize() int64 {
+	if c.MaxResponseBodySize <= 0 {
+		return DefaultMaxResponseBodySize
+	}
+	return c.MaxResponseBodySize
+}
+
+// sessionExpiryWindow is how long before expiration a session is refreshed.
+const sessionExpiryWindow = 5 * time.Minute
+
+func sessionNeedsRefresh(s APISession) bool {
+	return time.Now().After(s.Expiration.Add(-sessionExpiryWindow))
+}
+
+// ensureSession returns a session that is valid for at least
+// sessionExpiryWindow, refreshing it if necessary. Only one refresh runs at a
+// time: concurrent callers wait for it and then pick up the new session.
+func (c *Client) ensureSession(ctx context.Context) (APISession, error) {
+	if session := c.GetSession(); !sessionNeedsRefresh(session) {
+		if err := c.verifySession(ctx, session); err != nil {
+			return APISession{}, err
 		}
+		return session, nil
+	}
+
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
 
-		return &HTTPError{StatusCode: res.StatusCode}
+	session := c.GetSession()
+	if !sessionNeedsRefresh(session) {
+		// Refreshed by another goroutine while we waited.
+		if err := c.verifySession(ctx, session); err != nil {
+			return APISession{}, err
+		}
+		return session, nil
 	}
 
-	if err = json.NewDecoder(res.Body).Decode(&v); err != nil {
-		return err
+	c.Logger().DebugContext(ctx, "refreshing token", "expiration", session.Expiration)
+
+	ctx, span := c.telemetry().startSpan(ctx, "pennsieve.token_refresh")
+	_, err := c.authenticate(ctx)
+	span.SetAttributes(attrCredsSource.String(c.CredentialsSource()))
+	endSpan(span, err)
+	if err != nil {
+		c.Logger().DebugContext(ctx, "error refreshing token", "error", err)
+		return APISession{}, err
 	}
 
-	return nil
+	return c.GetSession(), nil
 }
 
 // SendRequest sends a http request with the appropriate Pennsieve headers and auth.
 // The method checks if the token is valid and refreshes the token if not.
-func (c *Client) sendRequest(ctx context.Context, req *http.Request, v interface{}) error {
-
-	// Check Expiration Time for current session and refresh if necessary
-	if time.Now().After(c.APISession.Expiration.Add(-5 * time.Minute)) {
-		log.Println("Refreshing token")
-
-		var err error
-		if c.APISession.RefreshToken != "" && c.aPIParams.ApiKey == "" {
-			// Session token mode: re-authenticate using refresh token
-			_, err = c.Authentication.AuthenticateWithRefreshToken(c.APISession.RefreshToken)
-		} else {
-			// API key/secret mode: re-authenticate using credentials
-			_, err = c.Authentication.Authenticate(c.aPIParams.ApiKey, c.aPIParams.ApiSecret)
-		}
-
-		if err != nil {
-			log.Println("Error authenticating:", err)
-			return err
-		}
+func (c *Client) sendRequest(ctx context.Context, req *http.Request, v interface{}) (err error) {
+	if m := mutationFrom(ctx); m != nil && c.DryRun() {
+		return c.planRequest(ctx, m, req, v)
 	}
 
-	req = req.WithContext(ctx)
+	done, record, err := c.journaled(ctx, req, v)
+	if err != nil || done {
+		return err
+	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json; charset=utf-8")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.APISession.Token))
-	req.Header.Set("X-ORGANIZATION-ID", c.OrganizationNodeId)
+	ctx, rt := c.telemetry().startRequest(ctx, req, c.serviceName(req))
+	defer func() { rt.end(err) }()
 
-	res, err := c.HTTPClient.Do(req)
+	res, err := c.do(ctx, req)
 	if err != nil {
 		return err
 	}
 
 	defer res.Body.Close()
 
-	// Try to unmarshall into errorResponse
 	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated {
-		var errRes errorResponse
-		if err = json.NewDecoder(res.Body).Decode(&errRes); err == nil {
-			return &HTTPError{StatusCode: res.StatusCode, Message: errRes.Message}
-		}
+		return newHTTPError(res)
+	}
 
-		return &HTTPError{StatusCode: res.StatusCode}
+	limit := c.maxResponseBodySize()
+	if record == nil {
+		return decodeResponse(res, v, limit)
 	}
+	body, err := readResponseBody(res.Body, limit)
+	if err != nil {
+		return err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	if err := decodeResponse(res, v, limit); err != nil {
+		return err
+	}
+	record(body)
+	return nil
+}
 
-	if v != nil {
-		if err = json.NewDecoder(res.Body).Decode(&v); err != nil {
-			return err
-		}
+// do sends req with the session token and organization header, refreshing
+// the session first if necessary. Responses with a status other than 2xx are
+// returned as an *HTTPError.
+func (c *Client) do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	// Check Expiration Time for current session and refresh if necessary
+	session, err := c.ensureSession(ctx)
+	if err != nil {
+		return nil, err
 	}
+	orgNodeId := c.requestOrganization(ctx)
 
-	return nil
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "application/json; charset=utf-8")
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", session.Token))
+	req.Header.Set("X-ORGANIZATION-ID", orgNodeId)
+	setIdempotencyKey(ctx, req)
+
+	res, err := c.doWithRetry(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		defer res.Body.Close()
+		return nil, newHTTPError(res)
+	}
+
+	return res, nil
 }
 
+// SetSession replaces the client's session. Before the session is used, its
+// ID token is verified like those the client obtains itself; a session
+// without an ID token must have a Cognito access token, which is verified
+// against the same key sets. Requests fail with ErrInvalidToken if the token
+// was not issued by the UserPool or TokenPool.
 func (c *Client) SetSession(s APISession) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.APISession = s
 }
 
 func (c *Client) GetSession() APISession {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.APISession
 }
 
+// GetAPIParams returns a copy of the client's parameters. Use Updateparams to
+// change them.
 func (c *Client) GetAPIParams() *APIParams {
-	return &c.aPIParams
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	params := c.aPIParams
+	return &params
 }
 
 func (c *Client) SetOrganization(orgId int, orgNodeId string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.OrganizationId = orgId
 	c.OrganizationNodeId = orgNodeId
 }
 
+// GetOrganization returns the id and node id of the client's current
+// organization.
+func (c *Client) GetOrganization() (orgId int, orgNodeId string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.OrganizationId, c.OrganizationNodeId
+}
+
+// Updateparams replaces the client's parameters and points every service at
+// the new hosts. When params.UseConfigFile is set, params.Profile (or
+// PENNSIEVE_PROFILE) is loaded from the config file, so this is also how to
+// switch profiles. Hosts and credentials set in params override those of the
+// profile, except those still equal to the client's current ones, which are
+// replaced by the profile's or default to the production API. If the
+// credentials or API host change, the current session is dropped and the
+// next call authenticates again. If the hosts change, the client leaves its
+// environment and the Cognito config and keys are loaded again for the new
+// hosts. Like SetEnvironment, the update waits for any token refresh in
+// progress.
 func (c *Client) Updateparams(params APIParams) {
+	if params.UseConfigFile {
+		params = withoutCarriedOver(params, *c.GetAPIParams())
+	}
+	resolved, err := resolveProfileParams(params)
+	if err != nil {
+		c.Logger().Error("error loading config profile", "profile", params.Profile, "error", err)
+	}
+	params = resolved
+
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	c.mu.Lock()
+	previous := c.aPIParams
 	c.aPIParams = params
+	if previous.ApiKey != params.ApiKey || previous.ApiHost != params.ApiHost {
+		c.APISession = APISession{}
+	}
+	hostsChanged := previous.ApiHost != params.ApiHost || previous.ApiHost2 != params.ApiHost2
+	if hostsChanged {
+		c.environment = ""
+	}
+	c.mu.Unlock()
 
+	c.setServiceHosts(params)
+	if auth, ok := c.Authentication.(*authenticationService); ok && hostsChanged {
+		// Back to the default Cognito endpoints, without the config and
+		// keys of the previous hosts.
+		auth.useEnvironment(Environment{})
+	}
+}
+
+// setServiceHosts points every service at the hosts in params.
+func (c *Client) setServiceHosts(params APIParams) {
 	c.Organization.SetBaseUrl(params.ApiHost)
 	c.Authentication.SetBaseUrl(params.ApiHost)
 	c.User.SetBaseUrl(params.ApiHost)
 	c.Dataset.SetBaseUrl(params.ApiHost)
+	if d, ok := c.Dataset.(interface{ SetBaseUrl2(string) }); ok {
+		d.SetBaseUrl2(params.ApiHost2)
+	}
+	c.Discover.SetBaseUrl(params.ApiHost)
 	c.Manifest.SetBaseUrl(params.ApiHost2)
 	c.Account.SetBaseUrl(params.ApiHost2)
 	c.Package.SetBaseUrl(params.ApiHost, params.ApiHost2)
-
+	c.Timeseries.SetBaseUrl(params.ApiHost2)
 }
@@ -5,8 +5,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -23,7 +26,7 @@ func (s *ClientTestSuite) SetupTest() {
 	s.MockPennsieveServer = NewMockPennsieveServerDefault(s.T())
 	AWSEndpoints = AWSCognitoEndpoints{IdentityProviderEndpoint: s.IdProviderServer.URL}
 	s.TestClient = NewClient(
-		APIParams{ApiHost: s.Server.URL})
+		APIParams{ApiHost: s.Server.URL, ApiKey: "test-key", ApiSecret: "test-secret"})
 }
 
 func (s *ClientTestSuite) TearDownTest() {
@@ -175,6 +178,245 @@ func (s *ClientTestSuite) TestSendRequestExpired() {
 
 }
 
+func (s *ClientTestSuite) TestSendRequestRetriesTransient() {
+	s.TestClient.RetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+	reqBody := requestBody{Name: "retried", ReqValue: 7}
+	var calls atomic.Int32
+	s.Mux.HandleFunc("/flaky", func(writer http.ResponseWriter, request *http.Request) {
+		// Body must be replayed on every attempt.
+		actualReqBody := requestBody{}
+		s.NoError(json.NewDecoder(request.Body).Decode(&actualReqBody))
+		s.Equal(reqBody, actualReqBody)
+		if calls.Add(1) < 3 {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, err := writer.Write([]byte(`{"name": "ok"}`))
+		s.NoError(err)
+	})
+
+	reqBodyBytes, err := json.Marshal(reqBody)
+	s.NoError(err)
+	request, err := http.NewRequest("POST", s.Server.URL+"/flaky", bytes.NewReader(reqBodyBytes))
+	s.NoError(err)
+
+	actualRespBody := responseBody{}
+	s.NoError(s.TestClient.sendRequest(context.Background(), request, &actualRespBody))
+	s.Equal("ok", actualRespBody.Name)
+	s.Equal(int32(3), calls.Load())
+}
+
+func (s *ClientTestSuite) TestSendRequestHonorsRetryAfter() {
+	s.TestClient.RetryPolicy = RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	var calls atomic.Int32
+	s.Mux.HandleFunc("/throttled", func(writer http.ResponseWriter, request *http.Request) {
+		if calls.Add(1) == 1 {
+			writer.Header().Set("Retry-After", "1")
+			writer.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, err := writer.Write([]byte(`{}`))
+		s.NoError(err)
+	})
+
+	request, err := http.NewRequest("GET", s.Server.URL+"/throttled", nil)
+	s.NoError(err)
+
+	start := time.Now()
+	s.NoError(s.TestClient.sendUnauthenticatedRequest(context.Background(), request, &responseBody{}))
+	s.GreaterOrEqual(time.Since(start), time.Second, "Retry-After should override the computed backoff")
+	s.Equal(int32(2), calls.Load())
+}
+
+func (s *ClientTestSuite) TestSendRequestDoesNotRetry4xx() {
+	s.TestClient.RetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	var calls atomic.Int32
+	s.Mux.HandleFunc("/missing", func(writer http.ResponseWriter, request *http.Request) {
+		calls.Add(1)
+		writer.WriteHeader(http.StatusNotFound)
+		_, err := writer.Write([]byte(`{"message": "no such thing"}`))
+		s.NoError(err)
+	})
+
+	request, err := http.NewRequest("GET", s.Server.URL+"/missing", nil)
+	s.NoError(err)
+
+	err = s.TestClient.sendRequest(context.Background(), request, &responseBody{})
+	var httpErr *HTTPError
+	if s.ErrorAs(err, &httpErr) {
+		s.Equal(http.StatusNotFound, httpErr.StatusCode)
+		s.Equal("no such thing", httpErr.Message)
+	}
+	s.Equal(int32(1), calls.Load(), "4xx responses should not be retried")
+}
+
+func (s *ClientTestSuite) TestSendRequestGivesUpAfterMaxAttempts() {
+	s.TestClient.RetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	var calls atomic.Int32
+	s.Mux.HandleFunc("/down", func(writer http.ResponseWriter, request *http.Request) {
+		calls.Add(1)
+		writer.WriteHeader(http.StatusBadGateway)
+	})
+
+	request, err := http.NewRequest("GET", s.Server.URL+"/down", nil)
+	s.NoError(err)
+
+	err = s.TestClient.sendRequest(context.Background(), request, &responseBody{})
+	var httpErr *HTTPError
+	if s.ErrorAs(err, &httpErr) {
+		s.Equal(http.StatusBadGateway, httpErr.StatusCode)
+	}
+	s.Equal(int32(3), calls.Load())
+}
+
+// TestPostNotRetriedOnGatewayError checks that a 502 or 504, which may come
+// after the backend applied the request, only retries idempotent requests,
+// while a 503 or 429 retries a POST too.
+func (s *ClientTestSuite) TestPostNotRetriedOnGatewayError() {
+	s.TestClient.RetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	var calls atomic.Int32
+	var status atomic.Int32
+	s.Mux.HandleFunc("/gateway", func(writer http.ResponseWriter, request *http.Request) {
+		calls.Add(1)
+		writer.WriteHeader(int(status.Load()))
+	})
+
+	for _, tc := range []struct {
+		method   string
+		status   int
+		attempts int32
+	}{
+		{"POST", http.StatusBadGateway, 1},
+		{"POST", http.StatusGatewayTimeout, 1},
+		{"POST", http.StatusServiceUnavailable, 3},
+		{"POST", http.StatusTooManyRequests, 3},
+		{"PUT", http.StatusBadGateway, 3},
+		{"GET", http.StatusGatewayTimeout, 3},
+	} {
+		calls.Store(0)
+		status.Store(int32(tc.status))
+		request, err := http.NewRequest(tc.method, s.Server.URL+"/gateway", nil)
+		s.NoError(err)
+
+		err = s.TestClient.sendRequest(context.Background(), request, &responseBody{})
+		s.Error(err, "%s %d", tc.method, tc.status)
+		s.Equal(tc.attempts, calls.Load(), "%s %d", tc.method, tc.status)
+	}
+
+	calls.Store(0)
+	status.Store(http.StatusBadGateway)
+	request, err := http.NewRequest("POST", s.Server.URL+"/gateway", nil)
+	s.NoError(err)
+	ctx := WithIdempotencyKey(context.Background(), "gateway-retry")
+	s.Error(s.TestClient.sendRequest(ctx, request, &responseBody{}))
+	s.Equal(int32(3), calls.Load(), "a POST with the caller's key is safe to resend")
+}
+
+// TestPostNotResentAfterEOF drops the connection once a request has been
+// received. A POST without an idempotency key may have been applied, so it
+// must not be sent again; a GET is retried.
+func (s *ClientTestSuite) TestPostNotResentAfterEOF() {
+	s.TestClient.RetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	var calls atomic.Int32
+	s.Mux.HandleFunc("/hangup", func(writer http.ResponseWriter, request *http.Request) {
+		calls.Add(1)
+		conn, _, err := writer.(http.Hijacker).Hijack()
+		if s.NoError(err) {
+			_ = conn.Close()
+		}
+	})
+
+	for method, attempts := range map[string]int32{"POST": 1, "GET": 3} {
+		calls.Store(0)
+		request, err := http.NewRequest(method, s.Server.URL+"/hangup", nil)
+		s.NoError(err)
+
+		err = s.TestClient.sendUnauthenticatedRequest(context.Background(), request, &responseBody{})
+		s.Error(err, method)
+		s.Equal(attempts, calls.Load(), method)
+	}
+}
+
+// TestAuthenticatedPostNotResentAfterEOF is TestPostNotResentAfterEOF for
+// authenticated requests. Only an idempotency key chosen by the caller makes
+// the POST safe to resend.
+func (s *ClientTestSuite) TestAuthenticatedPostNotResentAfterEOF() {
+	s.TestClient.RetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	var calls atomic.Int32
+	s.Mux.HandleFunc("/datasets/", func(writer http.ResponseWriter, request *http.Request) {
+		calls.Add(1)
+		conn, _, err := writer.(http.Hijacker).Hijack()
+		if s.NoError(err) {
+			_ = conn.Close()
+		}
+	})
+
+	_, err := s.TestClient.Dataset.Create(context.Background(), "once", "", "[]")
+	s.Error(err)
+	s.Equal(int32(1), calls.Load(), "a POST without a key must not be resent")
+
+	calls.Store(0)
+	ctx := WithIdempotencyKey(context.Background(), "create-once")
+	_, err = s.TestClient.Dataset.Create(ctx, "once", "", "[]")
+	s.Error(err)
+	s.Equal(int32(3), calls.Load(), "a POST with the caller's key is safe to resend")
+}
+
+// TestConcurrentRequestsRefreshOnce sends many requests with no session from
+// different goroutines. Exactly one of them should authenticate; the others
+// wait and reuse its session. Run with -race to check for data races.
+func (s *ClientTestSuite) TestConcurrentRequestsRefreshOnce() {
+	s.Mux.HandleFunc("/concurrent", func(writer http.ResponseWriter, request *http.Request) {
+		s.Equal("Bearer access-token-1", request.Header.Get("Authorization"))
+		s.Equal("N:Organization:abcd", request.Header.Get("X-ORGANIZATION-ID"))
+		_, err := writer.Write([]byte(`{}`))
+		s.NoError(err)
+	})
+
+	const n = 50
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			request, err := http.NewRequest("GET", s.Server.URL+"/concurrent", nil)
+			s.NoError(err)
+			s.NoError(s.TestClient.sendRequest(context.Background(), request, &responseBody{}))
+			_ = s.TestClient.GetSession()
+			_, _ = s.TestClient.GetOrganization()
+		}()
+	}
+	wg.Wait()
+
+	s.Equal("access-token-1", s.TestClient.GetSession().Token, "token should have been refreshed exactly once")
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	d, ok := parseRetryAfter("5", now)
+	assert.True(t, ok)
+	assert.Equal(t, 5*time.Second, d)
+
+	d, ok = parseRetryAfter(now.Add(30*time.Second).Format(http.TimeFormat), now)
+	assert.True(t, ok)
+	assert.Equal(t, 30*time.Second, d)
+
+	_, ok = parseRetryAfter("", now)
+	assert.False(t, ok)
+	_, ok = parseRetryAfter("soon", now)
+	assert.False(t, ok)
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for attempt := 0; attempt < 10; attempt++ {
+		d := p.backoff(attempt)
+		assert.LessOrEqual(t, d, time.Second, "backoff must not exceed MaxDelay")
+		assert.Greater(t, d, time.Duration(0))
+	}
+}
+
 func TestClientSuite(t *testing.T) {
 	suite.Run(t, new(ClientTestSuite))
 }
@@ -0,0 +1,259 @@
+package pennsieve
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// ProfileEnvVar selects the config profile when APIParams.Profile is empty.
+	ProfileEnvVar = "PENNSIEVE_PROFILE"
+
+	// globalSection holds settings that apply to all profiles, such as
+	// default_profile.
+	globalSection = "global"
+)
+
+// ErrProfileNotFound is returned when the requested profile is not in the
+// config file.
+var ErrProfileNotFound = errors.New("profile not found")
+
+// Profile is one named section of the Pennsieve config file, as written by
+// the Pennsieve agent and CLI.
+type Profile struct {
+	Name         string
+	ApiToken     string // api_token
+	ApiSecret    string // api_secret
+	ApiHost      string // api_host
+	ApiHost2     string // api2_host
+	UploadBucket string // upload_bucket
+}
+
+// ConfigFile is the parsed content of a Pennsieve config file
+// (~/.pennsieve/config.ini):
+//
+//	[global]
+//	default_profile = pennsieve
+//
+//	[pennsieve]
+//	api_token = <key>
+//	api_secret = <secret>
+//
+//	[dev]
+//	api_token = <key>
+//	api_secret = <secret>
+//	api_host = https://api.pennsieve.net
+//	api2_host = https://api2.pennsieve.net
+type ConfigFile struct {
+	DefaultProfile string
+	Profiles       map[string]Profile
+}
+
+// DefaultConfigPath returns the location of the Pennsieve config file,
+// ~/.pennsieve/config.ini.
+func DefaultConfigPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".pennsieve", "config.ini"), nil
+}
+
+// LoadConfigFile reads and parses the config file at path.
+func LoadConfigFile(path string) (*ConfigFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg, err := ParseConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// ParseConfig parses a Pennsieve config file in INI format. Lines starting
+// with '#' or ';' are comments. Unknown keys are ignored.
+func ParseConfig(r io.Reader) (*ConfigFile, error) {
+	cfg := &ConfigFile{Profiles: map[string]Profile{}}
+
+	var section string
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: malformed section header %q", lineNo, line)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if section != globalSection {
+				if _, ok := cfg.Profiles[section]; !ok {
+					cfg.Profiles[section] = Profile{Name: section}
+				}
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value, got %q", lineNo, line)
+		}
+		if section == "" {
+			return nil, fmt.Errorf("line %d: key %q outside of a section", lineNo, key)
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.Trim(strings.TrimSpace(value), `"'`)
+
+		if section == globalSection {
+			if key == "default_profile" {
+				cfg.DefaultProfile = value
+			}
+			continue
+		}
+
+		p := cfg.Profiles[section]
+		switch key {
+		case "api_token":
+			p.ApiToken = value
+		case "api_secret":
+			p.ApiSecret = value
+		case "api_host":
+			p.ApiHost = value
+		case "api2_host":
+			p.ApiHost2 = value
+		case "upload_bucket":
+			p.UploadBucket = value
+		}
+		cfg.Profiles[section] = p
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Profile returns the named profile. If name is empty, the profile is taken
+// from the PENNSIEVE_PROFILE environment variable and then from
+// default_profile in the [global] section.
+func (c *ConfigFile) Profile(name string) (Profile, error) {
+	if name == "" {
+		name = os.Getenv(ProfileEnvVar)
+	}
+	if name == "" {
+		name = c.DefaultProfile
+	}
+	if name == "" {
+		return Profile{}, fmt.Errorf("no profile selected: set APIParams.Profile, %s or default_profile", ProfileEnvVar)
+	}
+
+	p, ok := c.Profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("%w: %q", ErrProfileNotFound, name)
+	}
+	return p, nil
+}
+
+// apply returns params with the values of the profile that params does not
+// set: explicit hosts and credentials in params override the profile. The
+// key and secret are a pair, taken from the profile only if params has no
+// key, so that a profile without api_secret never pairs its key with another
+// secret.
+func (p Profile) apply(params APIParams) APIParams {
+	params.Profile = p.Name
+	if params.ApiKey == "" && p.ApiToken != "" {
+		params.ApiKey, params.ApiSecret = p.ApiToken, p.ApiSecret
+	}
+	if params.ApiHost == "" {
+		params.ApiHost = p.ApiHost
+	}
+	if params.ApiHost2 == "" {
+		params.ApiHost2 = p.ApiHost2
+	}
+	if params.UploadBucket == "" {
+		params.UploadBucket = p.UploadBucket
+	}
+	return params
+}
+
+// loadProfileParams resolves params against the config file when
+// params.UseConfigFile is set. Otherwise params is returned unchanged.
+func loadProfileParams(params APIParams) (APIParams, error) {
+	if !params.UseConfigFile {
+		return params, nil
+	}
+
+	path, err := DefaultConfigPath()
+	if err != nil {
+		return params, err
+	}
+	cfg, err := LoadConfigFile(path)
+	if err != nil {
+		return params, err
+	}
+	profile, err := cfg.Profile(params.Profile)
+	if err != nil {
+		return params, err
+	}
+	return profile.apply(params), nil
+}
+
+// resolveProfileParams is loadProfileParams for NewClient and Updateparams,
+// where hosts that neither params nor the profile set default to the
+// production API. On error params is returned with only the defaults
+// applied.
+func resolveProfileParams(params APIParams) (APIParams, error) {
+	if !params.UseConfigFile {
+		return params, nil
+	}
+	resolved, err := loadProfileParams(params)
+	if err != nil {
+		return withDefaultHosts(params), err
+	}
+	return withDefaultHosts(resolved), nil
+}
+
+// withoutCarriedOver returns params without the hosts and credentials that
+// are the same as those of current, such as those loaded from the previous
+// profile, so that they do not override the profile params selects.
+func withoutCarriedOver(params, current APIParams) APIParams {
+	if params.ApiKey == current.ApiKey {
+		params.ApiKey, params.ApiSecret = "", ""
+	}
+	if params.ApiHost == current.ApiHost {
+		params.ApiHost = ""
+	}
+	if params.ApiHost2 == current.ApiHost2 {
+		params.ApiHost2 = ""
+	}
+	if params.UploadBucket == current.UploadBucket {
+		params.UploadBucket = ""
+	}
+	return params
+}
+
+// withDefaultHosts returns params with unset hosts defaulting to BaseURLV1,
+// BaseURLV2 and DefaultUploadBucket.
+func withDefaultHosts(params APIParams) APIParams {
+	if params.ApiHost == "" {
+		params.ApiHost = BaseURLV1
+	}
+	if params.ApiHost2 == "" {
+		params.ApiHost2 = BaseURLV2
+	}
+	if params.UploadBucket == "" {
+		params.UploadBucket = DefaultUploadBucket
+	}
+	return params
+}
@@ -0,0 +1,217 @@
+package pennsieve
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testConfigFile = `
+# Pennsieve agent config
+[global]
+default_profile = main
+
+[main]
+api_token = main-key
+api_secret = main-secret
+
+; a second profile pointing at dev
+[dev]
+api_token = "dev-key"
+api_secret = dev-secret
+api_host = https://api.pennsieve.net
+api2_host = https://api2.pennsieve.net
+upload_bucket = pennsieve-dev-uploads-v2-use1
+`
+
+// writeTestConfig writes content to ~/.pennsieve/config.ini under a
+// temporary HOME.
+func writeTestConfig(t *testing.T, content string) string {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	t.Setenv(ProfileEnvVar, "")
+	dir := filepath.Join(home, ".pennsieve")
+	require.NoError(t, os.MkdirAll(dir, 0o700))
+	path := filepath.Join(dir, "config.ini")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := ParseConfig(strings.NewReader(testConfigFile))
+	require.NoError(t, err)
+
+	assert.Equal(t, "main", cfg.DefaultProfile)
+	assert.Len(t, cfg.Profiles, 2)
+	assert.Equal(t, Profile{
+		Name:         "dev",
+		ApiToken:     "dev-key",
+		ApiSecret:    "dev-secret",
+		ApiHost:      "https://api.pennsieve.net",
+		ApiHost2:     "https://api2.pennsieve.net",
+		UploadBucket: "pennsieve-dev-uploads-v2-use1",
+	}, cfg.Profiles["dev"])
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	_, err := ParseConfig(strings.NewReader("[broken\n"))
+	assert.ErrorContains(t, err, "line 1")
+
+	_, err = ParseConfig(strings.NewReader("api_token = x\n"))
+	assert.ErrorContains(t, err, "outside of a section")
+
+	_, err = ParseConfig(strings.NewReader("[p]\nnot a key value\n"))
+	assert.ErrorContains(t, err, "line 2")
+}
+
+func TestConfigProfileSelection(t *testing.T) {
+	cfg, err := ParseConfig(strings.NewReader(testConfigFile))
+	require.NoError(t, err)
+
+	t.Setenv(ProfileEnvVar, "")
+	p, err := cfg.Profile("")
+	require.NoError(t, err)
+	assert.Equal(t, "main", p.Name, "default_profile should be used")
+
+	t.Setenv(ProfileEnvVar, "dev")
+	p, err = cfg.Profile("")
+	require.NoError(t, err)
+	assert.Equal(t, "dev", p.Name, "PENNSIEVE_PROFILE should override default_profile")
+
+	p, err = cfg.Profile("main")
+	require.NoError(t, err)
+	assert.Equal(t, "main", p.Name, "an explicit profile should override PENNSIEVE_PROFILE")
+
+	_, err = cfg.Profile("missing")
+	assert.ErrorIs(t, err, ErrProfileNotFound)
+}
+
+func TestNewClientUsesConfigFile(t *testing.T) {
+	writeTestConfig(t, testConfigFile)
+
+	client := NewClient(APIParams{UseConfigFile: true, Profile: "dev"})
+	params := client.GetAPIParams()
+	assert.Equal(t, "dev-key", params.ApiKey)
+	assert.Equal(t, "dev-secret", params.ApiSecret)
+	assert.Equal(t, "https://api.pennsieve.net", params.ApiHost)
+	assert.Equal(t, "https://api2.pennsieve.net", params.ApiHost2)
+	assert.Equal(t, "pennsieve-dev-uploads-v2-use1", params.UploadBucket)
+}
+
+func TestNewClientProfileWithoutHosts(t *testing.T) {
+	writeTestConfig(t, testConfigFile)
+
+	client := NewClient(APIParams{UseConfigFile: true})
+	params := client.GetAPIParams()
+	assert.Equal(t, "main-key", params.ApiKey)
+	assert.Equal(t, BaseURLV1, params.ApiHost)
+	assert.Equal(t, BaseURLV2, params.ApiHost2)
+	assert.Equal(t, DefaultUploadBucket, params.UploadBucket)
+	assert.Equal(t, BaseURLV2, client.Manifest.(*manifestService).baseUrl)
+}
+
+func TestNewClientProfileExplicitParams(t *testing.T) {
+	writeTestConfig(t, testConfigFile)
+
+	client := NewClient(APIParams{UseConfigFile: true, Profile: "dev", ApiHost: "https://api.example.com"})
+	params := client.GetAPIParams()
+	assert.Equal(t, "https://api.example.com", params.ApiHost, "explicit hosts should override the profile")
+	assert.Equal(t, "https://api2.pennsieve.net", params.ApiHost2)
+	assert.Equal(t, "dev-key", params.ApiKey)
+
+	client = NewClient(APIParams{UseConfigFile: true, Profile: "dev", ApiKey: "own-key", ApiSecret: "own-secret"})
+	params = client.GetAPIParams()
+	assert.Equal(t, "own-key", params.ApiKey)
+	assert.Equal(t, "own-secret", params.ApiSecret)
+
+	client, err := NewClientWithOptions(WithProfile("dev"), WithBaseURLs("https://api.example.com", "https://api2.example.com"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "https://api2.example.com", client.GetAPIParams().ApiHost2)
+		assert.Equal(t, "dev-key", client.GetAPIParams().ApiKey)
+	}
+}
+
+func TestProfileKeyAndSecretArePaired(t *testing.T) {
+	writeTestConfig(t, `
+[tokenonly]
+api_token = token-only-key
+`)
+
+	client := NewClient(APIParams{UseConfigFile: true, Profile: "tokenonly", ApiSecret: "unrelated-secret"})
+	params := client.GetAPIParams()
+	assert.Equal(t, "token-only-key", params.ApiKey)
+	assert.Empty(t, params.ApiSecret, "the profile's key should not be paired with another secret")
+}
+
+func TestNewClientWithOptionsProfileErrors(t *testing.T) {
+	writeTestConfig(t, testConfigFile)
+
+	_, err := NewClientWithOptions(WithProfile("missing"))
+	assert.ErrorIs(t, err, ErrProfileNotFound)
+
+	client, err := NewClientWithOptions(WithProfile(""))
+	if assert.NoError(t, err) {
+		params := client.GetAPIParams()
+		assert.Equal(t, "main-key", params.ApiKey)
+		assert.Equal(t, BaseURLV1, params.ApiHost, "hosts not in the profile should default to production")
+	}
+}
+
+func TestUpdateparamsSwitchesProfile(t *testing.T) {
+	writeTestConfig(t, testConfigFile)
+
+	client := NewClient(APIParams{UseConfigFile: true})
+	assert.Equal(t, "main-key", client.GetAPIParams().ApiKey)
+	client.SetSession(APISession{Token: "main-token", Expiration: time.Now().Add(time.Hour)})
+
+	params := *client.GetAPIParams()
+	params.Profile = "dev"
+	client.Updateparams(params)
+
+	assert.Equal(t, "dev-key", client.GetAPIParams().ApiKey)
+	assert.Equal(t, "https://api2.pennsieve.net", client.Manifest.(*manifestService).baseUrl)
+	assert.Empty(t, client.GetSession().Token, "switching credentials should drop the old session")
+}
+
+func TestUpdateparamsSwitchesProfileHosts(t *testing.T) {
+	writeTestConfig(t, testConfigFile)
+
+	client := NewClient(APIParams{UseConfigFile: true, Profile: "dev"})
+	assert.Equal(t, "https://api.pennsieve.net", client.GetAPIParams().ApiHost)
+
+	// Switching to a profile without host overrides should not keep the
+	// hosts of the previous profile.
+	params := *client.GetAPIParams()
+	params.Profile = "main"
+	client.Updateparams(params)
+	params = *client.GetAPIParams()
+	assert.Equal(t, "main-key", params.ApiKey)
+	assert.Equal(t, BaseURLV1, params.ApiHost)
+	assert.Equal(t, BaseURLV2, params.ApiHost2)
+	assert.Equal(t, DefaultUploadBucket, params.UploadBucket)
+	assert.Equal(t, BaseURLV2, client.Manifest.(*manifestService).baseUrl)
+
+	params.Profile = "dev"
+	client.Updateparams(params)
+	params = *client.GetAPIParams()
+	assert.Equal(t, "https://api.pennsieve.net", params.ApiHost)
+	assert.Equal(t, "https://api2.pennsieve.net", params.ApiHost2)
+	assert.Equal(t, "pennsieve-dev-uploads-v2-use1", params.UploadBucket)
+	assert.Equal(t, "https://api2.pennsieve.net", client.Manifest.(*manifestService).baseUrl)
+
+	// A host that is not the current one is an explicit override.
+	params.Profile = "main"
+	params.ApiHost2 = "https://api2.example.com"
+	client.Updateparams(params)
+	params = *client.GetAPIParams()
+	assert.Equal(t, "main-key", params.ApiKey)
+	assert.Equal(t, BaseURLV1, params.ApiHost)
+	assert.Equal(t, "https://api2.example.com", params.ApiHost2)
+	assert.Equal(t, "https://api2.example.com", client.Manifest.(*manifestService).baseUrl)
+}
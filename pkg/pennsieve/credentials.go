@@ -0,0 +1,338 @@
+package pennsieve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// Environment variables read by EnvCredentialsProvider.
+const (
+	APIKeyEnvVar       = "PENNSIEVE_API_KEY"
+	APISecretEnvVar    = "PENNSIEVE_API_SECRET"
+	RefreshTokenEnvVar = "PENNSIEVE_REFRESH_TOKEN"
+)
+
+// Credential sources reported by Credentials.Source and
+// Client.CredentialsSource. Profile credentials are reported as
+// "profile:<name>".
+const (
+	CredentialsSourceParams  = "params"
+	CredentialsSourceEnv     = "env"
+	CredentialsSourceProfile = "profile"
+	CredentialsSourceSession = "session"
+	CredentialsSourceStatic  = "static"
+)
+
+// ErrNoCredentials is returned when no provider in a chain has credentials.
+// Providers wrap it to signal that the chain should try the next source.
+var ErrNoCredentials = errors.New("no credentials available")
+
+// Credentials are what the client authenticates with. Either ApiKey and
+// ApiSecret are set, or RefreshToken, or Session. A Session that is still
+// valid is used as is; once it expires its RefreshToken is used.
+type Credentials struct {
+	ApiKey       string
+	ApiSecret    string
+	RefreshToken string
+	Session      *APISession
+
+	// Source identifies where the credentials came from, for debugging.
+	Source string
+}
+
+func (c Credentials) empty() bool {
+	return c.ApiKey == "" && c.RefreshToken == "" && (c.Session == nil || c.Session.Token == "")
+}
+
+// CredentialsProvider supplies the credentials used to obtain a session.
+// Retrieve is called whenever the client needs to authenticate, so providers
+// may return different credentials over time (e.g. a rotated secret).
+//
+// A provider that has nothing to offer returns an error wrapping
+// ErrNoCredentials; any other error stops a CredentialsChain.
+type CredentialsProvider interface {
+	Retrieve(ctx context.Context) (Credentials, error)
+}
+
+// CredentialsProviderFunc adapts a function to CredentialsProvider.
+type CredentialsProviderFunc func(ctx context.Context) (Credentials, error)
+
+func (f CredentialsProviderFunc) Retrieve(ctx context.Context) (Credentials, error) {
+	return f(ctx)
+}
+
+// CredentialsChain tries each provider in order and returns the first
+// credentials found.
+type CredentialsChain []CredentialsProvider
+
+func (ch CredentialsChain) Retrieve(ctx context.Context) (Credentials, error) {
+	for _, p := range ch {
+		creds, err := p.Retrieve(ctx)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		if err != nil {
+			return Credentials{}, err
+		}
+		if !creds.empty() {
+			return creds, nil
+		}
+	}
+	return Credentials{}, ErrNoCredentials
+}
+
+// EnvCredentialsProvider reads credentials from PENNSIEVE_API_KEY and
+// PENNSIEVE_API_SECRET, or from PENNSIEVE_REFRESH_TOKEN when no key is set.
+type EnvCredentialsProvider struct{}
+
+func (EnvCredentialsProvider) Retrieve(ctx context.Context) (Credentials, error) {
+	creds := Credentials{
+		ApiKey:       os.Getenv(APIKeyEnvVar),
+		ApiSecret:    os.Getenv(APISecretEnvVar),
+		RefreshToken: os.Getenv(RefreshTokenEnvVar),
+		Source:       CredentialsSourceEnv,
+	}
+	switch {
+	case creds.ApiKey != "" && creds.ApiSecret == "":
+		return Credentials{}, fmt.Errorf("%s is set but %s is empty", APIKeyEnvVar, APISecretEnvVar)
+	case creds.ApiKey != "":
+		creds.RefreshToken = ""
+		return creds, nil
+	case creds.RefreshToken != "":
+		return creds, nil
+	}
+	return Credentials{}, fmt.Errorf("%w in environment", ErrNoCredentials)
+}
+
+// ProfileCredentialsProvider reads the API key and secret from a profile of
+// the Pennsieve config file. Path defaults to DefaultConfigPath and Profile
+// is resolved as described in ConfigFile.Profile. A missing file or profile
+// is not an error; a malformed file is.
+type ProfileCredentialsProvider struct {
+	Path    string
+	Profile string
+}
+
+func (p ProfileCredentialsProvider) Retrieve(ctx context.Context) (Credentials, error) {
+	path := p.Path
+	if path == "" {
+		var err error
+		if path, err = DefaultConfigPath(); err != nil {
+			return Credentials{}, fmt.Errorf("%w: %v", ErrNoCredentials, err)
+		}
+	}
+
+	cfg, err := LoadConfigFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Credentials{}, fmt.Errorf("%w: no config file at %s", ErrNoCredentials, path)
+	}
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	profile, err := cfg.Profile(p.Profile)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("%w: %v", ErrNoCredentials, err)
+	}
+	if profile.ApiToken == "" {
+		return Credentials{}, fmt.Errorf("%w: profile %q has no api_token", ErrNoCredentials, profile.Name)
+	}
+	return Credentials{
+		ApiKey:    profile.ApiToken,
+		ApiSecret: profile.ApiSecret,
+		Source:    CredentialsSourceProfile + ":" + profile.Name,
+	}, nil
+}
+
+// StaticSessionProvider supplies a session obtained elsewhere, such as the
+// token and refresh token handed to a workflow container.
+type StaticSessionProvider struct {
+	Session APISession
+}
+
+func (p StaticSessionProvider) Retrieve(ctx context.Context) (Credentials, error) {
+	if p.Session.Token == "" && p.Session.RefreshToken == "" {
+		return Credentials{}, fmt.Errorf("%w: empty static session", ErrNoCredentials)
+	}
+	s := p.Session
+	return Credentials{
+		RefreshToken: s.RefreshToken,
+		Session:      &s,
+		Source:       CredentialsSourceStatic,
+	}, nil
+}
+
+// paramsCredentialsProvider supplies the API key and secret from the
+// client's APIParams, including those loaded from a profile through
+// UseConfigFile.
+type paramsCredentialsProvider struct {
+	client *Client
+}
+
+func (p paramsCredentialsProvider) Retrieve(ctx context.Context) (Credentials, error) {
+	params := p.client.GetAPIParams()
+	if params.ApiKey == "" {
+		return Credentials{}, fmt.Errorf("%w in APIParams", ErrNoCredentials)
+	}
+	source := CredentialsSourceParams
+	if params.UseConfigFile && params.Profile != "" {
+		source = CredentialsSourceProfile + ":" + params.Profile
+	}
+	return Credentials{ApiKey: params.ApiKey, ApiSecret: params.ApiSecret, Source: source}, nil
+}
+
+// sessionCredentialsProvider supplies the refresh token of the session set
+// on the client with SetSession or WithSession.
+type sessionCredentialsProvider struct {
+	client *Client
+}
+
+func (p sessionCredentialsProvider) Retrieve(ctx context.Context) (Credentials, error) {
+	session := p.client.GetSession()
+	if session.RefreshToken == "" {
+		return Credentials{}, fmt.Errorf("%w: no refresh token in session", ErrNoCredentials)
+	}
+	return Credentials{RefreshToken: session.RefreshToken, Source: CredentialsSourceSession}, nil
+}
+
+// defaultCredentialsChain is used when no provider is configured. Explicit
+// APIParams come first, then the environment, the refresh token of an
+// injected session and finally, only if params.UseConfigFile is set, the
+// config file profile.
+func defaultCredentialsChain(c *Client) CredentialsChain {
+	params := c.GetAPIParams()
+	chain := CredentialsChain{
+		paramsCredentialsProvider{client: c},
+		EnvCredentialsProvider{},
+		sessionCredentialsProvider{client: c},
+	}
+	if params.UseConfigFile {
+		chain = append(chain, ProfileCredentialsProvider{Profile: params.Profile})
+	}
+	return chain
+}
+
+// SetCredentialsProvider replaces the client's credentials provider. Passing
+// nil restores the default chain: APIParams, environment variables
+// (PENNSIEVE_API_KEY/PENNSIEVE_API_SECRET or PENNSIEVE_REFRESH_TOKEN), the
+// refresh token of the current session and, with UseConfigFile, the config
+// file profile.
+func (c *Client) SetCredentialsProvider(p CredentialsProvider) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.credentials = p
+}
+
+// CredentialsSource reports where the credentials of the last
+// authentication came from, e.g. "env" or "profile:dev". It is empty until
+// the client has authenticated.
+func (c *Client) CredentialsSource() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.credentialsSource
+}
+
+// retrieveCredentials asks the configured provider, or the default chain,
+// for credentials.
+func (c *Client) retrieveCredentials(ctx context.Context) (Credentials, error) {
+	c.mu.RLock()
+	p := c.credentials
+	c.mu.RUnlock()
+	if p == nil {
+		p = defaultCredentialsChain(c)
+	}
+	return p.Retrieve(ctx)
+}
+
+// authenticate obtains a new session, from the session cache if the client
+// has one or else with the credentials from the client's provider, and
+// stores it on the client.
+func (c *Client) authenticate(ctx context.Context) (*APISession, error) {
+	if cache := c.getSessionCache(); cache != nil {
+		return c.authenticateWithCache(ctx, cache)
+	}
+	session, _, err := c.authenticateWithProvider(ctx)
+	return session, err
+}
+
+// authenticateWithProvider obtains a new session with the credentials from
+// the client's provider. It also returns the pool that issued the session's
+// refresh token.
+func (c *Client) authenticateWithProvider(ctx context.Context) (*APISession, cognitoPool, error) {
+	creds, err := c.retrieveCredentials(ctx)
+	if err != nil {
+		return nil, "", fmt.Errorf("error retrieving credentials: %w", err)
+	}
+	return c.authenticateWithCredentials(ctx, creds)
+}
+
+// authenticateWithCredentials obtains a new session with creds.
+func (c *Client) authenticateWithCredentials(ctx context.Context, creds Credentials) (*APISession, cognitoPool, error) {
+	c.setCredentialsSource(creds.Source)
+	c.Logger().DebugContext(ctx, "authenticating", "credentials_source", creds.Source)
+
+	auth := c.contextAuthenticator()
+
+	switch {
+	case creds.ApiKey != "":
+		session, err := auth.authenticateWithKey(ctx, creds.ApiKey, creds.ApiSecret)
+		return session, tokenPool, err
+	case creds.Session != nil && creds.Session.Token != "" && !sessionNeedsRefresh(*creds.Session):
+		session := *creds.Session
+		c.SetSession(session)
+		return &session, userPool, nil
+	case creds.RefreshToken != "":
+		session, err := auth.authenticateWithRefreshToken(ctx, creds.RefreshToken)
+		return session, userPool, err
+	}
+	return nil, "", fmt.Errorf("%w: %s credentials are empty or expired", ErrNoCredentials, creds.Source)
+}
+
+func (c *Client) setCredentialsSource(source string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.credentialsSource = source
+}
+
+func (c *Client) contextAuthenticator() contextAuthenticator {
+	auth, ok := c.Authentication.(contextAuthenticator)
+	if !ok {
+		auth = backgroundAuthenticator{c.Authentication}
+	}
+	return auth
+}
+
+// contextAuthenticator is implemented by authenticationService so that
+// authentication triggered by a request carries the request's context and
+// trace.
+type contextAuthenticator interface {
+	authenticateWithKey(ctx context.Context, apiKey, apiSecret string) (*APISession, error)
+	authenticateWithRefreshToken(ctx context.Context, refreshToken string) (*APISession, error)
+	refreshSession(ctx context.Context, refreshToken string, pool cognitoPool) (*APISession, error)
+}
+
+// backgroundAuthenticator adapts other AuthenticationService
+// implementations to contextAuthenticator.
+type backgroundAuthenticator struct {
+	AuthenticationService
+}
+
+func (a backgroundAuthenticator) authenticateWithKey(_ context.Context, apiKey, apiSecret string) (*APISession, error) {
+	return a.Authenticate(apiKey, apiSecret)
+}
+
+func (a backgroundAuthenticator) authenticateWithRefreshToken(_ context.Context, refreshToken string) (*APISession, error) {
+	return a.AuthenticateWithRefreshToken(refreshToken)
+}
+
+// refreshSession can only use the UserPool client through
+// AuthenticateWithRefreshToken.
+func (a backgroundAuthenticator) refreshSession(_ context.Context, refreshToken string, pool cognitoPool) (*APISession, error) {
+	if pool != userPool {
+		return nil, fmt.Errorf("%w: cannot refresh a %s pool session", ErrNoCredentials, pool)
+	}
+	return a.AuthenticateWithRefreshToken(refreshToken)
+}
@@ -0,0 +1,252 @@
+package pennsieve
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/pennsieve/pennsieve-go/pkg/pennsieve/models/authentication"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clearCredentialsEnv unsets the credential environment variables for the
+// duration of the test.
+func clearCredentialsEnv(t *testing.T) {
+	t.Setenv(APIKeyEnvVar, "")
+	t.Setenv(APISecretEnvVar, "")
+	t.Setenv(RefreshTokenEnvVar, "")
+}
+
+func TestEnvCredentialsProvider(t *testing.T) {
+	clearCredentialsEnv(t)
+
+	_, err := EnvCredentialsProvider{}.Retrieve(context.Background())
+	assert.ErrorIs(t, err, ErrNoCredentials)
+
+	t.Setenv(RefreshTokenEnvVar, "env-refresh")
+	creds, err := EnvCredentialsProvider{}.Retrieve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, Credentials{RefreshToken: "env-refresh", Source: CredentialsSourceEnv}, creds)
+
+	t.Setenv(APIKeyEnvVar, "env-key")
+	_, err = EnvCredentialsProvider{}.Retrieve(context.Background())
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrNoCredentials, "a key without a secret is a configuration error")
+
+	t.Setenv(APISecretEnvVar, "env-secret")
+	creds, err = EnvCredentialsProvider{}.Retrieve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, Credentials{ApiKey: "env-key", ApiSecret: "env-secret", Source: CredentialsSourceEnv}, creds)
+}
+
+func TestProfileCredentialsProvider(t *testing.T) {
+	path := writeTestConfig(t, testConfigFile)
+
+	creds, err := ProfileCredentialsProvider{}.Retrieve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, Credentials{ApiKey: "main-key", ApiSecret: "main-secret", Source: "profile:main"}, creds)
+
+	creds, err = ProfileCredentialsProvider{Path: path, Profile: "dev"}.Retrieve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "dev-key", creds.ApiKey)
+	assert.Equal(t, "profile:dev", creds.Source)
+
+	_, err = ProfileCredentialsProvider{Profile: "missing"}.Retrieve(context.Background())
+	assert.ErrorIs(t, err, ErrNoCredentials)
+
+	_, err = ProfileCredentialsProvider{Path: filepath.Join(t.TempDir(), "none.ini")}.Retrieve(context.Background())
+	assert.ErrorIs(t, err, ErrNoCredentials)
+
+	malformed := writeTestConfig(t, "[main\napi_token = x\n")
+	_, err = ProfileCredentialsProvider{Path: malformed}.Retrieve(context.Background())
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrNoCredentials)
+}
+
+func TestCredentialsChain(t *testing.T) {
+	none := CredentialsProviderFunc(func(ctx context.Context) (Credentials, error) {
+		return Credentials{}, fmt.Errorf("%w: nothing here", ErrNoCredentials)
+	})
+	static := StaticSessionProvider{Session: APISession{Token: "t", RefreshToken: "r"}}
+	broken := CredentialsProviderFunc(func(ctx context.Context) (Credentials, error) {
+		return Credentials{}, errors.New("broken")
+	})
+
+	creds, err := CredentialsChain{none, static, broken}.Retrieve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, CredentialsSourceStatic, creds.Source)
+	assert.Equal(t, "r", creds.RefreshToken)
+
+	_, err = CredentialsChain{none, broken, static}.Retrieve(context.Background())
+	assert.EqualError(t, err, "broken")
+
+	_, err = CredentialsChain{none}.Retrieve(context.Background())
+	assert.ErrorIs(t, err, ErrNoCredentials)
+}
+
+// cognitoRecorder is a Cognito stand-in that records the auth flow and
+// parameters of each InitiateAuth call.
+type cognitoRecorder struct {
+	*httptest.Server
+	mu    sync.Mutex
+	calls []map[string]any
+}
+
+func newCognitoRecorder(t *testing.T) *cognitoRecorder {
+	r := &cognitoRecorder{}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var body map[string]any
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			t.Errorf("error decoding InitiateAuth request: %v", err)
+		}
+		r.mu.Lock()
+		r.calls = append(r.calls, body)
+		r.mu.Unlock()
+		clientID, _ := body["ClientId"].(string)
+		_, _ = fmt.Fprintf(w, `{"AuthenticationResult": {"AccessToken": "access", "ExpiresIn": 3600, "IdToken": %q, "RefreshToken": "new-refresh", "TokenType": "Bearer"}}`,
+			NewTestIdToken(t, r.URL, credentialsCognitoConfig, clientID, map[string]any{OrgNodeIdClaimKey: "N:Organization:abcd", OrgIdClaimKey: "9999"}, time.Hour))
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *cognitoRecorder) lastCall() (flow string, authParams map[string]any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.calls) == 0 {
+		return "", nil
+	}
+	last := r.calls[len(r.calls)-1]
+	flow, _ = last["AuthFlow"].(string)
+	authParams, _ = last["AuthParameters"].(map[string]any)
+	return flow, authParams
+}
+
+var credentialsCognitoConfig = authentication.CognitoConfig{
+	Region:    "us-east-1",
+	UserPool:  authentication.UserPool{ID: "credentials-user-pool", AppClientID: "credentials-user-pool-client"},
+	TokenPool: authentication.TokenPool{ID: "credentials-token-pool", AppClientID: "credentials-token-pool-client"},
+}
+
+func newCredentialsTestClient(t *testing.T, cognito *cognitoRecorder, opts ...ClientOption) *Client {
+	opts = append([]ClientOption{
+		WithBaseURLs("http://localhost:1", "http://localhost:2"),
+		WithAWSConfig(newTestAWSConfig(cognito.URL)),
+		WithCognitoConfig(credentialsCognitoConfig),
+		WithJWKS(testJWKS),
+	}, opts...)
+	client, err := NewClientWithOptions(opts...)
+	require.NoError(t, err)
+	return client
+}
+
+func TestDefaultCredentialsChain(t *testing.T) {
+	writeTestConfig(t, testConfigFile)
+	clearCredentialsEnv(t)
+	cognito := newCognitoRecorder(t)
+
+	t.Run("params first", func(t *testing.T) {
+		t.Setenv(APIKeyEnvVar, "env-key")
+		t.Setenv(APISecretEnvVar, "env-secret")
+		client := newCredentialsTestClient(t, cognito, WithCredentials("param-key", "param-secret"))
+
+		_, err := client.ensureSession(context.Background())
+		require.NoError(t, err)
+		_, params := cognito.lastCall()
+		assert.Equal(t, "param-key", params["USERNAME"])
+		assert.Equal(t, CredentialsSourceParams, client.CredentialsSource())
+	})
+
+	t.Run("env before profile", func(t *testing.T) {
+		t.Setenv(APIKeyEnvVar, "env-key")
+		t.Setenv(APISecretEnvVar, "env-secret")
+		client := newCredentialsTestClient(t, cognito)
+
+		_, err := client.ensureSession(context.Background())
+		require.NoError(t, err)
+		_, params := cognito.lastCall()
+		assert.Equal(t, "env-key", params["USERNAME"])
+		assert.Equal(t, CredentialsSourceEnv, client.CredentialsSource())
+	})
+
+	t.Run("profile", func(t *testing.T) {
+		client := newCredentialsTestClient(t, cognito, WithProfile("main"))
+
+		_, err := client.ensureSession(context.Background())
+		require.NoError(t, err)
+		_, params := cognito.lastCall()
+		assert.Equal(t, "main-key", params["USERNAME"])
+		assert.Equal(t, "profile:main", client.CredentialsSource())
+	})
+
+	t.Run("profile only with UseConfigFile", func(t *testing.T) {
+		client := newCredentialsTestClient(t, cognito)
+
+		_, err := client.ensureSession(context.Background())
+		assert.ErrorIs(t, err, ErrNoCredentials, "the config file should not be read")
+	})
+
+	t.Run("injected session before profile", func(t *testing.T) {
+		client := newCredentialsTestClient(t, cognito, WithProfile("main"))
+		assert.Equal(t, CredentialsChain{
+			paramsCredentialsProvider{client: client},
+			EnvCredentialsProvider{},
+			sessionCredentialsProvider{client: client},
+			ProfileCredentialsProvider{Profile: "main"},
+		}, defaultCredentialsChain(client))
+	})
+
+	t.Run("injected session", func(t *testing.T) {
+		expired := APISession{Token: "old", Expiration: time.Now().Add(-time.Hour), RefreshToken: "injected-refresh"}
+		client := newCredentialsTestClient(t, cognito, WithSession(expired))
+
+		session, err := client.ensureSession(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "access", session.Token)
+		flow, params := cognito.lastCall()
+		assert.Equal(t, string(types.AuthFlowTypeRefreshToken), flow)
+		assert.Equal(t, "injected-refresh", params["REFRESH_TOKEN"])
+		assert.Equal(t, CredentialsSourceSession, client.CredentialsSource())
+	})
+
+	t.Run("none", func(t *testing.T) {
+		writeTestConfig(t, "")
+		client := newCredentialsTestClient(t, cognito)
+
+		_, err := client.ensureSession(context.Background())
+		assert.ErrorIs(t, err, ErrNoCredentials)
+	})
+}
+
+func TestStaticSessionProviderUsesValidSession(t *testing.T) {
+	cognito := newCognitoRecorder(t)
+	valid := APISession{Token: "static-token", Expiration: time.Now().Add(time.Hour), RefreshToken: "static-refresh"}
+	client := newCredentialsTestClient(t, cognito, WithCredentialsProvider(StaticSessionProvider{Session: valid}))
+
+	session, err := client.ensureSession(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "static-token", session.Token)
+	flow, _ := cognito.lastCall()
+	assert.Empty(t, flow, "a valid static session needs no Cognito call")
+	assert.Equal(t, CredentialsSourceStatic, client.CredentialsSource())
+
+	// Once expired, the static session's refresh token is used.
+	client.SetCredentialsProvider(StaticSessionProvider{Session: APISession{
+		Token: "static-token", Expiration: time.Now().Add(-time.Minute), RefreshToken: "static-refresh"}})
+	client.SetSession(APISession{})
+
+	session, err = client.ensureSession(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "access", session.Token)
+	_, params := cognito.lastCall()
+	assert.Equal(t, "static-refresh", params["REFRESH_TOKEN"])
+}
@@ -3,11 +3,13 @@ package pennsieve
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
-	"log"
+	"iter"
 	"net/http"
 	"net/url"
 	"strconv"
+	"sync"
 
 	"github.com/pennsieve/pennsieve-go/pkg/pennsieve/models/dataset"
 )
@@ -19,12 +21,15 @@ type DatasetService interface {
 	SetBaseUrl(url string)
 	Create(ctx context.Context, name, description, tags string) (*dataset.CreateDatasetResponse, error)
 	GetManifest(ctx context.Context, nodeId string) (*dataset.GetManifestResponse, error)
+	All(ctx context.Context) iter.Seq2[dataset.Datasets, error]
+	Search(ctx context.Context, query string) iter.Seq2[dataset.Datasets, error]
 }
 
 type datasetService struct {
 	Client   PennsieveHTTPClient
 	BaseUrl  string
 	BaseUrl2 string
+	mu       sync.RWMutex // guards BaseUrl and BaseUrl2
 }
 
 func NewDatasetService(client PennsieveHTTPClient, baseUrl string, baseUrl2 string) *datasetService {
@@ -38,7 +43,7 @@ func NewDatasetService(client PennsieveHTTPClient, baseUrl string, baseUrl2 stri
 // Get returns a single dataset by id.
 func (d *datasetService) Get(ctx context.Context, id string) (*dataset.GetDatasetResponse, error) {
 
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/datasets/%s", d.BaseUrl, id), nil)
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/datasets/%s", d.host(), id), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -50,7 +55,7 @@ func (d *datasetService) Get(ctx context.Context, id string) (*dataset.GetDatase
 	res := dataset.GetDatasetResponse{}
 	if err := d.Client.sendRequest(ctx, req, &res); err != nil {
 
-		log.Println("DatasetService: SendRequest Error in Get: ", err)
+		d.Client.Logger().DebugContext(ctx, "DatasetService.Get failed", "error", err)
 		return nil, err
 	}
 
@@ -64,23 +69,7 @@ func (d *datasetService) Find(ctx context.Context, limit int, query string) (*da
 	params.Add("limit", strconv.Itoa(limit))
 	params.Add("query", query)
 
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/datasets/paginated?%s", d.BaseUrl, params.Encode()), nil)
-	if err != nil {
-		return nil, err
-	}
-
-	if ctx == nil {
-		ctx = req.Context()
-	}
-
-	res := dataset.ListDatasetResponse{}
-	if err := d.Client.sendRequest(ctx, req, &res); err != nil {
-
-		fmt.Println("SendRequest Error: ", err)
-		return nil, err
-	}
-
-	return &res, nil
+	return d.listPaginated(ctx, params, "DatasetService.Find")
 }
 
 // List returns a list of datasets with a limit and an offset
@@ -90,7 +79,42 @@ func (d *datasetService) List(ctx context.Context, limit int, offset int) (*data
 	params.Add("limit", strconv.Itoa(limit))
 	params.Add("offset", strconv.Itoa(offset))
 
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/datasets/paginated?%s", d.BaseUrl, params.Encode()), nil)
+	return d.listPaginated(ctx, params, "DatasetService.List")
+}
+
+// All iterates over all datasets the user has access to, fetching pages as
+// needed.
+func (d *datasetService) All(ctx context.Context) iter.Seq2[dataset.Datasets, error] {
+	return d.iterate(ctx, "", "DatasetService.All")
+}
+
+// Search iterates over all datasets matching query, fetching pages as
+// needed.
+func (d *datasetService) Search(ctx context.Context, query string) iter.Seq2[dataset.Datasets, error] {
+	return d.iterate(ctx, query, "DatasetService.Search")
+}
+
+func (d *datasetService) iterate(ctx context.Context, query string, op string) iter.Seq2[dataset.Datasets, error] {
+	return offsetPages(ctx, defaultPageSize, func(ctx context.Context, limit, offset int) ([]dataset.Datasets, int, error) {
+		params := url.Values{}
+		params.Add("limit", strconv.Itoa(limit))
+		params.Add("offset", strconv.Itoa(offset))
+		if query != "" {
+			params.Add("query", query)
+		}
+		res, err := d.listPaginated(ctx, params, op)
+		if err != nil {
+			return nil, 0, err
+		}
+		return res.Datasets, res.TotalCount, nil
+	})
+}
+
+// listPaginated calls GET /datasets/paginated with the given query
+// parameters.
+func (d *datasetService) listPaginated(ctx context.Context, params url.Values, op string) (*dataset.ListDatasetResponse, error) {
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/datasets/paginated?%s", d.host(), params.Encode()), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -102,7 +126,7 @@ func (d *datasetService) List(ctx context.Context, limit int, offset int) (*data
 	res := dataset.ListDatasetResponse{}
 	if err := d.Client.sendRequest(ctx, req, &res); err != nil {
 
-		fmt.Println("SendRequest Error: ", err)
+		d.Client.Logger().DebugContext(ctx, op+" failed", "error", err)
 		return nil, err
 	}
 
@@ -114,7 +138,7 @@ func (d *datasetService) GetManifest(ctx context.Context, nodeId string) (*datas
 	params := url.Values{}
 	params.Add("dataset_id", nodeId)
 
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/datasets/manifest?%s", d.BaseUrl2, params.Encode()), nil)
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/datasets/manifest?%s", d.host2(), params.Encode()), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -126,7 +150,7 @@ func (d *datasetService) GetManifest(ctx context.Context, nodeId string) (*datas
 	res := dataset.GetManifestResponse{}
 	if err := d.Client.sendRequest(ctx, req, &res); err != nil {
 
-		log.Println("DatasetService: SendRequest Error in GetManifest: ", err)
+		d.Client.Logger().DebugContext(ctx, "DatasetService.GetManifest failed", "error", err)
 		return nil, err
 	}
 
@@ -134,9 +158,30 @@ func (d *datasetService) GetManifest(ctx context.Context, nodeId string) (*datas
 }
 
 func (d *datasetService) SetBaseUrl(url string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	d.BaseUrl = url
 }
 
+// SetBaseUrl2 sets the API v2 host, used by GetManifest.
+func (d *datasetService) SetBaseUrl2(url2 string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.BaseUrl2 = url2
+}
+
+func (d *datasetService) host() string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.BaseUrl
+}
+
+func (d *datasetService) host2() string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.BaseUrl2
+}
+
 func (d *datasetService) Create(ctx context.Context, name, description, tags string) (*dataset.CreateDatasetResponse, error) {
 	postParams := fmt.Sprintf(`
 		{
@@ -147,7 +192,7 @@ func (d *datasetService) Create(ctx context.Context, name, description, tags str
 
 	postParamsPayload := bytes.NewReader([]byte(postParams))
 
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/datasets/", d.BaseUrl), postParamsPayload)
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/datasets/", d.host()), postParamsPayload)
 	if err != nil {
 		return nil, err
 	}
@@ -157,8 +202,8 @@ func (d *datasetService) Create(ctx context.Context, name, description, tags str
 	}
 
 	res := dataset.CreateDatasetResponse{}
-	if err := d.Client.sendRequest(ctx, req, &res); err != nil {
-		fmt.Println("SendRequest Error: ", err)
+	if err := d.Client.sendRequest(withMutation(ctx, "DatasetService.Create", dryRunDataset), req, &res); err != nil {
+		d.Client.Logger().DebugContext(ctx, "DatasetService.Create failed", "error", err)
 		return nil, err
 	}
 
@@ -169,3 +214,21 @@ func (d *datasetService) Create(ctx context.Context, name, description, tags str
 	return &res, nil
 
 }
+
+// dryRunDataset is the synthetic response to Create in dry-run mode.
+func dryRunDataset(p PlannedRequest) any {
+	var body struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		Tags        []string `json:"tags"`
+	}
+	_ = json.Unmarshal([]byte(p.Body), &body)
+	return dataset.CreateDatasetResponse{Content: dataset.Content{
+		ID:          p.dryRunID("dataset"),
+		Name:        body.Name,
+		Description: body.Description,
+		Tags:        body.Tags,
+		CreatedAt:   p.Time,
+		UpdatedAt:   p.Time,
+	}}
+}
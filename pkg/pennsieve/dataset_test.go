@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 	"testing"
 
 	"github.com/pennsieve/pennsieve-go/pkg/pennsieve/models/dataset"
@@ -23,7 +25,9 @@ func (s *DatasetServiceTestSuite) SetupTest() {
 	s.MockPennsieveServer = NewMockPennsieveServerDefault(s.T())
 	AWSEndpoints = AWSCognitoEndpoints{IdentityProviderEndpoint: s.IdProviderServer.URL}
 	client := NewClient(APIParams{
-		ApiHost: s.Server.URL,
+		ApiKey:    "test-key",
+		ApiSecret: "test-secret",
+		ApiHost:   s.Server.URL,
 	})
 	s.TestService = client.Dataset
 }
@@ -95,6 +99,69 @@ func (s *DatasetServiceTestSuite) TestCreateDataset() {
 	}
 }
 
+// serveDatasetPages serves total datasets from /datasets/paginated, honoring
+// limit and offset, and records the query parameters of each request.
+func (s *DatasetServiceTestSuite) serveDatasetPages(total int, queries *[]url.Values) {
+	s.Mux.HandleFunc("/datasets/paginated", func(writer http.ResponseWriter, request *http.Request) {
+		q := request.URL.Query()
+		*queries = append(*queries, q)
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		offset, _ := strconv.Atoi(q.Get("offset"))
+
+		resp := dataset.ListDatasetResponse{Limit: limit, Offset: offset, TotalCount: total}
+		for i := offset; i < total && i < offset+limit; i++ {
+			resp.Datasets = append(resp.Datasets, dataset.Datasets{
+				Content: dataset.Content{ID: fmt.Sprintf("N:dataset:%d", i)},
+			})
+		}
+		body, err := json.Marshal(resp)
+		if s.NoError(err) {
+			_, err := writer.Write(body)
+			s.NoError(err)
+		}
+	})
+}
+
+func (s *DatasetServiceTestSuite) TestAllDatasets() {
+	total := defaultPageSize*2 + 5
+	var queries []url.Values
+	s.serveDatasetPages(total, &queries)
+
+	var ids []string
+	for ds, err := range s.TestService.All(context.Background()) {
+		if !s.NoError(err) {
+			return
+		}
+		ids = append(ids, ds.Content.ID)
+	}
+	s.Len(ids, total)
+	s.Equal("N:dataset:0", ids[0])
+	s.Equal(fmt.Sprintf("N:dataset:%d", total-1), ids[total-1])
+
+	if s.Len(queries, 3) {
+		s.Equal([]string{"0", "100", "200"}, []string{
+			queries[0].Get("offset"), queries[1].Get("offset"), queries[2].Get("offset")})
+		s.False(queries[0].Has("query"))
+	}
+}
+
+func (s *DatasetServiceTestSuite) TestSearchDatasets() {
+	var queries []url.Values
+	s.serveDatasetPages(3, &queries)
+
+	var count int
+	for _, err := range s.TestService.Search(context.Background(), "mouse brain") {
+		if !s.NoError(err) {
+			return
+		}
+		count++
+	}
+	s.Equal(3, count)
+	if s.Len(queries, 1) {
+		s.Equal("mouse brain", queries[0].Get("query"))
+	}
+}
+
 func TestDatasetServiceSuite(t *testing.T) {
 	suite.Run(t, new(DatasetServiceTestSuite))
 }
@@ -3,8 +3,8 @@ package pennsieve
 import (
 	"context"
 	"fmt"
-	"log"
 	"net/http"
+	"sync"
 
 	"github.com/pennsieve/pennsieve-go/pkg/pennsieve/models/discover"
 )
@@ -13,11 +13,13 @@ type DiscoverService interface {
 	GetDatasetByVersion(ctx context.Context, datasetId int32, versionId int32) (*discover.GetDatasetByVersionResponse, error)
 	GetDatasetMetadataByVersion(ctx context.Context, datasetId int32, versionId int32) (*discover.GetDatasetMetadataByVersionResponse, error)
 	GetDatasetFileByVersion(ctx context.Context, datasetId int32, versionId int32, filename string) (*discover.GetDatasetFileByVersionResponse, error)
+	SetBaseUrl(url string)
 }
 
 type discoverService struct {
 	Client  PennsieveHTTPClient
 	BaseUrl string
+	mu      sync.RWMutex // guards BaseUrl
 }
 
 func NewDiscoverService(client PennsieveHTTPClient, baseUrl string) *discoverService {
@@ -30,7 +32,7 @@ func NewDiscoverService(client PennsieveHTTPClient, baseUrl string) *discoverSer
 // GetDatasetByVersion returns a dataset by version.
 func (d *discoverService) GetDatasetByVersion(ctx context.Context, datasetId int32, versionId int32) (*discover.GetDatasetByVersionResponse, error) {
 	endpoint := fmt.Sprintf("%s/discover/datasets/%v/versions/%v",
-		d.BaseUrl, datasetId, versionId)
+		d.host(), datasetId, versionId)
 	req, err := http.NewRequest("GET", endpoint, nil)
 	if err != nil {
 		return nil, err
@@ -42,7 +44,7 @@ func (d *discoverService) GetDatasetByVersion(ctx context.Context, datasetId int
 
 	res := discover.GetDatasetByVersionResponse{}
 	if err := d.Client.sendUnauthenticatedRequest(ctx, req, &res); err != nil {
-		log.Println("DatasetService: SendRequest Error in GetDatasetByVersion: ", err)
+		d.Client.Logger().DebugContext(ctx, "DiscoverService.GetDatasetByVersion failed", "error", err)
 		return nil, err
 	}
 
@@ -52,7 +54,7 @@ func (d *discoverService) GetDatasetByVersion(ctx context.Context, datasetId int
 // GetDatasetMetadataByVersion returns dataset metadata by version.
 func (d *discoverService) GetDatasetMetadataByVersion(ctx context.Context, datasetId int32, versionId int32) (*discover.GetDatasetMetadataByVersionResponse, error) {
 	endpoint := fmt.Sprintf("%s/discover/datasets/%v/versions/%v/metadata",
-		d.BaseUrl, datasetId, versionId)
+		d.host(), datasetId, versionId)
 	req, err := http.NewRequest("GET", endpoint, nil)
 	if err != nil {
 		return nil, err
@@ -64,7 +66,7 @@ func (d *discoverService) GetDatasetMetadataByVersion(ctx context.Context, datas
 
 	res := discover.GetDatasetMetadataByVersionResponse{}
 	if err := d.Client.sendUnauthenticatedRequest(ctx, req, &res); err != nil {
-		log.Println("DatasetService: SendRequest Error in GetDatasetMetadataByVersion: ", err)
+		d.Client.Logger().DebugContext(ctx, "DiscoverService.GetDatasetMetadataByVersion failed", "error", err)
 		return nil, err
 	}
 
@@ -74,7 +76,7 @@ func (d *discoverService) GetDatasetMetadataByVersion(ctx context.Context, datas
 // GetDatasetFileByVersion returns a specific dataset file by version.
 func (d *discoverService) GetDatasetFileByVersion(ctx context.Context, datasetId int32, versionId int32, filename string) (*discover.GetDatasetFileByVersionResponse, error) {
 	endpoint := fmt.Sprintf("%s/discover/datasets/%v/versions/%v/files?path=%v",
-		d.BaseUrl, datasetId, versionId, filename)
+		d.host(), datasetId, versionId, filename)
 	req, err := http.NewRequest("GET", endpoint, nil)
 	if err != nil {
 		return nil, err
@@ -86,9 +88,21 @@ func (d *discoverService) GetDatasetFileByVersion(ctx context.Context, datasetId
 
 	res := discover.GetDatasetFileByVersionResponse{}
 	if err := d.Client.sendUnauthenticatedRequest(ctx, req, &res); err != nil {
-		log.Println("DatasetService: SendRequest Error in GetDatasetFileByVersion: ", err)
+		d.Client.Logger().DebugContext(ctx, "DiscoverService.GetDatasetFileByVersion failed", "error", err)
 		return nil, err
 	}
 
 	return &res, nil
 }
+
+func (d *discoverService) SetBaseUrl(url string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.BaseUrl = url
+}
+
+func (d *discoverService) host() string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.BaseUrl
+}
@@ -23,7 +23,9 @@ func (s *DiscoverServiceTestSuite) SetupTest() {
 	s.MockPennsieveServer = NewMockPennsieveServerDefault(s.T())
 	AWSEndpoints = AWSCognitoEndpoints{IdentityProviderEndpoint: s.IdProviderServer.URL}
 	client := NewClient(APIParams{
-		ApiHost: s.Server.URL,
+		ApiKey:    "test-key",
+		ApiSecret: "test-secret",
+		ApiHost:   s.Server.URL,
 	})
 	s.TestService = client.Discover
 }
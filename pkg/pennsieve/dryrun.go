@@ -0,0 +1,123 @@
+package pennsieve
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// PlannedRequest is a mutating call that a client in dry-run mode recorded
+// instead of sending. Secrets in URL and Body are redacted the way the
+// client's logs are.
+type PlannedRequest struct {
+	// Seq numbers the planned requests of a client from 1. Synthetic IDs
+	// returned in dry-run responses include it, e.g. "N:dataset:dry-run-3".
+	Seq int
+	// Operation is the service method, e.g. "DatasetService.Create".
+	Operation string
+	Method    string
+	URL       string
+	Body      string
+	Time      time.Time
+}
+
+func (p PlannedRequest) String() string {
+	return fmt.Sprintf("%s %s %s", p.Operation, p.Method, p.URL)
+}
+
+// dryRunID returns the synthetic ID of an object created by p.
+func (p PlannedRequest) dryRunID(kind string) string {
+	return fmt.Sprintf("N:%s:dry-run-%d", kind, p.Seq)
+}
+
+// SetDryRun turns dry-run mode on or off. In dry-run mode, calls that change
+// server state (creating datasets and accounts, posting manifests,
+// finalizing files, deleting accounts, requesting ECR access and switching
+// organizations, and requests other than GET and HEAD sent with Client.Do)
+// are not sent. They are appended to the plan returned by Plan and return a
+// synthetic response. Read-only calls still hit the API.
+func (c *Client) SetDryRun(enabled bool) {
+	c.dryRun.Store(enabled)
+}
+
+// DryRun reports whether the client is in dry-run mode.
+func (c *Client) DryRun() bool {
+	return c.dryRun.Load()
+}
+
+// Plan returns the requests recorded in dry-run mode, in the order they
+// were made.
+func (c *Client) Plan() []PlannedRequest {
+	c.planMu.Lock()
+	defer c.planMu.Unlock()
+	return append([]PlannedRequest(nil), c.plan...)
+}
+
+// ResetPlan clears the recorded plan. Sequence numbers keep counting.
+func (c *Client) ResetPlan() {
+	c.planMu.Lock()
+	defer c.planMu.Unlock()
+	c.plan = nil
+}
+
+// mutation marks a request as changing server state. fake builds the
+// synthetic response returned in dry-run mode; it may be nil for calls
+// without a response body.
+type mutation struct {
+	op   string
+	fake func(p PlannedRequest) any
+}
+
+type mutationKey struct{}
+
+// withMutation marks the request sent with ctx as the mutating operation
+// op.
+func withMutation(ctx context.Context, op string, fake func(p PlannedRequest) any) context.Context {
+	return context.WithValue(ctx, mutationKey{}, &mutation{op: op, fake: fake})
+}
+
+func mutationFrom(ctx context.Context) *mutation {
+	m, _ := ctx.Value(mutationKey{}).(*mutation)
+	return m
+}
+
+// planRequest records req in the plan and decodes m's synthetic response
+// into v.
+func (c *Client) planRequest(ctx context.Context, m *mutation, req *http.Request, v interface{}) error {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return err
+		}
+		req.Body.Close()
+	}
+
+	c.planMu.Lock()
+	c.planSeq++
+	p := PlannedRequest{
+		Seq:       c.planSeq,
+		Operation: m.op,
+		Method:    req.Method,
+		URL:       redactURL(req.URL.String()),
+		Body:      redactBody(body),
+		Time:      time.Now(),
+	}
+	c.plan = append(c.plan, p)
+	c.planMu.Unlock()
+
+	c.Logger().InfoContext(ctx, "dry run: request not sent",
+		"operation", p.Operation, "method", p.Method, "url", p.URL)
+
+	if m.fake == nil || v == nil {
+		return nil
+	}
+	data, err := json.Marshal(m.fake(p))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,188 @@
+package pennsieve
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/pennsieve/pennsieve-go-core/pkg/models/manifest"
+	"github.com/pennsieve/pennsieve-go-core/pkg/models/manifest/manifestFile"
+	"github.com/stretchr/testify/suite"
+)
+
+type DryRunTestSuite struct {
+	suite.Suite
+	MockCognitoServer
+	MockPennsieveServer
+	TestClient *Client
+}
+
+func (s *DryRunTestSuite) SetupTest() {
+	s.MockCognitoServer = NewMockCognitoServerDefault(s.T())
+	s.MockPennsieveServer = NewMockPennsieveServerDefault(s.T())
+	AWSEndpoints = AWSCognitoEndpoints{IdentityProviderEndpoint: s.IdProviderServer.URL}
+	s.TestClient = NewClient(APIParams{ApiHost: s.Server.URL, ApiHost2: s.Server.URL, ApiKey: "test-key", ApiSecret: "test-secret"})
+	s.TestClient.SetDryRun(true)
+}
+
+func (s *DryRunTestSuite) TearDownTest() {
+	s.MockCognitoServer.Close()
+	s.MockPennsieveServer.Close()
+	AWSEndpoints.Reset()
+}
+
+// Mutating calls have no handlers: the mock server fails the test if any of
+// them is sent.
+func (s *DryRunTestSuite) TestMutationsArePlannedNotSent() {
+	ctx := context.Background()
+
+	ds, err := s.TestClient.Dataset.Create(ctx, "migrated", "from the old system", `["a", "b"]`)
+	s.Require().NoError(err)
+	s.Equal("N:dataset:dry-run-1", ds.Content.ID)
+	s.Equal("migrated", ds.Content.Name)
+	s.Equal([]string{"a", "b"}, ds.Content.Tags)
+
+	m, err := s.TestClient.Manifest.Create(ctx, manifest.DTO{DatasetId: ds.Content.ID, Files: []manifestFile.FileDTO{
+		{UploadID: "u1"}, {UploadID: "u2"},
+	}})
+	s.Require().NoError(err)
+	s.Equal("N:manifest:dry-run-2", m.ManifestNodeId)
+	s.Equal(2, m.NrFilesUpdated)
+	s.Equal(manifestFile.Registered, m.UpdatedFiles[1].Status)
+
+	fin, err := s.TestClient.Manifest.FinalizeManifestFiles(ctx, ds.Content.ID, m.ManifestNodeId, []FinalizeFile{{UploadID: "u1", Size: 3}})
+	s.Require().NoError(err)
+	s.Equal([]FinalizeResult{{UploadID: "u1", Status: "finalized"}}, fin.Results)
+
+	acct, err := s.TestClient.Account.CreateAccount(ctx, "123456789012", "aws", "role", "ext")
+	s.Require().NoError(err)
+	s.Equal("dry-run-4", acct.Uuid)
+
+	deleted, err := s.TestClient.Account.DeleteAccount(ctx, "acct-uuid", true)
+	s.Require().NoError(err)
+	s.Equal("acct-uuid", deleted.Uuid)
+
+	s.NoError(s.TestClient.Account.RequestEcrAccess(ctx, "123456789012", "aws"))
+	s.NoError(s.TestClient.User.SwitchOrganization(ctx, "N:organization:other"))
+
+	plan := s.TestClient.Plan()
+	var ops []string
+	for _, p := range plan {
+		ops = append(ops, p.Operation)
+	}
+	s.Equal([]string{
+		"DatasetService.Create",
+		"ManifestService.Create",
+		"ManifestService.FinalizeManifestFiles",
+		"AccountService.CreateAccount",
+		"AccountService.DeleteAccount",
+		"AccountService.RequestEcrAccess",
+		"UserService.SwitchOrganization",
+	}, ops)
+
+	s.Equal(http.MethodPost, plan[0].Method)
+	s.Equal(s.Server.URL+"/datasets/", plan[0].URL)
+	s.Contains(plan[0].Body, `"name": "migrated"`)
+	s.Equal(http.MethodDelete, plan[4].Method)
+	s.Equal(s.Server.URL+"/compute/resources/accounts/acct-uuid?force=true", plan[4].URL)
+	s.Equal(http.MethodPut, plan[6].Method)
+	s.Contains(plan[6].URL, "organization_id=N:organization:other")
+}
+
+func (s *DryRunTestSuite) TestDoDelete() {
+	req, err := http.NewRequest(http.MethodDelete, s.Server.URL+"/datasets/N:dataset:1", nil)
+	s.Require().NoError(err)
+
+	res, err := s.TestClient.Do(context.Background(), req)
+	s.Require().NoError(err)
+	defer res.Body.Close()
+	s.Equal(http.StatusNoContent, res.StatusCode)
+
+	plan := s.TestClient.Plan()
+	if s.Len(plan, 1) {
+		s.Equal("Client.Do", plan[0].Operation)
+		s.Equal(http.MethodDelete, plan[0].Method)
+		s.Equal(s.Server.URL+"/datasets/N:dataset:1", plan[0].URL)
+	}
+}
+
+func (s *DryRunTestSuite) TestPlanIsRedacted() {
+	ctx := context.Background()
+	_, err := PostJSON[any](ctx, s.TestClient, "/credentials?api_key=key&token=secret-token&page=2", map[string]any{
+		"name":   "kept",
+		"secret": "api-secret",
+		"nested": map[string]any{"refreshToken": "refresh", "note": "Bearer abc.def"},
+	})
+	s.Require().NoError(err)
+
+	plan := s.TestClient.Plan()
+	s.Require().Len(plan, 1)
+	s.NotContains(plan[0].URL, "secret-token")
+	s.NotContains(plan[0].URL, "api_key=key")
+	s.Contains(plan[0].URL, "page=2")
+	s.JSONEq(`{"name": "kept", "secret": "[REDACTED]", "nested": {"refreshToken": "[REDACTED]", "note": "Bearer [REDACTED]"}}`, plan[0].Body)
+}
+
+func (s *DryRunTestSuite) TestReadsStillHitTheAPI() {
+	var gets int
+	s.Mux.HandleFunc("/datasets/N:dataset:1", func(writer http.ResponseWriter, request *http.Request) {
+		gets++
+		_, _ = writer.Write([]byte(`{"content": {"name": "real"}}`))
+	})
+	storage := 0
+	s.Mux.HandleFunc("/upload/manifest/storage-credentials", func(writer http.ResponseWriter, request *http.Request) {
+		storage++
+		_, _ = writer.Write([]byte(`{"bucket": "b"}`))
+	})
+
+	ds, err := s.TestClient.Dataset.Get(context.Background(), "N:dataset:1")
+	s.Require().NoError(err)
+	s.Equal("real", ds.Content.Name)
+	s.Equal(1, gets)
+
+	// A POST, but it does not change anything.
+	_, err = s.TestClient.Manifest.GetStorageCredentials(context.Background(), "N:dataset:1", "N:manifest:1")
+	s.Require().NoError(err)
+	s.Equal(1, storage)
+	s.Empty(s.TestClient.Plan())
+}
+
+func (s *DryRunTestSuite) TestDisablingSendsRequests() {
+	var posts int
+	s.Mux.HandleFunc("/datasets/", func(writer http.ResponseWriter, request *http.Request) {
+		posts++
+		_, _ = writer.Write([]byte(`{"content": {"id": "N:dataset:real"}}`))
+	})
+
+	_, err := s.TestClient.Dataset.Create(context.Background(), "planned", "", "[]")
+	s.Require().NoError(err)
+	s.Len(s.TestClient.Plan(), 1)
+	s.TestClient.ResetPlan()
+	s.Empty(s.TestClient.Plan())
+
+	s.TestClient.SetDryRun(false)
+	s.False(s.TestClient.DryRun())
+	ds, err := s.TestClient.Dataset.Create(context.Background(), "real", "", "[]")
+	s.Require().NoError(err)
+	s.Equal("N:dataset:real", ds.Content.ID)
+	s.Equal(1, posts)
+	s.Empty(s.TestClient.Plan())
+}
+
+func TestDryRunSuite(t *testing.T) {
+	suite.Run(t, new(DryRunTestSuite))
+}
+
+func TestWithDryRun(t *testing.T) {
+	client, err := NewClientWithOptions(
+		WithHTTPClient(&http.Client{Transport: failingTransport{t}}),
+		WithCredentials("key", "secret"),
+		WithDryRun(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !client.DryRun() {
+		t.Error("WithDryRun should enable dry-run mode")
+	}
+}
@@ -0,0 +1,205 @@
+package pennsieve
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentity"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	"github.com/pennsieve/pennsieve-go/pkg/pennsieve/models/authentication"
+)
+
+// Names of the built-in environments.
+const (
+	EnvironmentProd  = "prod"
+	EnvironmentDev   = "dev"
+	EnvironmentLocal = "local"
+)
+
+// ErrUnknownEnvironment is returned when selecting an environment that has
+// not been registered.
+var ErrUnknownEnvironment = errors.New("unknown environment")
+
+// Environment is a Pennsieve deployment: the hosts of its APIs, its upload
+// bucket and where to reach its Cognito pools.
+type Environment struct {
+	Name         string
+	ApiHost      string
+	ApiHost2     string
+	UploadBucket string
+
+	// Cognito overrides the AWS Cognito endpoints. Leave it empty to use
+	// AWS's endpoints for the region.
+	Cognito AWSCognitoEndpoints
+	// CognitoConfig, if set, is used instead of fetching the Cognito pools
+	// from the API's /authentication/cognito-config.
+	CognitoConfig *authentication.CognitoConfig
+}
+
+var (
+	environmentsMu sync.RWMutex
+	environments   = map[string]Environment{
+		EnvironmentProd: {
+			Name:         EnvironmentProd,
+			ApiHost:      BaseURLV1,
+			ApiHost2:     BaseURLV2,
+			UploadBucket: DefaultUploadBucket,
+		},
+		EnvironmentDev: {
+			Name:         EnvironmentDev,
+			ApiHost:      "https://api.pennsieve.net",
+			ApiHost2:     "https://api2.pennsieve.net",
+			UploadBucket: "pennsieve-dev-uploads-v2-use1",
+		},
+		// A stack running on this machine, with Cognito emulated by
+		// cognito-local on its default port.
+		EnvironmentLocal: {
+			Name:         EnvironmentLocal,
+			ApiHost:      "http://localhost:8080",
+			ApiHost2:     "http://localhost:8080",
+			UploadBucket: "pennsieve-local-uploads",
+			Cognito: AWSCognitoEndpoints{
+				IdentityProviderEndpoint: "http://localhost:9229",
+				IdentityEndpoint:         "http://localhost:9229",
+			},
+		},
+	}
+)
+
+// RegisterEnvironment adds env to the environments that clients can select
+// with WithEnvironment and Client.SetEnvironment, replacing any environment
+// with the same name, built-in ones included.
+func RegisterEnvironment(env Environment) error {
+	if env.Name == "" {
+		return errors.New("environment name is required")
+	}
+	if env.ApiHost == "" || env.ApiHost2 == "" {
+		return fmt.Errorf("environment %q: both API hosts are required", env.Name)
+	}
+
+	environmentsMu.Lock()
+	defer environmentsMu.Unlock()
+	environments[env.Name] = env
+	return nil
+}
+
+// LookupEnvironment returns the registered environment called name.
+func LookupEnvironment(name string) (Environment, error) {
+	environmentsMu.RLock()
+	defer environmentsMu.RUnlock()
+	env, ok := environments[name]
+	if !ok {
+		return Environment{}, fmt.Errorf("%w %q", ErrUnknownEnvironment, name)
+	}
+	return env, nil
+}
+
+// Environments returns the names of the registered environments, sorted.
+func Environments() []string {
+	environmentsMu.RLock()
+	defer environmentsMu.RUnlock()
+	names := make([]string, 0, len(environments))
+	for name := range environments {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// apply sets the hosts and upload bucket of params to env's.
+func (env Environment) apply(params APIParams) APIParams {
+	params.ApiHost = env.ApiHost
+	params.ApiHost2 = env.ApiHost2
+	params.UploadBucket = env.UploadBucket
+	return params
+}
+
+// Environment returns the name of the environment selected with
+// WithEnvironment or SetEnvironment, or "" if the client's hosts were set
+// directly.
+func (c *Client) Environment() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.environment
+}
+
+// SetEnvironment switches the client to the registered environment called
+// name. Every service is pointed at the environment's hosts, the upload
+// bucket is replaced, and the session and organization are dropped so the
+// next call authenticates against the environment's Cognito pools, whose
+// config is loaded again. Credentials are kept.
+//
+// The switch waits for any token refresh in progress, so a refresh started
+// against the previous environment cannot overwrite the cleared session.
+// Requests already in flight complete against the previous environment.
+func (c *Client) SetEnvironment(name string) error {
+	env, err := LookupEnvironment(name)
+	if err != nil {
+		return err
+	}
+
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	c.mu.Lock()
+	params := env.apply(c.aPIParams)
+	c.aPIParams = params
+	c.environment = env.Name
+	c.APISession = APISession{}
+	c.OrganizationId = 0
+	c.OrganizationNodeId = ""
+	c.mu.Unlock()
+
+	c.setServiceHosts(params)
+	if auth, ok := c.Authentication.(*authenticationService); ok {
+		auth.useEnvironment(env)
+	}
+	c.Logger().Info("switched environment", "environment", env.Name, "api_host", params.ApiHost)
+	return nil
+}
+
+// useEnvironment points the service at env's Cognito endpoints and drops the
+// cached Cognito config, or replaces it with env's, and the key sets fetched
+// for the previous pools.
+func (s *authenticationService) useEnvironment(env Environment) {
+	s.resetKeys()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.awsConfig = withCognitoEndpoints(s.baseAWSConfig, env.Cognito)
+	s.config = authentication.CognitoConfig{}
+	s.configLoaded = false
+	if env.CognitoConfig != nil {
+		s.config = *env.CognitoConfig
+		s.configLoaded = true
+	}
+}
+
+// withCognitoEndpoints returns a copy of cfg that sends Cognito calls to the
+// endpoints set in e. Other services, and Cognito services without an
+// endpoint in e, resolve as they do with cfg.
+func withCognitoEndpoints(cfg aws.Config, e AWSCognitoEndpoints) aws.Config {
+	if e.IsEmpty() {
+		return cfg
+	}
+	endpointMap := map[string]string{
+		cognitoidentityprovider.ServiceID: e.IdentityProviderEndpoint,
+		cognitoidentity.ServiceID:         e.IdentityEndpoint,
+	}
+	next := cfg.EndpointResolverWithOptions
+	cfg.EndpointResolverWithOptions = aws.EndpointResolverWithOptionsFunc(func(service string, region string, options ...interface{}) (aws.Endpoint, error) {
+		if endpoint := endpointMap[service]; endpoint != "" {
+			return aws.Endpoint{
+				URL: endpoint,
+			}, nil
+		}
+		if next != nil {
+			return next.ResolveEndpoint(service, region, options...)
+		}
+		// Returning EndpointNotFoundError will cause service to fallback to default
+		return aws.Endpoint{}, &aws.EndpointNotFoundError{}
+	})
+	return cfg
+}
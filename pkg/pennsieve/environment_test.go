@@ -0,0 +1,301 @@
+package pennsieve
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestBuiltinEnvironments(t *testing.T) {
+	prod, err := LookupEnvironment(EnvironmentProd)
+	if assert.NoError(t, err) {
+		assert.Equal(t, BaseURLV1, prod.ApiHost)
+		assert.Equal(t, BaseURLV2, prod.ApiHost2)
+		assert.Equal(t, DefaultUploadBucket, prod.UploadBucket)
+		assert.True(t, prod.Cognito.IsEmpty())
+	}
+
+	dev, err := LookupEnvironment(EnvironmentDev)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "https://api.pennsieve.net", dev.ApiHost)
+	}
+
+	assert.Subset(t, Environments(), []string{EnvironmentDev, EnvironmentLocal, EnvironmentProd})
+
+	_, err = LookupEnvironment("staging")
+	assert.ErrorIs(t, err, ErrUnknownEnvironment)
+}
+
+func TestRegisterEnvironmentValidates(t *testing.T) {
+	assert.Error(t, RegisterEnvironment(Environment{ApiHost: "https://a", ApiHost2: "https://b"}))
+	assert.Error(t, RegisterEnvironment(Environment{Name: "half", ApiHost: "https://a"}))
+	_, err := LookupEnvironment("half")
+	assert.ErrorIs(t, err, ErrUnknownEnvironment)
+}
+
+func TestWithUnknownEnvironment(t *testing.T) {
+	_, err := NewClientWithOptions(WithEnvironment("staging"))
+	assert.ErrorIs(t, err, ErrUnknownEnvironment)
+}
+
+// testEnvironment is a registered environment backed by its own mock API and
+// Cognito. Tokens issued by its Cognito carry orgNodeId.
+type testEnvironment struct {
+	MockCognitoServer
+	MockPennsieveServer
+	Environment
+	orgNodeId     string
+	configFetches atomic.Int32
+	datasetGets   atomic.Int32
+	channelGets   atomic.Int32
+	discoverGets  atomic.Int32
+}
+
+func newTestEnvironment(t *testing.T, name string) *testEnvironment {
+	e := &testEnvironment{orgNodeId: "N:organization:" + name}
+	e.MockCognitoServer = NewMockCognitoServer(t, mockCognitoConfig, map[string]any{
+		OrgNodeIdClaimKey: e.orgNodeId,
+		OrgIdClaimKey:     "1",
+	})
+	e.MockPennsieveServer = NewMockPennsieveServerDefault(t)
+	e.Environment = Environment{
+		Name:         name,
+		ApiHost:      e.Server.URL,
+		ApiHost2:     e.Server.URL,
+		UploadBucket: name + "-uploads",
+		Cognito:      AWSCognitoEndpoints{IdentityProviderEndpoint: e.IdProviderServer.URL},
+	}
+
+	// Count cognito-config fetches, then defer to the default handler.
+	configHandler, _ := e.Mux.Handler(httptest.NewRequest(http.MethodGet, "/authentication/cognito-config", nil))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/authentication/cognito-config", func(w http.ResponseWriter, r *http.Request) {
+		e.configFetches.Add(1)
+		configHandler.ServeHTTP(w, r)
+	})
+	mux.Handle("/", e.Mux)
+	e.Server.Config.Handler = mux
+
+	e.Mux.HandleFunc("/datasets/N:dataset:1", func(w http.ResponseWriter, r *http.Request) {
+		e.datasetGets.Add(1)
+		_, _ = w.Write([]byte(`{"content": {"name": "` + name + `"}}`))
+	})
+	e.Mux.HandleFunc("/datasets/manifest", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{}`))
+	})
+	e.Mux.HandleFunc("/timeseries/package/N:package:1/channels", func(w http.ResponseWriter, r *http.Request) {
+		e.channelGets.Add(1)
+		_, _ = w.Write([]byte(`[]`))
+	})
+	e.Mux.HandleFunc("/discover/datasets/1/versions/1", func(w http.ResponseWriter, r *http.Request) {
+		e.discoverGets.Add(1)
+		_, _ = w.Write([]byte(`{}`))
+	})
+
+	if err := RegisterEnvironment(e.Environment); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		environmentsMu.Lock()
+		delete(environments, name)
+		environmentsMu.Unlock()
+		e.MockCognitoServer.Close()
+		e.MockPennsieveServer.Close()
+	})
+	return e
+}
+
+type EnvironmentTestSuite struct {
+	suite.Suite
+	a, b       *testEnvironment
+	TestClient *Client
+}
+
+func (s *EnvironmentTestSuite) SetupTest() {
+	s.a = newTestEnvironment(s.T(), "test-a")
+	s.b = newTestEnvironment(s.T(), "test-b")
+
+	client, err := NewClientWithOptions(
+		WithEnvironment("test-a"),
+		WithCredentials("test-key", "test-secret"),
+	)
+	s.Require().NoError(err)
+	s.TestClient = client
+}
+
+// callAll makes a call through the services that use each host.
+func (s *EnvironmentTestSuite) callAll() string {
+	ctx := context.Background()
+	ds, err := s.TestClient.Dataset.Get(ctx, "N:dataset:1")
+	s.Require().NoError(err)
+	_, err = s.TestClient.Timeseries.GetChannels(ctx, "N:dataset:1", "N:package:1")
+	s.Require().NoError(err)
+	_, err = s.TestClient.Discover.GetDatasetByVersion(ctx, 1, 1)
+	s.Require().NoError(err)
+	return ds.Content.Name
+}
+
+func (s *EnvironmentTestSuite) TestWithEnvironment() {
+	s.Equal("test-a", s.TestClient.Environment())
+	params := s.TestClient.GetAPIParams()
+	s.Equal(s.a.Server.URL, params.ApiHost)
+	s.Equal("test-a-uploads", params.UploadBucket)
+
+	s.Equal("test-a", s.callAll())
+	_, orgNodeId := s.TestClient.GetOrganization()
+	s.Equal(s.a.orgNodeId, orgNodeId, "the environment's Cognito should issue the token")
+}
+
+func (s *EnvironmentTestSuite) TestSetEnvironment() {
+	s.callAll()
+	s.Equal(int32(1), s.a.configFetches.Load())
+
+	s.Require().NoError(s.TestClient.SetEnvironment("test-b"))
+	s.Equal("test-b", s.TestClient.Environment())
+	s.Empty(s.TestClient.GetSession().Token, "the session should be dropped")
+	s.Empty(s.TestClient.Authentication.(*authenticationService).keySets, "the keys of the previous pools should be dropped")
+	_, orgNodeId := s.TestClient.GetOrganization()
+	s.Empty(orgNodeId)
+	params := s.TestClient.GetAPIParams()
+	s.Equal(s.b.Server.URL, params.ApiHost2)
+	s.Equal("test-b-uploads", params.UploadBucket)
+	s.Equal("test-key", params.ApiKey, "credentials should be kept")
+
+	s.Equal("test-b", s.callAll())
+	_, orgNodeId = s.TestClient.GetOrganization()
+	s.Equal(s.b.orgNodeId, orgNodeId)
+	s.Equal(int32(1), s.b.configFetches.Load(), "the Cognito config should be loaded from the new environment")
+
+	for _, e := range []*testEnvironment{s.a, s.b} {
+		s.Equal(int32(1), e.datasetGets.Load(), e.Name)
+		s.Equal(int32(1), e.channelGets.Load(), e.Name)
+		s.Equal(int32(1), e.discoverGets.Load(), e.Name)
+	}
+}
+
+func (s *EnvironmentTestSuite) TestSetUnknownEnvironment() {
+	s.callAll()
+	session := s.TestClient.GetSession()
+
+	s.ErrorIs(s.TestClient.SetEnvironment("staging"), ErrUnknownEnvironment)
+	s.Equal("test-a", s.TestClient.Environment())
+	s.Equal(session, s.TestClient.GetSession())
+}
+
+func (s *EnvironmentTestSuite) TestUpdateparamsClearsEnvironment() {
+	// Outside an environment the client authenticates with b's Cognito.
+	client, err := NewClientWithOptions(
+		WithEnvironment("test-a"),
+		WithCredentials("test-key", "test-secret"),
+		WithAWSConfig(newTestAWSConfig(s.b.IdProviderServer.URL)),
+	)
+	s.Require().NoError(err)
+	s.TestClient = client
+	s.Equal("test-a", s.callAll())
+	auth := s.TestClient.Authentication.(*authenticationService)
+	s.NotEmpty(auth.keySets)
+
+	params := *s.TestClient.GetAPIParams()
+	params.ApiHost, params.ApiHost2 = s.b.Server.URL, s.b.Server.URL
+	s.TestClient.Updateparams(params)
+	s.Empty(s.TestClient.Environment())
+	s.Empty(s.TestClient.GetSession().Token)
+	s.Empty(auth.keySets, "the keys of environment a's pools should be dropped")
+
+	// Every service, including Timeseries and Discover, follows the new
+	// hosts, and the session comes from the client's own Cognito with the
+	// config of the new hosts rather than environment a's.
+	s.Equal("test-b", s.callAll())
+	s.Equal(int32(1), s.b.channelGets.Load())
+	s.Equal(int32(1), s.b.discoverGets.Load())
+	s.Equal(int32(1), s.a.datasetGets.Load())
+	s.Equal(int32(1), s.b.configFetches.Load(), "the Cognito config should be loaded again")
+	_, orgNodeId := s.TestClient.GetOrganization()
+	s.Equal(s.b.orgNodeId, orgNodeId)
+}
+
+// TestUpdateparamsWaitsForRefresh changes the credentials while a token
+// refresh is in flight. The update waits for the refresh, so the session
+// obtained with the previous credentials does not outlive the update.
+func (s *EnvironmentTestSuite) TestUpdateparamsWaitsForRefresh() {
+	retrieving := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	s.TestClient.SetCredentialsProvider(CredentialsProviderFunc(func(ctx context.Context) (Credentials, error) {
+		once.Do(func() { close(retrieving) })
+		<-release
+		return Credentials{ApiKey: "test-key", ApiSecret: "test-secret", Source: CredentialsSourceParams}, nil
+	}))
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.TestClient.Dataset.Get(context.Background(), "N:dataset:1")
+		done <- err
+	}()
+	<-retrieving
+
+	updated := make(chan struct{})
+	go func() {
+		params := *s.TestClient.GetAPIParams()
+		params.ApiKey = "other-key"
+		s.TestClient.Updateparams(params)
+		close(updated)
+	}()
+	select {
+	case <-updated:
+		s.Fail("Updateparams should wait for the refresh in progress")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	s.NoError(<-done)
+	<-updated
+	s.Empty(s.TestClient.GetSession().Token, "the session of the previous credentials should be dropped")
+}
+
+// TestSwitchDuringRequests switches environments while requests are in
+// flight. Requests may fail while the session is swapped; run with -race to
+// check that hosts are switched without data races.
+func (s *EnvironmentTestSuite) TestSwitchDuringRequests() {
+	s.TestClient.SetLogger(nil)
+	ctx := context.Background()
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				_, _ = s.TestClient.Dataset.Get(ctx, "N:dataset:1")
+				_, _ = s.TestClient.Dataset.GetManifest(ctx, "N:dataset:1")
+				_, _ = s.TestClient.Timeseries.GetChannels(ctx, "N:dataset:1", "N:package:1")
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		s.Require().NoError(s.TestClient.SetEnvironment([]string{"test-b", "test-a"}[i%2]))
+		params := *s.TestClient.GetAPIParams()
+		s.TestClient.Updateparams(params)
+	}
+	close(stop)
+	wg.Wait()
+
+	s.Require().NoError(s.TestClient.SetEnvironment("test-b"))
+	s.Equal("test-b", s.callAll())
+}
+
+func TestEnvironmentSuite(t *testing.T) {
+	suite.Run(t, new(EnvironmentTestSuite))
+}
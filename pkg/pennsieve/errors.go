@@ -0,0 +1,255 @@
+package pennsieve
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// Sentinel errors for common API failures. An *HTTPError matches the
+// sentinel for its status code with errors.Is:
+//
+//	if errors.Is(err, pennsieve.ErrNotFound) { ... }
+var (
+	ErrNotFound     = errors.New("not found")    // 404
+	ErrUnauthorized = errors.New("unauthorized") // 401
+	ErrForbidden    = errors.New("forbidden")    // 403
+	ErrConflict     = errors.New("conflict")     // 409
+	ErrRateLimited  = errors.New("rate limited") // 429
+	ErrServer       = errors.New("server error") // 5xx
+)
+
+const (
+	// maxErrorBodySnippet is how much of an error or undecodable response
+	// body, or of an error message, is kept on HTTPError and DecodeError.
+	maxErrorBodySnippet = 1024
+	// maxErrorBodySize is how much of an error response body is read.
+	maxErrorBodySize = 64 << 10
+)
+
+// DefaultMaxResponseBodySize is the default Client.MaxResponseBodySize.
+const DefaultMaxResponseBodySize = 1 << 30
+
+// HTTPError is returned when the server responds with an unexpected status.
+// Callers that need to branch on the status can use errors.Is with the
+// sentinel errors above, or errors.As(err, &httpErr) for the details.
+type HTTPError struct {
+	StatusCode int
+	Message    string // "message" from the JSON error body, if any
+
+	Method    string
+	URL       string // with credential-bearing query parameters redacted
+	RequestID string // request ID assigned by the API gateway, if any
+	Body      string // start of the response body, redacted
+}
+
+func (e *HTTPError) Error() string {
+	var b strings.Builder
+	if e.Method != "" {
+		fmt.Fprintf(&b, "%s %s: ", e.Method, e.URL)
+	}
+	fmt.Fprintf(&b, "http status %d", e.StatusCode)
+	if e.Message != "" {
+		b.WriteString(": " + e.Message)
+	}
+	if e.RequestID != "" {
+		b.WriteString(" (request id " + e.RequestID + ")")
+	}
+	return b.String()
+}
+
+// Is reports whether the status code corresponds to target, one of the
+// sentinel errors.
+func (e *HTTPError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrServer:
+		return e.StatusCode >= 500 && e.StatusCode < 600
+	}
+	return false
+}
+
+// Temporary reports whether the request may succeed if tried again later,
+// i.e. the status is one the client retries (429, 502, 503, 504). When a
+// Temporary error is returned the client's RetryPolicy was already
+// exhausted.
+func (e *HTTPError) Temporary() bool {
+	return isRetryableStatus(e.StatusCode)
+}
+
+// newHTTPError builds an HTTPError from a response with an unexpected status.
+// It reads (part of) the body but does not close it.
+func newHTTPError(res *http.Response) *HTTPError {
+	e := &HTTPError{StatusCode: res.StatusCode}
+	if req := res.Request; req != nil {
+		e.Method = req.Method
+		e.URL = redactURL(req.URL.String())
+	}
+	e.RequestID = serverRequestID(res)
+
+	body, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorBodySize))
+	e.Body = bodySnippet(body)
+
+	var errRes errorResponse
+	if err := json.Unmarshal(body, &errRes); err == nil {
+		e.Message = string(truncateUTF8([]byte(errRes.Message), maxErrorBodySnippet))
+	}
+	return e
+}
+
+// DecodeError is returned when a successful response body cannot be decoded
+// into the expected type.
+type DecodeError struct {
+	StatusCode  int
+	Method      string
+	URL         string // with credential-bearing query parameters redacted
+	ContentType string
+	Body        string // start of the response body, redacted
+	Err         error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%s %s: error decoding %d response (%s): %v",
+		e.Method, e.URL, e.StatusCode, e.ContentType, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Sentinel errors for authentication failures, matched by TokenError and
+// CognitoError.
+var (
+	ErrInvalidToken  = errors.New("invalid token")
+	ErrCognitoFailed = errors.New("cognito request failed")
+)
+
+// TokenError is returned when a token issued by Cognito is malformed or
+// lacks a claim the client needs. It matches ErrInvalidToken.
+type TokenError struct {
+	Claim  string // the offending claim, if any
+	Reason string
+	Err    error
+}
+
+func (e *TokenError) Error() string {
+	msg := "invalid token: "
+	if e.Claim != "" {
+		msg += "claim " + e.Claim + ": "
+	}
+	msg += e.Reason
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *TokenError) Unwrap() error {
+	return e.Err
+}
+
+func (e *TokenError) Is(target error) bool {
+	return target == ErrInvalidToken
+}
+
+// CognitoError is returned when a call to Cognito fails or returns an
+// unusable response. Err is the AWS error, so errors.As can reach the
+// Cognito exception types. It matches ErrCognitoFailed.
+type CognitoError struct {
+	Operation string // e.g. "InitiateAuth"
+	Err       error
+}
+
+func (e *CognitoError) Error() string {
+	return fmt.Sprintf("cognito %s: %v", e.Operation, e.Err)
+}
+
+func (e *CognitoError) Unwrap() error {
+	return e.Err
+}
+
+func (e *CognitoError) Is(target error) bool {
+	return target == ErrCognitoFailed
+}
+
+// decodeResponse decodes the JSON body of res into v. A nil v discards the
+// body.
+func decodeResponse(res *http.Response, v interface{}, limit int64) error {
+	if v == nil {
+		return nil
+	}
+	body := &io.LimitedReader{R: res.Body, N: limit + 1}
+	head := &headWriter{n: maxErrorBodySnippet}
+	err := json.NewDecoder(io.TeeReader(body, head)).Decode(v)
+	if body.N <= 0 {
+		err = fmt.Errorf("response body exceeds %d bytes", limit)
+	}
+	if err == nil {
+		return nil
+	}
+
+	e := &DecodeError{
+		StatusCode:  res.StatusCode,
+		ContentType: res.Header.Get("Content-Type"),
+		Body:        bodySnippet(head.buf),
+		Err:         err,
+	}
+	if req := res.Request; req != nil {
+		e.Method = req.Method
+		e.URL = redactURL(req.URL.String())
+	}
+	return e
+}
+
+// readResponseBody reads a response body of at most limit bytes. A larger
+// body is an error; what was read is returned with it.
+func readResponseBody(r io.Reader, limit int64) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err == nil && int64(len(body)) > limit {
+		return body[:limit], fmt.Errorf("response body exceeds %d bytes", limit)
+	}
+	return body, err
+}
+
+// headWriter keeps the first n bytes written to it.
+type headWriter struct {
+	buf []byte
+	n   int
+}
+
+func (w *headWriter) Write(p []byte) (int, error) {
+	if room := w.n - len(w.buf); room > 0 {
+		w.buf = append(w.buf, p[:min(room, len(p))]...)
+	}
+	return len(p), nil
+}
+
+// bodySnippet returns at most maxErrorBodySnippet bytes of body, cut at a
+// rune boundary, with tokens redacted.
+func bodySnippet(body []byte) string {
+	return redactString(strings.TrimSpace(string(truncateUTF8(body, maxErrorBodySnippet))))
+}
+
+// truncateUTF8 returns at most n bytes of b, cut at a rune boundary.
+func truncateUTF8(b []byte, n int) []byte {
+	if len(b) > n {
+		b = b[:n]
+		for len(b) > 0 && !utf8.Valid(b) {
+			b = b[:len(b)-1]
+		}
+	}
+	return b
+}
@@ -0,0 +1,210 @@
+package pennsieve
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestHTTPErrorIs(t *testing.T) {
+	sentinels := []error{ErrNotFound, ErrUnauthorized, ErrForbidden, ErrConflict, ErrRateLimited, ErrServer}
+	tests := []struct {
+		status    int
+		want      error
+		temporary bool
+	}{
+		{http.StatusNotFound, ErrNotFound, false},
+		{http.StatusUnauthorized, ErrUnauthorized, false},
+		{http.StatusForbidden, ErrForbidden, false},
+		{http.StatusConflict, ErrConflict, false},
+		{http.StatusTooManyRequests, ErrRateLimited, true},
+		{http.StatusInternalServerError, ErrServer, false},
+		{http.StatusBadGateway, ErrServer, true},
+		{http.StatusServiceUnavailable, ErrServer, true},
+		{http.StatusBadRequest, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.status), func(t *testing.T) {
+			err := fmt.Errorf("wrapped: %w", &HTTPError{StatusCode: tt.status})
+			for _, sentinel := range sentinels {
+				assert.Equal(t, sentinel == tt.want, errors.Is(err, sentinel), "errors.Is(%d, %v)", tt.status, sentinel)
+			}
+			var httpErr *HTTPError
+			if assert.ErrorAs(t, err, &httpErr) {
+				assert.Equal(t, tt.temporary, httpErr.Temporary())
+			}
+		})
+	}
+}
+
+func TestHTTPErrorMessage(t *testing.T) {
+	assert.EqualError(t, &HTTPError{StatusCode: 404}, "http status 404")
+	assert.EqualError(t, &HTTPError{
+		StatusCode: 404,
+		Message:    "no such dataset",
+		Method:     "GET",
+		URL:        "https://api.pennsieve.io/datasets/N:dataset:1",
+		RequestID:  "abc-123",
+	}, "GET https://api.pennsieve.io/datasets/N:dataset:1: http status 404: no such dataset (request id abc-123)")
+}
+
+func TestBodySnippetTruncates(t *testing.T) {
+	body := strings.Repeat("é", maxErrorBodySnippet)
+	snippet := bodySnippet([]byte(body))
+	assert.LessOrEqual(t, len(snippet), maxErrorBodySnippet)
+	assert.True(t, strings.HasPrefix(body, snippet), "snippet should end on a rune boundary")
+}
+
+// countingReader is an endless body that counts the bytes read from it.
+type countingReader struct{ n int }
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = ' '
+	}
+	r.n += len(p)
+	return len(p), nil
+}
+
+func TestErrorBodiesAreBounded(t *testing.T) {
+	// A large error body: the message is still parsed, but only a snippet of
+	// it is kept.
+	message := strings.Repeat("m", 4*maxErrorBodySnippet)
+	body := `{"padding": "` + strings.Repeat("p", 8*maxErrorBodySnippet) + `", "message": "` + message + `"}`
+	e := newHTTPError(&http.Response{StatusCode: 500, Body: io.NopCloser(strings.NewReader(body))})
+	assert.Equal(t, message[:maxErrorBodySnippet], e.Message)
+	assert.LessOrEqual(t, len(e.Body), maxErrorBodySnippet)
+
+	endless := &countingReader{}
+	e = newHTTPError(&http.Response{StatusCode: 500, Body: io.NopCloser(endless)})
+	assert.Equal(t, maxErrorBodySize, endless.n, "an error body should be read up to maxErrorBodySize")
+	assert.Empty(t, e.Body)
+
+	const limit = 1 << 20
+	endless = &countingReader{}
+	var v map[string]any
+	err := decodeResponse(&http.Response{StatusCode: 200, Body: io.NopCloser(endless)}, &v, limit)
+	var decodeErr *DecodeError
+	if assert.ErrorAs(t, err, &decodeErr) {
+		assert.ErrorContains(t, decodeErr, "response body exceeds")
+		assert.LessOrEqual(t, len(decodeErr.Body), maxErrorBodySnippet)
+	}
+	assert.Equal(t, limit+1, endless.n)
+}
+
+// TestDecodeResponseStreams checks that a response is decoded as it is read
+// rather than buffered: decoding stops after the JSON value.
+func TestDecodeResponseStreams(t *testing.T) {
+	endless := &countingReader{}
+	body := io.MultiReader(strings.NewReader(`{"name": "streamed"}`), endless)
+	var v struct{ Name string }
+	assert.NoError(t, decodeResponse(&http.Response{StatusCode: 200, Body: io.NopCloser(body)}, &v, DefaultMaxResponseBodySize))
+	assert.Equal(t, "streamed", v.Name)
+	assert.Less(t, endless.n, 1<<20)
+
+	err := decodeResponse(&http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"name": oops}`))}, &v, DefaultMaxResponseBodySize)
+	var decodeErr *DecodeError
+	if assert.ErrorAs(t, err, &decodeErr) {
+		assert.Equal(t, `{"name": oops}`, decodeErr.Body)
+	}
+}
+
+type ErrorsTestSuite struct {
+	suite.Suite
+	MockCognitoServer
+	MockPennsieveServer
+	TestClient *Client
+}
+
+func (s *ErrorsTestSuite) SetupTest() {
+	s.MockCognitoServer = NewMockCognitoServerDefault(s.T())
+	s.MockPennsieveServer = NewMockPennsieveServerDefault(s.T())
+	AWSEndpoints = AWSCognitoEndpoints{IdentityProviderEndpoint: s.IdProviderServer.URL}
+	s.TestClient = NewClient(APIParams{ApiHost: s.Server.URL, ApiKey: "test-key", ApiSecret: "test-secret"})
+	s.TestClient.RetryPolicy = NoRetryPolicy
+}
+
+func (s *ErrorsTestSuite) TearDownTest() {
+	s.MockCognitoServer.Close()
+	s.MockPennsieveServer.Close()
+	AWSEndpoints.Reset()
+}
+
+func (s *ErrorsTestSuite) TestHTTPErrorDetails() {
+	s.Mux.HandleFunc("/conflict", func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("X-Amzn-Requestid", "req-42")
+		writer.WriteHeader(http.StatusConflict)
+		_, _ = writer.Write([]byte(`{"message": "dataset name taken", "token": "eyJhbGciOiJIUzI1NiJ9.eyJzdWIiOiIxIn0.c2ln"}`))
+	})
+
+	request, err := http.NewRequest("POST", s.Server.URL+"/conflict?token=secret-value", strings.NewReader(`{}`))
+	s.NoError(err)
+
+	err = s.TestClient.sendRequest(context.Background(), request, nil)
+	s.ErrorIs(err, ErrConflict)
+
+	var httpErr *HTTPError
+	if s.ErrorAs(err, &httpErr) {
+		s.Equal(http.StatusConflict, httpErr.StatusCode)
+		s.Equal("dataset name taken", httpErr.Message)
+		s.Equal("POST", httpErr.Method)
+		s.Equal("req-42", httpErr.RequestID)
+		s.Contains(httpErr.URL, "/conflict")
+		s.NotContains(httpErr.URL, "secret-value")
+		s.Contains(httpErr.Body, "dataset name taken")
+		s.NotContains(httpErr.Body, "eyJhbGciOiJIUzI1NiJ9", "tokens in the body should be redacted")
+		s.False(httpErr.Temporary())
+	}
+}
+
+func (s *ErrorsTestSuite) TestDecodeError() {
+	s.Mux.HandleFunc("/html", func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "text/html")
+		_, _ = writer.Write([]byte(`<html>gateway</html>`))
+	})
+
+	request, err := http.NewRequest("GET", s.Server.URL+"/html", nil)
+	s.NoError(err)
+
+	err = s.TestClient.sendRequest(context.Background(), request, &responseBody{})
+	var decodeErr *DecodeError
+	if s.ErrorAs(err, &decodeErr) {
+		s.Equal(http.StatusOK, decodeErr.StatusCode)
+		s.Equal("GET", decodeErr.Method)
+		s.Equal("text/html", decodeErr.ContentType)
+		s.Equal("<html>gateway</html>", decodeErr.Body)
+	}
+	var syntaxErr *json.SyntaxError
+	s.ErrorAs(err, &syntaxErr, "DecodeError should wrap the json error")
+}
+
+func (s *ErrorsTestSuite) TestMaxResponseBodySize() {
+	s.Mux.HandleFunc("/large", func(writer http.ResponseWriter, request *http.Request) {
+		_, _ = writer.Write([]byte(`{"name": "` + strings.Repeat("n", 4096) + `"}`))
+	})
+	send := func() error {
+		request, err := http.NewRequest("GET", s.Server.URL+"/large", nil)
+		s.NoError(err)
+		return s.TestClient.sendRequest(context.Background(), request, &responseBody{})
+	}
+
+	s.NoError(send())
+
+	s.TestClient.MaxResponseBodySize = 1024
+	var decodeErr *DecodeError
+	if s.ErrorAs(send(), &decodeErr) {
+		s.ErrorContains(decodeErr, "response body exceeds 1024 bytes")
+	}
+}
+
+func TestErrorsSuite(t *testing.T) {
+	suite.Run(t, new(ErrorsTestSuite))
+}
@@ -0,0 +1,15 @@
+//go:build !unix && !windows
+
+package pennsieve
+
+import "os"
+
+// lockFile is a no-op on platforms without file locking; the session cache
+// is then not safe for concurrent processes.
+func lockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,23 @@
+//go:build unix
+
+package pennsieve
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile blocks until it holds an exclusive lock on f. The lock is
+// released when f is closed, including when the process exits.
+func lockFile(f *os.File) error {
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
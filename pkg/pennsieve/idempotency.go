@@ -0,0 +1,242 @@
+package pennsieve
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// IdempotencyKeyHeader is the header carrying a request's idempotency key.
+// Requests other than GET and HEAD made with a context from
+// WithIdempotencyKey carry it, and automatic retries of the request send the
+// same key, so the server can recognize a retried create whose first attempt
+// timed out. The key marks the request as safe to resend: both RetryPolicy
+// and net/http's Transport resend keyed requests after failures that may
+// have reached the server, so requests without a key from the caller do not
+// get one.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// ErrIdempotencyKeyReused is returned when an idempotency key found in the
+// journal was recorded for a different request.
+var ErrIdempotencyKeyReused = errors.New("idempotency key reused for a different request")
+
+type idempotencyKey struct{}
+
+// WithIdempotencyKey returns a copy of ctx that sends key as the idempotency
+// key of the request made with it. Use one key per
+// logical operation, derived from something stable in your job (e.g.
+// "import-2024-05/dataset/EEG study"), so that re-running the job sends the
+// same key. With a journal configured (see WithIdempotencyJournal), a
+// request whose key is already in the journal is not sent again: the
+// recorded response is returned.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKey{}, key)
+}
+
+// IdempotencyKeyFromContext returns the key set on ctx with
+// WithIdempotencyKey.
+func IdempotencyKeyFromContext(ctx context.Context) (key string, ok bool) {
+	if ctx == nil {
+		return "", false
+	}
+	key, ok = ctx.Value(idempotencyKey{}).(string)
+	return key, ok && key != ""
+}
+
+// setIdempotencyKey adds the idempotency key of ctx, if any, to req unless
+// it is a GET or HEAD or already has one.
+func setIdempotencyKey(ctx context.Context, req *http.Request) {
+	if req.Method == http.MethodGet || req.Method == http.MethodHead || req.Header.Get(IdempotencyKeyHeader) != "" {
+		return
+	}
+	if key, ok := IdempotencyKeyFromContext(ctx); ok {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+}
+
+// JournalEntry is the response recorded for an idempotency key.
+type JournalEntry struct {
+	// Fingerprint identifies the request: its method, URL and body.
+	Fingerprint string          `json:"fingerprint"`
+	Response    json.RawMessage `json:"response"`
+	Time        time.Time       `json:"time"`
+}
+
+// IdempotencyJournal records the responses of completed requests by
+// idempotency key, so that re-running a job does not repeat them.
+type IdempotencyJournal interface {
+	// Get returns the entry recorded for key, if any.
+	Get(key string) (JournalEntry, bool, error)
+	// Put records entry for key.
+	Put(key string, entry JournalEntry) error
+}
+
+// MemoryJournal is an IdempotencyJournal kept in memory. It dedupes retries
+// within a process.
+type MemoryJournal struct {
+	mu      sync.Mutex
+	entries map[string]JournalEntry
+}
+
+// NewMemoryJournal returns an empty MemoryJournal.
+func NewMemoryJournal() *MemoryJournal {
+	return &MemoryJournal{entries: map[string]JournalEntry{}}
+}
+
+func (j *MemoryJournal) Get(key string) (JournalEntry, bool, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e, ok := j.entries[key]
+	return e, ok, nil
+}
+
+func (j *MemoryJournal) Put(key string, entry JournalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries[key] = entry
+	return nil
+}
+
+// FileJournal is an IdempotencyJournal stored as a JSON file, so that it
+// survives re-running a job. The file is rewritten on every Put. It is safe
+// for concurrent use within a process, but not by several processes.
+type FileJournal struct {
+	path string
+	mem  *MemoryJournal
+}
+
+// NewFileJournal opens the journal at path, creating it on the first Put if
+// it does not exist.
+func NewFileJournal(path string) (*FileJournal, error) {
+	j := &FileJournal{path: path, mem: NewMemoryJournal()}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &j.mem.entries); err != nil {
+		return nil, fmt.Errorf("error reading idempotency journal %s: %w", path, err)
+	}
+	return j, nil
+}
+
+func (j *FileJournal) Get(key string) (JournalEntry, bool, error) {
+	return j.mem.Get(key)
+}
+
+func (j *FileJournal) Put(key string, entry JournalEntry) error {
+	j.mem.mu.Lock()
+	defer j.mem.mu.Unlock()
+	j.mem.entries[key] = entry
+
+	data, err := json.MarshalIndent(j.mem.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(j.path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), filepath.Base(j.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), j.path)
+}
+
+// SetIdempotencyJournal sets the journal used to dedupe requests made with
+// WithIdempotencyKey. nil disables deduping.
+func (c *Client) SetIdempotencyJournal(j IdempotencyJournal) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.journal = j
+}
+
+func (c *Client) idempotencyJournal() IdempotencyJournal {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.journal
+}
+
+// requestFingerprint returns a hash of req's method, URL and body. The body
+// is left readable.
+func requestFingerprint(req *http.Request) (string, error) {
+	if err := makeReplayable(req); err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", req.Method, req.URL)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return "", err
+		}
+		defer body.Close()
+		if _, err := io.Copy(h, body); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// journaled handles a request made with an idempotency key when the client
+// has a journal. If the key was recorded, its response is decoded into v and
+// done is true. Otherwise record must be called with the response body once
+// the request succeeds.
+func (c *Client) journaled(ctx context.Context, req *http.Request, v interface{}) (done bool, record func(body []byte), err error) {
+	journal := c.idempotencyJournal()
+	key, ok := IdempotencyKeyFromContext(ctx)
+	if journal == nil || !ok || req.Method == http.MethodGet || req.Method == http.MethodHead {
+		return false, nil, nil
+	}
+
+	fingerprint, err := requestFingerprint(req)
+	if err != nil {
+		return false, nil, err
+	}
+	entry, found, err := journal.Get(key)
+	if err != nil {
+		return false, nil, err
+	}
+	if found {
+		if entry.Fingerprint != fingerprint {
+			return false, nil, fmt.Errorf("%w: %q", ErrIdempotencyKeyReused, key)
+		}
+		c.Logger().InfoContext(ctx, "request already completed, using journaled response",
+			"idempotency_key", key, "method", req.Method, "url", redactURL(req.URL.String()))
+		if v == nil || len(entry.Response) == 0 {
+			return true, nil, nil
+		}
+		return true, nil, json.Unmarshal(entry.Response, v)
+	}
+
+	record = func(body []byte) {
+		entry := JournalEntry{Fingerprint: fingerprint, Time: time.Now().UTC()}
+		if body = bytes.TrimSpace(body); json.Valid(body) {
+			entry.Response = body
+		}
+		if err := journal.Put(key, entry); err != nil {
+			c.Logger().WarnContext(ctx, "error writing idempotency journal", "idempotency_key", key, "error", err)
+		}
+	}
+	return false, record, nil
+}
@@ -0,0 +1,175 @@
+package pennsieve
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type IdempotencyTestSuite struct {
+	suite.Suite
+	MockCognitoServer
+	MockPennsieveServer
+	TestClient *Client
+
+	mu      sync.Mutex
+	keys    []string // Idempotency-Key of each dataset create received
+	failing int      // number of creates to fail with 503
+}
+
+func (s *IdempotencyTestSuite) SetupTest() {
+	s.MockCognitoServer = NewMockCognitoServerDefault(s.T())
+	s.MockPennsieveServer = NewMockPennsieveServerDefault(s.T())
+	AWSEndpoints = AWSCognitoEndpoints{IdentityProviderEndpoint: s.IdProviderServer.URL}
+	s.TestClient = s.newClient()
+	s.keys, s.failing = nil, 0
+
+	s.Mux.HandleFunc("/datasets/", func(writer http.ResponseWriter, request *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.keys = append(s.keys, request.Header.Get(IdempotencyKeyHeader))
+		if s.failing > 0 {
+			s.failing--
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = writer.Write([]byte(`{"content": {"id": "N:dataset:created", "name": "created"}}`))
+	})
+	s.Mux.HandleFunc("/datasets/N:dataset:1", func(writer http.ResponseWriter, request *http.Request) {
+		s.Empty(request.Header.Get(IdempotencyKeyHeader), "GET requests should not carry a key")
+		_, _ = writer.Write([]byte(`{"content": {"id": "N:dataset:1"}}`))
+	})
+}
+
+func (s *IdempotencyTestSuite) TearDownTest() {
+	s.MockCognitoServer.Close()
+	s.MockPennsieveServer.Close()
+	AWSEndpoints.Reset()
+}
+
+func (s *IdempotencyTestSuite) newClient() *Client {
+	client := NewClient(APIParams{ApiHost: s.Server.URL, ApiHost2: s.Server.URL, ApiKey: "test-key", ApiSecret: "test-secret"})
+	client.RetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	return client
+}
+
+func (s *IdempotencyTestSuite) receivedKeys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.keys...)
+}
+
+func (s *IdempotencyTestSuite) TestKeyReusedAcrossRetries() {
+	s.failing = 2
+
+	ctx := WithIdempotencyKey(context.Background(), "job-42/dataset/retried")
+	_, err := s.TestClient.Dataset.Create(ctx, "retried", "", "[]")
+	s.Require().NoError(err)
+	s.Equal([]string{"job-42/dataset/retried", "job-42/dataset/retried", "job-42/dataset/retried"}, s.receivedKeys())
+
+	_, err = s.TestClient.Dataset.Get(ctx, "N:dataset:1")
+	s.NoError(err)
+}
+
+// TestNoKeyWithoutCaller checks that requests are not given a key of their
+// own: a key marks a request as safe to resend, which only the caller knows.
+func (s *IdempotencyTestSuite) TestNoKeyWithoutCaller() {
+	s.failing = 1
+
+	_, err := s.TestClient.Dataset.Create(context.Background(), "unkeyed", "", "[]")
+	s.Require().NoError(err, "a 503 is retried without a key")
+	s.Equal([]string{"", ""}, s.receivedKeys())
+}
+
+func (s *IdempotencyTestSuite) TestCallerKey() {
+	ctx := WithIdempotencyKey(context.Background(), "job-42/dataset/EEG")
+	_, err := s.TestClient.Dataset.Create(ctx, "EEG", "", "[]")
+	s.Require().NoError(err)
+	s.Equal([]string{"job-42/dataset/EEG"}, s.receivedKeys())
+
+	// Without a journal the request is sent again.
+	_, err = s.TestClient.Dataset.Create(ctx, "EEG", "", "[]")
+	s.Require().NoError(err)
+	s.Len(s.receivedKeys(), 2)
+}
+
+func (s *IdempotencyTestSuite) TestJournalReturnsPreviousResponse() {
+	s.TestClient.SetIdempotencyJournal(NewMemoryJournal())
+	ctx := WithIdempotencyKey(context.Background(), "job-42/dataset/EEG")
+
+	first, err := s.TestClient.Dataset.Create(ctx, "EEG", "", "[]")
+	s.Require().NoError(err)
+	second, err := s.TestClient.Dataset.Create(ctx, "EEG", "", "[]")
+	s.Require().NoError(err)
+
+	s.Len(s.receivedKeys(), 1, "the second create should not be sent")
+	s.Equal(first, second)
+
+	_, err = s.TestClient.Dataset.Create(ctx, "Different name", "", "[]")
+	s.ErrorIs(err, ErrIdempotencyKeyReused)
+	s.Len(s.receivedKeys(), 1)
+
+	// Requests without a caller key are not journaled.
+	_, err = s.TestClient.Dataset.Create(context.Background(), "EEG", "", "[]")
+	s.Require().NoError(err)
+	_, err = s.TestClient.Dataset.Create(context.Background(), "EEG", "", "[]")
+	s.Require().NoError(err)
+	s.Len(s.receivedKeys(), 3)
+}
+
+func (s *IdempotencyTestSuite) TestFailedRequestsAreNotJournaled() {
+	s.TestClient.SetIdempotencyJournal(NewMemoryJournal())
+	s.TestClient.RetryPolicy = NoRetryPolicy
+	s.failing = 1
+	ctx := WithIdempotencyKey(context.Background(), "job-42/dataset/EEG")
+
+	_, err := s.TestClient.Dataset.Create(ctx, "EEG", "", "[]")
+	s.ErrorIs(err, ErrServer)
+	_, err = s.TestClient.Dataset.Create(ctx, "EEG", "", "[]")
+	s.NoError(err)
+	s.Len(s.receivedKeys(), 2)
+}
+
+func (s *IdempotencyTestSuite) TestFileJournalSurvivesRestart() {
+	path := filepath.Join(s.T().TempDir(), "journal", "idempotency.json")
+	ctx := WithIdempotencyKey(context.Background(), "job-42/dataset/EEG")
+
+	journal, err := NewFileJournal(path)
+	s.Require().NoError(err)
+	s.TestClient.SetIdempotencyJournal(journal)
+	first, err := s.TestClient.Dataset.Create(ctx, "EEG", "", "[]")
+	s.Require().NoError(err)
+
+	info, err := os.Stat(path)
+	s.Require().NoError(err)
+	s.Equal(os.FileMode(0o600), info.Mode().Perm())
+
+	// A re-run of the job with a new client and the same journal file.
+	journal, err = NewFileJournal(path)
+	s.Require().NoError(err)
+	client := s.newClient()
+	client.SetIdempotencyJournal(journal)
+	second, err := client.Dataset.Create(ctx, "EEG", "", "[]")
+	s.Require().NoError(err)
+
+	s.Len(s.receivedKeys(), 1)
+	s.Equal(first, second)
+}
+
+func TestIdempotencySuite(t *testing.T) {
+	suite.Run(t, new(IdempotencyTestSuite))
+}
+
+func TestNewFileJournalRejectsCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.json")
+	assert.NoError(t, os.WriteFile(path, []byte("{not json"), 0o600))
+	_, err := NewFileJournal(path)
+	assert.ErrorContains(t, err, "idempotency journal")
+}
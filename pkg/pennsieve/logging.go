@@ -0,0 +1,242 @@
+package pennsieve
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// redacted replaces secret values in log output.
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are log attribute keys whose values are always redacted.
+// Keys are compared case-insensitively with '-' and '_' removed.
+var sensitiveKeys = map[string]struct{}{
+	"authorization":     {},
+	"token":             {},
+	"accesstoken":       {},
+	"idtoken":           {},
+	"refreshtoken":      {},
+	"apisecret":         {},
+	"secret":            {},
+	"password":          {},
+	"secretaccesskey":   {},
+	"sessiontoken":      {},
+	"xamzsecuritytoken": {},
+	"secrethash":        {},
+	"newpassword":       {},
+	"xamzsignature":     {},
+	"xamzcredential":    {},
+	"clientsecret":      {},
+	"codeverifier":      {},
+}
+
+// sensitiveQueryParams are URL query parameters stripped from logged URLs,
+// mostly the parts of S3 presigned URLs that grant access.
+var sensitiveQueryParams = []string{
+	"X-Amz-Signature",
+	"X-Amz-Credential",
+	"X-Amz-Security-Token",
+	"Signature",
+	"AWSAccessKeyId",
+	"x-amz-security-token",
+	"api_key",
+	"token",
+}
+
+var (
+	bearerPattern = regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9\-._~+/]+=*`)
+	jwtPattern    = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+)
+
+func isSensitiveKey(key string) bool {
+	k := strings.ToLower(strings.NewReplacer("-", "", "_", "").Replace(key))
+	_, ok := sensitiveKeys[k]
+	return ok
+}
+
+// redactString scrubs bearer tokens, JWTs and presigned URL signatures from
+// free-form text.
+func redactString(s string) string {
+	s = bearerPattern.ReplaceAllString(s, "${1}"+redacted)
+	s = jwtPattern.ReplaceAllString(s, redacted)
+	if strings.Contains(s, "://") && strings.Contains(s, "?") {
+		s = redactURL(s)
+	}
+	return s
+}
+
+// redactURL returns rawURL with credential-bearing query parameters replaced.
+// Strings that do not parse as URLs are returned unchanged.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.RawQuery == "" {
+		return rawURL
+	}
+	q := u.Query()
+	changed := false
+	for _, p := range sensitiveQueryParams {
+		if q.Has(p) {
+			q.Set(p, redacted)
+			changed = true
+		}
+	}
+	if !changed {
+		return rawURL
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// redactBody returns a request body with secrets redacted. In JSON bodies
+// the values of sensitive keys are replaced, at any depth; the body keeps its
+// formatting unless something had to be redacted. Other bodies are scrubbed
+// like free-form text.
+func redactBody(body []byte) string {
+	var doc any
+	if json.Unmarshal(body, &doc) != nil {
+		return redactString(string(body))
+	}
+	doc, changed := redactJSON(doc)
+	if !changed {
+		return redactString(string(body))
+	}
+	redactedBody, err := json.Marshal(doc)
+	if err != nil {
+		return redacted
+	}
+	return string(redactedBody)
+}
+
+// redactJSON redacts a decoded JSON value and reports whether it changed.
+func redactJSON(v any) (any, bool) {
+	changed := false
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			if isSensitiveKey(k) {
+				v[k], changed = redacted, true
+			} else if r, ok := redactJSON(e); ok {
+				v[k], changed = r, true
+			}
+		}
+	case []any:
+		for i, e := range v {
+			if r, ok := redactJSON(e); ok {
+				v[i], changed = r, true
+			}
+		}
+	case string:
+		if r := redactString(v); r != v {
+			return r, true
+		}
+	}
+	return v, changed
+}
+
+// redactAttr returns a copy of a with secret values redacted, descending into
+// groups.
+func redactAttr(a slog.Attr) slog.Attr {
+	if isSensitiveKey(a.Key) {
+		return slog.String(a.Key, redacted)
+	}
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, redactString(v.String()))
+	case slog.KindGroup:
+		attrs := v.Group()
+		out := make([]slog.Attr, len(attrs))
+		for i, ga := range attrs {
+			out[i] = redactAttr(ga)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(out...)}
+	case slog.KindAny:
+		if err, ok := v.Any().(error); ok {
+			return slog.String(a.Key, redactString(err.Error()))
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
+
+// redactingHandler wraps a slog.Handler and scrubs secrets from every record
+// before it reaches the wrapped handler.
+type redactingHandler struct {
+	next slog.Handler
+}
+
+func newRedactingHandler(h slog.Handler) slog.Handler {
+	if _, ok := h.(*redactingHandler); ok {
+		return h
+	}
+	return &redactingHandler{next: h}
+}
+
+func (h *redactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *redactingHandler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, redactString(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(redactAttr(a))
+		return true
+	})
+	return h.next.Handle(ctx, out)
+}
+
+func (h *redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		out[i] = redactAttr(a)
+	}
+	return &redactingHandler{next: h.next.WithAttrs(out)}
+}
+
+func (h *redactingHandler) WithGroup(name string) slog.Handler {
+	return &redactingHandler{next: h.next.WithGroup(name)}
+}
+
+// discardLogger drops everything. Used when SetLogger is called with nil.
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError + 1}))
+
+// SetLogger sets the logger used by the client and all of its services.
+// Secrets (tokens, API secrets, presigned URL signatures) are redacted before
+// records reach the logger's handler. Passing nil silences the client.
+//
+// Per-request records are logged at Debug level; retries at Info.
+func (c *Client) SetLogger(l *slog.Logger) {
+	logger := discardLogger
+	if l != nil {
+		logger = slog.New(newRedactingHandler(l.Handler()))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.logger = logger
+}
+
+// Logger returns the client's logger. Defaults to slog.Default() with
+// redaction applied.
+func (c *Client) Logger() *slog.Logger {
+	c.mu.RLock()
+	logger := c.logger
+	c.mu.RUnlock()
+	if logger == nil {
+		return slog.New(newRedactingHandler(slog.Default().Handler()))
+	}
+	return logger
+}
+
+// newRequestID returns a short random identifier used to correlate the log
+// records of one logical request across retries.
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
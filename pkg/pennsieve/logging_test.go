@@ -0,0 +1,137 @@
+package pennsieve
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestRedactingHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(newRedactingHandler(slog.NewTextHandler(&buf, nil)))
+
+	jwtToken := NewTestJWT(t, "N:organization:1", "1", 0)
+	logger.Info("auth with Bearer abc.def-123",
+		"api_secret", "super-secret",
+		"RefreshToken", "refresh-me",
+		"header", "Bearer access-token-1",
+		"id", jwtToken,
+		"url", "https://bucket.s3.amazonaws.com/key?X-Amz-Signature=deadbeef&X-Amz-Expires=60",
+		"error", errors.New("failed with "+jwtToken),
+		slog.Group("cognito", "PASSWORD", "hunter2"),
+	)
+
+	out := buf.String()
+	for _, secret := range []string{"super-secret", "refresh-me", "access-token-1", "abc.def-123", jwtToken, "deadbeef", "hunter2"} {
+		assert.NotContains(t, out, secret)
+	}
+	assert.Contains(t, out, "X-Amz-Expires=60", "non-sensitive query params should be kept")
+	assert.Contains(t, out, redacted)
+}
+
+func TestRedactingHandlerWithAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(newRedactingHandler(slog.NewTextHandler(&buf, nil))).With("token", "abc")
+	logger.Info("hello")
+	assert.NotContains(t, buf.String(), "abc")
+}
+
+func TestRedactURL(t *testing.T) {
+	assert.Equal(t, "https://api.pennsieve.io/datasets?limit=10", redactURL("https://api.pennsieve.io/datasets?limit=10"))
+	assert.Equal(t, "not a url", redactURL("not a url"))
+	assert.NotContains(t, redactURL("https://s3/k?Signature=abc&AWSAccessKeyId=AKIA"), "AKIA")
+}
+
+func TestRedactBody(t *testing.T) {
+	assert.Equal(t, `{"name": "kept"}`, redactBody([]byte(`{"name": "kept"}`)))
+	assert.JSONEq(t, `[{"password": "[REDACTED]"}]`, redactBody([]byte(`[{"password": "hunter2"}]`)))
+	assert.Equal(t, "Authorization: Bearer [REDACTED]", redactBody([]byte("Authorization: Bearer abc")))
+}
+
+func TestSetLoggerNilSilences(t *testing.T) {
+	c := &Client{}
+	c.SetLogger(nil)
+	assert.False(t, c.Logger().Enabled(context.Background(), slog.LevelError))
+}
+
+type LoggingTestSuite struct {
+	suite.Suite
+	MockCognitoServer
+	MockPennsieveServer
+	TestClient *Client
+	Logs       bytes.Buffer
+}
+
+func (s *LoggingTestSuite) SetupTest() {
+	s.MockCognitoServer = NewMockCognitoServerDefault(s.T())
+	s.MockPennsieveServer = NewMockPennsieveServerDefault(s.T())
+	AWSEndpoints = AWSCognitoEndpoints{IdentityProviderEndpoint: s.IdProviderServer.URL}
+	s.TestClient = NewClient(APIParams{ApiHost: s.Server.URL, ApiKey: "key", ApiSecret: "secret-value"})
+	s.Logs.Reset()
+	s.TestClient.SetLogger(slog.New(slog.NewTextHandler(&s.Logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+}
+
+func (s *LoggingTestSuite) TearDownTest() {
+	s.MockCognitoServer.Close()
+	s.MockPennsieveServer.Close()
+	AWSEndpoints.Reset()
+}
+
+func (s *LoggingTestSuite) TestRequestsAreLogged() {
+	s.Mux.HandleFunc("/logged", func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("X-Amzn-Requestid", "server-req-1")
+		_, err := writer.Write([]byte(`{}`))
+		s.NoError(err)
+	})
+
+	req, err := http.NewRequest("GET", s.Server.URL+"/logged", nil)
+	s.NoError(err)
+	s.NoError(s.TestClient.sendRequest(context.Background(), req, &responseBody{}))
+
+	logs := s.Logs.String()
+	s.Contains(logs, "refreshing token")
+	s.Contains(logs, "cognito InitiateAuth")
+	s.Contains(logs, "/logged")
+	s.Contains(logs, "request_id=")
+	s.Contains(logs, "duration=")
+	s.Contains(logs, "server_request_id=server-req-1")
+	s.NotContains(logs, "secret-value")
+	s.NotContains(logs, s.TestClient.APISession.Token)
+	s.NotContains(logs, s.TestClient.APISession.RefreshToken)
+	for _, line := range strings.Split(strings.TrimSpace(logs), "\n") {
+		s.Contains(line, "level=DEBUG", "routine request logs should be at debug level")
+	}
+}
+
+// TestRetriesAreLoggedAtDebug checks that retries, a routine event, do not
+// reach the default logger at its info level.
+func (s *LoggingTestSuite) TestRetriesAreLoggedAtDebug() {
+	calls := 0
+	s.Mux.HandleFunc("/retried", func(writer http.ResponseWriter, request *http.Request) {
+		if calls++; calls == 1 {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = writer.Write([]byte(`{}`))
+	})
+	s.TestClient.RetryPolicy = RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+	req, err := http.NewRequest("GET", s.Server.URL+"/retried", nil)
+	s.NoError(err)
+	s.NoError(s.TestClient.sendRequest(context.Background(), req, &responseBody{}))
+
+	s.Equal(2, calls)
+	s.Contains(s.Logs.String(), `level=DEBUG msg="retrying pennsieve request"`)
+}
+
+func TestLoggingSuite(t *testing.T) {
+	suite.Run(t, new(LoggingTestSuite))
+}
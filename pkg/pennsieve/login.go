@@ -0,0 +1,197 @@
+package pennsieve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+)
+
+// Challenges that LoginWithPassword passes to its prompt.
+const (
+	// ChallengeSoftwareTokenMFA asks for the code of the user's
+	// authenticator app.
+	ChallengeSoftwareTokenMFA = string(types.ChallengeNameTypeSoftwareTokenMfa)
+	// ChallengeSMSMFA asks for the code sent by text message to
+	// Parameters["CODE_DELIVERY_DESTINATION"].
+	ChallengeSMSMFA = string(types.ChallengeNameTypeSmsMfa)
+	// ChallengeNewPasswordRequired asks for a new password, for users whose
+	// password was set by an administrator.
+	ChallengeNewPasswordRequired = string(types.ChallengeNameTypeNewPasswordRequired)
+)
+
+// maxLoginChallenges bounds the challenges of one login, e.g. a new
+// password followed by MFA.
+const maxLoginChallenges = 5
+
+// ErrLoginAborted wraps the error returned by a LoginWithPassword prompt.
+var ErrLoginAborted = errors.New("login aborted")
+
+// AuthChallenge is a challenge Cognito requires to be answered during
+// LoginWithPassword.
+type AuthChallenge struct {
+	// Name is one of ChallengeSoftwareTokenMFA, ChallengeSMSMFA and
+	// ChallengeNewPasswordRequired.
+	Name string
+	// Parameters are the challenge parameters sent by Cognito, such as
+	// CODE_DELIVERY_DESTINATION for SMS_MFA.
+	Parameters map[string]string
+}
+
+// ChallengePrompt answers an AuthChallenge, typically by asking the user: it
+// returns the MFA code, or the new password for ChallengeNewPasswordRequired.
+type ChallengePrompt func(challenge AuthChallenge) (string, error)
+
+// authStep is the outcome of InitiateAuth or RespondToAuthChallenge: either
+// tokens or another challenge.
+type authStep struct {
+	result     *types.AuthenticationResultType
+	challenge  types.ChallengeNameType
+	parameters map[string]string
+	session    *string
+}
+
+// LoginWithPassword signs a user in with their email and password through
+// the UserPool app client, as the web app does, or with SRP if the client
+// was created with WithSRPAuthentication. Challenges are answered with
+// prompt: SOFTWARE_TOKEN_MFA and SMS_MFA codes, and a new password for
+// NEW_PASSWORD_REQUIRED. prompt may be nil for accounts without MFA.
+//
+// The session, including its refresh token, is set on the client, along
+// with the organization in the ID token if it has one.
+func (s *authenticationService) LoginWithPassword(ctx context.Context, email, password string, prompt ChallengePrompt) (*APISession, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	cognitoConfig, err := s.loadCognitoConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	clientID := aws.String(cognitoConfig.UserPool.AppClientID)
+
+	step, err := s.passwordAuth(ctx, clientID, cognitoConfig.UserPool.ID, email, password)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; step.result == nil && step.challenge != ""; i++ {
+		if i == maxLoginChallenges {
+			return nil, &CognitoError{Operation: "RespondToAuthChallenge", Err: fmt.Errorf("too many challenges, last %s", step.challenge)}
+		}
+		if step, err = s.answerChallenge(ctx, clientID, email, step, prompt); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := step.tokens()
+	if err != nil {
+		return nil, err
+	}
+	return s.userPoolSession(ctx, result)
+}
+
+// answerChallenge asks prompt for the answer to the challenge of step and
+// sends it to Cognito.
+func (s *authenticationService) answerChallenge(ctx context.Context, clientID *string, email string, step authStep, prompt ChallengePrompt) (authStep, error) {
+	var responseKey string
+	switch step.challenge {
+	case types.ChallengeNameTypeSoftwareTokenMfa:
+		responseKey = "SOFTWARE_TOKEN_MFA_CODE"
+	case types.ChallengeNameTypeSmsMfa:
+		responseKey = "SMS_MFA_CODE"
+	case types.ChallengeNameTypeNewPasswordRequired:
+		responseKey = "NEW_PASSWORD"
+	default:
+		return authStep{}, &CognitoError{Operation: "InitiateAuth", Err: fmt.Errorf("unsupported challenge %s", step.challenge)}
+	}
+	if prompt == nil {
+		return authStep{}, fmt.Errorf("%w: %s challenge requires a prompt", ErrLoginAborted, step.challenge)
+	}
+
+	answer, err := prompt(AuthChallenge{Name: string(step.challenge), Parameters: step.parameters})
+	if err != nil {
+		return authStep{}, fmt.Errorf("%w: %w", ErrLoginAborted, err)
+	}
+
+	// Cognito identifies the user by its internal name after the first step.
+	username := email
+	if id := step.parameters["USER_ID_FOR_SRP"]; id != "" {
+		username = id
+	}
+
+	out, err := s.respondToAuthChallenge(ctx, &cognitoidentityprovider.RespondToAuthChallengeInput{
+		ChallengeName: step.challenge,
+		ClientId:      clientID,
+		Session:       step.session,
+		ChallengeResponses: map[string]string{
+			"USERNAME":  username,
+			responseKey: answer,
+		},
+	})
+	if err != nil {
+		return authStep{}, err
+	}
+	return authStep{out.AuthenticationResult, out.ChallengeName, out.ChallengeParameters, out.Session}, nil
+}
+
+// userPoolSession sets the session of a UserPool sign-in on the client.
+func (s *authenticationService) userPoolSession(ctx context.Context, result *types.AuthenticationResultType) (*APISession, error) {
+	idTokenJwt := aws.ToString(result.IdToken)
+	claims, err := s.verifyIdToken(ctx, idTokenJwt)
+	if err != nil {
+		return nil, err
+	}
+	expiration, err := getTokenExpFromClaim(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	session := APISession{
+		Token:        aws.ToString(result.AccessToken),
+		RefreshToken: aws.ToString(result.RefreshToken),
+		IdToken:      idTokenJwt,
+		Expiration:   expiration,
+	}
+
+	// Users of the web app may not have an organization in their token yet.
+	orgNodeId, nodeErr := stringClaim(claims, "custom:organization_node_id")
+	orgId, idErr := stringClaim(claims, "custom:organization_id")
+	if nodeErr == nil && idErr == nil {
+		orgIdInt, err := strconv.Atoi(orgId)
+		if err != nil {
+			return nil, &TokenError{Claim: "custom:organization_id", Reason: fmt.Sprintf("%q is not an integer", orgId)}
+		}
+		s.client.SetOrganization(orgIdInt, orgNodeId)
+	}
+	s.client.SetSession(session)
+
+	return &session, nil
+}
+
+// respondToAuthChallenge calls Cognito RespondToAuthChallenge and logs the
+// challenge and duration.
+func (s *authenticationService) respondToAuthChallenge(ctx context.Context, params *cognitoidentityprovider.RespondToAuthChallengeInput) (*cognitoidentityprovider.RespondToAuthChallengeOutput, error) {
+	if s.awsConfigErr != nil {
+		return nil, s.awsConfigErr
+	}
+	svc := cognitoidentityprovider.NewFromConfig(s.cognitoAWSConfig())
+
+	spanCtx, span := s.startCognitoSpan(ctx, "CognitoIdentityProvider", "RespondToAuthChallenge")
+	start := time.Now()
+	out, err := svc.RespondToAuthChallenge(spanCtx, params)
+	endSpan(span, err)
+	logger := s.client.Logger().With("challenge", params.ChallengeName, "duration", time.Since(start))
+	if err != nil {
+		logger.DebugContext(ctx, "cognito RespondToAuthChallenge failed", "error", err)
+		return nil, &CognitoError{Operation: "RespondToAuthChallenge", Err: err}
+	}
+	logger.DebugContext(ctx, "cognito RespondToAuthChallenge")
+
+	return out, nil
+}
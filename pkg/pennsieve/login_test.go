@@ -0,0 +1,221 @@
+package pennsieve
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type LoginTestSuite struct {
+	suite.Suite
+	MockPennsieveServer
+	MockCognito MockServer
+	TestClient  *Client
+
+	mu         sync.Mutex
+	challenges []string            // challenges to send, in order
+	responses  []map[string]string // ChallengeResponses received
+	targets    []string            // X-Amz-Target of each call
+	rejectCode bool                // answer challenges with CodeMismatchException
+	idToken    string
+}
+
+func (s *LoginTestSuite) SetupTest() {
+	cognitoMux := http.NewServeMux()
+	s.MockCognito = MockServer{Server: httptest.NewServer(cognitoMux), Mux: cognitoMux}
+	s.MockPennsieveServer = NewMockPennsieveServer(s.T(), expectedCognitoConfig)
+	AWSEndpoints = AWSCognitoEndpoints{IdentityProviderEndpoint: s.MockCognito.Server.URL}
+	s.TestClient = NewClient(APIParams{ApiHost: s.Server.URL})
+	s.challenges, s.responses, s.targets, s.rejectCode = nil, nil, nil, false
+	s.idToken = NewTestIdToken(s.T(), s.MockCognito.Server.URL, expectedCognitoConfig, expectedCognitoConfig.UserPool.AppClientID, map[string]any{
+		OrgNodeIdClaimKey: "N:organization:login",
+		OrgIdClaimKey:     "77",
+	}, time.Hour)
+
+	cognitoMux.HandleFunc("/", s.handleCognito)
+}
+
+func (s *LoginTestSuite) TearDownTest() {
+	s.MockCognito.Close()
+	s.MockPennsieveServer.Close()
+	AWSEndpoints.Reset()
+}
+
+// handleCognito answers InitiateAuth with the first of s.challenges, and
+// each RespondToAuthChallenge with the next one, then with tokens.
+func (s *LoginTestSuite) handleCognito(writer http.ResponseWriter, request *http.Request) {
+	if serveTestJWKS(writer, request) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	target := strings.TrimPrefix(request.Header.Get("X-Amz-Target"), "AWSCognitoIdentityProviderService.")
+	s.targets = append(s.targets, target)
+
+	var body struct {
+		AuthFlow           string
+		ClientId           string
+		AuthParameters     map[string]string
+		ChallengeName      string
+		ChallengeResponses map[string]string
+		Session            string
+	}
+	s.NoError(json.NewDecoder(request.Body).Decode(&body))
+	s.Equal(expectedCognitoConfig.UserPool.AppClientID, body.ClientId, "login should use the UserPool client")
+
+	step := len(s.targets) - 1
+	switch target {
+	case "InitiateAuth":
+		s.Equal(string(types.AuthFlowTypeUserPasswordAuth), body.AuthFlow)
+		s.Equal("ada@example.com", body.AuthParameters["USERNAME"])
+		s.Equal("correct horse", body.AuthParameters["PASSWORD"])
+	case "RespondToAuthChallenge":
+		s.Equal(s.challenges[step-1], body.ChallengeName)
+		s.Equal(fmt.Sprintf("session-%d", step), body.Session)
+		s.responses = append(s.responses, body.ChallengeResponses)
+		if s.rejectCode {
+			writer.Header().Set("X-Amzn-Errortype", "CodeMismatchException")
+			writer.WriteHeader(http.StatusBadRequest)
+			_, _ = writer.Write([]byte(`{"__type": "CodeMismatchException", "message": "Invalid code received for user"}`))
+			return
+		}
+	default:
+		s.Failf("unexpected Cognito call", "target %q", target)
+	}
+
+	if step < len(s.challenges) {
+		_, _ = fmt.Fprintf(writer, `{"ChallengeName": %q, "ChallengeParameters": {"USER_ID_FOR_SRP": "user-uuid", "CODE_DELIVERY_DESTINATION": "+*******1234"}, "Session": "session-%d"}`,
+			s.challenges[step], step+1)
+		return
+	}
+	_, _ = fmt.Fprintf(writer, `{"AuthenticationResult": {"AccessToken": "login-access", "ExpiresIn": 3600, "IdToken": %q, "RefreshToken": "login-refresh", "TokenType": "Bearer"}, "ChallengeParameters": {}}`,
+		s.idToken)
+}
+
+func (s *LoginTestSuite) TestNoChallenge() {
+	session, err := s.TestClient.Authentication.LoginWithPassword(context.Background(), "ada@example.com", "correct horse", nil)
+	s.Require().NoError(err)
+	s.Equal("login-access", session.Token)
+	s.Equal("login-refresh", session.RefreshToken)
+	s.Equal(s.idToken, session.IdToken)
+	s.Equal(*session, s.TestClient.GetSession())
+	orgId, orgNodeId := s.TestClient.GetOrganization()
+	s.Equal(77, orgId)
+	s.Equal("N:organization:login", orgNodeId)
+}
+
+func (s *LoginTestSuite) TestMFAChallenges() {
+	for _, tc := range []struct {
+		challenge   string
+		responseKey string
+	}{
+		{ChallengeSoftwareTokenMFA, "SOFTWARE_TOKEN_MFA_CODE"},
+		{ChallengeSMSMFA, "SMS_MFA_CODE"},
+	} {
+		s.Run(tc.challenge, func() {
+			s.challenges, s.responses, s.targets = []string{tc.challenge}, nil, nil
+			var prompted []AuthChallenge
+			session, err := s.TestClient.Authentication.LoginWithPassword(context.Background(), "ada@example.com", "correct horse",
+				func(challenge AuthChallenge) (string, error) {
+					prompted = append(prompted, challenge)
+					return "123456", nil
+				})
+			s.Require().NoError(err)
+			s.Equal("login-access", session.Token)
+
+			s.Require().Len(prompted, 1)
+			s.Equal(tc.challenge, prompted[0].Name)
+			s.Equal("+*******1234", prompted[0].Parameters["CODE_DELIVERY_DESTINATION"])
+			s.Equal([]map[string]string{{"USERNAME": "user-uuid", tc.responseKey: "123456"}}, s.responses)
+		})
+	}
+}
+
+func (s *LoginTestSuite) TestNewPasswordThenMFA() {
+	s.challenges = []string{ChallengeNewPasswordRequired, ChallengeSoftwareTokenMFA}
+	answers := map[string]string{
+		ChallengeNewPasswordRequired: "battery staple",
+		ChallengeSoftwareTokenMFA:    "654321",
+	}
+
+	_, err := s.TestClient.Authentication.LoginWithPassword(context.Background(), "ada@example.com", "correct horse",
+		func(challenge AuthChallenge) (string, error) { return answers[challenge.Name], nil })
+	s.Require().NoError(err)
+	s.Equal([]string{"InitiateAuth", "RespondToAuthChallenge", "RespondToAuthChallenge"}, s.targets)
+	s.Equal([]map[string]string{
+		{"USERNAME": "user-uuid", "NEW_PASSWORD": "battery staple"},
+		{"USERNAME": "user-uuid", "SOFTWARE_TOKEN_MFA_CODE": "654321"},
+	}, s.responses)
+}
+
+func (s *LoginTestSuite) TestPromptErrors() {
+	s.challenges = []string{ChallengeSoftwareTokenMFA}
+
+	cancelled := errors.New("user pressed ctrl-c")
+	_, err := s.TestClient.Authentication.LoginWithPassword(context.Background(), "ada@example.com", "correct horse",
+		func(challenge AuthChallenge) (string, error) { return "", cancelled })
+	s.ErrorIs(err, ErrLoginAborted)
+	s.ErrorIs(err, cancelled)
+
+	s.targets = nil
+	_, err = s.TestClient.Authentication.LoginWithPassword(context.Background(), "ada@example.com", "correct horse", nil)
+	s.ErrorIs(err, ErrLoginAborted, "a challenge without a prompt should fail")
+	s.Empty(s.TestClient.GetSession().Token)
+}
+
+func (s *LoginTestSuite) TestUnsupportedChallenge() {
+	s.challenges = []string{string(types.ChallengeNameTypeMfaSetup)}
+
+	_, err := s.TestClient.Authentication.LoginWithPassword(context.Background(), "ada@example.com", "correct horse",
+		func(challenge AuthChallenge) (string, error) {
+			s.Fail("the prompt should not be called")
+			return "", nil
+		})
+	s.ErrorIs(err, ErrCognitoFailed)
+	s.ErrorContains(err, "unsupported challenge MFA_SETUP")
+}
+
+func (s *LoginTestSuite) TestWrongCode() {
+	s.challenges = []string{ChallengeSoftwareTokenMFA}
+	s.rejectCode = true
+
+	_, err := s.TestClient.Authentication.LoginWithPassword(context.Background(), "ada@example.com", "correct horse",
+		func(challenge AuthChallenge) (string, error) { return "000000", nil })
+	var cognitoErr *CognitoError
+	if s.ErrorAs(err, &cognitoErr) {
+		s.Equal("RespondToAuthChallenge", cognitoErr.Operation)
+	}
+	var mismatch *types.CodeMismatchException
+	s.ErrorAs(err, &mismatch)
+}
+
+func TestLoginSuite(t *testing.T) {
+	suite.Run(t, new(LoginTestSuite))
+}
+
+func TestLoginWithPasswordMockCognitoServer(t *testing.T) {
+	cognito := NewMockCognitoServerDefault(t)
+	defer cognito.Close()
+	api := NewMockPennsieveServerDefault(t)
+	defer api.Close()
+	AWSEndpoints = AWSCognitoEndpoints{IdentityProviderEndpoint: cognito.IdProviderServer.URL}
+	defer AWSEndpoints.Reset()
+
+	client := NewClient(APIParams{ApiHost: api.Server.URL})
+	session, err := client.Authentication.LoginWithPassword(context.Background(), "ada@example.com", "correct horse", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "access-token-1", session.Token)
+	assert.Equal(t, "mock-refresh-token", session.RefreshToken)
+}
@@ -6,8 +6,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"iter"
 	"net/http"
+	"net/url"
 	"sync"
 	"time"
 
@@ -20,6 +21,7 @@ type ManifestService interface {
 	Create(ctx context.Context, requestBody manifest.DTO) (*manifest.PostResponse, error)
 	GetFilesForStatus(ctx context.Context, manifestId string,
 		status manifestFile.Status, continuationToken string, verify bool) (*manifest.GetStatusEndpointResponse, error)
+	AllFilesForStatus(ctx context.Context, manifestId string, status manifestFile.Status) iter.Seq2[string, error]
 	GetStorageCredentials(ctx context.Context, datasetId, manifestNodeId string) (*StorageCredentials, error)
 	FinalizeManifestFiles(ctx context.Context, datasetId, manifestNodeId string, files []FinalizeFile, opts ...FinalizeOption) (*FinalizeResponse, error)
 	SetBaseUrl(url string)
@@ -85,6 +87,7 @@ type FinalizeResponse struct {
 type manifestService struct {
 	client  PennsieveHTTPClient
 	baseUrl string
+	mu      sync.RWMutex // guards baseUrl
 }
 
 func NewManifestService(client PennsieveHTTPClient, baseUrl string) *manifestService {
@@ -97,12 +100,11 @@ func NewManifestService(client PennsieveHTTPClient, baseUrl string) *manifestSer
 // Create Creates a manifest using the Pensnieve service.
 func (s *manifestService) Create(ctx context.Context, requestBody manifest.DTO) (*manifest.PostResponse, error) {
 
-	requestStr := fmt.Sprintf("%s/upload/manifest?dataset_id=%s", s.baseUrl, requestBody.DatasetId)
+	requestStr := fmt.Sprintf("%s/upload/manifest?dataset_id=%s", s.host(), requestBody.DatasetId)
 
 	body, _ := json.Marshal(requestBody)
 	req, err := http.NewRequest("POST", requestStr, bytes.NewBuffer(body))
 	if err != nil {
-		log.Println("Error: ManifestService.Create || ", err)
 		return nil, err
 	}
 
@@ -111,9 +113,9 @@ func (s *manifestService) Create(ctx context.Context, requestBody manifest.DTO)
 	}
 
 	res := manifest.PostResponse{}
-	if err := s.client.sendRequest(ctx, req, &res); err != nil {
+	if err := s.client.sendRequest(withMutation(ctx, "ManifestService.Create", dryRunManifest(requestBody)), req, &res); err != nil {
 
-		fmt.Println("SendRequest Error: ", err)
+		s.client.Logger().DebugContext(ctx, "ManifestService.Create failed", "error", err)
 		return nil, err
 	}
 
@@ -124,9 +126,9 @@ func (s *manifestService) Create(ctx context.Context, requestBody manifest.DTO)
 func (s *manifestService) GetFilesForStatus(ctx context.Context, manifestId string,
 	status manifestFile.Status, continuationToken string, verify bool) (*manifest.GetStatusEndpointResponse, error) {
 
-	requestStr := fmt.Sprintf("%s/upload/manifest/status?manifest_id=%s&status=%s&verify=%t", s.baseUrl, manifestId, status, verify)
+	requestStr := fmt.Sprintf("%s/upload/manifest/status?manifest_id=%s&status=%s&verify=%t", s.host(), manifestId, status, verify)
 	if len(continuationToken) > 0 {
-		requestStr = requestStr + fmt.Sprintf("&continuation_token=%s", continuationToken)
+		requestStr = requestStr + fmt.Sprintf("&continuation_token=%s", url.QueryEscape(continuationToken))
 	}
 
 	req, err := http.NewRequest("GET", requestStr, nil)
@@ -140,7 +142,7 @@ func (s *manifestService) GetFilesForStatus(ctx context.Context, manifestId stri
 
 	res := manifest.GetStatusEndpointResponse{}
 	if err := s.client.sendRequest(ctx, req, &res); err != nil {
-		fmt.Println("SendRequest Error: ", err)
+		s.client.Logger().DebugContext(ctx, "ManifestService.GetFilesForStatus failed", "manifest_id", manifestId, "error", err)
 		return nil, err
 	}
 
@@ -148,16 +150,36 @@ func (s *manifestService) GetFilesForStatus(ctx context.Context, manifestId stri
 
 }
 
+// AllFilesForStatus iterates over the upload IDs of all files in the manifest
+// with the given status, following continuation tokens as needed.
+func (s *manifestService) AllFilesForStatus(ctx context.Context, manifestId string, status manifestFile.Status) iter.Seq2[string, error] {
+	return tokenPages(ctx, func(ctx context.Context, token string) ([]string, string, error) {
+		res, err := s.GetFilesForStatus(ctx, manifestId, status, token, false)
+		if err != nil {
+			return nil, "", err
+		}
+		return res.Files, res.ContinuationToken, nil
+	})
+}
+
 func (s *manifestService) SetBaseUrl(url string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.baseUrl = url
 }
 
+func (s *manifestService) host() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.baseUrl
+}
+
 // GetStorageCredentials requests STS credentials scoped to the manifest's
 // destination storage bucket + O{org}/D{ds}/{manifest}/* prefix. The caller
-// can treat HTTPError{StatusCode=404} as "endpoint not yet deployed" and fall
-// back to the legacy Cognito + upload-bucket path.
+// can treat errors.Is(err, ErrNotFound) as "endpoint not yet deployed" and
+// fall back to the legacy Cognito + upload-bucket path.
 func (s *manifestService) GetStorageCredentials(ctx context.Context, datasetId, manifestNodeId string) (*StorageCredentials, error) {
-	requestStr := fmt.Sprintf("%s/upload/manifest/storage-credentials?dataset_id=%s", s.baseUrl, datasetId)
+	requestStr := fmt.Sprintf("%s/upload/manifest/storage-credentials?dataset_id=%s", s.host(), datasetId)
 
 	body, _ := json.Marshal(map[string]string{"manifestNodeId": manifestNodeId})
 	req, err := http.NewRequest("POST", requestStr, bytes.NewBuffer(body))
@@ -189,7 +211,7 @@ func (s *manifestService) FinalizeManifestFiles(ctx context.Context, datasetId,
 		fn(&o)
 	}
 
-	requestStr := fmt.Sprintf("%s/upload/manifest/files/finalize?dataset_id=%s", s.baseUrl, datasetId)
+	requestStr := fmt.Sprintf("%s/upload/manifest/files/finalize?dataset_id=%s", s.host(), datasetId)
 
 	body, err := json.Marshal(struct {
 		ManifestNodeID string         `json:"manifestNodeId"`
@@ -209,12 +231,44 @@ func (s *manifestService) FinalizeManifestFiles(ctx context.Context, datasetId,
 	}
 
 	res := FinalizeResponse{}
-	if err := s.client.sendRequest(ctx, req, &res); err != nil {
+	if err := s.client.sendRequest(withMutation(ctx, "ManifestService.FinalizeManifestFiles", dryRunFinalize(files)), req, &res); err != nil {
 		return nil, err
 	}
 	return &res, nil
 }
 
+// dryRunManifest returns the synthetic response to Create in dry-run mode:
+// every file is registered, in a new manifest unless requestBody names one.
+func dryRunManifest(requestBody manifest.DTO) func(p PlannedRequest) any {
+	return func(p PlannedRequest) any {
+		res := manifest.PostResponse{
+			ManifestNodeId: requestBody.ID,
+			NrFilesUpdated: len(requestBody.Files),
+			UpdatedFiles:   []manifestFile.FileStatusDTO{},
+			FailedFiles:    []string{},
+		}
+		if res.ManifestNodeId == "" {
+			res.ManifestNodeId = p.dryRunID("manifest")
+		}
+		for _, f := range requestBody.Files {
+			res.UpdatedFiles = append(res.UpdatedFiles, manifestFile.FileStatusDTO{UploadId: f.UploadID, Status: manifestFile.Registered})
+		}
+		return res
+	}
+}
+
+// dryRunFinalize returns the synthetic response to FinalizeManifestFiles in
+// dry-run mode: every file is reported finalized.
+func dryRunFinalize(files []FinalizeFile) func(p PlannedRequest) any {
+	return func(p PlannedRequest) any {
+		res := FinalizeResponse{Results: []FinalizeResult{}}
+		for _, f := range files {
+			res.Results = append(res.Results, FinalizeResult{UploadID: f.UploadID, Status: "finalized"})
+		}
+		return res
+	}
+}
+
 // StorageCredentialsProvider implements aws.CredentialsProvider by fetching
 // STS credentials from the Pennsieve storage-credentials endpoint and
 // refreshing before they expire. Thread-safe. One provider per
@@ -287,8 +341,10 @@ func (p *StorageCredentialsProvider) cachedIfValid() (aws.Credentials, bool) {
 
 // fetchWithRetry calls GetStorageCredentials with exponential backoff on
 // transient errors. 4xx responses (permanent — auth, not-found, wrong
-// dataset) are returned immediately so the caller can fall back.
+// dataset) are returned immediately so the caller can fall back. Each call is
+// sent with NoRetryPolicy so the client's own retries don't multiply these.
 func (p *StorageCredentialsProvider) fetchWithRetry(ctx context.Context) (*StorageCredentials, error) {
+	ctx = withRetryPolicy(ctx, NoRetryPolicy)
 	var lastErr error
 	for attempt := 0; attempt < credsFetchAttempts; attempt++ {
 		c, err := p.Manifest.GetStorageCredentials(ctx, p.DatasetID, p.ManifestNodeID)
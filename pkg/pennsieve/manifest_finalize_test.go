@@ -25,6 +25,8 @@ func (s *FinalizeManifestFilesTestSuite) SetupTest() {
 	s.API2Server = NewMockPennsieveServerDefault(s.T())
 	AWSEndpoints = AWSCognitoEndpoints{IdentityProviderEndpoint: s.IdProviderServer.URL}
 	client := NewClient(APIParams{
+		ApiKey:    "test-key",
+		ApiSecret: "test-secret",
 		ApiHost:  s.APIServer.Server.URL,
 		ApiHost2: s.API2Server.Server.URL,
 	})
@@ -24,8 +24,10 @@ func (s *ManifestServiceTestSuite) SetupTest() {
 	s.API2Server = NewMockPennsieveServerDefault(s.T())
 	AWSEndpoints = AWSCognitoEndpoints{IdentityProviderEndpoint: s.IdProviderServer.URL}
 	client := NewClient(APIParams{
-		ApiHost:  s.APIServer.Server.URL,
-		ApiHost2: s.API2Server.Server.URL,
+		ApiKey:    "test-key",
+		ApiSecret: "test-secret",
+		ApiHost:   s.APIServer.Server.URL,
+		ApiHost2:  s.API2Server.Server.URL,
 	})
 	s.TestService = client.Manifest
 }
@@ -51,7 +53,7 @@ func (s *ManifestServiceTestSuite) TestCreateManifest() {
 		Status: manifest.Initiated,
 	}
 
-	s.API2Server.Mux.HandleFunc("/manifest", func(writer http.ResponseWriter, request *http.Request) {
+	s.API2Server.Mux.HandleFunc("/upload/manifest", func(writer http.ResponseWriter, request *http.Request) {
 		s.Equal("POST", request.Method, "unexpected http method for Create Manifest")
 		err := request.ParseForm()
 		if s.NoError(err) {
@@ -80,3 +82,37 @@ func (s *ManifestServiceTestSuite) TestCreateManifest() {
 func TestManifestService(t *testing.T) {
 	suite.Run(t, new(ManifestServiceTestSuite))
 }
+
+func (s *ManifestServiceTestSuite) TestAllFilesForStatus() {
+	manifestId := "N:manifest:1234"
+	// Tokens are opaque and may need escaping.
+	pages := map[string]manifest.GetStatusEndpointResponse{
+		"":        {ManifestId: manifestId, Files: []string{"u1", "u2"}, ContinuationToken: "abc+/=="},
+		"abc+/==": {ManifestId: manifestId, Files: []string{"u3"}, ContinuationToken: "def"},
+		"def":     {ManifestId: manifestId, Files: []string{"u4"}},
+	}
+	var tokens []string
+	s.API2Server.Mux.HandleFunc("/upload/manifest/status", func(writer http.ResponseWriter, request *http.Request) {
+		q := request.URL.Query()
+		s.Equal(manifestId, q.Get("manifest_id"))
+		s.Equal(manifestFile.Finalized.String(), q.Get("status"))
+		token := q.Get("continuation_token")
+		tokens = append(tokens, token)
+
+		respBody, err := json.Marshal(pages[token])
+		if s.NoError(err) {
+			_, err := writer.Write(respBody)
+			s.NoError(err)
+		}
+	})
+
+	var files []string
+	for file, err := range s.TestService.AllFilesForStatus(context.Background(), manifestId, manifestFile.Finalized) {
+		if !s.NoError(err) {
+			return
+		}
+		files = append(files, file)
+	}
+	s.Equal([]string{"u1", "u2", "u3", "u4"}, files)
+	s.Equal([]string{"", "abc+/==", "def"}, tokens)
+}
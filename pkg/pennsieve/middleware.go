@@ -0,0 +1,43 @@
+package pennsieve
+
+import "net/http"
+
+// RoundTripFunc sends a single HTTP request and returns its response.
+type RoundTripFunc func(req *http.Request) (*http.Response, error)
+
+// Middleware wraps a RoundTripFunc to add behavior around every request the
+// Client sends, e.g. custom headers, request signing, audit logging or fault
+// injection in tests.
+//
+// Middleware runs once per attempt, inside the retry loop, so a signing
+// middleware signs every retry and an injected fault is retried like a real
+// one. Headers set by the Client (auth, organization) are already present
+// when the middleware is called.
+type Middleware func(next RoundTripFunc) RoundTripFunc
+
+// Use appends middleware to the client's chain. The first middleware
+// registered is the outermost: it sees the request first and the response
+// last. All services share the chain, so it applies to every call made
+// through the client.
+//
+// Use may be called while requests are in flight; attempts that have
+// already started keep the chain they started with.
+func (c *Client) Use(mw ...Middleware) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Always copy, so that chains read by roundTrip are never modified.
+	c.middleware = append(c.middleware[:len(c.middleware):len(c.middleware)], mw...)
+}
+
+// roundTrip sends req through the middleware chain, ending at HTTPClient.
+func (c *Client) roundTrip(req *http.Request) (*http.Response, error) {
+	c.mu.RLock()
+	middleware := c.middleware
+	c.mu.RUnlock()
+
+	next := RoundTripFunc(c.HTTPClient.Do)
+	for i := len(middleware) - 1; i >= 0; i-- {
+		next = middleware[i](next)
+	}
+	return next(req)
+}
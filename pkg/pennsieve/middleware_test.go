@@ -0,0 +1,188 @@
+package pennsieve
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pennsieve/pennsieve-go/pkg/pennsieve/models/dataset"
+	"github.com/stretchr/testify/suite"
+)
+
+type MiddlewareTestSuite struct {
+	suite.Suite
+	MockCognitoServer
+	MockPennsieveServer
+	TestClient *Client
+}
+
+func (s *MiddlewareTestSuite) SetupTest() {
+	s.MockCognitoServer = NewMockCognitoServerDefault(s.T())
+	s.MockPennsieveServer = NewMockPennsieveServerDefault(s.T())
+	AWSEndpoints = AWSCognitoEndpoints{IdentityProviderEndpoint: s.IdProviderServer.URL}
+	s.TestClient = NewClient(APIParams{ApiHost: s.Server.URL, ApiKey: "test-key", ApiSecret: "test-secret"})
+	s.TestClient.RetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+}
+
+func (s *MiddlewareTestSuite) TearDownTest() {
+	s.MockCognitoServer.Close()
+	s.MockPennsieveServer.Close()
+	AWSEndpoints.Reset()
+}
+
+func headerMiddleware(key, value string) Middleware {
+	return func(next RoundTripFunc) RoundTripFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			req.Header.Set(key, value)
+			return next(req)
+		}
+	}
+}
+
+// TestMiddlewareAppliesToServices checks that middleware registered on the
+// client is used by both authenticated and unauthenticated service calls.
+func (s *MiddlewareTestSuite) TestMiddlewareAppliesToServices() {
+	s.TestClient.Use(headerMiddleware("X-Custom", "custom-value"))
+
+	s.Mux.HandleFunc("/datasets/N:Dataset:1234", func(writer http.ResponseWriter, request *http.Request) {
+		s.Equal("custom-value", request.Header.Get("X-Custom"))
+		s.NotEmpty(request.Header.Get("Authorization"), "client headers should be set before middleware runs")
+		body, err := json.Marshal(dataset.GetDatasetResponse{})
+		if s.NoError(err) {
+			_, err = writer.Write(body)
+			s.NoError(err)
+		}
+	})
+	s.Mux.HandleFunc("/discover/datasets/1/versions/1", func(writer http.ResponseWriter, request *http.Request) {
+		s.Equal("custom-value", request.Header.Get("X-Custom"))
+		_, err := writer.Write([]byte(`{}`))
+		s.NoError(err)
+	})
+
+	_, err := s.TestClient.Dataset.Get(context.Background(), "N:Dataset:1234")
+	s.NoError(err)
+	_, err = s.TestClient.Discover.GetDatasetByVersion(context.Background(), 1, 1)
+	s.NoError(err)
+}
+
+func (s *MiddlewareTestSuite) TestMiddlewareOrder() {
+	var order []string
+	record := func(name string) Middleware {
+		return func(next RoundTripFunc) RoundTripFunc {
+			return func(req *http.Request) (*http.Response, error) {
+				order = append(order, name+":before")
+				res, err := next(req)
+				order = append(order, name+":after")
+				return res, err
+			}
+		}
+	}
+	s.TestClient.Use(record("outer"), record("inner"))
+
+	s.Mux.HandleFunc("/ordered", func(writer http.ResponseWriter, request *http.Request) {
+		_, err := writer.Write([]byte(`{}`))
+		s.NoError(err)
+	})
+	req, err := http.NewRequest("GET", s.Server.URL+"/ordered", nil)
+	s.NoError(err)
+	s.NoError(s.TestClient.sendUnauthenticatedRequest(context.Background(), req, &responseBody{}))
+
+	s.Equal([]string{"outer:before", "inner:before", "inner:after", "outer:after"}, order)
+}
+
+// TestMiddlewareFaultInjection checks that faults injected by middleware go
+// through the same retry handling as real failures.
+func (s *MiddlewareTestSuite) TestMiddlewareFaultInjection() {
+	var attempts atomic.Int32
+	s.TestClient.Use(func(next RoundTripFunc) RoundTripFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			if req.URL.Path != "/faulty" {
+				return next(req)
+			}
+			if attempts.Add(1) == 1 {
+				return nil, &timeoutError{}
+			}
+			return next(req)
+		}
+	})
+
+	s.Mux.HandleFunc("/faulty", func(writer http.ResponseWriter, request *http.Request) {
+		_, err := writer.Write([]byte(`{"name": "recovered"}`))
+		s.NoError(err)
+	})
+	req, err := http.NewRequest("GET", s.Server.URL+"/faulty", nil)
+	s.NoError(err)
+	resp := responseBody{}
+	s.NoError(s.TestClient.sendRequest(context.Background(), req, &resp))
+	s.Equal("recovered", resp.Name)
+	s.Equal(int32(2), attempts.Load())
+}
+
+func (s *MiddlewareTestSuite) TestMiddlewareShortCircuit() {
+	expectedErr := errors.New("blocked by middleware")
+	s.TestClient.Use(func(next RoundTripFunc) RoundTripFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			return nil, expectedErr
+		}
+	})
+
+	req, err := http.NewRequest("GET", s.Server.URL+"/never-called", nil)
+	s.NoError(err)
+	s.ErrorIs(s.TestClient.sendUnauthenticatedRequest(context.Background(), req, &responseBody{}), expectedErr)
+}
+
+// TestUseDuringRequests registers middleware and sets loggers while requests
+// are in flight. Run with -race.
+func (s *MiddlewareTestSuite) TestUseDuringRequests() {
+	var served atomic.Int32
+	s.Mux.HandleFunc("/datasets/N:Dataset:1234", func(writer http.ResponseWriter, request *http.Request) {
+		served.Add(1)
+		_, _ = writer.Write([]byte(`{}`))
+	})
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				_, err := s.TestClient.Dataset.Get(context.Background(), "N:Dataset:1234")
+				s.NoError(err)
+			}
+		}()
+	}
+	// Start once requests are past authentication.
+	for served.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 100; i++ {
+		s.TestClient.Use(headerMiddleware("X-Custom", "custom-value"))
+		s.TestClient.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	}
+	close(stop)
+	wg.Wait()
+}
+
+// timeoutError is a net.Error that reports a timeout.
+type timeoutError struct{}
+
+func (*timeoutError) Error() string   { return "injected timeout" }
+func (*timeoutError) Timeout() bool   { return true }
+func (*timeoutError) Temporary() bool { return true }
+
+func TestMiddlewareSuite(t *testing.T) {
+	suite.Run(t, new(MiddlewareTestSuite))
+}
@@ -10,6 +10,7 @@ type UserPool struct {
 	Region      string `json:"region"`
 	ID          string `json:"id"`
 	AppClientID string `json:"appClientId"`
+	Domain      string `json:"domain,omitempty"` // base URL of the hosted UI
 }
 type TokenPool struct {
 	Region      string `json:"region"`
@@ -0,0 +1,19 @@
+//go:build !plan9
+
+package pennsieve
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isConnRefused reports whether err is a refused connection: nothing was
+// sent.
+func isConnRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// isConnReset reports whether err is a connection reset by the peer.
+func isConnReset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET)
+}
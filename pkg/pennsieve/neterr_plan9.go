@@ -0,0 +1,16 @@
+//go:build plan9
+
+package pennsieve
+
+import "strings"
+
+// isConnRefused reports whether err is a refused connection: nothing was
+// sent. Plan 9 has no errno values; its network errors are only text.
+func isConnRefused(err error) bool {
+	return strings.Contains(err.Error(), "connection refused")
+}
+
+// isConnReset reports whether err is a connection reset by the peer.
+func isConnReset(err error) bool {
+	return strings.Contains(err.Error(), "connection reset")
+}
@@ -0,0 +1,254 @@
+package pennsieve
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/pennsieve/pennsieve-go/pkg/pennsieve/models/authentication"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ClientOption configures a Client created with NewClientWithOptions. Use
+// the provided With* helpers to set values.
+type ClientOption func(*clientOptions)
+
+type clientOptions struct {
+	params          APIParams
+	httpClient      *http.Client
+	logger          *slog.Logger
+	loggerSet       bool
+	awsConfig       *aws.Config
+	cognitoConfig   *authentication.CognitoConfig
+	retryPolicy     *RetryPolicy
+	maxResponseBody int64
+	middleware      []Middleware
+	credentials     CredentialsProvider
+	session         *APISession
+	cassette        *Cassette
+	dryRun          bool
+	environmentName string
+	environment     *Environment // resolved from environmentName
+	journal         IdempotencyJournal
+	sessionCache    *SessionCache
+	srp             bool
+	browserLogin    BrowserLoginConfig
+	jwks            *JWKS
+
+	tracerProvider trace.TracerProvider
+	meterProvider  metric.MeterProvider
+}
+
+// NewClientWithOptions creates a new Pennsieve client configured by opts.
+//
+// Unlike NewClient, hosts that are not set default to those of the
+// environment selected with WithEnvironment, or else to the production API
+// (BaseURLV1, BaseURLV2) and DefaultUploadBucket, and configuration errors,
+// such as a missing config file profile, are returned instead of being
+// logged or deferred. No network calls are made: the
+// Cognito config is loaded on first authentication unless WithCognitoConfig
+// is given.
+func NewClientWithOptions(opts ...ClientOption) (*Client, error) {
+	var o clientOptions
+	for _, fn := range opts {
+		fn(&o)
+	}
+
+	params, err := loadProfileParams(o.params)
+	if err != nil {
+		return nil, err
+	}
+	o.params = params
+
+	if o.environmentName != "" {
+		env, err := LookupEnvironment(o.environmentName)
+		if err != nil {
+			return nil, err
+		}
+		o.environment = &env
+		if o.params.ApiHost == "" {
+			o.params.ApiHost = env.ApiHost
+		}
+		if o.params.ApiHost2 == "" {
+			o.params.ApiHost2 = env.ApiHost2
+		}
+		if o.params.UploadBucket == "" {
+			o.params.UploadBucket = env.UploadBucket
+		}
+	}
+	o.params = withDefaultHosts(o.params)
+
+	if err := o.useDefaultSessionCache(); err != nil {
+		return nil, err
+	}
+
+	if o.awsConfig == nil {
+		cfg, err := newAwsConfig()
+		if err != nil {
+			return nil, err
+		}
+		o.awsConfig = &cfg
+	}
+
+	return newClient(o), nil
+}
+
+// WithAPIParams sets the client's parameters (credentials, hosts, profile).
+// Options applied after it, such as WithBaseURLs, override its values.
+// Set UseConfigFile to load a profile from the Pennsieve config file.
+func WithAPIParams(params APIParams) ClientOption {
+	return func(o *clientOptions) { o.params = params }
+}
+
+// WithProfile loads credentials and hosts from the named profile of the
+// Pennsieve config file. An empty name selects the profile from
+// PENNSIEVE_PROFILE or the file's default_profile. Hosts and credentials set
+// by other options override the profile's.
+func WithProfile(name string) ClientOption {
+	return func(o *clientOptions) {
+		o.params.UseConfigFile = true
+		o.params.Profile = name
+	}
+}
+
+// WithCredentials sets the API key and secret used to authenticate.
+func WithCredentials(apiKey, apiSecret string) ClientOption {
+	return func(o *clientOptions) {
+		o.params.ApiKey = apiKey
+		o.params.ApiSecret = apiSecret
+	}
+}
+
+// WithBaseURLs sets the hosts of the Pennsieve API (apiHost, e.g.
+// https://api.pennsieve.io) and API v2 (apiHost2, e.g.
+// https://api2.pennsieve.io).
+func WithBaseURLs(apiHost, apiHost2 string) ClientOption {
+	return func(o *clientOptions) {
+		o.params.ApiHost = apiHost
+		o.params.ApiHost2 = apiHost2
+	}
+}
+
+// WithHTTPClient sets the HTTP client used for Pennsieve API calls.
+func WithHTTPClient(httpClient *http.Client) ClientOption {
+	return func(o *clientOptions) { o.httpClient = httpClient }
+}
+
+// WithLogger sets the client's logger. See Client.SetLogger.
+func WithLogger(logger *slog.Logger) ClientOption {
+	return func(o *clientOptions) {
+		o.logger = logger
+		o.loggerSet = true
+	}
+}
+
+// WithAWSConfig sets the AWS config used for Cognito calls. When set, the
+// global AWSEndpoints overrides are not applied; configure endpoints on cfg
+// instead.
+func WithAWSConfig(cfg aws.Config) ClientOption {
+	return func(o *clientOptions) { o.awsConfig = &cfg }
+}
+
+// WithCognitoConfig sets the Cognito pools used to authenticate so the client
+// does not fetch them from /authentication/cognito-config.
+func WithCognitoConfig(cfg authentication.CognitoConfig) ClientOption {
+	return func(o *clientOptions) { o.cognitoConfig = &cfg }
+}
+
+// WithRetryPolicy sets the client's retry policy. See Client.RetryPolicy.
+func WithRetryPolicy(policy RetryPolicy) ClientOption {
+	return func(o *clientOptions) { o.retryPolicy = &policy }
+}
+
+// WithMaxResponseBodySize sets the largest response body a service call
+// decodes. See Client.MaxResponseBodySize.
+func WithMaxResponseBodySize(n int64) ClientOption {
+	return func(o *clientOptions) { o.maxResponseBody = n }
+}
+
+// WithMiddleware adds middleware to the client. See Client.Use.
+func WithMiddleware(mw ...Middleware) ClientOption {
+	return func(o *clientOptions) { o.middleware = append(o.middleware, mw...) }
+}
+
+// WithCredentialsProvider sets where the client gets its credentials from,
+// replacing the default chain. See Client.SetCredentialsProvider.
+func WithCredentialsProvider(p CredentialsProvider) ClientOption {
+	return func(o *clientOptions) { o.credentials = p }
+}
+
+// WithSession starts the client with an existing session. Its token is used
+// until it expires, after which the default credentials chain refreshes it
+// with the session's refresh token unless other credentials are found first.
+// Its ID token, or access token if it has none, is verified before the
+// session is first used; see Client.SetSession.
+func WithSession(s APISession) ClientOption {
+	return func(o *clientOptions) { o.session = &s }
+}
+
+// WithTracerProvider enables OpenTelemetry tracing. See
+// Client.SetTracerProvider.
+func WithTracerProvider(tp trace.TracerProvider) ClientOption {
+	return func(o *clientOptions) { o.tracerProvider = tp }
+}
+
+// WithMeterProvider enables OpenTelemetry metrics. See
+// Client.SetMeterProvider.
+func WithMeterProvider(mp metric.MeterProvider) ClientOption {
+	return func(o *clientOptions) { o.meterProvider = mp }
+}
+
+// WithCassette records or replays all of the client's HTTP traffic with c,
+// both Pennsieve API calls and the Cognito calls made to authenticate. In
+// replay mode no network calls are made. If c.Transport is nil, recording
+// goes through the transport of the HTTP client set with WithHTTPClient.
+func WithCassette(c *Cassette) ClientOption {
+	return func(o *clientOptions) { o.cassette = c }
+}
+
+// WithDryRun starts the client in dry-run mode. See Client.SetDryRun.
+func WithDryRun() ClientOption {
+	return func(o *clientOptions) { o.dryRun = true }
+}
+
+// WithEnvironment selects a registered environment, such as EnvironmentDev:
+// its hosts and upload bucket are used unless set by other options, and its
+// Cognito endpoints are applied on top of the AWS config. See
+// RegisterEnvironment.
+func WithEnvironment(name string) ClientOption {
+	return func(o *clientOptions) { o.environmentName = name }
+}
+
+// WithIdempotencyJournal dedupes requests made with WithIdempotencyKey
+// through j. See Client.SetIdempotencyJournal.
+func WithIdempotencyJournal(j IdempotencyJournal) ClientOption {
+	return func(o *clientOptions) { o.journal = j }
+}
+
+// WithSessionCache reuses sessions stored in cache and stores new ones
+// there. See SessionCache and APIParams.UseSessionCache.
+func WithSessionCache(cache *SessionCache) ClientOption {
+	return func(o *clientOptions) { o.sessionCache = cache }
+}
+
+// WithSRPAuthentication signs in with Cognito's USER_SRP_AUTH flow instead
+// of USER_PASSWORD_AUTH, for both API keys and LoginWithPassword: the client
+// proves it knows the secret or password without sending it. The app
+// clients must allow ALLOW_USER_SRP_AUTH.
+func WithSRPAuthentication() ClientOption {
+	return func(o *clientOptions) { o.srp = true }
+}
+
+// WithBrowserLogin configures LoginWithBrowser, e.g. its hosted UI domain
+// and redirect listener. See BrowserLoginConfig.
+func WithBrowserLogin(cfg BrowserLoginConfig) ClientOption {
+	return func(o *clientOptions) { o.browserLogin = cfg }
+}
+
+// WithJWKS verifies ID tokens against set instead of the key sets fetched
+// from Cognito. It is meant for tests that sign tokens with a locally
+// generated key; the iss, aud and token_use claims are still checked.
+func WithJWKS(set *JWKS) ClientOption {
+	return func(o *clientOptions) { o.jwks = set }
+}
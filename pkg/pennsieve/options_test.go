@@ -0,0 +1,159 @@
+package pennsieve
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+// failingTransport fails the test if the client makes any request.
+type failingTransport struct {
+	t *testing.T
+}
+
+func (f failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.t.Errorf("unexpected request during construction: %s %s", req.Method, req.URL)
+	return nil, errors.New("no network")
+}
+
+// newTestAWSConfig returns an AWS config that sends Cognito calls to endpoint.
+func newTestAWSConfig(endpoint string) aws.Config {
+	return aws.Config{
+		Region: "us-east-1",
+		EndpointResolverWithOptions: aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+			return aws.Endpoint{URL: endpoint}, nil
+		}),
+	}
+}
+
+func TestNewClientWithOptionsDefaults(t *testing.T) {
+	client, err := NewClientWithOptions(
+		WithHTTPClient(&http.Client{Transport: failingTransport{t}}),
+		WithCredentials("key", "secret"),
+	)
+	if assert.NoError(t, err) {
+		params := client.GetAPIParams()
+		assert.Equal(t, BaseURLV1, params.ApiHost)
+		assert.Equal(t, BaseURLV2, params.ApiHost2)
+		assert.Equal(t, DefaultUploadBucket, params.UploadBucket)
+		assert.Equal(t, "key", params.ApiKey)
+		assert.Equal(t, "secret", params.ApiSecret)
+	}
+}
+
+func TestNewClientWithOptionsOverrides(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	policy := RetryPolicy{MaxAttempts: 7}
+	httpClient := &http.Client{Transport: failingTransport{t}}
+
+	client, err := NewClientWithOptions(
+		WithAPIParams(APIParams{ApiKey: "key", ApiHost: "https://one", ApiHost2: "https://two"}),
+		WithBaseURLs("https://api.example.com", "https://api2.example.com"),
+		WithHTTPClient(httpClient),
+		WithLogger(logger),
+		WithRetryPolicy(policy),
+		WithMaxResponseBodySize(1<<20),
+		WithAWSConfig(newTestAWSConfig("https://cognito.example.com")),
+	)
+	if assert.NoError(t, err) {
+		params := client.GetAPIParams()
+		assert.Equal(t, "key", params.ApiKey)
+		assert.Equal(t, "https://api.example.com", params.ApiHost)
+		assert.Equal(t, "https://api2.example.com", params.ApiHost2)
+		assert.Same(t, httpClient, client.HTTPClient)
+		assert.Equal(t, policy, client.RetryPolicy)
+		assert.Equal(t, int64(1<<20), client.MaxResponseBodySize)
+		assert.NotNil(t, client.Logger())
+	}
+}
+
+type ClientOptionsTestSuite struct {
+	suite.Suite
+	MockCognitoServer
+	API                MockServer
+	cognitoConfigCalls atomic.Int32
+}
+
+func (s *ClientOptionsTestSuite) SetupTest() {
+	s.MockCognitoServer = NewMockCognitoServerDefault(s.T())
+	mux := http.NewServeMux()
+	s.API = MockServer{Server: httptest.NewServer(mux), Mux: mux}
+	s.cognitoConfigCalls.Store(0)
+}
+
+func (s *ClientOptionsTestSuite) TearDownTest() {
+	s.MockCognitoServer.Close()
+	s.API.Close()
+}
+
+func (s *ClientOptionsTestSuite) newClient(opts ...ClientOption) *Client {
+	opts = append([]ClientOption{
+		WithCredentials("key", "secret"),
+		WithBaseURLs(s.API.Server.URL, s.API.Server.URL),
+		WithAWSConfig(newTestAWSConfig(s.IdProviderServer.URL)),
+		WithRetryPolicy(NoRetryPolicy),
+	}, opts...)
+	client, err := NewClientWithOptions(opts...)
+	s.Require().NoError(err)
+	return client
+}
+
+// TestWithCognitoConfigSkipsFetch authenticates without the cognito-config
+// endpoint being available.
+func (s *ClientOptionsTestSuite) TestWithCognitoConfigSkipsFetch() {
+	s.API.Mux.HandleFunc("/authentication/cognito-config", func(writer http.ResponseWriter, request *http.Request) {
+		s.Fail("cognito-config should not be fetched when WithCognitoConfig is given")
+	})
+	client := s.newClient(WithCognitoConfig(mockCognitoConfig))
+
+	session, err := client.Authentication.Authenticate("key", "secret")
+	if s.NoError(err) {
+		s.NotEmpty(session.Token)
+	}
+	_, orgNodeId := client.GetOrganization()
+	s.Equal("N:Organization:abcd", orgNodeId)
+}
+
+// TestCognitoConfigLoadedLazilyOnce checks that the config is fetched on the
+// first authentication, not at construction, and then cached.
+func (s *ClientOptionsTestSuite) TestCognitoConfigLoadedLazilyOnce() {
+	s.API.Mux.HandleFunc("/authentication/cognito-config", func(writer http.ResponseWriter, request *http.Request) {
+		s.cognitoConfigCalls.Add(1)
+		_, err := writer.Write([]byte(`{"tokenPool": {"appClientId": "mockTokenPoolAppClientId", "id": "mock-token-pool-id"}}`))
+		s.NoError(err)
+	})
+	client := s.newClient()
+	s.Equal(int32(0), s.cognitoConfigCalls.Load(), "construction must not call the API")
+
+	_, err := client.Authentication.Authenticate("key", "secret")
+	s.NoError(err)
+	_, err = client.Authentication.Authenticate("key", "secret")
+	s.NoError(err)
+	s.Equal(int32(1), s.cognitoConfigCalls.Load())
+}
+
+func (s *ClientOptionsTestSuite) TestCognitoConfigErrorIsReturned() {
+	s.API.Mux.HandleFunc("/authentication/cognito-config", func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusInternalServerError)
+	})
+	client := s.newClient()
+
+	_, err := client.Authentication.Authenticate("key", "secret")
+	var httpErr *HTTPError
+	if s.ErrorAs(err, &httpErr) {
+		s.Equal(http.StatusInternalServerError, httpErr.StatusCode)
+	}
+	s.ErrorContains(err, "cognito config")
+}
+
+func TestClientOptionsSuite(t *testing.T) {
+	suite.Run(t, new(ClientOptionsTestSuite))
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/pennsieve/pennsieve-go/pkg/pennsieve/models/organization"
 	"net/http"
+	"sync"
 )
 
 type OrganizationService interface {
@@ -16,6 +17,7 @@ type OrganizationService interface {
 type organizationService struct {
 	client  PennsieveHTTPClient
 	baseUrl string
+	mu      sync.RWMutex // guards baseUrl
 }
 
 func NewOrganizationService(client PennsieveHTTPClient, baseUrl string) *organizationService {
@@ -28,7 +30,7 @@ func NewOrganizationService(client PennsieveHTTPClient, baseUrl string) *organiz
 // List lists all the organizations that the user belongs to.
 func (o *organizationService) List(ctx context.Context) (*organization.GetOrganizationsResponse, error) {
 
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/organizations", o.baseUrl), nil)
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/organizations", o.host()), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -44,7 +46,7 @@ func (o *organizationService) List(ctx context.Context) (*organization.GetOrgani
 // Get returns a single organization by id.
 func (o *organizationService) Get(ctx context.Context, id string) (*organization.GetOrganizationResponse, error) {
 
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/organizations/%s", o.baseUrl, id), nil)
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/organizations/%s", o.host(), id), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -56,7 +58,7 @@ func (o *organizationService) Get(ctx context.Context, id string) (*organization
 	res := organization.GetOrganizationResponse{}
 	if err := o.client.sendRequest(ctx, req, &res); err != nil {
 
-		fmt.Println("ERROR: ", err)
+		o.client.Logger().DebugContext(ctx, "OrganizationService.Get failed", "error", err)
 		return nil, err
 	}
 
@@ -64,5 +66,13 @@ func (o *organizationService) Get(ctx context.Context, id string) (*organization
 }
 
 func (s *organizationService) SetBaseUrl(url string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.baseUrl = url
 }
+
+func (s *organizationService) host() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.baseUrl
+}
@@ -21,7 +21,7 @@ func (s *OrganizationServiceTestSuite) SetupTest() {
 	s.MockPennsieveServer = NewMockPennsieveServerDefault(s.T())
 	AWSEndpoints = AWSCognitoEndpoints{IdentityProviderEndpoint: s.IdProviderServer.URL}
 	client := NewClient(
-		APIParams{ApiHost: s.Server.URL})
+		APIParams{ApiHost: s.Server.URL, ApiKey: "test-key", ApiSecret: "test-secret"})
 	s.TestService = client.Organization
 }
 
@@ -0,0 +1,115 @@
+package pennsieve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/pennsieve/pennsieve-go/pkg/pennsieve/models/organization"
+)
+
+// maxOrganizationFanOut is the number of organizations AcrossOrganizations
+// calls concurrently.
+const maxOrganizationFanOut = 4
+
+type organizationKey struct{}
+
+// WithOrganization returns a copy of ctx that scopes requests made with it
+// to the organization with node id orgNodeId. It overrides the client's
+// organization (see Client.SetOrganization) for those requests only, so one
+// client can serve several organizations concurrently:
+//
+//	ctx := pennsieve.WithOrganization(ctx, "N:organization:1234")
+//	datasets, err := client.Dataset.List(ctx, 25, 0)
+func WithOrganization(ctx context.Context, orgNodeId string) context.Context {
+	return context.WithValue(ctx, organizationKey{}, orgNodeId)
+}
+
+// OrganizationFromContext returns the organization node id set on ctx with
+// WithOrganization.
+func OrganizationFromContext(ctx context.Context) (orgNodeId string, ok bool) {
+	if ctx == nil {
+		return "", false
+	}
+	orgNodeId, ok = ctx.Value(organizationKey{}).(string)
+	return orgNodeId, ok
+}
+
+// requestOrganization returns the organization node id to send with a
+// request made with ctx.
+func (c *Client) requestOrganization(ctx context.Context) string {
+	if orgNodeId, ok := OrganizationFromContext(ctx); ok {
+		return orgNodeId
+	}
+	_, orgNodeId := c.GetOrganization()
+	return orgNodeId
+}
+
+// OrganizationResult is the outcome of a call made for one organization by
+// AcrossOrganizations.
+type OrganizationResult[T any] struct {
+	Organization organization.Organization
+	Value        T
+	Err          error
+}
+
+// AcrossOrganizations calls fn once for every organization the user belongs
+// to, as returned by Organization.List. Each call gets a context scoped to
+// its organization with WithOrganization, so any request fn makes through c
+// is sent to that organization. Calls run concurrently.
+//
+// Results are returned in the order of Organization.List, with per
+// organization errors in OrganizationResult.Err. The returned error is only
+// set when the organizations cannot be listed.
+func AcrossOrganizations[T any](ctx context.Context, c *Client, fn func(ctx context.Context, org organization.Organization) (T, error)) ([]OrganizationResult[T], error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	orgs, err := c.Organization.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]OrganizationResult[T], len(orgs.Organizations))
+	sem := make(chan struct{}, maxOrganizationFanOut)
+	var wg sync.WaitGroup
+	for i, o := range orgs.Organizations {
+		results[i].Organization = o.Organization
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				results[i].Err = ctx.Err()
+				return
+			}
+			results[i].Value, results[i].Err = fn(WithOrganization(ctx, o.Organization.ID), o.Organization)
+		}()
+	}
+	wg.Wait()
+
+	return results, nil
+}
+
+// ForEachOrganization calls fn once for every organization the user belongs
+// to, like AcrossOrganizations. Errors from fn are wrapped with the
+// organization's node id and joined.
+func (c *Client) ForEachOrganization(ctx context.Context, fn func(ctx context.Context, org organization.Organization) error) error {
+	results, err := AcrossOrganizations(ctx, c, func(ctx context.Context, org organization.Organization) (struct{}, error) {
+		return struct{}{}, fn(ctx, org)
+	})
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, r := range results {
+		if r.Err != nil {
+			errs = append(errs, fmt.Errorf("organization %s: %w", r.Organization.ID, r.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
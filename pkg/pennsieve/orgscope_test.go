@@ -0,0 +1,163 @@
+package pennsieve
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/pennsieve/pennsieve-go/pkg/pennsieve/models/organization"
+	"github.com/stretchr/testify/suite"
+)
+
+type OrgScopeTestSuite struct {
+	suite.Suite
+	MockCognitoServer
+	MockPennsieveServer
+	TestClient *Client
+}
+
+func (s *OrgScopeTestSuite) SetupTest() {
+	s.MockCognitoServer = NewMockCognitoServerDefault(s.T())
+	s.MockPennsieveServer = NewMockPennsieveServerDefault(s.T())
+	AWSEndpoints = AWSCognitoEndpoints{IdentityProviderEndpoint: s.IdProviderServer.URL}
+	s.TestClient = NewClient(APIParams{ApiHost: s.Server.URL, ApiHost2: s.Server.URL, ApiKey: "test-key", ApiSecret: "test-secret"})
+
+	// The dataset's name is the organization the request was sent to.
+	s.Mux.HandleFunc("/datasets/N:dataset:1", func(writer http.ResponseWriter, request *http.Request) {
+		orgNodeId := request.Header.Get("X-ORGANIZATION-ID")
+		if orgNodeId == "N:organization:broken" {
+			http.Error(writer, "no access", http.StatusForbidden)
+			return
+		}
+		_, _ = fmt.Fprintf(writer, `{"content": {"name": %q}}`, orgNodeId)
+	})
+}
+
+func (s *OrgScopeTestSuite) TearDownTest() {
+	s.MockCognitoServer.Close()
+	s.MockPennsieveServer.Close()
+	AWSEndpoints.Reset()
+}
+
+func (s *OrgScopeTestSuite) handleOrganizations(ids ...string) {
+	s.Mux.HandleFunc("/organizations", func(writer http.ResponseWriter, request *http.Request) {
+		var res organization.GetOrganizationsResponse
+		for _, id := range ids {
+			res.Organizations = append(res.Organizations, organization.Organizations{
+				Organization: organization.Organization{ID: id, Name: "Org " + id},
+			})
+		}
+		s.NoError(json.NewEncoder(writer).Encode(res))
+	})
+}
+
+func (s *OrgScopeTestSuite) datasetOrg(ctx context.Context) string {
+	ds, err := s.TestClient.Dataset.Get(ctx, "N:dataset:1")
+	s.Require().NoError(err)
+	return ds.Content.Name
+}
+
+func (s *OrgScopeTestSuite) TestContextOverridesClientOrganization() {
+	ctx := context.Background()
+	s.Equal("N:Organization:abcd", s.datasetOrg(ctx), "the organization from the ID token is the default")
+
+	scoped := WithOrganization(ctx, "N:organization:other")
+	s.Equal("N:organization:other", s.datasetOrg(scoped))
+	s.Equal("N:Organization:abcd", s.datasetOrg(ctx), "the override should not stick to the client")
+
+	_, orgNodeId := s.TestClient.GetOrganization()
+	s.Equal("N:Organization:abcd", orgNodeId)
+
+	got, ok := OrganizationFromContext(scoped)
+	s.True(ok)
+	s.Equal("N:organization:other", got)
+	_, ok = OrganizationFromContext(ctx)
+	s.False(ok)
+}
+
+func (s *OrgScopeTestSuite) TestConcurrentOrganizations() {
+	// Authenticate first so the goroutines below only race on the header.
+	s.datasetOrg(context.Background())
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			orgNodeId := fmt.Sprintf("N:organization:%d", i)
+			ds, err := s.TestClient.Dataset.Get(WithOrganization(context.Background(), orgNodeId), "N:dataset:1")
+			if s.NoError(err) {
+				s.Equal(orgNodeId, ds.Content.Name)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *OrgScopeTestSuite) TestAcrossOrganizations() {
+	s.handleOrganizations("N:organization:1", "N:organization:broken", "N:organization:3")
+
+	results, err := AcrossOrganizations(context.Background(), s.TestClient,
+		func(ctx context.Context, org organization.Organization) (string, error) {
+			ds, err := s.TestClient.Dataset.Get(ctx, "N:dataset:1")
+			if err != nil {
+				return "", err
+			}
+			return ds.Content.Name, nil
+		})
+	s.Require().NoError(err)
+	s.Require().Len(results, 3)
+
+	s.Equal("N:organization:1", results[0].Organization.ID)
+	s.Equal("N:organization:1", results[0].Value)
+	s.NoError(results[0].Err)
+	s.ErrorIs(results[1].Err, ErrForbidden)
+	s.Equal("N:organization:3", results[2].Value)
+}
+
+func (s *OrgScopeTestSuite) TestForEachOrganization() {
+	s.handleOrganizations("N:organization:1", "N:organization:broken", "N:organization:3")
+
+	var mu sync.Mutex
+	seen := map[string]string{}
+	err := s.TestClient.ForEachOrganization(context.Background(), func(ctx context.Context, org organization.Organization) error {
+		ds, err := s.TestClient.Dataset.Get(ctx, "N:dataset:1")
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		seen[org.ID] = ds.Content.Name
+		return nil
+	})
+
+	s.ErrorIs(err, ErrForbidden)
+	s.ErrorContains(err, "organization N:organization:broken")
+	s.Equal(map[string]string{
+		"N:organization:1": "N:organization:1",
+		"N:organization:3": "N:organization:3",
+	}, seen)
+}
+
+func (s *OrgScopeTestSuite) TestListFailure() {
+	s.Mux.HandleFunc("/organizations", func(writer http.ResponseWriter, request *http.Request) {
+		http.Error(writer, "down", http.StatusBadRequest)
+	})
+
+	called := false
+	err := s.TestClient.ForEachOrganization(context.Background(), func(ctx context.Context, org organization.Organization) error {
+		called = true
+		return nil
+	})
+	var httpErr *HTTPError
+	s.True(errors.As(err, &httpErr))
+	s.False(called)
+}
+
+func TestOrgScopeSuite(t *testing.T) {
+	suite.Run(t, new(OrgScopeTestSuite))
+}
@@ -4,13 +4,17 @@ import (
 	"context"
 	"fmt"
 	"github.com/pennsieve/pennsieve-go/pkg/pennsieve/models/ps_package"
-	"log"
+	"iter"
 	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
 )
 
 type PackageService interface {
 	GetPresignedUrl(ctx context.Context, packageId string, short bool) (*ps_package.GetPresignedUrlResponse, error)
 	GetPackageSources(ctx context.Context, packageId string) (*ps_package.GetPackageSourcesResponse, error)
+	AllSources(ctx context.Context, packageId string) iter.Seq2[ps_package.Result, error]
 	SetBaseUrl(url string, url2 string)
 }
 
@@ -18,6 +22,7 @@ type packageService struct {
 	client   PennsieveHTTPClient
 	baseUrl  string
 	baseUrl2 string
+	mu       sync.RWMutex // guards baseUrl and baseUrl2
 }
 
 func NewPackageService(client PennsieveHTTPClient, baseUrl string, baseUrl2 string) *packageService {
@@ -30,14 +35,47 @@ func NewPackageService(client PennsieveHTTPClient, baseUrl string, baseUrl2 stri
 }
 
 func (p *packageService) SetBaseUrl(url string, url2 string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	p.baseUrl = url
 	p.baseUrl2 = url2
 }
 
-// GetPackageSources returns an array of package resource files.
+func (p *packageService) host() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.baseUrl
+}
+
+// GetPackageSources returns the first page of package resource files. Use
+// AllSources to iterate over all of them.
 func (p *packageService) GetPackageSources(ctx context.Context, packageId string) (*ps_package.GetPackageSourcesResponse, error) {
+	return p.getSourcesPage(ctx, packageId, nil)
+}
+
+// AllSources iterates over all resource files of a package, fetching pages
+// as needed.
+func (p *packageService) AllSources(ctx context.Context, packageId string) iter.Seq2[ps_package.Result, error] {
+	return offsetPages(ctx, defaultPageSize, func(ctx context.Context, limit, offset int) ([]ps_package.Result, int, error) {
+		params := url.Values{}
+		params.Add("limit", strconv.Itoa(limit))
+		params.Add("offset", strconv.Itoa(offset))
+		res, err := p.getSourcesPage(ctx, packageId, params)
+		if err != nil {
+			return nil, 0, err
+		}
+		return res.Results, int(res.TotalCount), nil
+	})
+}
+
+func (p *packageService) getSourcesPage(ctx context.Context, packageId string, params url.Values) (*ps_package.GetPackageSourcesResponse, error) {
+
+	requestStr := fmt.Sprintf("%s/packages/%s/sources-paged", p.host(), packageId)
+	if len(params) > 0 {
+		requestStr += "?" + params.Encode()
+	}
 
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/packages/%s/sources-paged", p.baseUrl, packageId), nil)
+	req, err := http.NewRequest("GET", requestStr, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -46,10 +84,9 @@ func (p *packageService) GetPackageSources(ctx context.Context, packageId string
 		ctx = req.Context()
 	}
 
-	fmt.Println(req.URL.String())
 	res := ps_package.GetPackageSourcesResponse{}
 	if err := p.client.sendRequest(ctx, req, &res); err != nil {
-		log.Println("Error getting sources for package:", err)
+		p.client.Logger().DebugContext(ctx, "PackageService.GetPackageSources failed", "package_id", packageId, "error", err)
 		return nil, err
 	}
 
@@ -59,19 +96,17 @@ func (p *packageService) GetPackageSources(ctx context.Context, packageId string
 // GetPresignedUrl returns a pre-signed URL for a file in a package.
 func (p *packageService) GetPresignedUrl(ctx context.Context, packageId string, short bool) (*ps_package.GetPresignedUrlResponse, error) {
 
-	// --- Get Sources for Package ---
-	sources, err := p.GetPackageSources(ctx, packageId)
-	if err != nil {
-		log.Println("Error getting presigned url for package:", err)
-		return nil, err
-	}
-
-	// --- Get Presigned URL for sources ---
+	// --- Get Presigned URL for each source ---
 	var resultFileInfo []ps_package.PresignedFileInfo
-	for i, _ := range sources.Results {
-		fileId := sources.Results[i].Content.ID
+	for source, err := range p.AllSources(ctx, packageId) {
+		if err != nil {
+			p.client.Logger().DebugContext(ctx, "PackageService.GetPresignedUrl failed", "package_id", packageId, "error", err)
+			return nil, err
+		}
+
+		fileId := source.Content.ID
 		req, err := http.NewRequest("GET",
-			fmt.Sprintf("%s/packages/%s/files/%d?short=%t", p.baseUrl, packageId, fileId, short), nil)
+			fmt.Sprintf("%s/packages/%s/files/%d?short=%t", p.host(), packageId, fileId, short), nil)
 		if err != nil {
 			return nil, err
 		}
@@ -83,7 +118,7 @@ func (p *packageService) GetPresignedUrl(ctx context.Context, packageId string,
 
 		fileInfo := ps_package.PresignedFileInfo{
 			URL:  res.URL,
-			Name: sources.Results[i].Content.Filename,
+			Name: source.Content.Filename,
 		}
 
 		resultFileInfo = append(resultFileInfo, fileInfo)
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/pennsieve/pennsieve-go/pkg/pennsieve/models/ps_package"
 	"net/http"
+	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/suite"
@@ -23,7 +24,9 @@ func (s *PackageServiceTestSuite) SetupTest() {
 	s.MockPennsieveServer = NewMockPennsieveServerDefault(s.T())
 	AWSEndpoints = AWSCognitoEndpoints{IdentityProviderEndpoint: s.IdProviderServer.URL}
 	client := NewClient(APIParams{
-		ApiHost: s.Server.URL,
+		ApiKey:    "test-key",
+		ApiSecret: "test-secret",
+		ApiHost:   s.Server.URL,
 	})
 	s.TestService = client.Package
 }
@@ -87,6 +90,38 @@ func (s *PackageServiceTestSuite) TestGetPresignedUrl() {
 	}
 }
 
+func (s *PackageServiceTestSuite) TestAllSources() {
+	packageId := "N:Package:5678"
+	total := defaultPageSize + 1
+	var offsets []string
+	s.Mux.HandleFunc(fmt.Sprintf("/packages/%s/sources-paged", packageId), func(writer http.ResponseWriter, request *http.Request) {
+		offsets = append(offsets, request.URL.Query().Get("offset"))
+		offset, _ := strconv.Atoi(request.URL.Query().Get("offset"))
+		limit, _ := strconv.Atoi(request.URL.Query().Get("limit"))
+
+		resp := ps_package.GetPackageSourcesResponse{Limit: int64(limit), Offset: int64(offset), TotalCount: int64(total)}
+		for i := offset; i < total && i < offset+limit; i++ {
+			resp.Results = append(resp.Results, ps_package.Result{Content: ps_package.Content{ID: int64(i)}})
+		}
+		body, err := json.Marshal(resp)
+		if s.NoError(err) {
+			_, err := writer.Write(body)
+			s.NoError(err)
+		}
+	})
+
+	var ids []int64
+	for source, err := range s.TestService.AllSources(context.Background(), packageId) {
+		if !s.NoError(err) {
+			return
+		}
+		ids = append(ids, source.Content.ID)
+	}
+	s.Len(ids, total)
+	s.Equal(int64(total-1), ids[total-1])
+	s.Equal([]string{"0", "100"}, offsets)
+}
+
 func TestPackageServiceSuite(t *testing.T) {
 	suite.Run(t, new(PackageServiceTestSuite))
 }
@@ -0,0 +1,99 @@
+package pennsieve
+
+import (
+	"context"
+	"iter"
+)
+
+// defaultPageSize is the number of items requested per page by the
+// iterators.
+const defaultPageSize = 100
+
+// offsetPageFunc fetches the page of at most limit items starting at offset.
+// total is the total number of items reported by the server, or 0 if
+// unknown.
+type offsetPageFunc[T any] func(ctx context.Context, limit, offset int) (items []T, total int, err error)
+
+// offsetPages iterates over the items of a limit/offset paged endpoint.
+// Iteration ends when the server returns an empty page, when totalCount is
+// reached or, if the server does not report a total, on a short page.
+//
+// Errors, including context cancellation, are yielded once with a zero item
+// and end the iteration.
+func offsetPages[T any](ctx context.Context, pageSize int, fetch offsetPageFunc[T]) iter.Seq2[T, error] {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return func(yield func(T, error) bool) {
+		var zero T
+		for offset := 0; ; {
+			if err := ctx.Err(); err != nil {
+				yield(zero, err)
+				return
+			}
+			items, total, err := fetch(ctx, pageSize, offset)
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+			for _, item := range items {
+				if err := ctx.Err(); err != nil {
+					yield(zero, err)
+					return
+				}
+				if !yield(item, nil) {
+					return
+				}
+			}
+
+			offset += len(items)
+			if len(items) == 0 ||
+				(total > 0 && offset >= total) ||
+				(total <= 0 && len(items) < pageSize) {
+				return
+			}
+		}
+	}
+}
+
+// tokenPageFunc fetches the page that starts at token ("" for the first
+// page) and returns the token of the next page, or "" for the last page.
+type tokenPageFunc[T any] func(ctx context.Context, token string) (items []T, next string, err error)
+
+// tokenPages iterates over the items of a continuation-token paged endpoint.
+// Errors, including context cancellation, are yielded once with a zero item
+// and end the iteration.
+func tokenPages[T any](ctx context.Context, fetch tokenPageFunc[T]) iter.Seq2[T, error] {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return func(yield func(T, error) bool) {
+		var zero T
+		for token := ""; ; {
+			if err := ctx.Err(); err != nil {
+				yield(zero, err)
+				return
+			}
+			items, next, err := fetch(ctx, token)
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+			for _, item := range items {
+				if err := ctx.Err(); err != nil {
+					yield(zero, err)
+					return
+				}
+				if !yield(item, nil) {
+					return
+				}
+			}
+
+			// A repeated token would loop forever; treat it as the end.
+			if next == "" || next == token {
+				return
+			}
+			token = next
+		}
+	}
+}
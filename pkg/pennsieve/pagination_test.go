@@ -0,0 +1,150 @@
+package pennsieve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// numbers returns an offsetPageFunc over the integers [0, n). When
+// reportTotal is false the server does not send a total count.
+func numbers(n int, reportTotal bool, calls *int) offsetPageFunc[int] {
+	return func(ctx context.Context, limit, offset int) ([]int, int, error) {
+		*calls++
+		var items []int
+		for i := offset; i < n && i < offset+limit; i++ {
+			items = append(items, i)
+		}
+		if !reportTotal {
+			return items, 0, nil
+		}
+		return items, n, nil
+	}
+}
+
+func collect[T any](t *testing.T, seq func(func(T, error) bool)) ([]T, error) {
+	t.Helper()
+	var items []T
+	for item, err := range seq {
+		if err != nil {
+			return items, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func TestOffsetPages(t *testing.T) {
+	tests := []struct {
+		n           int
+		reportTotal bool
+		wantCalls   int
+	}{
+		{n: 0, reportTotal: true, wantCalls: 1},
+		{n: 7, reportTotal: true, wantCalls: 3},
+		{n: 9, reportTotal: true, wantCalls: 3},
+		{n: 7, reportTotal: false, wantCalls: 3},
+		{n: 9, reportTotal: false, wantCalls: 4}, // needs an empty page to know it is done
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("n=%d,total=%t", tt.n, tt.reportTotal), func(t *testing.T) {
+			calls := 0
+			items, err := collect(t, offsetPages(context.Background(), 3, numbers(tt.n, tt.reportTotal, &calls)))
+			assert.NoError(t, err)
+			assert.Len(t, items, tt.n)
+			for i, item := range items {
+				assert.Equal(t, i, item)
+			}
+			assert.Equal(t, tt.wantCalls, calls)
+		})
+	}
+}
+
+func TestOffsetPagesStopsOnBreak(t *testing.T) {
+	calls := 0
+	for item, err := range offsetPages(context.Background(), 3, numbers(100, true, &calls)) {
+		assert.NoError(t, err)
+		if item == 4 {
+			break
+		}
+	}
+	assert.Equal(t, 2, calls, "no pages should be fetched after break")
+}
+
+func TestOffsetPagesStopsOnError(t *testing.T) {
+	boom := errors.New("boom")
+	calls := 0
+	fetch := func(ctx context.Context, limit, offset int) ([]int, int, error) {
+		calls++
+		if offset > 0 {
+			return nil, 0, boom
+		}
+		return []int{1, 2}, 10, nil
+	}
+
+	items, err := collect(t, offsetPages(context.Background(), 2, fetch))
+	assert.ErrorIs(t, err, boom)
+	assert.Equal(t, []int{1, 2}, items)
+	assert.Equal(t, 2, calls)
+}
+
+func TestOffsetPagesStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	calls := 0
+	var items []int
+	var iterErr error
+	for item, err := range offsetPages(ctx, 3, numbers(100, true, &calls)) {
+		if err != nil {
+			iterErr = err
+			continue // the iterator must end on its own
+		}
+		items = append(items, item)
+		if item == 1 {
+			cancel()
+		}
+	}
+	assert.ErrorIs(t, iterErr, context.Canceled)
+	assert.Equal(t, []int{0, 1}, items, "items after cancellation should not be yielded")
+	assert.Equal(t, 1, calls)
+}
+
+func TestTokenPages(t *testing.T) {
+	pages := map[string]struct {
+		items []string
+		next  string
+	}{
+		"":   {[]string{"a", "b"}, "t1"},
+		"t1": {[]string{"c"}, "t2"},
+		"t2": {nil, "t3"},
+		"t3": {[]string{"d"}, ""},
+	}
+	var tokens []string
+	fetch := func(ctx context.Context, token string) ([]string, string, error) {
+		tokens = append(tokens, token)
+		p := pages[token]
+		return p.items, p.next, nil
+	}
+
+	items, err := collect(t, tokenPages(context.Background(), fetch))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c", "d"}, items)
+	assert.Equal(t, []string{"", "t1", "t2", "t3"}, tokens, "empty pages with a token should be followed")
+}
+
+func TestTokenPagesStopsOnRepeatedToken(t *testing.T) {
+	calls := 0
+	fetch := func(ctx context.Context, token string) ([]string, string, error) {
+		calls++
+		return []string{"x"}, "same", nil
+	}
+
+	items, err := collect(t, tokenPages(context.Background(), fetch))
+	assert.NoError(t, err)
+	assert.Len(t, items, 2)
+	assert.Equal(t, 2, calls)
+}
@@ -1,12 +1,16 @@
 package pennsieve
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
 	"encoding/json"
 	"fmt"
 	"github.com/golang-jwt/jwt"
 	"github.com/pennsieve/pennsieve-go/pkg/pennsieve/models/authentication"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -27,21 +31,29 @@ const (
 )
 
 // Returns a MockCognitoServer configured to always return an unexpired IDToken JWT that includes the claims made in expectedClaims.
+// The tokens are issued by the pools of cognitoConfig, which should be the config served to the client.
 // If expectedClaims is nil, the returned claims will be as in NewMockCognitoServerDefault()
-func NewMockCognitoServer(t *testing.T, expectedClaims map[string]any) MockCognitoServer {
+func NewMockCognitoServer(t *testing.T, cognitoConfig authentication.CognitoConfig, expectedClaims map[string]any) MockCognitoServer {
 	if expectedClaims == nil {
-		return NewMockCognitoServerDefault(t)
+		expectedClaims = defaultMockClaims
 	}
-	counter := 0
-	cognitoServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+	var counter atomic.Int64
+	var cognitoServer *httptest.Server
+	cognitoServer = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if serveTestJWKS(writer, request) {
+			return
+		}
 		if request.URL.String() != "/" {
 			t.Errorf("unexpected cognito identity provider call: expected: %q, got: %q", "/", request.URL)
 		}
+		var body struct{ ClientId string }
+		if err := json.NewDecoder(request.Body).Decode(&body); err != nil {
+			t.Errorf("error decoding cognito request: %s", err)
+		}
 
-		idTokenString := NewTestJWTWithClaims(t, expectedClaims, time.Hour)
+		idTokenString := NewTestIdToken(t, cognitoServer.URL, cognitoConfig, body.ClientId, expectedClaims, time.Hour)
 		// hack to probably get a unique access token
-		counter++
-		accessToken := fmt.Sprintf("access-token-%d", counter)
+		accessToken := fmt.Sprintf("access-token-%d", counter.Add(1))
 		_, err := fmt.Fprintf(writer, `{"AuthenticationResult": {"AccessToken": %q, "ExpiresIn": 3600, "IdToken": %q, "RefreshToken": %q, "TokenType": "Bearer"}, "ChallengeParameters": {}}`,
 			accessToken,
 			idTokenString,
@@ -54,29 +66,81 @@ func NewMockCognitoServer(t *testing.T, expectedClaims map[string]any) MockCogni
 	return MockCognitoServer{IdProviderServer: cognitoServer}
 }
 
+var defaultMockClaims = map[string]any{
+	OrgNodeIdClaimKey: "N:Organization:abcd",
+	OrgIdClaimKey:     "9999",
+}
+
 // Returns a MockCognitoServer configured to always return a JWT IdToken that includes org node id and org id claims.
-// Also, an exp claim with a time an hour in the future.
+// Also, an exp claim with a time an hour in the future. Tokens are issued by the pools of the default
+// CognitoConfig served by NewMockPennsieveServerDefault().
 func NewMockCognitoServerDefault(t *testing.T) MockCognitoServer {
-	return NewMockCognitoServer(t, map[string]any{
-		OrgNodeIdClaimKey: "N:Organization:abcd",
-		OrgIdClaimKey:     "9999",
-	})
+	return NewMockCognitoServer(t, mockCognitoConfig, defaultMockClaims)
 }
 
 func (m *MockCognitoServer) Close() {
 	m.IdProviderServer.Close()
 }
 
+// testSigningKey signs the ID tokens of the mock Cognito servers. Like
+// Cognito, they serve its public key at /{poolId}/.well-known/jwks.json;
+// clients that cannot reach them use WithJWKS(testJWKS).
+var (
+	testSigningKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+	testJWKS          = NewJWKS(map[string]*rsa.PublicKey{testSigningKeyID: &testSigningKey.PublicKey})
+)
+
+const testSigningKeyID = "test-signing-key"
+
+// serveTestJWKS answers a request for a pool's key set with testJWKS and
+// reports whether request was one.
+func serveTestJWKS(writer http.ResponseWriter, request *http.Request) bool {
+	if request.Method != http.MethodGet || !strings.HasSuffix(request.URL.Path, jwksPath) {
+		return false
+	}
+	_ = json.NewEncoder(writer).Encode(testJWKS)
+	return true
+}
+
+// testPoolID returns the pool of cfg whose app client is clientID.
+func testPoolID(cfg authentication.CognitoConfig, clientID string) string {
+	switch clientID {
+	case cfg.UserPool.AppClientID:
+		return cfg.UserPool.ID
+	case cfg.TokenPool.AppClientID:
+		return cfg.TokenPool.ID
+	}
+	return "unknown-pool"
+}
+
+// NewTestIdToken returns an ID token with customClaims for the app client
+// clientID of cfg, issued by the mock Cognito server at cognitoURL.
+func NewTestIdToken(t *testing.T, cognitoURL string, cfg authentication.CognitoConfig, clientID string, customClaims map[string]any, sessionTTL time.Duration) string {
+	claims := map[string]any{
+		"iss": cognitoURL + "/" + testPoolID(cfg, clientID),
+		"aud": clientID,
+	}
+	for k, v := range customClaims {
+		claims[k] = v
+	}
+	return NewTestJWTWithClaims(t, claims, sessionTTL)
+}
+
+// NewTestJWTWithClaims returns an ID token signed with testSigningKey. Add
+// iss and aud claims, or use NewTestIdToken, for tokens the client should
+// accept.
 func NewTestJWTWithClaims(t *testing.T, customClaims map[string]any, sessionTTL time.Duration) string {
 	claims := jwt.MapClaims{
-		"exp": time.Now().Add(sessionTTL).UTC().Unix(),
+		"exp":       time.Now().Add(sessionTTL).UTC().Unix(),
+		"token_use": "id",
 	}
 	for k, v := range customClaims {
 		claims[k] = v
 	}
-	idToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	idToken.Header["kid"] = testSigningKeyID
 
-	idTokenString, err := idToken.SignedString([]byte("test-signing-key"))
+	idTokenString, err := idToken.SignedString(testSigningKey)
 	if err != nil {
 		t.Errorf("error getting signed string from JWT token: %s", err)
 	}
@@ -112,23 +176,26 @@ type MockPennsieveServer struct {
 // Returns a MockPennsieveServer with the same configuration as
 // NewMockPennsieveServer() with a default authentication.CognitoConfig
 func NewMockPennsieveServerDefault(t *testing.T) MockPennsieveServer {
-	return NewMockPennsieveServer(t, authentication.CognitoConfig{
-		Region: "us-east-1",
-		UserPool: authentication.UserPool{
-			Region:      "us-east-1",
-			ID:          "mock-user-pool-id",
-			AppClientID: "mock-user-pool-app-client-id",
-		},
-		TokenPool: authentication.TokenPool{
-			Region:      "us-east-1",
-			AppClientID: "mockTokenPoolAppClientId",
-		},
-		IdentityPool: authentication.IdentityPool{
-			Region: "us-east-1",
-			ID:     "mock-identity-pool-id",
-		}})
+	return NewMockPennsieveServer(t, mockCognitoConfig)
 }
 
+var mockCognitoConfig = authentication.CognitoConfig{
+	Region: "us-east-1",
+	UserPool: authentication.UserPool{
+		Region:      "us-east-1",
+		ID:          "mock-user-pool-id",
+		AppClientID: "mock-user-pool-app-client-id",
+	},
+	TokenPool: authentication.TokenPool{
+		Region:      "us-east-1",
+		ID:          "mock-token-pool-id",
+		AppClientID: "mockTokenPoolAppClientId",
+	},
+	IdentityPool: authentication.IdentityPool{
+		Region: "us-east-1",
+		ID:     "mock-identity-pool-id",
+	}}
+
 // Returns a MockPennsieveServer with a GET "/authentication/cognito-config" handler already configured. It will return the given
 // expectedCognitoConfig. If you don't care about the content of the CognitoConfig you can use NewMockPennsieveServerDefault() instead.
 // There is also a "/" handler configured that always fails the test to catch any unexpected requests.
@@ -0,0 +1,146 @@
+package pennsievetest
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/pennsieve/pennsieve-go/pkg/pennsieve"
+)
+
+// Claims put in issued ID tokens.
+const (
+	OrgNodeIdClaimKey = "custom:organization_node_id"
+	OrgIdClaimKey     = "custom:organization_id"
+)
+
+// signingKeyID is the key ID of the server's ID token signing key.
+const signingKeyID = "pennsievetest"
+
+// serveCognito handles the Cognito identity provider and identity JSON
+// APIs, dispatching on X-Amz-Target, and serves the key set of the user
+// pools at /{poolId}/.well-known/jwks.json so that the SDK can verify the
+// ID tokens it issues.
+func (s *Server) serveCognito(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/.well-known/jwks.json") {
+		writeJSON(w, http.StatusOK, pennsieve.NewJWKS(map[string]*rsa.PublicKey{signingKeyID: &s.signingKey.PublicKey}))
+		return
+	}
+
+	var body map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		cognitoError(w, "InvalidParameterException", "malformed request body")
+		return
+	}
+
+	target := r.Header.Get("X-Amz-Target")
+	switch target[strings.LastIndex(target, ".")+1:] {
+	case "InitiateAuth":
+		s.initiateAuth(w, body)
+	case "GetId":
+		writeJSON(w, http.StatusOK, map[string]string{"IdentityId": s.CognitoConfig.IdentityPool.ID})
+	case "GetCredentialsForIdentity":
+		writeJSON(w, http.StatusOK, map[string]any{
+			"IdentityId": s.CognitoConfig.IdentityPool.ID,
+			"Credentials": map[string]any{
+				"AccessKeyId":  "ASIAPENNSIEVETEST",
+				"SecretKey":    "pennsievetest-secret-key",
+				"SessionToken": "pennsievetest-session-token",
+				"Expiration":   time.Now().Add(s.TokenTTL).Unix(),
+			},
+		})
+	default:
+		cognitoError(w, "UnknownOperationException", fmt.Sprintf("pennsievetest: unsupported Cognito operation %q", target))
+	}
+}
+
+func (s *Server) initiateAuth(w http.ResponseWriter, body map[string]any) {
+	params, _ := body["AuthParameters"].(map[string]any)
+	param := func(k string) string {
+		v, _ := params[k].(string)
+		return v
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	refreshToken := ""
+	switch flow, _ := body["AuthFlow"].(string); flow {
+	case "USER_PASSWORD_AUTH":
+		if param("USERNAME") != s.APIKey || param("PASSWORD") != s.APISecret {
+			cognitoError(w, "NotAuthorizedException", "Incorrect username or password.")
+			return
+		}
+		refreshToken = fmt.Sprintf("refresh-token-%d", len(s.refresh)+1)
+		s.refresh[refreshToken] = true
+	case "REFRESH_TOKEN", "REFRESH_TOKEN_AUTH":
+		if !s.refresh[param("REFRESH_TOKEN")] {
+			cognitoError(w, "NotAuthorizedException", "Invalid Refresh Token.")
+			return
+		}
+	default:
+		cognitoError(w, "InvalidParameterException", fmt.Sprintf("pennsievetest: unsupported AuthFlow %q", flow))
+		return
+	}
+
+	expires := time.Now().Add(s.TokenTTL)
+	accessToken := fmt.Sprintf("access-token-%d", len(s.sessions)+1)
+	s.sessions[accessToken] = session{expires: expires}
+
+	clientID, _ := body["ClientId"].(string)
+	poolID := s.CognitoConfig.TokenPool.ID
+	if clientID == s.CognitoConfig.UserPool.AppClientID {
+		poolID = s.CognitoConfig.UserPool.ID
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":             s.Cognito.URL + "/" + poolID,
+		"aud":             clientID,
+		"exp":             expires.Unix(),
+		"iat":             time.Now().Unix(),
+		"token_use":       "id",
+		OrgNodeIdClaimKey: s.OrganizationNodeId,
+		OrgIdClaimKey:     strconv.Itoa(s.OrganizationId),
+	})
+	token.Header["kid"] = signingKeyID
+	idToken, err := token.SignedString(s.signingKey)
+	if err != nil {
+		cognitoError(w, "InternalErrorException", err.Error())
+		return
+	}
+
+	result := map[string]any{
+		"AccessToken": accessToken,
+		"ExpiresIn":   int(s.TokenTTL.Seconds()),
+		"IdToken":     idToken,
+		"TokenType":   "Bearer",
+	}
+	// Like Cognito, the refresh flow does not issue a new refresh token.
+	if refreshToken != "" {
+		result["RefreshToken"] = refreshToken
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"AuthenticationResult": result, "ChallengeParameters": map[string]any{}})
+}
+
+// authorized reports whether the request carries a live access token.
+// Callers hold s.mu.
+func (s *Server) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	sess, ok := s.sessions[token]
+	return ok && time.Now().Before(sess.expires)
+}
+
+// cognitoError writes an error in the AWS JSON protocol format.
+func cognitoError(w http.ResponseWriter, errorType, message string) {
+	w.Header().Set("X-Amzn-Errortype", errorType)
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]string{"__type": errorType, "message": message})
+}
@@ -0,0 +1,497 @@
+package pennsievetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pennsieve/pennsieve-go-core/pkg/models/manifest"
+	"github.com/pennsieve/pennsieve-go-core/pkg/models/manifest/manifestFile"
+	"github.com/pennsieve/pennsieve-go/pkg/pennsieve"
+	"github.com/pennsieve/pennsieve-go/pkg/pennsieve/models/dataset"
+	"github.com/pennsieve/pennsieve-go/pkg/pennsieve/models/ps_package"
+	"github.com/pennsieve/pennsieve-go/pkg/pennsieve/models/timeseries"
+)
+
+// apiHandler routes the fake API. Every request is logged and checked
+// against FailNext; all routes except the Cognito config require a live
+// access token. Handlers run with s.mu held.
+func (s *Server) apiHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /authentication/cognito-config", s.getCognitoConfig)
+
+	mux.HandleFunc("GET /datasets/paginated", s.listDatasets)
+	mux.HandleFunc("GET /datasets/manifest", s.getDatasetManifest)
+	mux.HandleFunc("GET /datasets/{id}", s.getDataset)
+	mux.HandleFunc("POST /datasets/{$}", s.createDataset)
+
+	mux.HandleFunc("GET /packages/{id}/sources-paged", s.getPackageSources)
+	mux.HandleFunc("GET /packages/{id}/files/{fileId}", s.getPresignedUrl)
+
+	mux.HandleFunc("POST /upload/manifest", s.postManifest)
+	mux.HandleFunc("GET /upload/manifest/status", s.getManifestStatus)
+	mux.HandleFunc("POST /upload/manifest/storage-credentials", s.getStorageCredentials)
+	mux.HandleFunc("POST /upload/manifest/files/finalize", s.finalizeFiles)
+
+	mux.HandleFunc("GET /timeseries/package/{id}/channels", s.getChannels)
+	mux.HandleFunc("GET /timeseries/package/{id}/range", s.getRange)
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		apiError(w, http.StatusNotFound, fmt.Sprintf("pennsievetest: no handler for %s %s", r.Method, r.URL.Path))
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.requests = append(s.requests, Request{
+			Method:         r.Method,
+			Path:           r.URL.Path,
+			Query:          r.URL.Query(),
+			OrganizationId: r.Header.Get("X-ORGANIZATION-ID"),
+		})
+		for i, f := range s.failures {
+			if f.method == r.Method && f.path == r.URL.Path {
+				s.failures = slices.Delete(s.failures, i, i+1)
+				apiError(w, f.status, "pennsievetest: injected failure")
+				return
+			}
+		}
+		if r.URL.Path != "/authentication/cognito-config" && !s.authorized(r) {
+			apiError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) getCognitoConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.CognitoConfig)
+}
+
+func (s *Server) datasetResponse(ds *datasetState) dataset.Datasets {
+	return dataset.Datasets{
+		Content: dataset.Content{
+			ID:          ds.ID,
+			IntID:       ds.IntID,
+			Name:        ds.Name,
+			Description: ds.Description,
+			Tags:        ds.Tags,
+			State:       "READY",
+			CreatedAt:   ds.CreatedAt,
+			UpdatedAt:   ds.CreatedAt,
+			PackageType: "DataSet",
+			DatasetType: "research",
+			Status:      "NO_STATUS",
+		},
+		Organization: s.OrganizationNodeId,
+		Properties:   []interface{}{},
+		Publication:  dataset.Publication{Status: "draft"},
+	}
+}
+
+func (s *Server) listDatasets(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := intParam(q.Get("limit"), 25)
+	offset := intParam(q.Get("offset"), 0)
+	query := strings.ToLower(q.Get("query"))
+
+	var matches []dataset.Datasets
+	for _, ds := range s.datasets {
+		if query == "" || strings.Contains(strings.ToLower(ds.Name), query) {
+			matches = append(matches, s.datasetResponse(ds))
+		}
+	}
+
+	writeJSON(w, http.StatusOK, dataset.ListDatasetResponse{
+		Limit:      limit,
+		Offset:     offset,
+		TotalCount: len(matches),
+		Datasets:   page(matches, limit, offset),
+	})
+}
+
+func (s *Server) getDataset(w http.ResponseWriter, r *http.Request) {
+	ds := s.dataset(r.PathValue("id"))
+	if ds == nil {
+		apiError(w, http.StatusNotFound, fmt.Sprintf("dataset %s not found", r.PathValue("id")))
+		return
+	}
+
+	res := s.datasetResponse(ds)
+	children := []dataset.Children{}
+	for _, p := range s.packages {
+		if p.DatasetID == ds.ID {
+			children = append(children, dataset.Children{Content: dataset.ChildrenContent{
+				ID:            p.ID,
+				NodeID:        p.ID,
+				Name:          p.Name,
+				PackageType:   "Unsupported",
+				DatasetID:     ds.ID,
+				DatasetNodeID: ds.ID,
+				State:         "READY",
+			}})
+		}
+	}
+	slices.SortFunc(children, func(a, b dataset.Children) int { return strings.Compare(a.Content.ID, b.Content.ID) })
+
+	writeJSON(w, http.StatusOK, dataset.GetDatasetResponse{
+		Content:      res.Content,
+		Organization: res.Organization,
+		Children:     children,
+		Properties:   res.Properties,
+		Publication:  res.Publication,
+	})
+}
+
+func (s *Server) createDataset(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		Tags        []string `json:"tags"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		apiError(w, http.StatusBadRequest, "malformed request body: "+err.Error())
+		return
+	}
+	if body.Name == "" {
+		apiError(w, http.StatusBadRequest, "dataset name is required")
+		return
+	}
+	for _, ds := range s.datasets {
+		if ds.Name == body.Name {
+			apiError(w, http.StatusBadRequest, fmt.Sprintf("dataset name %q is already taken", body.Name))
+			return
+		}
+	}
+
+	res := s.datasetResponse(s.addDataset(body.Name, body.Description, body.Tags))
+	writeJSON(w, http.StatusOK, dataset.CreateDatasetResponse{
+		Content:      res.Content,
+		Organization: res.Organization,
+		Properties:   res.Properties,
+		Publication:  res.Publication,
+	})
+}
+
+func (s *Server) getDatasetManifest(w http.ResponseWriter, r *http.Request) {
+	ds := s.dataset(r.URL.Query().Get("dataset_id"))
+	if ds == nil {
+		apiError(w, http.StatusNotFound, "dataset not found")
+		return
+	}
+	key := fmt.Sprintf("O%d/D%d/manifest.json", s.OrganizationId, ds.IntID)
+	writeJSON(w, http.StatusOK, dataset.GetManifestResponse{
+		URL:      s.presignedURL(key),
+		S3Bucket: s.StorageBucket,
+		S3Key:    key,
+	})
+}
+
+func (s *Server) getPackageSources(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.packages[r.PathValue("id")]
+	if !ok {
+		apiError(w, http.StatusNotFound, "package not found")
+		return
+	}
+	q := r.URL.Query()
+	limit := intParam(q.Get("limit"), 100)
+	offset := intParam(q.Get("offset"), 0)
+
+	var results []ps_package.Result
+	for _, src := range p.Sources {
+		results = append(results, ps_package.Result{Content: src})
+	}
+	writeJSON(w, http.StatusOK, ps_package.GetPackageSourcesResponse{
+		Limit:      int64(limit),
+		Offset:     int64(offset),
+		TotalCount: int64(len(results)),
+		Results:    page(results, limit, offset),
+	})
+}
+
+func (s *Server) getPresignedUrl(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.packages[r.PathValue("id")]
+	if !ok {
+		apiError(w, http.StatusNotFound, "package not found")
+		return
+	}
+	fileID, _ := strconv.ParseInt(r.PathValue("fileId"), 10, 64)
+	for _, src := range p.Sources {
+		if src.ID == fileID {
+			writeJSON(w, http.StatusOK, ps_package.GetPresignedUrlDTO{URL: s.presignedURL(src.S3Key)})
+			return
+		}
+	}
+	apiError(w, http.StatusNotFound, "file not found")
+}
+
+func (s *Server) postManifest(w http.ResponseWriter, r *http.Request) {
+	var body manifest.DTO
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		apiError(w, http.StatusBadRequest, "malformed request body: "+err.Error())
+		return
+	}
+	datasetID := r.URL.Query().Get("dataset_id")
+	if s.dataset(datasetID) == nil {
+		apiError(w, http.StatusNotFound, "dataset not found")
+		return
+	}
+
+	m, ok := s.manifests[body.ID]
+	switch {
+	case body.ID == "":
+		id, _ := s.nextID("manifest")
+		m = &manifestState{ID: id, DatasetID: datasetID, files: map[string]*ManifestFile{}}
+		s.manifests[id] = m
+	case !ok || m.DatasetID != datasetID:
+		apiError(w, http.StatusNotFound, "manifest not found")
+		return
+	}
+
+	res := manifest.PostResponse{ManifestNodeId: m.ID, UpdatedFiles: []manifestFile.FileStatusDTO{}, FailedFiles: []string{}}
+	for _, f := range body.Files {
+		if f.UploadID == "" {
+			res.FailedFiles = append(res.FailedFiles, f.TargetName)
+			continue
+		}
+		if f.Status == manifestFile.Removed {
+			if _, ok := m.files[f.UploadID]; ok {
+				delete(m.files, f.UploadID)
+				m.order = slices.DeleteFunc(m.order, func(id string) bool { return id == f.UploadID })
+				res.NrFilesRemoved++
+			}
+			continue
+		}
+		mf, ok := m.files[f.UploadID]
+		if !ok {
+			m.order = append(m.order, f.UploadID)
+			mf = &ManifestFile{UploadID: f.UploadID}
+			m.files[f.UploadID] = mf
+		}
+		mf.TargetPath, mf.TargetName, mf.Status = f.TargetPath, f.TargetName, manifestFile.Registered
+		res.NrFilesUpdated++
+		res.UpdatedFiles = append(res.UpdatedFiles, manifestFile.FileStatusDTO{UploadId: f.UploadID, Status: mf.Status})
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) getManifestStatus(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	m, ok := s.manifests[q.Get("manifest_id")]
+	if !ok {
+		apiError(w, http.StatusNotFound, "manifest not found")
+		return
+	}
+	status := manifestFile.Status(0).ManifestFileStatusMap(q.Get("status"))
+	offset := intParam(q.Get("continuation_token"), 0)
+
+	var files []string
+	for _, id := range m.order {
+		if m.files[id].Status == status {
+			files = append(files, id)
+		}
+	}
+
+	res := manifest.GetStatusEndpointResponse{
+		ManifestId: m.ID,
+		Status:     status.String(),
+		Files:      page(files, s.ManifestPageSize, offset),
+		Verified:   q.Get("verify") == "true",
+	}
+	if res.Files == nil {
+		res.Files = []string{}
+	}
+	if next := offset + s.ManifestPageSize; next < len(files) {
+		res.ContinuationToken = strconv.Itoa(next)
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// manifestFor returns the manifest named in a storage-credentials or
+// finalize request body, writing a 404 if it does not belong to the
+// dataset in the query.
+func (s *Server) manifestFor(w http.ResponseWriter, r *http.Request, manifestNodeID string) *manifestState {
+	m, ok := s.manifests[manifestNodeID]
+	if !ok || m.DatasetID != r.URL.Query().Get("dataset_id") {
+		apiError(w, http.StatusNotFound, "manifest not found")
+		return nil
+	}
+	return m
+}
+
+func (s *Server) getStorageCredentials(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ManifestNodeID string `json:"manifestNodeId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		apiError(w, http.StatusBadRequest, "malformed request body: "+err.Error())
+		return
+	}
+	m := s.manifestFor(w, r, body.ManifestNodeID)
+	if m == nil {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, pennsieve.StorageCredentials{
+		AccessKeyID:     "ASIAPENNSIEVETEST",
+		SecretAccessKey: "pennsievetest-secret-access-key",
+		SessionToken:    "pennsievetest-session-token",
+		Expiration:      time.Now().Add(s.TokenTTL).UTC(),
+		Bucket:          s.StorageBucket,
+		KeyPrefix:       s.keyPrefix(m),
+		Region:          "us-east-1",
+	})
+}
+
+func (s *Server) keyPrefix(m *manifestState) string {
+	return fmt.Sprintf("O%d/D%d/%s/", s.OrganizationId, s.dataset(m.DatasetID).IntID, m.ID)
+}
+
+func (s *Server) finalizeFiles(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ManifestNodeID string                   `json:"manifestNodeId"`
+		Files          []pennsieve.FinalizeFile `json:"files"`
+		OnConflict     string                   `json:"onConflict"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		apiError(w, http.StatusBadRequest, "malformed request body: "+err.Error())
+		return
+	}
+	m := s.manifestFor(w, r, body.ManifestNodeID)
+	if m == nil {
+		return
+	}
+	if len(body.Files) > 500 {
+		apiError(w, http.StatusBadRequest, "at most 500 files can be finalized at once")
+		return
+	}
+
+	res := pennsieve.FinalizeResponse{Results: []pennsieve.FinalizeResult{}}
+	for _, f := range body.Files {
+		result := pennsieve.FinalizeResult{UploadID: f.UploadID, Status: "finalized"}
+		mf, ok := m.files[f.UploadID]
+		switch {
+		case !ok:
+			result.Status, result.Error = "failed", "upload not found in manifest"
+		case mf.Status == manifestFile.Finalized:
+			// Already done; finalize is idempotent.
+		default:
+			mf.Size, mf.Status = f.Size, manifestFile.Finalized
+			mf.PackageID = s.packageForUpload(m, mf, body.OnConflict).ID
+		}
+		res.Results = append(res.Results, result)
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// packageForUpload creates the package of a finalized file. A name clash
+// in the dataset replaces the existing package's sources with
+// FinalizeOnConflictReplace and otherwise renames the new package to
+// "name (N)".
+func (s *Server) packageForUpload(m *manifestState, mf *ManifestFile, onConflict string) *packageState {
+	source := ps_package.Content{
+		Name:       mf.TargetName,
+		Filename:   mf.TargetName,
+		S3Bucket:   s.StorageBucket,
+		S3Key:      s.keyPrefix(m) + mf.UploadID,
+		ObjectType: "source",
+		Size:       mf.Size,
+	}
+
+	name := mf.TargetName
+	for n := 1; ; n++ {
+		existing := s.packageNamed(m.DatasetID, name)
+		if existing == nil {
+			break
+		}
+		if onConflict == pennsieve.FinalizeOnConflictReplace {
+			existing.Sources = nil
+			existing.addSource(s, source)
+			return existing
+		}
+		name = fmt.Sprintf("%s (%d)", mf.TargetName, n)
+	}
+	return s.addPackage(m.DatasetID, name, []ps_package.Content{source})
+}
+
+func (s *Server) packageNamed(datasetID, name string) *packageState {
+	for _, p := range s.packages {
+		if p.DatasetID == datasetID && p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+func (s *Server) getChannels(w http.ResponseWriter, r *http.Request) {
+	res := []timeseries.GetChannelsResponse{}
+	for _, c := range s.channels[r.PathValue("id")] {
+		res = append(res, timeseries.GetChannelsResponse{
+			ChannelID: c.ChannelID,
+			Name:      c.Name,
+			StartTime: int(c.StartTime),
+			EndTime:   int(c.EndTime),
+			Unit:      c.Unit,
+			Rate:      c.Rate,
+		})
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) getRange(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	start, _ := strconv.ParseInt(q.Get("start"), 10, 64)
+	end, _ := strconv.ParseInt(q.Get("end"), 10, 64)
+	channelID := q.Get("channel_id")
+
+	res := timeseries.GetRangeResponse{RequestedStartTime: start, RequestedEndTime: end, Channels: []timeseries.Channels{}}
+	for _, c := range s.channels[r.PathValue("id")] {
+		if channelID != "" && c.ChannelID != channelID {
+			continue
+		}
+		ranges := []timeseries.Ranges{}
+		for _, rg := range c.Ranges {
+			if rg.EndTime >= start && rg.StartTime <= end {
+				ranges = append(ranges, rg)
+			}
+		}
+		c.Ranges = ranges
+		res.Channels = append(res.Channels, c)
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) presignedURL(key string) string {
+	return fmt.Sprintf("https://%s.s3.amazonaws.com/%s?X-Amz-Signature=pennsievetest", s.StorageBucket, key)
+}
+
+// page returns items[offset:offset+limit], clamped to the slice.
+func page[T any](items []T, limit, offset int) []T {
+	if offset >= len(items) {
+		return nil
+	}
+	return items[offset:min(offset+limit, len(items))]
+}
+
+func intParam(v string, def int) int {
+	if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+		return n
+	}
+	return def
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// apiError writes an error in the format of the Pennsieve API.
+func apiError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]any{"code": status, "message": message})
+}
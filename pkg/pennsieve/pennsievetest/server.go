@@ -0,0 +1,371 @@
+// Package pennsievetest provides a fake Pennsieve API for testing code built
+// on the pennsieve package.
+//
+// A Server runs two httptest servers: one standing in for the Pennsieve API
+// (both the v1 and v2 hosts) and one for Cognito. It keeps datasets,
+// packages, upload manifests and timeseries channels in memory, so a test
+// can create a dataset, upload files through a manifest and read the
+// resulting packages back:
+//
+//	srv := pennsievetest.NewServer(t)
+//	client := srv.Client()
+//	ds, err := client.Dataset.Create(ctx, "My dataset", "", "[]")
+//
+// State can also be seeded directly with AddDataset, AddPackage and
+// AddChannels, and inspected with Dataset, Manifest and Requests.
+package pennsievetest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/pennsieve/pennsieve-go-core/pkg/models/manifest/manifestFile"
+	"github.com/pennsieve/pennsieve-go/pkg/pennsieve"
+	"github.com/pennsieve/pennsieve-go/pkg/pennsieve/models/authentication"
+	"github.com/pennsieve/pennsieve-go/pkg/pennsieve/models/ps_package"
+	"github.com/pennsieve/pennsieve-go/pkg/pennsieve/models/timeseries"
+)
+
+// Defaults used by NewServer.
+const (
+	DefaultAPIKey             = "pennsievetest-key"
+	DefaultAPISecret          = "pennsievetest-secret"
+	DefaultOrganizationNodeId = "N:organization:00000000-0000-0000-0000-000000000001"
+	DefaultOrganizationId     = 1
+	DefaultStorageBucket      = "pennsievetest-storage"
+)
+
+// Server is a fake Pennsieve API and Cognito with in-memory state. Create
+// one with NewServer; it is closed when the test ends. Exported fields may
+// be changed before the first request.
+type Server struct {
+	// API serves the Pennsieve API. Use its URL for both ApiHost and
+	// ApiHost2.
+	API *httptest.Server
+	// Cognito serves the Cognito identity provider and identity APIs.
+	Cognito *httptest.Server
+
+	// APIKey and APISecret are the only credentials Cognito accepts.
+	APIKey    string
+	APISecret string
+	// OrganizationNodeId and OrganizationId are put in the ID token claims.
+	OrganizationNodeId string
+	OrganizationId     int
+	// TokenTTL is the lifetime of issued tokens. Defaults to an hour.
+	TokenTTL time.Duration
+	// StorageBucket is returned with storage credentials.
+	StorageBucket string
+	// ManifestPageSize is the number of files per page of the manifest
+	// status endpoint. Defaults to 1000.
+	ManifestPageSize int
+	// CognitoConfig is served at /authentication/cognito-config.
+	CognitoConfig authentication.CognitoConfig
+
+	t          testing.TB
+	signingKey *rsa.PrivateKey // signs issued ID tokens
+
+	mu        sync.Mutex
+	ids       int
+	datasets  []*datasetState
+	packages  map[string]*packageState
+	manifests map[string]*manifestState
+	channels  map[string][]timeseries.Channels
+	sessions  map[string]session // keyed by access token
+	refresh   map[string]bool    // issued refresh tokens
+	requests  []Request
+	failures  []failure
+}
+
+// Request is a request received by the fake API.
+type Request struct {
+	Method string
+	Path   string
+	Query  url.Values
+	// OrganizationId is the X-ORGANIZATION-ID header.
+	OrganizationId string
+}
+
+// Dataset is a dataset held by the Server.
+type Dataset struct {
+	ID          string
+	IntID       int
+	Name        string
+	Description string
+	Tags        []string
+	CreatedAt   time.Time
+}
+
+// Package is a package held by the Server, with its source files.
+type Package struct {
+	ID        string
+	DatasetID string
+	Name      string
+	Sources   []ps_package.Content
+}
+
+// Manifest is an upload manifest held by the Server.
+type Manifest struct {
+	ID        string
+	DatasetID string
+	Files     []ManifestFile
+}
+
+// ManifestFile is a file registered in a manifest.
+type ManifestFile struct {
+	UploadID   string
+	TargetPath string
+	TargetName string
+	Status     manifestFile.Status
+	Size       int64
+	// PackageID is set once the file is finalized.
+	PackageID string
+}
+
+type datasetState struct {
+	Dataset
+}
+
+type packageState struct {
+	Package
+}
+
+type manifestState struct {
+	ID        string
+	DatasetID string
+	order     []string // upload IDs in registration order
+	files     map[string]*ManifestFile
+}
+
+type session struct {
+	expires time.Time
+}
+
+type failure struct {
+	method, path string
+	status       int
+}
+
+// NewServer starts a fake Pennsieve API and Cognito, both closed when t
+// ends.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	s := &Server{
+		APIKey:             DefaultAPIKey,
+		APISecret:          DefaultAPISecret,
+		OrganizationNodeId: DefaultOrganizationNodeId,
+		OrganizationId:     DefaultOrganizationId,
+		TokenTTL:           time.Hour,
+		StorageBucket:      DefaultStorageBucket,
+		ManifestPageSize:   1000,
+		CognitoConfig: authentication.CognitoConfig{
+			Region: "us-east-1",
+			UserPool: authentication.UserPool{
+				Region:      "us-east-1",
+				ID:          "us-east-1_pennsievetest",
+				AppClientID: "pennsievetest-user-pool-client",
+			},
+			TokenPool: authentication.TokenPool{
+				Region:      "us-east-1",
+				ID:          "us-east-1_pennsievetesttokens",
+				AppClientID: "pennsievetest-token-pool-client",
+			},
+			IdentityPool: authentication.IdentityPool{
+				Region: "us-east-1",
+				ID:     "us-east-1:00000000-0000-0000-0000-000000000000",
+			},
+		},
+		t:         t,
+		packages:  map[string]*packageState{},
+		manifests: map[string]*manifestState{},
+		channels:  map[string][]timeseries.Channels{},
+		sessions:  map[string]session{},
+		refresh:   map[string]bool{},
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("pennsievetest: generating signing key: %v", err)
+	}
+	s.signingKey = key
+	s.API = httptest.NewServer(s.apiHandler())
+	s.Cognito = httptest.NewServer(http.HandlerFunc(s.serveCognito))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Close shuts down both servers.
+func (s *Server) Close() {
+	s.API.Close()
+	s.Cognito.Close()
+}
+
+// AWSConfig returns an AWS config that sends Cognito calls to the fake
+// Cognito server.
+func (s *Server) AWSConfig() aws.Config {
+	return aws.Config{
+		Region: "us-east-1",
+		EndpointResolverWithOptions: aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+			return aws.Endpoint{URL: s.Cognito.URL}, nil
+		}),
+	}
+}
+
+// Client returns a client authenticating with the server's credentials and
+// pointing at the fake API and Cognito. Retries are kept but their delays
+// shortened. opts are applied last and can override any of this.
+func (s *Server) Client(opts ...pennsieve.ClientOption) *pennsieve.Client {
+	s.t.Helper()
+	all := append([]pennsieve.ClientOption{
+		pennsieve.WithCredentials(s.APIKey, s.APISecret),
+		pennsieve.WithBaseURLs(s.API.URL, s.API.URL),
+		pennsieve.WithAWSConfig(s.AWSConfig()),
+		pennsieve.WithRetryPolicy(pennsieve.RetryPolicy{
+			MaxAttempts: pennsieve.DefaultRetryPolicy.MaxAttempts,
+			BaseDelay:   time.Millisecond,
+			MaxDelay:    10 * time.Millisecond,
+		}),
+	}, opts...)
+	client, err := pennsieve.NewClientWithOptions(all...)
+	if err != nil {
+		s.t.Fatalf("pennsievetest: creating client: %v", err)
+	}
+	return client
+}
+
+// FailNext makes the next request matching method and path fail with
+// status. Calls queue up, so FailNext twice fails the next two requests.
+func (s *Server) FailNext(method, path string, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, failure{method: method, path: path, status: status})
+}
+
+// Requests returns the API requests received so far, in order. Cognito
+// calls are not included.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// AddDataset stores a dataset and returns it with its ID set.
+func (s *Server) AddDataset(name, description string, tags ...string) Dataset {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addDataset(name, description, tags).Dataset
+}
+
+// Dataset returns the dataset with the given ID.
+func (s *Server) Dataset(id string) (Dataset, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ds := s.dataset(id); ds != nil {
+		return ds.Dataset, true
+	}
+	return Dataset{}, false
+}
+
+// AddPackage stores a package with the given source files in a dataset and
+// returns its ID. Sources without an ID are numbered.
+func (s *Server) AddPackage(datasetID, name string, sources ...ps_package.Content) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addPackage(datasetID, name, sources).ID
+}
+
+// Package returns the package with the given ID.
+func (s *Server) Package(id string) (Package, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.packages[id]; ok {
+		pkg := p.Package
+		pkg.Sources = append([]ps_package.Content(nil), p.Sources...)
+		return pkg, true
+	}
+	return Package{}, false
+}
+
+// Manifest returns the manifest with the given ID.
+func (s *Server) Manifest(id string) (Manifest, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.manifests[id]
+	if !ok {
+		return Manifest{}, false
+	}
+	out := Manifest{ID: m.ID, DatasetID: m.DatasetID}
+	for _, id := range m.order {
+		out.Files = append(out.Files, *m.files[id])
+	}
+	return out, true
+}
+
+// AddChannels stores timeseries channels for a package. Their ranges are
+// served by the range endpoint.
+func (s *Server) AddChannels(packageID string, channels ...timeseries.Channels) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.channels[packageID] = append(s.channels[packageID], channels...)
+}
+
+// nextID returns a new node ID of the given kind, e.g.
+// "N:dataset:00000000-0000-0000-0000-000000000001".
+func (s *Server) nextID(kind string) (string, int) {
+	s.ids++
+	return fmt.Sprintf("N:%s:00000000-0000-0000-0000-%012d", kind, s.ids), s.ids
+}
+
+func (s *Server) addDataset(name, description string, tags []string) *datasetState {
+	id, intID := s.nextID("dataset")
+	if tags == nil {
+		tags = []string{}
+	}
+	ds := &datasetState{Dataset{
+		ID:          id,
+		IntID:       intID,
+		Name:        name,
+		Description: description,
+		Tags:        tags,
+		CreatedAt:   time.Now().UTC(),
+	}}
+	s.datasets = append(s.datasets, ds)
+	return ds
+}
+
+func (s *Server) dataset(id string) *datasetState {
+	for _, ds := range s.datasets {
+		if ds.ID == id {
+			return ds
+		}
+	}
+	return nil
+}
+
+func (s *Server) addPackage(datasetID, name string, sources []ps_package.Content) *packageState {
+	id, _ := s.nextID("package")
+	p := &packageState{Package{ID: id, DatasetID: datasetID, Name: name}}
+	for _, src := range sources {
+		p.addSource(s, src)
+	}
+	s.packages[id] = p
+	return p
+}
+
+func (p *packageState) addSource(s *Server, src ps_package.Content) {
+	if src.ID == 0 {
+		_, id := s.nextID("file")
+		src.ID = int64(id)
+	}
+	src.PackageID = p.ID
+	if src.Name == "" {
+		src.Name = p.Name
+	}
+	p.Sources = append(p.Sources, src)
+}
@@ -0,0 +1,232 @@
+package pennsievetest_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/pennsieve/pennsieve-go-core/pkg/models/manifest"
+	"github.com/pennsieve/pennsieve-go-core/pkg/models/manifest/manifestFile"
+	"github.com/pennsieve/pennsieve-go/pkg/pennsieve"
+	"github.com/pennsieve/pennsieve-go/pkg/pennsieve/models/ps_package"
+	"github.com/pennsieve/pennsieve-go/pkg/pennsieve/models/timeseries"
+	"github.com/pennsieve/pennsieve-go/pkg/pennsieve/pennsievetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatasets(t *testing.T) {
+	srv := pennsievetest.NewServer(t)
+	client := srv.Client()
+	ctx := context.Background()
+
+	created, err := client.Dataset.Create(ctx, "EEG study", "recordings", `["eeg", "sleep"]`)
+	require.NoError(t, err)
+	assert.Equal(t, "EEG study", created.Content.Name)
+	assert.Equal(t, []string{"eeg", "sleep"}, created.Content.Tags)
+	assert.Equal(t, pennsievetest.DefaultOrganizationNodeId, created.Organization)
+
+	got, err := client.Dataset.Get(ctx, created.Content.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "recordings", got.Content.Description)
+
+	_, err = client.Dataset.Create(ctx, "EEG study", "", "[]")
+	assert.ErrorContains(t, err, "already taken")
+
+	_, err = client.Dataset.Get(ctx, "N:dataset:missing")
+	assert.ErrorIs(t, err, pennsieve.ErrNotFound)
+
+	for i := range 5 {
+		srv.AddDataset(fmt.Sprintf("seeded %d", i), "")
+	}
+	list, err := client.Dataset.List(ctx, 2, 4)
+	require.NoError(t, err)
+	assert.Equal(t, 6, list.TotalCount)
+	require.Len(t, list.Datasets, 2)
+	assert.Equal(t, "seeded 3", list.Datasets[0].Content.Name)
+
+	found, err := client.Dataset.Find(ctx, 10, "EEG")
+	require.NoError(t, err)
+	assert.Equal(t, 1, found.TotalCount)
+
+	var names []string
+	for ds, err := range client.Dataset.All(ctx) {
+		require.NoError(t, err)
+		names = append(names, ds.Content.Name)
+	}
+	assert.Len(t, names, 6)
+}
+
+func TestUploadFlow(t *testing.T) {
+	srv := pennsievetest.NewServer(t)
+	srv.ManifestPageSize = 2
+	client := srv.Client()
+	ctx := context.Background()
+	ds := srv.AddDataset("uploads", "")
+
+	var files []manifestFile.FileDTO
+	for i := range 3 {
+		files = append(files, manifestFile.FileDTO{
+			UploadID:   fmt.Sprintf("upload-%d", i),
+			TargetName: fmt.Sprintf("file-%d.csv", i),
+		})
+	}
+	created, err := client.Manifest.Create(ctx, manifest.DTO{DatasetId: ds.ID, Files: files})
+	require.NoError(t, err)
+	assert.Equal(t, 3, created.NrFilesUpdated)
+	manifestID := created.ManifestNodeId
+
+	var registered []string
+	for id, err := range client.Manifest.AllFilesForStatus(ctx, manifestID, manifestFile.Registered) {
+		require.NoError(t, err)
+		registered = append(registered, id)
+	}
+	assert.Equal(t, []string{"upload-0", "upload-1", "upload-2"}, registered, "pages should be followed")
+
+	creds, err := client.Manifest.GetStorageCredentials(ctx, ds.ID, manifestID)
+	require.NoError(t, err)
+	assert.Equal(t, pennsievetest.DefaultStorageBucket, creds.Bucket)
+	assert.Contains(t, creds.KeyPrefix, manifestID)
+
+	_, err = client.Manifest.GetStorageCredentials(ctx, ds.ID, "N:manifest:missing")
+	assert.ErrorIs(t, err, pennsieve.ErrNotFound)
+
+	finalized, err := client.Manifest.FinalizeManifestFiles(ctx, ds.ID, manifestID, []pennsieve.FinalizeFile{
+		{UploadID: "upload-0", Size: 10},
+		{UploadID: "upload-unknown", Size: 1},
+	})
+	require.NoError(t, err)
+	require.Len(t, finalized.Results, 2)
+	assert.Equal(t, "finalized", finalized.Results[0].Status)
+	assert.Equal(t, "failed", finalized.Results[1].Status)
+
+	m, ok := srv.Manifest(manifestID)
+	require.True(t, ok)
+	assert.Equal(t, manifestFile.Finalized, m.Files[0].Status)
+	assert.Equal(t, manifestFile.Registered, m.Files[1].Status)
+
+	sources, err := client.Package.GetPackageSources(ctx, m.Files[0].PackageID)
+	require.NoError(t, err)
+	require.Len(t, sources.Results, 1)
+	assert.Equal(t, "file-0.csv", sources.Results[0].Content.Name)
+	assert.Equal(t, int64(10), sources.Results[0].Content.Size)
+
+	urls, err := client.Package.GetPresignedUrl(ctx, m.Files[0].PackageID, true)
+	require.NoError(t, err)
+	require.Len(t, urls.Files, 1)
+	assert.Contains(t, urls.Files[0].URL, sources.Results[0].Content.S3Key)
+
+	got, err := client.Dataset.Get(ctx, ds.ID)
+	require.NoError(t, err)
+	require.Len(t, got.Children, 1)
+	assert.Equal(t, "file-0.csv", got.Children[0].Content.Name)
+}
+
+func TestFinalizeNameConflicts(t *testing.T) {
+	srv := pennsievetest.NewServer(t)
+	client := srv.Client()
+	ctx := context.Background()
+	ds := srv.AddDataset("conflicts", "")
+	existing := srv.AddPackage(ds.ID, "data.csv", ps_package.Content{Filename: "data.csv"})
+
+	finalize := func(uploadID string, opts ...pennsieve.FinalizeOption) string {
+		created, err := client.Manifest.Create(ctx, manifest.DTO{DatasetId: ds.ID, Files: []manifestFile.FileDTO{
+			{UploadID: uploadID, TargetName: "data.csv"},
+		}})
+		require.NoError(t, err)
+		_, err = client.Manifest.FinalizeManifestFiles(ctx, ds.ID, created.ManifestNodeId,
+			[]pennsieve.FinalizeFile{{UploadID: uploadID, Size: 1}}, opts...)
+		require.NoError(t, err)
+		m, _ := srv.Manifest(created.ManifestNodeId)
+		return m.Files[0].PackageID
+	}
+
+	renamed, _ := srv.Package(finalize("keep-both"))
+	assert.Equal(t, "data.csv (1)", renamed.Name)
+
+	assert.Equal(t, existing, finalize("replace", pennsieve.WithOnConflict(pennsieve.FinalizeOnConflictReplace)))
+	replaced, _ := srv.Package(existing)
+	require.Len(t, replaced.Sources, 1)
+	assert.Contains(t, replaced.Sources[0].S3Key, "replace")
+}
+
+func TestTimeseries(t *testing.T) {
+	srv := pennsievetest.NewServer(t)
+	client := srv.Client()
+	ctx := context.Background()
+	ds := srv.AddDataset("timeseries", "")
+	pkg := srv.AddPackage(ds.ID, "recording.edf")
+	srv.AddChannels(pkg,
+		timeseries.Channels{ChannelID: "ch-1", Name: "Fp1", StartTime: 0, EndTime: 300, Rate: 256, Ranges: []timeseries.Ranges{
+			{ID: "r1", StartTime: 0, EndTime: 100},
+			{ID: "r2", StartTime: 100, EndTime: 200},
+			{ID: "r3", StartTime: 200, EndTime: 300},
+		}},
+		timeseries.Channels{ChannelID: "ch-2", Name: "Fp2", StartTime: 0, EndTime: 300, Rate: 256},
+	)
+
+	channels, err := client.Timeseries.GetChannels(ctx, ds.ID, pkg)
+	require.NoError(t, err)
+	require.Len(t, channels, 2)
+	assert.Equal(t, "Fp1", channels[0].Name)
+
+	blocks, err := client.Timeseries.GetRangeBlocks(ctx, ds.ID, pkg, 150, 250, "ch-1")
+	require.NoError(t, err)
+	require.Len(t, blocks.Channels, 1)
+	var ids []string
+	for _, r := range blocks.Channels[0].Ranges {
+		ids = append(ids, r.ID)
+	}
+	assert.Equal(t, []string{"r2", "r3"}, ids)
+}
+
+func TestWrongCredentialsAreRejected(t *testing.T) {
+	srv := pennsievetest.NewServer(t)
+	client := srv.Client(pennsieve.WithCredentials("wrong", "wrong"))
+
+	_, err := client.Dataset.List(context.Background(), 10, 0)
+	assert.ErrorContains(t, err, "NotAuthorizedException")
+	for _, req := range srv.Requests() {
+		assert.Equal(t, "/authentication/cognito-config", req.Path, "no API call should be made without a session")
+	}
+}
+
+func TestFailNextIsRetried(t *testing.T) {
+	srv := pennsievetest.NewServer(t)
+	client := srv.Client()
+	ds := srv.AddDataset("flaky", "")
+	srv.FailNext(http.MethodGet, "/datasets/"+ds.ID, http.StatusServiceUnavailable)
+
+	_, err := client.Dataset.Get(context.Background(), ds.ID)
+	require.NoError(t, err)
+
+	var gets []pennsievetest.Request
+	for _, req := range srv.Requests() {
+		if req.Path == "/datasets/"+ds.ID {
+			gets = append(gets, req)
+		}
+	}
+	require.Len(t, gets, 2)
+	assert.Equal(t, pennsievetest.DefaultOrganizationNodeId, gets[1].OrganizationId)
+}
+
+func TestExpiredSessionIsRefreshed(t *testing.T) {
+	srv := pennsievetest.NewServer(t)
+	client := srv.Client()
+	ctx := context.Background()
+
+	_, err := client.Dataset.List(ctx, 10, 0)
+	require.NoError(t, err)
+	first := client.GetSession()
+
+	// A session within the client's expiry window is renewed before the
+	// next call.
+	session := first
+	session.Expiration = session.Expiration.Add(-srv.TokenTTL)
+	client.SetSession(session)
+
+	_, err = client.Dataset.List(ctx, 10, 0)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.Token, client.GetSession().Token)
+}
@@ -0,0 +1,172 @@
+package pennsieve
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// RateLimit configures a client-side token bucket: requests are allowed at
+// Rate per second on average, with bursts of up to Burst requests.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimiterStats reports how much a rate limiter has been throttling.
+type RateLimiterStats struct {
+	Waiting   int           // requests currently blocked on the limiter
+	Waits     int64         // requests that had to wait since the limiter was created
+	TotalWait time.Duration // cumulative time requests spent waiting
+	LastWait  time.Duration // wait of the most recent throttled request
+}
+
+// rateLimiter is a token bucket. Tokens are reserved up front, so a caller
+// that has to wait knows exactly how long; if its context is cancelled while
+// waiting the token is handed back.
+type rateLimiter struct {
+	mu     sync.Mutex
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+	stats  RateLimiterStats
+}
+
+func newRateLimiter(limit RateLimit) *rateLimiter {
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+	return &rateLimiter{
+		limit:  limit,
+		tokens: float64(limit.Burst),
+		last:   time.Now(),
+	}
+}
+
+// reserve takes a token and returns how long the caller must wait before
+// using it.
+func (l *rateLimiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill(now)
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+
+	wait := time.Duration(-l.tokens / l.limit.Rate * float64(time.Second))
+	l.stats.Waiting++
+	l.stats.Waits++
+	l.stats.LastWait = wait
+	return wait
+}
+
+// Wait blocks until a request is allowed or ctx is done.
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	wait := l.reserve(time.Now())
+	if wait == 0 {
+		return nil
+	}
+
+	start := time.Now()
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stats.Waiting--
+	l.stats.TotalWait += time.Since(start)
+	if err != nil {
+		l.refund(time.Now())
+	}
+	return err
+}
+
+// refill adds the tokens accrued since the last reserve or refund. Callers
+// hold l.mu.
+func (l *rateLimiter) refill(now time.Time) {
+	elapsed := now.Sub(l.last).Seconds()
+	l.last = now
+	l.tokens = min(float64(l.limit.Burst), l.tokens+elapsed*l.limit.Rate)
+}
+
+// refund gives back the token of a cancelled waiter. The bucket may have
+// refilled since the token was reserved, so the refund is capped at Burst.
+// Callers hold l.mu.
+func (l *rateLimiter) refund(now time.Time) {
+	l.refill(now)
+	l.tokens = min(float64(l.limit.Burst), l.tokens+1)
+}
+
+func (l *rateLimiter) Stats() RateLimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stats
+}
+
+// SetRateLimit limits the rate of requests the client sends to the host of
+// baseURL (e.g. APIParams.ApiHost or APIParams.ApiHost2). Every attempt,
+// including retries, takes a token. Callers block until a token is available
+// or their context is done. A Rate <= 0 removes the limit.
+func (c *Client) SetRateLimit(baseURL string, limit RateLimit) error {
+	host, err := rateLimitKey(baseURL)
+	if err != nil {
+		return err
+	}
+
+	c.limitersMu.Lock()
+	defer c.limitersMu.Unlock()
+	if limit.Rate <= 0 {
+		delete(c.limiters, host)
+		return nil
+	}
+	if c.limiters == nil {
+		c.limiters = make(map[string]*rateLimiter)
+	}
+	c.limiters[host] = newRateLimiter(limit)
+	return nil
+}
+
+// RateLimitStats returns the current statistics of every configured rate
+// limiter, keyed by host.
+func (c *Client) RateLimitStats() map[string]RateLimiterStats {
+	c.limitersMu.RLock()
+	defer c.limitersMu.RUnlock()
+	stats := make(map[string]RateLimiterStats, len(c.limiters))
+	for host, l := range c.limiters {
+		stats[host] = l.Stats()
+	}
+	return stats
+}
+
+// waitRateLimit blocks on the limiter for host, if there is one.
+func (c *Client) waitRateLimit(ctx context.Context, host string) error {
+	c.limitersMu.RLock()
+	l := c.limiters[host]
+	c.limitersMu.RUnlock()
+	if l == nil {
+		return nil
+	}
+	return l.Wait(ctx)
+}
+
+func rateLimitKey(baseURL string) (string, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		// Allow bare hosts such as "api.pennsieve.io".
+		return baseURL, nil
+	}
+	return u.Host, nil
+}
@@ -0,0 +1,132 @@
+package pennsieve
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestRateLimiterBurstThenThrottle(t *testing.T) {
+	l := newRateLimiter(RateLimit{Rate: 10, Burst: 2})
+	now := time.Now()
+
+	assert.Zero(t, l.reserve(now))
+	assert.Zero(t, l.reserve(now))
+	wait := l.reserve(now)
+	assert.InDelta(t, 100*time.Millisecond, wait, float64(5*time.Millisecond))
+
+	stats := l.Stats()
+	assert.Equal(t, int64(1), stats.Waits)
+	assert.Equal(t, 1, stats.Waiting)
+}
+
+func TestRateLimiterWaitRespectsContext(t *testing.T) {
+	l := newRateLimiter(RateLimit{Rate: 0.1, Burst: 1})
+	assert.NoError(t, l.Wait(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, l.Wait(ctx), context.DeadlineExceeded)
+
+	stats := l.Stats()
+	assert.Equal(t, 0, stats.Waiting)
+	assert.Greater(t, stats.TotalWait, time.Duration(0))
+}
+
+func TestRateLimiterRefundCappedAtBurst(t *testing.T) {
+	l := newRateLimiter(RateLimit{Rate: 10, Burst: 1})
+	now := time.Now()
+	assert.Zero(t, l.reserve(now))
+	assert.NotZero(t, l.reserve(now))
+	assert.NotZero(t, l.reserve(now))
+
+	// The bucket refills before both waiters are cancelled.
+	later := now.Add(time.Second)
+	assert.Zero(t, l.reserve(later))
+	l.mu.Lock()
+	l.refund(later)
+	l.refund(later)
+	l.mu.Unlock()
+
+	assert.Equal(t, float64(1), l.tokens)
+	assert.Zero(t, l.reserve(later))
+	assert.NotZero(t, l.reserve(later), "refunds should not allow more than Burst requests at once")
+}
+
+type RateLimitTestSuite struct {
+	suite.Suite
+	MockCognitoServer
+	APIServer  MockPennsieveServer
+	API2Server MockPennsieveServer
+	TestClient *Client
+}
+
+func (s *RateLimitTestSuite) SetupTest() {
+	s.MockCognitoServer = NewMockCognitoServerDefault(s.T())
+	s.APIServer = NewMockPennsieveServerDefault(s.T())
+	s.API2Server = NewMockPennsieveServerDefault(s.T())
+	AWSEndpoints = AWSCognitoEndpoints{IdentityProviderEndpoint: s.IdProviderServer.URL}
+	s.TestClient = NewClient(APIParams{
+		ApiKey:    "test-key",
+		ApiSecret: "test-secret",
+		ApiHost:   s.APIServer.Server.URL,
+		ApiHost2:  s.API2Server.Server.URL,
+	})
+}
+
+func (s *RateLimitTestSuite) TearDownTest() {
+	s.MockCognitoServer.Close()
+	s.APIServer.Close()
+	s.API2Server.Close()
+	AWSEndpoints.Reset()
+}
+
+func (s *RateLimitTestSuite) TestLimitIsPerHost() {
+	ok := func(writer http.ResponseWriter, request *http.Request) {
+		_, err := writer.Write([]byte(`{}`))
+		s.NoError(err)
+	}
+	s.APIServer.Mux.HandleFunc("/unlimited", ok)
+	s.API2Server.Mux.HandleFunc("/limited", ok)
+
+	s.NoError(s.TestClient.SetRateLimit(s.API2Server.Server.URL, RateLimit{Rate: 20, Burst: 1}))
+
+	send := func(rawURL string) {
+		req, err := http.NewRequest("GET", rawURL, nil)
+		s.NoError(err)
+		s.NoError(s.TestClient.sendUnauthenticatedRequest(context.Background(), req, &responseBody{}))
+	}
+
+	for i := 0; i < 5; i++ {
+		send(s.APIServer.Server.URL + "/unlimited")
+	}
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		send(s.API2Server.Server.URL + "/limited")
+	}
+	s.GreaterOrEqual(time.Since(start), 180*time.Millisecond, "ApiHost2 should be limited to 20 req/s")
+
+	u, err := url.Parse(s.API2Server.Server.URL)
+	s.NoError(err)
+	stats := s.TestClient.RateLimitStats()
+	s.Len(stats, 1, "ApiHost is not limited")
+	s.Equal(int64(4), stats[u.Host].Waits)
+	s.Equal(0, stats[u.Host].Waiting)
+}
+
+func (s *RateLimitTestSuite) TestRemoveLimit() {
+	s.NoError(s.TestClient.SetRateLimit(s.APIServer.Server.URL, RateLimit{Rate: 1, Burst: 1}))
+	s.Len(s.TestClient.RateLimitStats(), 1)
+	s.NoError(s.TestClient.SetRateLimit(s.APIServer.Server.URL, RateLimit{}))
+	s.Empty(s.TestClient.RateLimitStats())
+}
+
+func TestRateLimitSuite(t *testing.T) {
+	suite.Run(t, new(RateLimitTestSuite))
+}
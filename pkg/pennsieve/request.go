@@ -0,0 +1,124 @@
+package pennsieve
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Do sends req to the Pennsieve API the way the services do: the session is
+// refreshed if needed, the bearer token and organization header (see
+// WithOrganization) are added, and the request is retried and rate limited.
+// Unlike the services, the response body is not decoded, so Do suits
+// binary, CSV or large responses. The caller must close the body.
+//
+// Content-Type and Accept default to JSON unless already set on req. A
+// status other than 2xx is returned as an *HTTPError with the body closed.
+// The client's HTTPClient timeout also bounds reading the body; use a client
+// without one for long downloads.
+//
+// In dry-run mode, requests other than GET and HEAD are added to the plan
+// instead of being sent, and Do returns a synthetic 204 No Content response.
+func (c *Client) Do(ctx context.Context, req *http.Request) (res *http.Response, err error) {
+	if ctx == nil {
+		ctx = req.Context()
+	}
+	if c.DryRun() && req.Method != http.MethodGet && req.Method != http.MethodHead {
+		m := mutationFrom(ctx)
+		if m == nil {
+			m = &mutation{op: "Client.Do"}
+		}
+		if err := c.planRequest(ctx, m, req, nil); err != nil {
+			return nil, err
+		}
+		return &http.Response{
+			Status:     "204 No Content",
+			StatusCode: http.StatusNoContent,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     make(http.Header),
+			Body:       http.NoBody,
+			Request:    req,
+		}, nil
+	}
+	ctx, rt := c.telemetry().startRequest(ctx, req, c.serviceName(req))
+	defer func() { rt.end(err) }()
+
+	res, err = c.do(ctx, req)
+	if err != nil {
+		c.Logger().DebugContext(ctx, "Client.Do failed", "error", err)
+		return nil, err
+	}
+	return res, nil
+}
+
+// GetJSON sends an authenticated GET request to url and decodes the JSON
+// response into a T. It reaches endpoints the services do not cover yet:
+//
+//	type Contributor struct{ FirstName, LastName string }
+//	contributors, err := pennsieve.GetJSON[[]Contributor](ctx, client, "/datasets/"+id+"/contributors")
+//
+// A url starting with "/" is relative to the client's ApiHost; pass an
+// absolute URL to reach ApiHost2 or another host.
+func GetJSON[T any](ctx context.Context, c *Client, url string) (T, error) {
+	var res T
+	req, err := http.NewRequest(http.MethodGet, c.resolveURL(url), nil)
+	if err != nil {
+		return res, err
+	}
+
+	if ctx == nil {
+		ctx = req.Context()
+	}
+
+	if err := c.sendRequest(ctx, req, &res); err != nil {
+		c.Logger().DebugContext(ctx, "GetJSON failed", "error", err)
+		return res, err
+	}
+
+	return res, nil
+}
+
+// PostJSON sends body, encoded as JSON, in an authenticated POST request to
+// url and decodes the JSON response into a T. A nil body sends no body. url
+// is resolved as for GetJSON.
+//
+// PostJSON is treated as changing server state: in dry-run mode the request
+// is added to the plan and the zero T is returned.
+func PostJSON[T any](ctx context.Context, c *Client, url string, body any) (T, error) {
+	var res T
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return res, err
+		}
+	}
+	req, err := http.NewRequest(http.MethodPost, c.resolveURL(url), bytes.NewReader(payload))
+	if err != nil {
+		return res, err
+	}
+
+	if ctx == nil {
+		ctx = req.Context()
+	}
+
+	if err := c.sendRequest(withMutation(ctx, "PostJSON", nil), req, &res); err != nil {
+		c.Logger().DebugContext(ctx, "PostJSON failed", "error", err)
+		return res, err
+	}
+
+	return res, nil
+}
+
+// resolveURL returns url, with a leading "/" resolved against the client's
+// ApiHost.
+func (c *Client) resolveURL(url string) string {
+	if strings.HasPrefix(url, "/") {
+		return strings.TrimSuffix(c.GetAPIParams().ApiHost, "/") + url
+	}
+	return url
+}
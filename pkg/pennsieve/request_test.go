@@ -0,0 +1,150 @@
+package pennsieve
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type RequestTestSuite struct {
+	suite.Suite
+	MockCognitoServer
+	MockPennsieveServer
+	TestClient *Client
+}
+
+func (s *RequestTestSuite) SetupTest() {
+	s.MockCognitoServer = NewMockCognitoServerDefault(s.T())
+	s.MockPennsieveServer = NewMockPennsieveServerDefault(s.T())
+	AWSEndpoints = AWSCognitoEndpoints{IdentityProviderEndpoint: s.IdProviderServer.URL}
+	s.TestClient = NewClient(APIParams{ApiHost: s.Server.URL, ApiHost2: s.Server.URL, ApiKey: "test-key", ApiSecret: "test-secret"})
+}
+
+func (s *RequestTestSuite) TearDownTest() {
+	s.MockCognitoServer.Close()
+	s.MockPennsieveServer.Close()
+	AWSEndpoints.Reset()
+}
+
+func (s *RequestTestSuite) TestDoStreamsBody() {
+	s.Mux.HandleFunc("/packages/N:package:1/export", func(writer http.ResponseWriter, request *http.Request) {
+		s.Equal("Bearer access-token-1", request.Header.Get("Authorization"))
+		s.Equal("N:Organization:abcd", request.Header.Get("X-ORGANIZATION-ID"))
+		s.Equal("text/csv", request.Header.Get("Accept"), "a caller's Accept header should be kept")
+		writer.Header().Set("Content-Type", "text/csv")
+		writer.WriteHeader(http.StatusPartialContent)
+		_, _ = writer.Write([]byte("time,value\n0,1\n"))
+	})
+
+	req, err := http.NewRequest(http.MethodGet, s.Server.URL+"/packages/N:package:1/export", nil)
+	s.Require().NoError(err)
+	req.Header.Set("Accept", "text/csv")
+
+	res, err := s.TestClient.Do(context.Background(), req)
+	s.Require().NoError(err)
+	defer res.Body.Close()
+	s.Equal(http.StatusPartialContent, res.StatusCode)
+	body, err := io.ReadAll(res.Body)
+	s.NoError(err)
+	s.Equal("time,value\n0,1\n", string(body))
+}
+
+func (s *RequestTestSuite) TestDoMapsErrors() {
+	s.Mux.HandleFunc("/packages/N:package:missing/export", func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusNotFound)
+		_, _ = writer.Write([]byte(`{"message": "package not found"}`))
+	})
+
+	req, err := http.NewRequest(http.MethodGet, s.Server.URL+"/packages/N:package:missing/export", nil)
+	s.Require().NoError(err)
+	res, err := s.TestClient.Do(context.Background(), req)
+	s.Nil(res)
+	s.ErrorIs(err, ErrNotFound)
+	var httpErr *HTTPError
+	if s.ErrorAs(err, &httpErr) {
+		s.Equal("package not found", httpErr.Message)
+	}
+}
+
+func (s *RequestTestSuite) TestDoRefreshesSessionAndScopesOrganization() {
+	s.TestClient.SetSession(APISession{Token: "expired", Expiration: time.Now().Add(-time.Minute)})
+	s.Mux.HandleFunc("/raw", func(writer http.ResponseWriter, request *http.Request) {
+		s.Equal("Bearer access-token-1", request.Header.Get("Authorization"))
+		s.Equal("N:organization:other", request.Header.Get("X-ORGANIZATION-ID"))
+	})
+
+	req, err := http.NewRequest(http.MethodGet, s.Server.URL+"/raw", nil)
+	s.Require().NoError(err)
+	res, err := s.TestClient.Do(WithOrganization(context.Background(), "N:organization:other"), req)
+	s.Require().NoError(err)
+	s.NoError(res.Body.Close())
+}
+
+type testContributor struct {
+	ID        int    `json:"id"`
+	FirstName string `json:"firstName"`
+}
+
+func (s *RequestTestSuite) TestGetJSON() {
+	s.Mux.HandleFunc("/datasets/N:dataset:1/contributors", func(writer http.ResponseWriter, request *http.Request) {
+		s.Equal(http.MethodGet, request.Method)
+		_, _ = writer.Write([]byte(`[{"id": 1, "firstName": "Ada"}, {"id": 2, "firstName": "Alan"}]`))
+	})
+
+	contributors, err := GetJSON[[]testContributor](context.Background(), s.TestClient, "/datasets/N:dataset:1/contributors")
+	s.Require().NoError(err)
+	s.Equal([]testContributor{{1, "Ada"}, {2, "Alan"}}, contributors)
+
+	// Absolute URLs are used as is.
+	one, err := GetJSON[[]testContributor](context.Background(), s.TestClient, s.Server.URL+"/datasets/N:dataset:1/contributors")
+	s.Require().NoError(err)
+	s.Len(one, 2)
+}
+
+func (s *RequestTestSuite) TestGetJSONDecodeError() {
+	s.Mux.HandleFunc("/not-json", func(writer http.ResponseWriter, request *http.Request) {
+		_, _ = writer.Write([]byte(`<html>`))
+	})
+
+	_, err := GetJSON[map[string]any](context.Background(), s.TestClient, "/not-json")
+	var decodeErr *DecodeError
+	s.ErrorAs(err, &decodeErr)
+}
+
+func (s *RequestTestSuite) TestPostJSON() {
+	s.Mux.HandleFunc("/datasets/N:dataset:1/contributors", func(writer http.ResponseWriter, request *http.Request) {
+		s.Equal(http.MethodPost, request.Method)
+		var got testContributor
+		s.NoError(json.NewDecoder(request.Body).Decode(&got))
+		got.ID = 3
+		s.NoError(json.NewEncoder(writer).Encode(got))
+	})
+
+	created, err := PostJSON[testContributor](context.Background(), s.TestClient,
+		"/datasets/N:dataset:1/contributors", testContributor{FirstName: "Grace"})
+	s.Require().NoError(err)
+	s.Equal(testContributor{3, "Grace"}, created)
+}
+
+func (s *RequestTestSuite) TestPostJSONDryRun() {
+	s.TestClient.SetDryRun(true)
+
+	created, err := PostJSON[testContributor](context.Background(), s.TestClient,
+		"/datasets/N:dataset:1/contributors", testContributor{FirstName: "Grace"})
+	s.Require().NoError(err)
+	s.Zero(created)
+
+	plan := s.TestClient.Plan()
+	s.Require().Len(plan, 1)
+	s.Equal("PostJSON", plan[0].Operation)
+	s.JSONEq(`{"id": 0, "firstName": "Grace"}`, plan[0].Body)
+}
+
+func TestRequestSuite(t *testing.T) {
+	suite.Run(t, new(RequestTestSuite))
+}
@@ -0,0 +1,278 @@
+package pennsieve
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how the Client retries requests that fail with a
+// transient error. A request is retried when the server responds with 429
+// or 503, or when the connection is refused. Failures that may follow the
+// server handling the request (502 and 504 from the gateway, reset,
+// unexpected EOF, timeout) are retried only for idempotent methods and
+// requests with an idempotency key (see WithIdempotencyKey). Other 4xx/5xx responses are returned immediately.
+//
+// Delays grow exponentially from BaseDelay up to MaxDelay with jitter. When
+// the server sends a Retry-After header its value is used instead, unless it
+// exceeds MaxRetryAfter, in which case the error is returned to the caller.
+type RetryPolicy struct {
+	MaxAttempts   int           // total attempts including the first; <= 1 disables retries
+	BaseDelay     time.Duration // delay before the first retry
+	MaxDelay      time.Duration // upper bound for the computed backoff
+	MaxRetryAfter time.Duration // longest Retry-After the client is willing to honor; 0 means no limit
+}
+
+// DefaultRetryPolicy is the policy used by NewClient.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:   4,
+	BaseDelay:     200 * time.Millisecond,
+	MaxDelay:      10 * time.Second,
+	MaxRetryAfter: time.Minute,
+}
+
+// NoRetryPolicy disables automatic retries.
+var NoRetryPolicy = RetryPolicy{MaxAttempts: 1}
+
+// backoff returns the delay before retry number attempt (0-based), with
+// "equal jitter": half of the exponential delay is fixed, the other half is
+// random. This keeps a floor under the delay while spreading out clients
+// that failed at the same moment.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay << attempt
+	if d <= 0 || (p.MaxDelay > 0 && d > p.MaxDelay) {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	half := d / 2
+	return half + rand.N(half+1)
+}
+
+// isRetryableStatus reports whether a response status is worth retrying.
+func isRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// isRetryableResponse reports whether a response status of req is worth
+// retrying. 429 and 503 mean the request was refused, so they are always
+// retried. A 502 or 504 from the gateway may come after the backend did the
+// work, so it is retried only if sending req twice is harmless.
+func isRetryableResponse(req *http.Request, code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	}
+	return isRetryableStatus(code) && isIdempotent(req)
+}
+
+// isRetryableError reports whether a transport error of req is transient and
+// safe to retry. A refused connection means nothing was sent, so it is always
+// retried. Other failures (reset, EOF, timeout) may happen after the server
+// received the request, so they are retried only if sending req twice is
+// harmless: its method is idempotent or it carries an idempotency key.
+// Context cancellation is never retried.
+func isRetryableError(ctx context.Context, req *http.Request, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if isConnRefused(err) {
+		return true
+	}
+	if !isIdempotent(req) {
+		return false
+	}
+	if isConnReset(err) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// isIdempotent reports whether req can be sent again without repeating its
+// effect.
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get(IdempotencyKeyHeader) != ""
+}
+
+// parseRetryAfter parses a Retry-After header, which is either a number of
+// seconds or an HTTP date. ok is false when the header is absent or invalid.
+func parseRetryAfter(h string, now time.Time) (d time.Duration, ok bool) {
+	if h == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(h); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		if d = t.Sub(now); d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+// makeReplayable makes sure the request body can be re-read for each
+// attempt. Requests created by http.NewRequest with a bytes.Buffer,
+// bytes.Reader or strings.Reader already have GetBody; anything else is
+// buffered in memory.
+func makeReplayable(req *http.Request) error {
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
+		return nil
+	}
+	buf, err := io.ReadAll(req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return err
+	}
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf)), nil
+	}
+	req.Body, _ = req.GetBody()
+	return nil
+}
+
+type retryPolicyKey struct{}
+
+// withRetryPolicy makes requests sent with ctx use policy instead of the
+// client's RetryPolicy.
+func withRetryPolicy(ctx context.Context, policy RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey{}, policy)
+}
+
+// doWithRetry sends req through the client's middleware chain, retrying
+// transient failures according to c.RetryPolicy, or the policy set on ctx by
+// withRetryPolicy. The returned response is the last one received; its body
+// is left open for the caller.
+func (c *Client) doWithRetry(ctx context.Context, req *http.Request) (*http.Response, error) {
+	policy := c.RetryPolicy
+	if p, ok := ctx.Value(retryPolicyKey{}).(RetryPolicy); ok {
+		policy = p
+	}
+	maxAttempts := max(policy.MaxAttempts, 1)
+
+	if maxAttempts > 1 {
+		if err := makeReplayable(req); err != nil {
+			return nil, err
+		}
+	}
+
+	rt := requestTelemetryFrom(ctx)
+	logger := c.Logger().With(
+		"request_id", newRequestID(),
+		"method", req.Method,
+		"url", redactURL(req.URL.String()))
+
+	for attempt := 0; ; attempt++ {
+		attemptReq := req.Clone(ctx)
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq.Body = body
+		}
+
+		if err := c.waitRateLimit(ctx, req.URL.Host); err != nil {
+			return nil, err
+		}
+
+		breaker, circuitKey := c.circuitFor(req)
+		var probe uint64
+		if breaker != nil {
+			var err error
+			if probe, err = breaker.allow(circuitKey, time.Now()); err != nil {
+				logger.DebugContext(ctx, "pennsieve request refused", "attempt", attempt+1, "error", err)
+				return nil, err
+			}
+		}
+
+		start := time.Now()
+		res, err := c.roundTrip(attemptReq)
+		elapsed := time.Since(start)
+		rt.attempt(res)
+		if breaker != nil {
+			breaker.done(ctx, circuitKey, probe, res, err)
+		}
+
+		if err != nil {
+			logger.DebugContext(ctx, "pennsieve request failed",
+				"attempt", attempt+1, "duration", elapsed, "error", err)
+		} else {
+			logger.DebugContext(ctx, "pennsieve request",
+				"attempt", attempt+1, "duration", elapsed, "status", res.StatusCode,
+				"server_request_id", serverRequestID(res))
+		}
+
+		last := attempt == maxAttempts-1
+		var delay time.Duration
+		switch {
+		case err != nil:
+			if last || !isRetryableError(ctx, req, err) {
+				return nil, err
+			}
+			delay = policy.backoff(attempt)
+		case isRetryableResponse(req, res.StatusCode):
+			if last {
+				return res, nil
+			}
+			delay = policy.backoff(attempt)
+			if ra, ok := parseRetryAfter(res.Header.Get("Retry-After"), time.Now()); ok {
+				if policy.MaxRetryAfter > 0 && ra > policy.MaxRetryAfter {
+					return res, nil
+				}
+				delay = ra
+			}
+			// Drain so the connection can be reused.
+			_, _ = io.Copy(io.Discard, res.Body)
+			_ = res.Body.Close()
+		default:
+			return res, nil
+		}
+
+		logger.DebugContext(ctx, "retrying pennsieve request",
+			"attempt", attempt+1, "delay", delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// serverRequestID returns the request ID assigned by the API gateway, if any.
+func serverRequestID(res *http.Response) string {
+	for _, h := range []string{"X-Amzn-Requestid", "X-Request-Id", "Apigw-Requestid"} {
+		if id := res.Header.Get(h); id != "" {
+			return id
+		}
+	}
+	return ""
+}
@@ -0,0 +1,408 @@
+package pennsieve
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pennsieve/pennsieve-go/pkg/pennsieve/models/authentication"
+)
+
+// CredentialsSourceCache is reported by Client.CredentialsSource when the
+// session came from the session cache.
+const CredentialsSourceCache = "cache"
+
+// sessionCacheMagic starts every session cache file and is authenticated
+// along with its content.
+const sessionCacheMagic = "PSC1"
+
+// SessionCache stores sessions on disk so that short-lived processes reuse
+// a valid session, or refresh it, instead of authenticating with Cognito
+// every time. Sessions are kept per identity (the API key, or the refresh
+// token, the client's credentials resolve to) and API host, together with
+// the organization and Cognito config of the session.
+//
+// The file is encrypted with AES-256-GCM, written with mode 0600 and locked
+// while in use, so it can be shared by concurrent processes: while one
+// process refreshes an expired session the others wait for it and then use
+// the new session.
+type SessionCache struct {
+	path string
+	aead cipher.AEAD
+}
+
+// cachedSession is a session cache entry.
+type cachedSession struct {
+	Session            APISession                    `json:"session"`
+	Identity           string                        `json:"identity"` // see sessionCacheIdentity
+	OrganizationId     int                           `json:"organizationId"`
+	OrganizationNodeId string                        `json:"organizationNodeId"`
+	Pool               cognitoPool                   `json:"pool"`
+	CognitoConfig      *authentication.CognitoConfig `json:"cognitoConfig,omitempty"`
+}
+
+// DefaultSessionCachePath returns the location of the session cache in the
+// user's config dir, e.g. ~/.config/pennsieve/sessions on Linux.
+func DefaultSessionCachePath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "pennsieve", "sessions"), nil
+}
+
+// DefaultSessionCache opens the session cache at DefaultSessionCachePath,
+// with a key kept next to it.
+func DefaultSessionCache() (*SessionCache, error) {
+	path, err := DefaultSessionCachePath()
+	if err != nil {
+		return nil, err
+	}
+	return NewSessionCache(path, nil)
+}
+
+// NewSessionCache opens the session cache at path, creating it on first use.
+// key is the 32-byte AES-256 key the cache is encrypted with. If key is nil,
+// a random key is created in path + ".key" (mode 0600) and reused; this
+// keeps tokens out of copies and backups of the cache file, but not from
+// other processes of the same user.
+func NewSessionCache(path string, key []byte) (*SessionCache, error) {
+	if key == nil {
+		var err error
+		if key, err = loadSessionCacheKey(path + ".key"); err != nil {
+			return nil, err
+		}
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("invalid session cache key: got %d bytes, want 32", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &SessionCache{path: path, aead: aead}, nil
+}
+
+// loadSessionCacheKey reads the hex-encoded key at path, creating it if it
+// does not exist.
+func loadSessionCacheKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return nil, err
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if errors.Is(err, os.ErrExist) {
+			// Created by another process in the meantime.
+			return loadSessionCacheKey(path)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.WriteString(hex.EncodeToString(key)); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return key, f.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("error reading session cache key %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// Clear removes every session from the cache, e.g. to log out.
+func (sc *SessionCache) Clear() error {
+	unlock, err := sc.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := os.Remove(sc.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// lock takes an exclusive lock on the cache, shared with other processes,
+// and returns the function that releases it.
+func (sc *SessionCache) lock() (unlock func(), err error) {
+	if err := os.MkdirAll(filepath.Dir(sc.path), 0o700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(sc.path+".lock", os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error locking session cache: %w", err)
+	}
+	return func() {
+		_ = unlockFile(f)
+		f.Close()
+	}, nil
+}
+
+// read returns the entries of the cache. The caller must hold the lock.
+func (sc *SessionCache) read() (map[string]cachedSession, error) {
+	entries := map[string]cachedSession{}
+	data, err := os.ReadFile(sc.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return entries, nil
+	}
+	if err != nil {
+		return entries, err
+	}
+
+	n := len(sessionCacheMagic) + sc.aead.NonceSize()
+	if len(data) < n || string(data[:len(sessionCacheMagic)]) != sessionCacheMagic {
+		return entries, fmt.Errorf("session cache %s is not a session cache file", sc.path)
+	}
+	plain, err := sc.aead.Open(nil, data[len(sessionCacheMagic):n], data[n:], []byte(sessionCacheMagic))
+	if err != nil {
+		return entries, fmt.Errorf("error decrypting session cache %s: %w", sc.path, err)
+	}
+	if err := json.Unmarshal(plain, &entries); err != nil {
+		return map[string]cachedSession{}, fmt.Errorf("error reading session cache %s: %w", sc.path, err)
+	}
+	return entries, nil
+}
+
+// write replaces the entries of the cache. The caller must hold the lock.
+func (sc *SessionCache) write(entries map[string]cachedSession) error {
+	plain, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, sc.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	data := append([]byte(sessionCacheMagic), nonce...)
+	data = sc.aead.Seal(data, nonce, plain, []byte(sessionCacheMagic))
+
+	if err := os.MkdirAll(filepath.Dir(sc.path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(sc.path), filepath.Base(sc.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), sc.path)
+}
+
+// sessionCacheIdentity returns whose session creds obtain: the API key, or
+// for a refresh token a digest of the token and the pool that refreshes it.
+// It is empty if creds cannot be told apart from other credentials, and
+// then the cache is not used.
+func sessionCacheIdentity(creds Credentials) string {
+	switch {
+	case creds.ApiKey != "":
+		return "key:" + creds.ApiKey
+	case creds.RefreshToken != "":
+		sum := sha256.Sum256([]byte(creds.RefreshToken))
+		return "refresh:" + hex.EncodeToString(sum[:]) + "/" + string(userPool)
+	}
+	return ""
+}
+
+// sessionCacheKey returns the cache entry of identity on apiHost.
+func sessionCacheKey(identity, apiHost string) string {
+	return identity + "@" + apiHost
+}
+
+// usableWith reports whether the entry may be used by a client whose
+// credentials resolve to identity. Entries without an identity, such as
+// those written by older versions, are never used.
+func (e cachedSession) usableWith(identity string) bool {
+	return e.Session.Token != "" && identity != "" && e.Identity == identity
+}
+
+// SetSessionCache makes the client reuse and store its sessions in cache.
+// nil disables caching.
+func (c *Client) SetSessionCache(cache *SessionCache) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sessionCache = cache
+}
+
+func (c *Client) getSessionCache() *SessionCache {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.sessionCache
+}
+
+// loadCachedSession starts the client with its cached session, if it is
+// still valid. The client's credentials are resolved to find the entry.
+func (c *Client) loadCachedSession() {
+	cache := c.getSessionCache()
+	if cache == nil {
+		return
+	}
+	creds, err := c.retrieveCredentials(context.Background())
+	if err != nil {
+		c.Logger().Debug("not loading cached session", "error", err)
+		return
+	}
+	identity := sessionCacheIdentity(creds)
+	if identity == "" {
+		return
+	}
+	unlock, err := cache.lock()
+	if err != nil {
+		c.Logger().Warn("error opening session cache", "error", err)
+		return
+	}
+	defer unlock()
+
+	entries, err := cache.read()
+	if err != nil {
+		c.Logger().Warn("ignoring unreadable session cache", "error", err)
+		return
+	}
+	key := sessionCacheKey(identity, c.GetAPIParams().ApiHost)
+	if entry, ok := entries[key]; ok && entry.usableWith(identity) && !sessionNeedsRefresh(entry.Session) {
+		c.useCachedSession(entry)
+		c.setCredentialsSource(CredentialsSourceCache)
+	}
+}
+
+// useCachedSession sets the session, organization and Cognito config of
+// entry on the client.
+func (c *Client) useCachedSession(entry cachedSession) {
+	c.SetSession(entry.Session)
+	if entry.OrganizationNodeId != "" {
+		c.SetOrganization(entry.OrganizationId, entry.OrganizationNodeId)
+	}
+	if auth, ok := c.Authentication.(*authenticationService); ok && entry.CognitoConfig != nil {
+		if _, loaded := auth.loadedCognitoConfig(); !loaded {
+			auth.setCognitoConfig(*entry.CognitoConfig)
+		}
+	}
+}
+
+// authenticateWithCache obtains a new session for a client with a session
+// cache. Holding the cache lock, it uses the cached session of the client's
+// credentials if another process has refreshed it, or else refreshes the
+// cached session with its refresh token, and only then authenticates with
+// the credentials. The new session is written back to the cache.
+func (c *Client) authenticateWithCache(ctx context.Context, cache *SessionCache) (*APISession, error) {
+	logger := c.Logger()
+	creds, err := c.retrieveCredentials(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving credentials: %w", err)
+	}
+	identity := sessionCacheIdentity(creds)
+	if identity == "" {
+		session, _, err := c.authenticateWithCredentials(ctx, creds)
+		return session, err
+	}
+	unlock, err := cache.lock()
+	if err != nil {
+		logger.WarnContext(ctx, "error opening session cache", "error", err)
+		session, _, err := c.authenticateWithCredentials(ctx, creds)
+		return session, err
+	}
+	defer unlock()
+
+	key := sessionCacheKey(identity, c.GetAPIParams().ApiHost)
+	entries, err := cache.read()
+	if err != nil {
+		logger.WarnContext(ctx, "ignoring unreadable session cache", "error", err)
+	}
+
+	if entry, ok := entries[key]; ok && entry.usableWith(identity) {
+		if !sessionNeedsRefresh(entry.Session) {
+			logger.DebugContext(ctx, "using cached session", "expiration", entry.Session.Expiration)
+			c.useCachedSession(entry)
+			c.setCredentialsSource(CredentialsSourceCache)
+			return &entry.Session, nil
+		}
+		if entry.Session.RefreshToken != "" {
+			c.useCachedSession(entry)
+			session, err := c.contextAuthenticator().refreshSession(ctx, entry.Session.RefreshToken, entry.Pool)
+			if err == nil {
+				logger.DebugContext(ctx, "refreshed cached session")
+				c.setCredentialsSource(CredentialsSourceCache)
+				c.storeCachedSession(ctx, cache, entries, key, identity, *session, entry.Pool)
+				return session, nil
+			}
+			logger.DebugContext(ctx, "error refreshing cached session, falling back to credentials", "error", err)
+		}
+	}
+
+	session, pool, err := c.authenticateWithCredentials(ctx, creds)
+	if err != nil {
+		return nil, err
+	}
+	c.storeCachedSession(ctx, cache, entries, key, identity, *session, pool)
+	return session, nil
+}
+
+// storeCachedSession writes session of identity and the client's
+// organization and Cognito config to entries[key] and the cache. Errors are
+// logged: the session is usable even if it could not be cached.
+func (c *Client) storeCachedSession(ctx context.Context, cache *SessionCache, entries map[string]cachedSession, key, identity string, session APISession, pool cognitoPool) {
+	entry := cachedSession{Session: session, Identity: identity, Pool: pool}
+	entry.OrganizationId, entry.OrganizationNodeId = c.GetOrganization()
+	if auth, ok := c.Authentication.(*authenticationService); ok {
+		if cfg, loaded := auth.loadedCognitoConfig(); loaded {
+			entry.CognitoConfig = &cfg
+		}
+	}
+	entries[key] = entry
+	if err := cache.write(entries); err != nil {
+		c.Logger().WarnContext(ctx, "error writing session cache", "error", err)
+	}
+}
+
+// loadedCognitoConfig returns the Cognito config if it has been loaded.
+func (s *authenticationService) loadedCognitoConfig() (authentication.CognitoConfig, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config, s.configLoaded
+}
+
+// useDefaultSessionCache opens the default session cache if
+// o.params.UseSessionCache is set and no cache was given.
+func (o *clientOptions) useDefaultSessionCache() error {
+	if !o.params.UseSessionCache || o.sessionCache != nil {
+		return nil
+	}
+	cache, err := DefaultSessionCache()
+	if err != nil {
+		return fmt.Errorf("error opening session cache: %w", err)
+	}
+	o.sessionCache = cache
+	return nil
+}
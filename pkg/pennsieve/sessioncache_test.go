@@ -0,0 +1,388 @@
+package pennsieve
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/pennsieve/pennsieve-go/pkg/pennsieve/models/authentication"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testSessionCacheKey = bytes.Repeat([]byte{7}, 32)
+
+func TestSessionCacheReadWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache", "sessions")
+	cache, err := NewSessionCache(path, testSessionCacheKey)
+	require.NoError(t, err)
+
+	entries, err := cache.read()
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	entries["main@https://api.pennsieve.io"] = cachedSession{
+		Session: APISession{Token: "secret-access-token", RefreshToken: "secret-refresh-token"},
+		Pool:    tokenPool,
+	}
+	require.NoError(t, cache.write(entries))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "secret", "tokens should be encrypted")
+
+	read, err := cache.read()
+	require.NoError(t, err)
+	assert.Equal(t, entries, read)
+
+	other, err := NewSessionCache(path, bytes.Repeat([]byte{8}, 32))
+	require.NoError(t, err)
+	_, err = other.read()
+	assert.ErrorContains(t, err, "error decrypting session cache")
+
+	require.NoError(t, cache.Clear())
+	_, err = os.Stat(path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.NoError(t, cache.Clear(), "clearing an empty cache is not an error")
+}
+
+func TestNewSessionCacheKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions")
+	first, err := NewSessionCache(path, nil)
+	require.NoError(t, err)
+	require.NoError(t, first.write(map[string]cachedSession{"k": {Session: APISession{Token: "t"}}}))
+
+	info, err := os.Stat(path + ".key")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	second, err := NewSessionCache(path, nil)
+	require.NoError(t, err)
+	entries, err := second.read()
+	require.NoError(t, err)
+	assert.Equal(t, "t", entries["k"].Session.Token)
+
+	_, err = NewSessionCache(path, []byte("short"))
+	assert.ErrorContains(t, err, "invalid session cache key")
+}
+
+func TestDefaultSessionCachePath(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("HOME", dir)
+	t.Setenv("AppData", dir)
+
+	path, err := DefaultSessionCachePath()
+	require.NoError(t, err)
+	assert.Equal(t, "sessions", filepath.Base(path))
+	assert.Equal(t, "pennsieve", filepath.Base(filepath.Dir(path)))
+}
+
+// sessionCacheCognito is a Cognito stand-in that counts InitiateAuth calls
+// by flow and can reject refresh tokens.
+type sessionCacheCognito struct {
+	*httptest.Server
+	mu            sync.Mutex
+	calls         map[string]int // by auth flow
+	clientIDs     []string
+	rejectRefresh bool
+}
+
+func newSessionCacheCognito(t *testing.T) *sessionCacheCognito {
+	c := &sessionCacheCognito{calls: map[string]int{}}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var body struct {
+			AuthFlow string
+			ClientId string
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			t.Errorf("error decoding InitiateAuth request: %v", err)
+		}
+		c.mu.Lock()
+		c.calls[body.AuthFlow]++
+		c.clientIDs = append(c.clientIDs, body.ClientId)
+		reject := c.rejectRefresh && body.AuthFlow == string(types.AuthFlowTypeRefreshToken)
+		n := c.calls[body.AuthFlow]
+		c.mu.Unlock()
+
+		if reject {
+			w.Header().Set("X-Amzn-Errortype", "NotAuthorizedException")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"__type": "NotAuthorizedException", "message": "Refresh Token has expired"}`))
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"AuthenticationResult": {"AccessToken": "%s-%d", "ExpiresIn": 3600, "IdToken": %q, "RefreshToken": "cached-refresh", "TokenType": "Bearer"}}`,
+			body.AuthFlow, n, NewTestIdToken(t, c.URL, sessionCacheCognitoConfig, body.ClientId, map[string]any{OrgNodeIdClaimKey: "N:Organization:abcd", OrgIdClaimKey: "9999"}, time.Hour))
+	}))
+	t.Cleanup(c.Close)
+	return c
+}
+
+func (c *sessionCacheCognito) callCount(flow types.AuthFlowType) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls[string(flow)]
+}
+
+func (c *sessionCacheCognito) lastClientID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.clientIDs[len(c.clientIDs)-1]
+}
+
+var sessionCacheCognitoConfig = authentication.CognitoConfig{
+	Region:    "us-east-1",
+	UserPool:  authentication.UserPool{ID: "us-east-1_userpool", AppClientID: "user-pool-client"},
+	TokenPool: authentication.TokenPool{ID: "us-east-1_tokenpool", AppClientID: "token-pool-client"},
+}
+
+func newSessionCacheTestClient(t *testing.T, cognito *sessionCacheCognito, cache *SessionCache, opts ...ClientOption) *Client {
+	opts = append([]ClientOption{
+		WithBaseURLs("http://localhost:1", "http://localhost:2"),
+		WithCredentials("cache-key", "cache-secret"),
+		WithAWSConfig(newTestAWSConfig(cognito.URL)),
+		WithHTTPClient(&http.Client{Transport: failingTransport{t}}),
+		WithJWKS(testJWKS),
+		WithSessionCache(cache),
+	}, opts...)
+	client, err := NewClientWithOptions(opts...)
+	require.NoError(t, err)
+	return client
+}
+
+func newTestSessionCache(t *testing.T) *SessionCache {
+	cache, err := NewSessionCache(filepath.Join(t.TempDir(), "sessions"), testSessionCacheKey)
+	require.NoError(t, err)
+	return cache
+}
+
+// expireCachedSessions makes every session in cache expired.
+func expireCachedSessions(t *testing.T, cache *SessionCache) {
+	entries, err := cache.read()
+	require.NoError(t, err)
+	for key, entry := range entries {
+		entry.Session.Expiration = time.Now().Add(-time.Minute)
+		entries[key] = entry
+	}
+	require.NoError(t, cache.write(entries))
+}
+
+func TestSessionCacheReusesValidSession(t *testing.T) {
+	clearCredentialsEnv(t)
+	cognito := newSessionCacheCognito(t)
+	cache := newTestSessionCache(t)
+
+	first := newSessionCacheTestClient(t, cognito, cache, WithCognitoConfig(sessionCacheCognitoConfig))
+	session, err := first.ensureSession(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, cognito.callCount(types.AuthFlowTypeUserPasswordAuth))
+
+	// A later process: the cached session is used without calling Cognito
+	// or fetching the Cognito config.
+	second := newSessionCacheTestClient(t, cognito, cache)
+	assert.Equal(t, session.Token, second.GetSession().Token)
+	orgId, orgNodeId := second.GetOrganization()
+	assert.Equal(t, 9999, orgId)
+	assert.Equal(t, "N:Organization:abcd", orgNodeId)
+	assert.Equal(t, CredentialsSourceCache, second.CredentialsSource())
+
+	_, err = second.ensureSession(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, cognito.callCount(types.AuthFlowTypeUserPasswordAuth))
+
+	// Sessions of other API keys are not used.
+	other := newSessionCacheTestClient(t, cognito, cache, WithCredentials("other-key", "other-secret"),
+		WithCognitoConfig(sessionCacheCognitoConfig))
+	assert.Empty(t, other.GetSession().Token)
+}
+
+func TestSessionCacheRefreshesExpiredSession(t *testing.T) {
+	clearCredentialsEnv(t)
+	cognito := newSessionCacheCognito(t)
+	cache := newTestSessionCache(t)
+
+	first := newSessionCacheTestClient(t, cognito, cache, WithCognitoConfig(sessionCacheCognitoConfig))
+	_, err := first.ensureSession(context.Background())
+	require.NoError(t, err)
+	expireCachedSessions(t, cache)
+
+	// The Cognito config comes from the cache too.
+	second := newSessionCacheTestClient(t, cognito, cache)
+	assert.Empty(t, second.GetSession().Token, "an expired session should not be loaded")
+	session, err := second.ensureSession(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "REFRESH_TOKEN-1", session.Token)
+	assert.Equal(t, "token-pool-client", cognito.lastClientID(), "a key/secret session is refreshed with the token pool client")
+	assert.Equal(t, 1, cognito.callCount(types.AuthFlowTypeUserPasswordAuth))
+	assert.Equal(t, CredentialsSourceCache, second.CredentialsSource())
+
+	entries, err := cache.read()
+	require.NoError(t, err)
+	entry := entries[sessionCacheKey("key:cache-key", "http://localhost:1")]
+	assert.Equal(t, "REFRESH_TOKEN-1", entry.Session.Token)
+	assert.Equal(t, "cached-refresh", entry.Session.RefreshToken)
+}
+
+func TestSessionCacheFallsBackToCredentials(t *testing.T) {
+	clearCredentialsEnv(t)
+	cognito := newSessionCacheCognito(t)
+	cognito.rejectRefresh = true
+	cache := newTestSessionCache(t)
+
+	first := newSessionCacheTestClient(t, cognito, cache, WithCognitoConfig(sessionCacheCognitoConfig))
+	_, err := first.ensureSession(context.Background())
+	require.NoError(t, err)
+	expireCachedSessions(t, cache)
+
+	second := newSessionCacheTestClient(t, cognito, cache)
+	session, err := second.ensureSession(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "USER_PASSWORD_AUTH-2", session.Token)
+	assert.Equal(t, 1, cognito.callCount(types.AuthFlowTypeRefreshToken))
+	assert.Equal(t, CredentialsSourceParams, second.CredentialsSource())
+}
+
+func TestSessionCacheIgnoresCorruptFile(t *testing.T) {
+	clearCredentialsEnv(t)
+	cognito := newSessionCacheCognito(t)
+	cache := newTestSessionCache(t)
+	require.NoError(t, os.WriteFile(cache.path, []byte("not a cache"), 0o600))
+
+	client := newSessionCacheTestClient(t, cognito, cache, WithCognitoConfig(sessionCacheCognitoConfig))
+	_, err := client.ensureSession(context.Background())
+	require.NoError(t, err)
+
+	entries, err := cache.read()
+	require.NoError(t, err, "the cache should be rewritten")
+	assert.Len(t, entries, 1)
+}
+
+func TestSessionCacheSharedByProcesses(t *testing.T) {
+	clearCredentialsEnv(t)
+	cognito := newSessionCacheCognito(t)
+	path := filepath.Join(t.TempDir(), "sessions")
+
+	// Each client opens the cache separately, as separate processes would.
+	var wg sync.WaitGroup
+	tokens := make([]string, 8)
+	for i := range tokens {
+		cache, err := NewSessionCache(path, testSessionCacheKey)
+		require.NoError(t, err)
+		client := newSessionCacheTestClient(t, cognito, cache, WithCognitoConfig(sessionCacheCognitoConfig))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			session, err := client.ensureSession(context.Background())
+			if assert.NoError(t, err) {
+				tokens[i] = session.Token
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, cognito.callCount(types.AuthFlowTypeUserPasswordAuth), "only one client should authenticate")
+	for _, token := range tokens {
+		assert.Equal(t, "USER_PASSWORD_AUTH-1", token)
+	}
+}
+
+func TestNewClientUseSessionCache(t *testing.T) {
+	clearCredentialsEnv(t)
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("HOME", dir)
+	t.Setenv("AppData", dir)
+
+	path, err := DefaultSessionCachePath()
+	require.NoError(t, err)
+	cache, err := DefaultSessionCache()
+	require.NoError(t, err)
+	params := APIParams{ApiKey: "cache-key", ApiSecret: "cache-secret", ApiHost: "http://localhost:1", UseSessionCache: true}
+	entries := map[string]cachedSession{sessionCacheKey("key:cache-key", params.ApiHost): {
+		Session:            APISession{Token: "cached-token", Expiration: time.Now().Add(time.Hour)},
+		Identity:           "key:cache-key",
+		OrganizationId:     1,
+		OrganizationNodeId: "N:organization:cached",
+	}}
+	require.NoError(t, cache.write(entries))
+
+	client := NewClient(params)
+	assert.Equal(t, "cached-token", client.GetSession().Token)
+	_, orgNodeId := client.GetOrganization()
+	assert.Equal(t, "N:organization:cached", orgNodeId)
+	assert.FileExists(t, path+".key")
+}
+
+// TestSessionCacheKeyedByResolvedCredentials uses credentials from the
+// environment, with no API key or profile in APIParams: sessions of
+// different keys and refresh tokens must not be shared.
+func TestSessionCacheKeyedByResolvedCredentials(t *testing.T) {
+	clearCredentialsEnv(t)
+	cognito := newSessionCacheCognito(t)
+	cache := newTestSessionCache(t)
+	newEnvClient := func() *Client {
+		return newSessionCacheTestClient(t, cognito, cache, WithCredentials("", ""), WithCognitoConfig(sessionCacheCognitoConfig))
+	}
+
+	t.Setenv(APIKeyEnvVar, "env-key-1")
+	t.Setenv(APISecretEnvVar, "env-secret-1")
+	first := newEnvClient()
+	session, err := first.ensureSession(context.Background())
+	require.NoError(t, err)
+
+	t.Setenv(APIKeyEnvVar, "env-key-2")
+	t.Setenv(APISecretEnvVar, "env-secret-2")
+	other := newEnvClient()
+	assert.Empty(t, other.GetSession().Token, "another key's session should not be loaded")
+	otherSession, err := other.ensureSession(context.Background())
+	require.NoError(t, err)
+	assert.NotEqual(t, session.Token, otherSession.Token)
+	assert.Equal(t, 2, cognito.callCount(types.AuthFlowTypeUserPasswordAuth))
+
+	t.Setenv(APIKeyEnvVar, "env-key-1")
+	t.Setenv(APISecretEnvVar, "env-secret-1")
+	assert.Equal(t, session.Token, newEnvClient().GetSession().Token)
+
+	t.Setenv(APIKeyEnvVar, "")
+	t.Setenv(APISecretEnvVar, "")
+	t.Setenv(RefreshTokenEnvVar, "env-refresh-1")
+	user := newEnvClient()
+	assert.Empty(t, user.GetSession().Token, "a refresh token should not get an API key's session")
+	userSession, err := user.ensureSession(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, cognito.callCount(types.AuthFlowTypeRefreshToken))
+
+	t.Setenv(RefreshTokenEnvVar, "env-refresh-2")
+	assert.Empty(t, newEnvClient().GetSession().Token, "another refresh token's session should not be loaded")
+	t.Setenv(RefreshTokenEnvVar, "env-refresh-1")
+	assert.Equal(t, userSession.Token, newEnvClient().GetSession().Token)
+}
+
+func TestSessionCacheRejectsEntriesWithoutIdentity(t *testing.T) {
+	clearCredentialsEnv(t)
+	cognito := newSessionCacheCognito(t)
+	cache := newTestSessionCache(t)
+	entries := map[string]cachedSession{
+		sessionCacheKey("key:cache-key", "http://localhost:1"): {Session: APISession{Token: "unmatched", Expiration: time.Now().Add(time.Hour)}},
+		"default@http://localhost:1":                           {Session: APISession{Token: "legacy", Expiration: time.Now().Add(time.Hour)}},
+	}
+	require.NoError(t, cache.write(entries))
+
+	client := newSessionCacheTestClient(t, cognito, cache, WithCognitoConfig(sessionCacheCognitoConfig))
+	assert.Empty(t, client.GetSession().Token)
+	session, err := client.ensureSession(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "USER_PASSWORD_AUTH-1", session.Token)
+}
@@ -0,0 +1,218 @@
+package pennsieve
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+)
+
+// srpN and srpG are the SRP-6a group used by Cognito: the 3072-bit MODP
+// prime of RFC 3526 and generator 2.
+var (
+	srpN, _ = new(big.Int).SetString(""+
+		"FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD1"+
+		"29024E088A67CC74020BBEA63B139B22514A08798E3404DD"+
+		"EF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245"+
+		"E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED"+
+		"EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE45B3D"+
+		"C2007CB8A163BF0598DA48361C55D39A69163FA8FD24CF5F"+
+		"83655D23DCA3AD961C62F356208552BB9ED529077096966D"+
+		"670C354E4ABC9804F1746C08CA18217C32905E462E36CE3B"+
+		"E39E772C180E86039B2783A2EC07A28FB5C55DF06F4C52C9"+
+		"DE2BCBF6955817183995497CEA956AE515D2261898FA0510"+
+		"15728E5A8AAAC42DAD33170D04507A33A85521ABDF1CBA64"+
+		"ECFB850458DBEF0A8AEA71575D060C7DB3970F85A6E1E4C7"+
+		"ABF5AE8CDB0933D71E8C94E04A25619DCEE3D2261AD2EE6B"+
+		"F12FFA06D98A0864D87602733EC86A64521F2B18177B200C"+
+		"BBE117577A615D6C770988C0BAD946E208E24FA074E5AB31"+
+		"43DB5BFCE0FD108E4B82D120A93AD2CAFFFFFFFFFFFFFFFF", 16)
+	srpG = big.NewInt(2)
+	srpK = srpHashInt(srpPadHex(srpN) + srpPadHex(srpG))
+)
+
+// srpTimestampLayout is the format of the TIMESTAMP challenge response,
+// e.g. "Tue Jul 4 09:03:27 UTC 2023".
+const srpTimestampLayout = "Mon Jan 2 15:04:05 UTC 2006"
+
+// srpClient holds the ephemeral secret of one SRP exchange.
+type srpClient struct {
+	a *big.Int // secret
+	A *big.Int // public value g^a, sent as SRP_A
+}
+
+func newSRPClient() (*srpClient, error) {
+	for {
+		buf := make([]byte, 128)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		a := new(big.Int).Mod(new(big.Int).SetBytes(buf), srpN)
+		A := new(big.Int).Exp(srpG, a, srpN)
+		if A.Sign() != 0 {
+			return &srpClient{a: a, A: A}, nil
+		}
+	}
+}
+
+// passwordClaim answers a PASSWORD_VERIFIER challenge: it proves knowledge of
+// password without sending it. poolID is the user pool's id, e.g.
+// "us-east-1_AbCdEf".
+func (c *srpClient) passwordClaim(poolID, password string, params map[string]string, now time.Time) (map[string]string, error) {
+	_, poolName, ok := strings.Cut(poolID, "_")
+	if !ok {
+		return nil, fmt.Errorf("invalid user pool id %q", poolID)
+	}
+	userID := params["USER_ID_FOR_SRP"]
+	secretBlock := params["SECRET_BLOCK"]
+	B, okB := new(big.Int).SetString(params["SRP_B"], 16)
+	salt, okSalt := new(big.Int).SetString(params["SALT"], 16)
+	if userID == "" || secretBlock == "" || !okB || !okSalt {
+		return nil, errors.New("incomplete PASSWORD_VERIFIER challenge")
+	}
+	if new(big.Int).Mod(B, srpN).Sign() == 0 {
+		return nil, errors.New("invalid SRP_B in PASSWORD_VERIFIER challenge")
+	}
+	secretBlockBytes, err := base64.StdEncoding.DecodeString(secretBlock)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SECRET_BLOCK in PASSWORD_VERIFIER challenge: %w", err)
+	}
+
+	u := srpHashInt(srpPadHex(c.A) + srpPadHex(B))
+	if u.Sign() == 0 {
+		return nil, errors.New("invalid SRP_B in PASSWORD_VERIFIER challenge")
+	}
+	identity := sha256.Sum256([]byte(poolName + userID + ":" + password))
+	x := srpHashInt(srpPadHex(salt) + hex.EncodeToString(identity[:]))
+
+	// S = (B - k * g^x) ^ (a + u * x) mod N
+	base := new(big.Int).Mul(srpK, new(big.Int).Exp(srpG, x, srpN))
+	base.Sub(B, base).Mod(base, srpN)
+	exp := new(big.Int).Mul(u, x)
+	exp.Add(exp, c.a)
+	S := new(big.Int).Exp(base, exp, srpN)
+
+	key := srpDeriveKey(S, u)
+	timestamp := now.UTC().Format(srpTimestampLayout)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(poolName))
+	mac.Write([]byte(userID))
+	mac.Write(secretBlockBytes)
+	mac.Write([]byte(timestamp))
+
+	return map[string]string{
+		"USERNAME":                    userID,
+		"PASSWORD_CLAIM_SECRET_BLOCK": secretBlock,
+		"PASSWORD_CLAIM_SIGNATURE":    base64.StdEncoding.EncodeToString(mac.Sum(nil)),
+		"TIMESTAMP":                   timestamp,
+	}, nil
+}
+
+// srpDeriveKey derives the 16-byte signing key from the shared secret S and
+// the scrambler u with HKDF-SHA256.
+func srpDeriveKey(S, u *big.Int) []byte {
+	ikm, _ := hex.DecodeString(srpPadHex(S))
+	salt, _ := hex.DecodeString(srpPadHex(u))
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(ikm)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write([]byte("Caldera Derived Key\x01"))
+	return expand.Sum(nil)[:16]
+}
+
+// srpPadHex returns n in hex with an even number of digits and, like a
+// two's complement encoding, a leading zero byte if its high bit is set.
+func srpPadHex(n *big.Int) string {
+	h := n.Text(16)
+	if len(h)%2 == 1 {
+		return "0" + h
+	}
+	if strings.ContainsRune("89abcdef", rune(h[0])) {
+		return "00" + h
+	}
+	return h
+}
+
+// srpHashInt returns SHA-256 of the bytes in hex string h as an integer.
+func srpHashInt(h string) *big.Int {
+	b, _ := hex.DecodeString(h)
+	sum := sha256.Sum256(b)
+	return new(big.Int).SetBytes(sum[:])
+}
+
+// passwordAuth starts a sign-in with username and password through
+// clientID, with USER_SRP_AUTH if the service uses SRP or else
+// USER_PASSWORD_AUTH. With SRP the password is never sent: the
+// PASSWORD_VERIFIER challenge is answered here. The returned step holds the
+// tokens or the next challenge, such as MFA.
+func (s *authenticationService) passwordAuth(ctx context.Context, clientID *string, poolID, username, password string) (authStep, error) {
+	if !s.srp {
+		out, err := s.initiateAuth(ctx, &cognitoidentityprovider.InitiateAuthInput{
+			AuthFlow: types.AuthFlowTypeUserPasswordAuth,
+			AuthParameters: map[string]string{
+				"USERNAME": username,
+				"PASSWORD": password,
+			},
+			ClientId: clientID,
+		})
+		if err != nil {
+			return authStep{}, err
+		}
+		return authStep{out.AuthenticationResult, out.ChallengeName, out.ChallengeParameters, out.Session}, nil
+	}
+
+	srp, err := newSRPClient()
+	if err != nil {
+		return authStep{}, err
+	}
+	out, err := s.initiateAuth(ctx, &cognitoidentityprovider.InitiateAuthInput{
+		AuthFlow: types.AuthFlowTypeUserSrpAuth,
+		AuthParameters: map[string]string{
+			"USERNAME": username,
+			"SRP_A":    srp.A.Text(16),
+		},
+		ClientId: clientID,
+	})
+	if err != nil {
+		return authStep{}, err
+	}
+	if out.ChallengeName != types.ChallengeNameTypePasswordVerifier {
+		return authStep{}, &CognitoError{Operation: "InitiateAuth", Err: fmt.Errorf("expected %s challenge, got %q", types.ChallengeNameTypePasswordVerifier, out.ChallengeName)}
+	}
+
+	responses, err := srp.passwordClaim(poolID, password, out.ChallengeParameters, time.Now())
+	if err != nil {
+		return authStep{}, &CognitoError{Operation: "InitiateAuth", Err: err}
+	}
+	res, err := s.respondToAuthChallenge(ctx, &cognitoidentityprovider.RespondToAuthChallengeInput{
+		ChallengeName:      types.ChallengeNameTypePasswordVerifier,
+		ClientId:           clientID,
+		Session:            out.Session,
+		ChallengeResponses: responses,
+	})
+	if err != nil {
+		return authStep{}, err
+	}
+	if res.ChallengeParameters == nil {
+		res.ChallengeParameters = map[string]string{}
+	}
+	if _, ok := res.ChallengeParameters["USER_ID_FOR_SRP"]; !ok {
+		res.ChallengeParameters["USER_ID_FOR_SRP"] = responses["USERNAME"]
+	}
+	return authStep{res.AuthenticationResult, res.ChallengeName, res.ChallengeParameters, res.Session}, nil
+}
+
+// tokens returns the tokens of a step that completed the sign-in.
+func (st authStep) tokens() (*types.AuthenticationResultType, error) {
+	return authResult(&cognitoidentityprovider.InitiateAuthOutput{AuthenticationResult: st.result, ChallengeName: st.challenge})
+}
@@ -0,0 +1,373 @@
+package pennsieve
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/pennsieve/pennsieve-go/pkg/pennsieve/models/authentication"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+var srpCognitoConfig = authentication.CognitoConfig{
+	Region: "us-east-1",
+	UserPool: authentication.UserPool{
+		Region:      "us-east-1",
+		ID:          "us-east-1_UserPool",
+		AppClientID: "srp-user-pool-client",
+	},
+	TokenPool: authentication.TokenPool{
+		Region:      "us-east-1",
+		ID:          "us-east-1_TokenPool",
+		AppClientID: "srp-token-pool-client",
+	},
+}
+
+// srpUser is a user of the mock Cognito SRP server.
+type srpUser struct {
+	id       string // USER_ID_FOR_SRP
+	password string
+	mfa      bool
+}
+
+// srpExchange is the server side of one SRP sign-in.
+type srpExchange struct {
+	user   srpUser
+	pool   string
+	salt   *big.Int
+	v      *big.Int
+	b, A   *big.Int
+	B      *big.Int
+	secret []byte // SECRET_BLOCK
+}
+
+// SRPTestSuite runs sign-ins against a mock Cognito that implements the
+// server side of SRP-6a and verifies the client's password claim.
+type SRPTestSuite struct {
+	suite.Suite
+	API         MockServer
+	MockCognito MockServer
+
+	mu        sync.Mutex
+	users     map[string]srpUser // by USERNAME
+	exchanges map[string]*srpExchange
+	bodies    []string
+	targets   []string
+}
+
+func (s *SRPTestSuite) SetupTest() {
+	cognitoMux := http.NewServeMux()
+	s.MockCognito = MockServer{Server: httptest.NewServer(cognitoMux), Mux: cognitoMux}
+	cognitoMux.HandleFunc("/", s.handleCognito)
+	apiMux := http.NewServeMux()
+	s.API = MockServer{Server: httptest.NewServer(apiMux), Mux: apiMux}
+
+	s.users = map[string]srpUser{
+		"api-key":         {id: "api-key", password: "api-secret"},
+		"ada@example.com": {id: "ada-uuid", password: "correct horse", mfa: true},
+	}
+	s.exchanges = map[string]*srpExchange{}
+	s.bodies, s.targets = nil, nil
+}
+
+func (s *SRPTestSuite) TearDownTest() {
+	s.MockCognito.Close()
+	s.API.Close()
+}
+
+func (s *SRPTestSuite) newClient(opts ...ClientOption) *Client {
+	opts = append([]ClientOption{
+		WithCredentials("api-key", "api-secret"),
+		WithBaseURLs(s.API.Server.URL, s.API.Server.URL),
+		WithAWSConfig(newTestAWSConfig(s.MockCognito.Server.URL)),
+		WithCognitoConfig(srpCognitoConfig),
+		WithRetryPolicy(NoRetryPolicy),
+		WithSRPAuthentication(),
+	}, opts...)
+	client, err := NewClientWithOptions(opts...)
+	s.Require().NoError(err)
+	return client
+}
+
+func (s *SRPTestSuite) handleCognito(writer http.ResponseWriter, request *http.Request) {
+	if serveTestJWKS(writer, request) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	target := strings.TrimPrefix(request.Header.Get("X-Amz-Target"), "AWSCognitoIdentityProviderService.")
+	s.targets = append(s.targets, target)
+	raw, err := io.ReadAll(request.Body)
+	s.Require().NoError(err)
+	s.bodies = append(s.bodies, string(raw))
+
+	var body struct {
+		AuthFlow           string
+		ClientId           string
+		AuthParameters     map[string]string
+		ChallengeName      string
+		ChallengeResponses map[string]string
+		Session            string
+	}
+	s.Require().NoError(json.Unmarshal(raw, &body))
+
+	switch {
+	case target == "InitiateAuth":
+		s.initiateSRP(writer, body.ClientId, body.AuthFlow, body.AuthParameters)
+	case target == "RespondToAuthChallenge" && body.ChallengeName == string(types.ChallengeNameTypePasswordVerifier):
+		s.verifyPasswordClaim(writer, body.ClientId, body.Session, body.ChallengeResponses)
+	case target == "RespondToAuthChallenge" && body.ChallengeName == ChallengeSoftwareTokenMFA:
+		s.Equal("ada-uuid", body.ChallengeResponses["USERNAME"])
+		if body.ChallengeResponses["SOFTWARE_TOKEN_MFA_CODE"] != "123456" {
+			writeCognitoError(writer, "CodeMismatchException", "Invalid code received for user")
+			return
+		}
+		s.writeTokens(writer, body.ClientId)
+	default:
+		s.Failf("unexpected Cognito call", "target %q, challenge %q", target, body.ChallengeName)
+	}
+}
+
+func (s *SRPTestSuite) initiateSRP(writer http.ResponseWriter, clientID, flow string, params map[string]string) {
+	s.Equal(string(types.AuthFlowTypeUserSrpAuth), flow)
+	s.NotContains(params, "PASSWORD")
+
+	pool := map[string]string{
+		srpCognitoConfig.UserPool.AppClientID:  "UserPool",
+		srpCognitoConfig.TokenPool.AppClientID: "TokenPool",
+	}[clientID]
+	user, ok := s.users[params["USERNAME"]]
+	A, okA := new(big.Int).SetString(params["SRP_A"], 16)
+	if pool == "" || !ok || !okA || new(big.Int).Mod(A, srpN).Sign() == 0 {
+		writeCognitoError(writer, "NotAuthorizedException", "Incorrect username or password.")
+		return
+	}
+
+	// The verifier v = g^x is what Cognito stores instead of the password.
+	salt := srpRandom(s.T(), 16)
+	v := new(big.Int).Exp(srpG, srpTestX(salt, pool, user.id, user.password), srpN)
+	b := srpRandom(s.T(), 128)
+	B := new(big.Int).Exp(srpG, b, srpN)
+	B.Add(B, new(big.Int).Mul(srpK, v)).Mod(B, srpN)
+	secret := srpRandom(s.T(), 64).Bytes()
+
+	session := fmt.Sprintf("srp-session-%d", len(s.exchanges))
+	s.exchanges[session] = &srpExchange{user: user, pool: pool, salt: salt, v: v, b: b, A: A, B: B, secret: secret}
+	_ = json.NewEncoder(writer).Encode(map[string]any{
+		"ChallengeName": types.ChallengeNameTypePasswordVerifier,
+		"ChallengeParameters": map[string]string{
+			"SALT":            salt.Text(16),
+			"SRP_B":           B.Text(16),
+			"SECRET_BLOCK":    base64.StdEncoding.EncodeToString(secret),
+			"USER_ID_FOR_SRP": user.id,
+			"USERNAME":        user.id,
+		},
+		"Session": session,
+	})
+}
+
+func (s *SRPTestSuite) verifyPasswordClaim(writer http.ResponseWriter, clientID, session string, responses map[string]string) {
+	ex := s.exchanges[session]
+	if !s.NotNil(ex, "unknown session %q", session) {
+		writeCognitoError(writer, "NotAuthorizedException", "Invalid session")
+		return
+	}
+	s.Equal(ex.user.id, responses["USERNAME"])
+	s.Equal(base64.StdEncoding.EncodeToString(ex.secret), responses["PASSWORD_CLAIM_SECRET_BLOCK"])
+	timestamp, err := time.Parse(srpTimestampLayout, responses["TIMESTAMP"])
+	if s.NoError(err) {
+		s.WithinDuration(time.Now(), timestamp, time.Minute)
+	}
+
+	// S = (A * v^u) ^ b mod N, which equals the client's (B - k*g^x) ^ (a + u*x).
+	u := srpTestHash(ex.A, ex.B)
+	S := new(big.Int).Exp(ex.v, u, srpN)
+	S.Mul(S, ex.A).Mod(S, srpN).Exp(S, ex.b, srpN)
+
+	prk := hmac.New(sha256.New, srpTestBytes(u))
+	prk.Write(srpTestBytes(S))
+	okm := hmac.New(sha256.New, prk.Sum(nil))
+	okm.Write([]byte("Caldera Derived Key"))
+	okm.Write([]byte{1})
+	mac := hmac.New(sha256.New, okm.Sum(nil)[:16])
+	mac.Write([]byte(ex.pool + ex.user.id))
+	mac.Write(ex.secret)
+	mac.Write([]byte(responses["TIMESTAMP"]))
+
+	signature, err := base64.StdEncoding.DecodeString(responses["PASSWORD_CLAIM_SIGNATURE"])
+	if err != nil || !hmac.Equal(signature, mac.Sum(nil)) {
+		writeCognitoError(writer, "NotAuthorizedException", "Incorrect username or password.")
+		return
+	}
+	if ex.user.mfa {
+		_ = json.NewEncoder(writer).Encode(map[string]any{
+			"ChallengeName":       ChallengeSoftwareTokenMFA,
+			"ChallengeParameters": map[string]string{},
+			"Session":             "mfa-session",
+		})
+		return
+	}
+	s.writeTokens(writer, clientID)
+}
+
+// writeTokens answers with tokens issued to the app client clientID.
+func (s *SRPTestSuite) writeTokens(writer http.ResponseWriter, clientID string) {
+	idToken := NewTestIdToken(s.T(), s.MockCognito.Server.URL, srpCognitoConfig, clientID, map[string]any{
+		OrgNodeIdClaimKey: "N:organization:srp",
+		OrgIdClaimKey:     "42",
+	}, time.Hour)
+	_, _ = fmt.Fprintf(writer, `{"AuthenticationResult": {"AccessToken": "srp-access", "ExpiresIn": 3600, "IdToken": %q, "RefreshToken": "srp-refresh", "TokenType": "Bearer"}, "ChallengeParameters": {}}`,
+		idToken)
+}
+
+func writeCognitoError(writer http.ResponseWriter, errorType, message string) {
+	writer.Header().Set("X-Amzn-Errortype", errorType)
+	writer.WriteHeader(http.StatusBadRequest)
+	_, _ = fmt.Fprintf(writer, `{"__type": %q, "message": %q}`, errorType, message)
+}
+
+// srpTestX returns x = H(salt | H(pool | userID | ":" | password)).
+func srpTestX(salt *big.Int, pool, userID, password string) *big.Int {
+	inner := sha256.Sum256([]byte(pool + userID + ":" + password))
+	h := sha256.New()
+	h.Write(srpTestBytes(salt))
+	h.Write(inner[:])
+	return new(big.Int).SetBytes(h.Sum(nil))
+}
+
+// srpTestHash returns H(A | B) over the padded big-endian encodings.
+func srpTestHash(A, B *big.Int) *big.Int {
+	h := sha256.New()
+	h.Write(srpTestBytes(A))
+	h.Write(srpTestBytes(B))
+	return new(big.Int).SetBytes(h.Sum(nil))
+}
+
+// srpTestBytes encodes n big-endian with a leading zero byte if its high bit
+// is set.
+func srpTestBytes(n *big.Int) []byte {
+	b := n.Bytes()
+	if len(b) == 0 || b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return b
+}
+
+func srpRandom(t *testing.T, size int) *big.Int {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	return new(big.Int).SetBytes(buf)
+}
+
+func (s *SRPTestSuite) TestAuthenticate() {
+	client := s.newClient()
+
+	session, err := client.Authentication.Authenticate("api-key", "api-secret")
+	s.Require().NoError(err)
+	s.Equal("srp-access", session.Token)
+	s.Equal("srp-refresh", session.RefreshToken)
+	orgId, orgNodeId := client.GetOrganization()
+	s.Equal(42, orgId)
+	s.Equal("N:organization:srp", orgNodeId)
+
+	s.Equal([]string{"InitiateAuth", "RespondToAuthChallenge"}, s.targets)
+	for _, body := range s.bodies {
+		s.NotContains(body, "api-secret", "the secret should never be sent")
+	}
+}
+
+func (s *SRPTestSuite) TestReAuthenticate() {
+	client := s.newClient()
+
+	session, err := client.Authentication.ReAuthenticate()
+	s.Require().NoError(err)
+	s.Equal("srp-access", session.Token)
+	s.True(session.IsRefreshed)
+}
+
+func (s *SRPTestSuite) TestWrongSecret() {
+	client := s.newClient()
+
+	_, err := client.Authentication.Authenticate("api-key", "not-the-secret")
+	s.ErrorIs(err, ErrCognitoFailed)
+	var notAuthorized *types.NotAuthorizedException
+	s.ErrorAs(err, &notAuthorized)
+	var cognitoErr *CognitoError
+	if s.ErrorAs(err, &cognitoErr) {
+		s.Equal("RespondToAuthChallenge", cognitoErr.Operation)
+	}
+	s.Empty(client.GetSession().Token)
+}
+
+func (s *SRPTestSuite) TestLoginWithPasswordThenMFA() {
+	client := s.newClient()
+
+	var prompted []string
+	session, err := client.Authentication.LoginWithPassword(context.Background(), "ada@example.com", "correct horse",
+		func(challenge AuthChallenge) (string, error) {
+			prompted = append(prompted, challenge.Name)
+			return "123456", nil
+		})
+	s.Require().NoError(err)
+	s.Equal("srp-access", session.Token)
+	s.Equal([]string{ChallengeSoftwareTokenMFA}, prompted)
+	s.Equal([]string{"InitiateAuth", "RespondToAuthChallenge", "RespondToAuthChallenge"}, s.targets)
+	for _, body := range s.bodies {
+		s.NotContains(body, "correct horse", "the password should never be sent")
+	}
+}
+
+func (s *SRPTestSuite) TestMissingPoolID() {
+	cfg := srpCognitoConfig
+	cfg.TokenPool.ID = ""
+	client := s.newClient(WithCognitoConfig(cfg))
+
+	_, err := client.Authentication.Authenticate("api-key", "api-secret")
+	s.ErrorIs(err, ErrCognitoFailed)
+	s.ErrorContains(err, "invalid user pool id")
+}
+
+func TestSRPSuite(t *testing.T) {
+	suite.Run(t, new(SRPTestSuite))
+}
+
+func TestSRPGroup(t *testing.T) {
+	assert.Equal(t, 3072, srpN.BitLen())
+	assert.True(t, srpN.ProbablyPrime(20))
+	q := new(big.Int).Rsh(srpN, 1)
+	assert.True(t, q.ProbablyPrime(20), "N should be a safe prime")
+}
+
+func TestSRPPadHex(t *testing.T) {
+	for _, tc := range []struct {
+		n    int64
+		want string
+	}{
+		{0x1, "01"},
+		{0x7f, "7f"},
+		{0x80, "0080"},
+		{0xabc, "0abc"},
+		{0x1234, "1234"},
+	} {
+		got := srpPadHex(big.NewInt(tc.n))
+		assert.Equal(t, tc.want, got)
+		assert.Equal(t, hex.EncodeToString(srpTestBytes(big.NewInt(tc.n))), got)
+	}
+}
@@ -3,6 +3,8 @@ package pennsieve
 import (
 	"context"
 	"errors"
+	"iter"
+	"net/http"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -27,6 +29,9 @@ func (f *fakeManifest) Create(ctx context.Context, _ manifest.DTO) (*manifest.Po
 func (f *fakeManifest) GetFilesForStatus(_ context.Context, _ string, _ manifestFile.Status, _ string, _ bool) (*manifest.GetStatusEndpointResponse, error) {
 	return nil, nil
 }
+func (f *fakeManifest) AllFilesForStatus(_ context.Context, _ string, _ manifestFile.Status) iter.Seq2[string, error] {
+	return nil
+}
 func (f *fakeManifest) SetBaseUrl(_ string) {}
 func (f *fakeManifest) FinalizeManifestFiles(_ context.Context, _, _ string, _ []FinalizeFile, _ ...FinalizeOption) (*FinalizeResponse, error) {
 	return nil, nil
@@ -114,6 +119,9 @@ func (b *blockingManifest) Create(ctx context.Context, d manifest.DTO) (*manifes
 func (b *blockingManifest) GetFilesForStatus(ctx context.Context, id string, s manifestFile.Status, ct string, v bool) (*manifest.GetStatusEndpointResponse, error) {
 	return b.inner.GetFilesForStatus(ctx, id, s, ct, v)
 }
+func (b *blockingManifest) AllFilesForStatus(ctx context.Context, id string, s manifestFile.Status) iter.Seq2[string, error] {
+	return b.inner.AllFilesForStatus(ctx, id, s)
+}
 func (b *blockingManifest) SetBaseUrl(u string) { b.inner.SetBaseUrl(u) }
 func (b *blockingManifest) FinalizeManifestFiles(ctx context.Context, d, m string, f []FinalizeFile, opts ...FinalizeOption) (*FinalizeResponse, error) {
 	return b.inner.FinalizeManifestFiles(ctx, d, m, f, opts...)
@@ -154,3 +162,27 @@ func TestStorageCredsProvider_DoesNotRetry4xx(t *testing.T) {
 	assert.Equal(t, 404, httpErr.StatusCode)
 	assert.Equal(t, int32(1), fm.calls.Load(), "permanent errors should not be retried")
 }
+
+// TestStorageCredsProvider_ClientDoesNotRetryEachFetch checks that the
+// provider's own retries are not multiplied by the client's RetryPolicy.
+func TestStorageCredsProvider_ClientDoesNotRetryEachFetch(t *testing.T) {
+	cognito := NewMockCognitoServerDefault(t)
+	defer cognito.Close()
+	api := NewMockPennsieveServerDefault(t)
+	defer api.Close()
+	AWSEndpoints = AWSCognitoEndpoints{IdentityProviderEndpoint: cognito.IdProviderServer.URL}
+	defer AWSEndpoints.Reset()
+
+	var calls atomic.Int32
+	api.Mux.HandleFunc("/upload/manifest/storage-credentials", func(writer http.ResponseWriter, request *http.Request) {
+		calls.Add(1)
+		writer.WriteHeader(http.StatusServiceUnavailable)
+	})
+	client := NewClient(APIParams{ApiHost: api.Server.URL, ApiHost2: api.Server.URL, ApiKey: "test-key", ApiSecret: "test-secret"})
+	client.RetryPolicy = RetryPolicy{MaxAttempts: 4, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	p := &StorageCredentialsProvider{Manifest: client.Manifest, DatasetID: "N:dataset:1", ManifestNodeID: "N:manifest:1"}
+
+	_, err := p.Retrieve(context.Background())
+	assert.ErrorIs(t, err, ErrServer)
+	assert.Equal(t, int32(credsFetchAttempts), calls.Load())
+}
@@ -0,0 +1,250 @@
+package pennsieve
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+)
+
+// instrumentationName identifies the tracer and meter of this package.
+const instrumentationName = "github.com/pennsieve/pennsieve-go/pkg/pennsieve"
+
+// Metric names recorded by the client.
+const (
+	metricRequestDuration = "pennsieve.client.request.duration"
+	metricRequestRetries  = "pennsieve.client.request.retries"
+)
+
+// Span and metric attribute keys specific to Pennsieve.
+const (
+	attrService     = attribute.Key("pennsieve.service")
+	attrRetryCount  = attribute.Key("pennsieve.retry_count")
+	attrAuthFlow    = attribute.Key("pennsieve.auth.flow")
+	attrCredsSource = attribute.Key("pennsieve.credentials.source")
+)
+
+// telemetry holds the OpenTelemetry instruments of a Client. A nil
+// *telemetry records nothing.
+type telemetry struct {
+	tracer          trace.Tracer
+	requestDuration metric.Float64Histogram
+	requestRetries  metric.Int64Histogram
+}
+
+// newTelemetry creates the client's tracer and instruments. Nil providers
+// are replaced by no-op ones.
+func newTelemetry(tp trace.TracerProvider, mp metric.MeterProvider) *telemetry {
+	if tp == nil {
+		tp = tracenoop.NewTracerProvider()
+	}
+	if mp == nil {
+		mp = metricnoop.NewMeterProvider()
+	}
+	meter := mp.Meter(instrumentationName)
+
+	t := &telemetry{tracer: tp.Tracer(instrumentationName)}
+	// Instrument creation only fails on invalid names or options, which are
+	// constants here; the returned instrument is usable either way.
+	t.requestDuration, _ = meter.Float64Histogram(metricRequestDuration,
+		metric.WithDescription("Duration of Pennsieve API requests, including retries."),
+		metric.WithUnit("s"))
+	t.requestRetries, _ = meter.Int64Histogram(metricRequestRetries,
+		metric.WithDescription("Number of retries per Pennsieve API request."),
+		metric.WithUnit("{retry}"))
+	return t
+}
+
+// SetTracerProvider enables tracing of the client's requests with tp: one
+// client span per API request, and child spans for token refresh and
+// Cognito calls. Passing nil disables tracing.
+func (c *Client) SetTracerProvider(tp trace.TracerProvider) {
+	c.telemetryMu.Lock()
+	defer c.telemetryMu.Unlock()
+	c.tracerProvider = tp
+	c.otel.Store(newTelemetry(c.tracerProvider, c.meterProvider))
+}
+
+// SetMeterProvider enables request metrics with mp: the histograms
+// pennsieve.client.request.duration (seconds) and
+// pennsieve.client.request.retries, with service, method and status
+// attributes. Passing nil disables metrics.
+func (c *Client) SetMeterProvider(mp metric.MeterProvider) {
+	c.telemetryMu.Lock()
+	defer c.telemetryMu.Unlock()
+	c.meterProvider = mp
+	c.otel.Store(newTelemetry(c.tracerProvider, c.meterProvider))
+}
+
+func (c *Client) telemetry() *telemetry {
+	return c.otel.Load()
+}
+
+// Service names of the pennsieve.service attribute and per-route circuits.
+const (
+	serviceAPI        = "api"
+	serviceAPI2       = "api2"
+	serviceDiscover   = "discover"
+	serviceTimeseries = "timeseries"
+	serviceOther      = "other" // hosts the client is not configured with
+)
+
+// serviceName returns the configured service req is sent to: Discover or
+// Timeseries for the endpoints of those services, otherwise API or API2 by
+// host. Requests to other hosts, such as the hosted UI, are "other".
+func (c *Client) serviceName(req *http.Request) string {
+	target := req.URL.String()
+	if d, ok := c.Discover.(*discoverService); ok && strings.HasPrefix(target, d.host()+"/discover/") {
+		return serviceDiscover
+	}
+	if t, ok := c.Timeseries.(*timeseriesService); ok && strings.HasPrefix(target, t.host()+"/timeseries/") {
+		return serviceTimeseries
+	}
+
+	params := c.GetAPIParams()
+	switch req.URL.Host {
+	case hostOf(params.ApiHost):
+		return serviceAPI
+	case hostOf(params.ApiHost2):
+		return serviceAPI2
+	}
+	return serviceOther
+}
+
+// hostOf returns the host of baseURL, or "" if it has none.
+func hostOf(baseURL string) string {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// startSpan starts an internal span. It is safe to call on a nil receiver.
+func (t *telemetry) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if t == nil {
+		return ctx, tracenoop.Span{}
+	}
+	return t.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// startClientSpan starts a span for a call to an external service.
+func (t *telemetry) startClientSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if t == nil {
+		return ctx, tracenoop.Span{}
+	}
+	return t.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// endSpan records err on span, if any, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// requestTelemetry records the span and metrics of one API request across
+// its attempts.
+type requestTelemetry struct {
+	t     *telemetry
+	ctx   context.Context
+	span  trace.Span
+	start time.Time
+	attrs []attribute.KeyValue
+
+	attempts int
+	status   int // status of the last response, 0 if none
+}
+
+type requestTelemetryKey struct{}
+
+// startRequest starts the span of an API request to service. The returned
+// context carries the span and is used by doWithRetry to report attempts.
+func (t *telemetry) startRequest(ctx context.Context, req *http.Request, service string) (context.Context, *requestTelemetry) {
+	if t == nil {
+		return ctx, nil
+	}
+	attrs := []attribute.KeyValue{
+		attrService.String(service),
+		semconv.HTTPRequestMethodKey.String(req.Method),
+	}
+	ctx, span := t.tracer.Start(ctx, req.Method+" "+service,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+		trace.WithAttributes(
+			semconv.ServerAddress(req.URL.Hostname()),
+			semconv.URLFull(redactURL(req.URL.String()))))
+
+	rt := &requestTelemetry{t: t, span: span, start: time.Now(), attrs: attrs}
+	ctx = context.WithValue(ctx, requestTelemetryKey{}, rt)
+	rt.ctx = ctx
+	return ctx, rt
+}
+
+// requestTelemetryFrom returns the requestTelemetry started for ctx, or nil.
+func requestTelemetryFrom(ctx context.Context) *requestTelemetry {
+	rt, _ := ctx.Value(requestTelemetryKey{}).(*requestTelemetry)
+	return rt
+}
+
+// attempt records the outcome of one attempt.
+func (r *requestTelemetry) attempt(res *http.Response) {
+	if r == nil {
+		return
+	}
+	r.attempts++
+	if res != nil {
+		r.status = res.StatusCode
+	}
+}
+
+// end finishes the request span and records the duration and retry count.
+func (r *requestTelemetry) end(err error) {
+	if r == nil {
+		return
+	}
+	attrs := r.attrs
+	retries := max(r.attempts-1, 0)
+	r.span.SetAttributes(attrRetryCount.Int(retries))
+
+	if r.status != 0 {
+		status := semconv.HTTPResponseStatusCode(r.status)
+		attrs = append(attrs, status)
+		r.span.SetAttributes(status)
+	} else if err != nil {
+		attrs = append(attrs, semconv.ErrorTypeKey.String(errorType(err)))
+	}
+	if err != nil {
+		r.span.RecordError(err)
+		r.span.SetStatus(codes.Error, err.Error())
+	}
+	r.span.End()
+
+	set := metric.WithAttributeSet(attribute.NewSet(attrs...))
+	r.t.requestDuration.Record(r.ctx, time.Since(r.start).Seconds(), set)
+	r.t.requestRetries.Record(r.ctx, int64(retries), set)
+}
+
+// errorType returns a low-cardinality description of a transport error for
+// the error.type attribute.
+func errorType(err error) string {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	}
+	return "_OTHER"
+}
@@ -0,0 +1,208 @@
+package pennsieve
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type TelemetryTestSuite struct {
+	suite.Suite
+	MockCognitoServer
+	MockPennsieveServer
+	TestClient *Client
+	Spans      *tracetest.SpanRecorder
+	Metrics    *sdkmetric.ManualReader
+}
+
+func (s *TelemetryTestSuite) SetupTest() {
+	s.MockCognitoServer = NewMockCognitoServerDefault(s.T())
+	s.MockPennsieveServer = NewMockPennsieveServerDefault(s.T())
+	AWSEndpoints = AWSCognitoEndpoints{IdentityProviderEndpoint: s.IdProviderServer.URL}
+
+	s.Spans = tracetest.NewSpanRecorder()
+	s.Metrics = sdkmetric.NewManualReader()
+	s.TestClient = NewClient(APIParams{ApiHost: s.Server.URL, ApiKey: "test-key", ApiSecret: "test-secret"})
+	s.TestClient.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(s.Spans)))
+	s.TestClient.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(s.Metrics)))
+	s.TestClient.RetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+}
+
+func (s *TelemetryTestSuite) TearDownTest() {
+	s.MockCognitoServer.Close()
+	s.MockPennsieveServer.Close()
+	AWSEndpoints.Reset()
+}
+
+// span returns the ended span with the given name.
+func (s *TelemetryTestSuite) span(name string) sdktrace.ReadOnlySpan {
+	for _, span := range s.Spans.Ended() {
+		if span.Name() == name {
+			return span
+		}
+	}
+	s.Failf("span not found", "no span named %q", name)
+	return nil
+}
+
+// requestSpan returns the ended span of the request for path.
+func (s *TelemetryTestSuite) requestSpan(path string) sdktrace.ReadOnlySpan {
+	for _, span := range s.Spans.Ended() {
+		if strings.HasSuffix(spanAttrs(span)["url.full"].AsString(), path) {
+			return span
+		}
+	}
+	s.Failf("span not found", "no span for a request to %q", path)
+	return nil
+}
+
+func spanAttrs(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func (s *TelemetryTestSuite) TestRequestAndRefreshSpans() {
+	s.Mux.HandleFunc("/datasets/N:dataset:1", func(writer http.ResponseWriter, request *http.Request) {
+		_, _ = writer.Write([]byte(`{}`))
+	})
+
+	_, err := s.TestClient.Dataset.Get(context.Background(), "N:dataset:1")
+	s.Require().NoError(err)
+
+	request := s.requestSpan("/datasets/N:dataset:1")
+	s.Equal("GET api", request.Name())
+	s.Equal(trace.SpanKindClient, request.SpanKind())
+	attrs := spanAttrs(request)
+	s.Equal("api", attrs["pennsieve.service"].AsString())
+	s.Equal("GET", attrs["http.request.method"].AsString())
+	s.Equal(int64(http.StatusOK), attrs["http.response.status_code"].AsInt64())
+	s.Equal(int64(0), attrs["pennsieve.retry_count"].AsInt64())
+	s.Equal(codes.Unset, request.Status().Code)
+
+	refresh := s.span("pennsieve.token_refresh")
+	s.Equal(request.SpanContext().SpanID(), refresh.Parent().SpanID(), "token refresh should be a child of the request")
+	s.Equal(CredentialsSourceParams, spanAttrs(refresh)["pennsieve.credentials.source"].AsString())
+
+	cognito := s.span("CognitoIdentityProvider.InitiateAuth")
+	s.Equal(refresh.SpanContext().SpanID(), cognito.Parent().SpanID(), "Cognito call should be a child of the refresh")
+	s.Equal("USER_PASSWORD_AUTH", spanAttrs(cognito)["pennsieve.auth.flow"].AsString())
+
+	config := s.requestSpan("/authentication/cognito-config")
+	s.Equal(refresh.SpanContext().SpanID(), config.Parent().SpanID(), "cognito-config fetch should be a child of the refresh")
+}
+
+func (s *TelemetryTestSuite) TestRetriesAndErrorsAreRecorded() {
+	var calls atomic.Int32
+	s.Mux.HandleFunc("/packages/N:package:1/sources-paged", func(writer http.ResponseWriter, request *http.Request) {
+		if calls.Add(1) == 1 {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		writer.WriteHeader(http.StatusNotFound)
+	})
+
+	_, err := s.TestClient.Package.GetPackageSources(context.Background(), "N:package:1")
+	s.ErrorIs(err, ErrNotFound)
+
+	request := s.requestSpan("/packages/N:package:1/sources-paged")
+	s.Equal("GET api", request.Name())
+	attrs := spanAttrs(request)
+	s.Equal(int64(http.StatusNotFound), attrs["http.response.status_code"].AsInt64())
+	s.Equal(int64(1), attrs["pennsieve.retry_count"].AsInt64())
+	s.Equal(codes.Error, request.Status().Code)
+
+	var rm metricdata.ResourceMetrics
+	s.Require().NoError(s.Metrics.Collect(context.Background(), &rm))
+
+	retries := s.histogramFor(rm, metricRequestRetries, "api", http.StatusNotFound)
+	if s.NotNil(retries) {
+		s.Equal(uint64(1), retries.Count)
+		s.Equal(int64(1), retries.Sum)
+	}
+
+	durations := findMetric(rm, metricRequestDuration)
+	if s.NotNil(durations) {
+		hist := durations.Data.(metricdata.Histogram[float64])
+		var statuses []int64
+		for _, dp := range hist.DataPoints {
+			v, _ := dp.Attributes.Value("pennsieve.service")
+			status, _ := dp.Attributes.Value("http.response.status_code")
+			if v.AsString() == "api" {
+				statuses = append(statuses, status.AsInt64())
+				s.Greater(dp.Sum, 0.0)
+			}
+		}
+		s.Contains(statuses, int64(http.StatusNotFound))
+		s.Contains(statuses, int64(http.StatusOK), "the cognito-config request should be measured too")
+	}
+}
+
+func (s *TelemetryTestSuite) histogramFor(rm metricdata.ResourceMetrics, name, service string, status int) *metricdata.HistogramDataPoint[int64] {
+	m := findMetric(rm, name)
+	if m == nil {
+		return nil
+	}
+	for _, dp := range m.Data.(metricdata.Histogram[int64]).DataPoints {
+		v, _ := dp.Attributes.Value("pennsieve.service")
+		code, _ := dp.Attributes.Value("http.response.status_code")
+		if v.AsString() == service && code.AsInt64() == int64(status) {
+			return &dp
+		}
+	}
+	return nil
+}
+
+func findMetric(rm metricdata.ResourceMetrics, name string) *metricdata.Metrics {
+	for _, sm := range rm.ScopeMetrics {
+		for i, m := range sm.Metrics {
+			if m.Name == name {
+				return &sm.Metrics[i]
+			}
+		}
+	}
+	return nil
+}
+
+func TestTelemetrySuite(t *testing.T) {
+	suite.Run(t, new(TelemetryTestSuite))
+}
+
+func TestTelemetryDisabledByDefault(t *testing.T) {
+	client := NewClient(APIParams{})
+	_, span := client.telemetry().startSpan(context.Background(), "noop")
+	assert.False(t, span.SpanContext().IsValid(), "no spans should be recorded without a tracer provider")
+	span.End()
+}
+
+func TestServiceName(t *testing.T) {
+	client := NewClient(APIParams{ApiHost: "https://api.pennsieve.io", ApiHost2: "https://api2.pennsieve.io"})
+	tests := map[string]string{
+		"https://api.pennsieve.io/datasets/N:dataset:1":                     serviceAPI,
+		"https://api.pennsieve.io/discover/datasets/1/versions/2":           serviceDiscover,
+		"https://api2.pennsieve.io/manifest/status":                         serviceAPI2,
+		"https://api2.pennsieve.io/timeseries/package/N:package:1/channels": serviceTimeseries,
+		"https://api.pennsieve.io/timeseries/package/N:package:1/channels":  serviceAPI,
+		"https://login.pennsieve.io/oauth2/token":                           serviceOther,
+	}
+	for target, want := range tests {
+		req, err := http.NewRequest(http.MethodGet, target, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, want, client.serviceName(req), target)
+	}
+}
@@ -7,17 +7,20 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"sync"
 )
 
 type TimeseriesService interface {
 	GetChannels(ctx context.Context, datasetId string, packageId string) ([]timeseries.GetChannelsResponse, error)
 	GetRangeBlocks(ctx context.Context, datasetId string, packageId string,
 		startTime uint64, endTime uint64, channelId string) (*timeseries.GetRangeResponse, error)
+	SetBaseUrl(url string)
 }
 
 type timeseriesService struct {
 	client  PennsieveHTTPClient
 	BaseUrl string
+	mu      sync.RWMutex // guards BaseUrl
 }
 
 func NewTimeseriesService(client PennsieveHTTPClient, baseUrl string) *timeseriesService {
@@ -32,7 +35,7 @@ func (s *timeseriesService) GetChannels(ctx context.Context, datasetId string, p
 	params := url.Values{}
 	params.Add("dataset_id", datasetId)
 
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/timeseries/package/%s/channels?%s", s.BaseUrl, packageId, params.Encode()), nil)
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/timeseries/package/%s/channels?%s", s.host(), packageId, params.Encode()), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -61,7 +64,7 @@ func (s *timeseriesService) GetRangeBlocks(ctx context.Context, datasetId string
 		params.Add("channel_id", channelId)
 	}
 
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/timeseries/package/%s/range?%s", s.BaseUrl, packageId, params.Encode()), nil)
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/timeseries/package/%s/range?%s", s.host(), packageId, params.Encode()), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -78,3 +81,15 @@ func (s *timeseriesService) GetRangeBlocks(ctx context.Context, datasetId string
 	return &res, nil
 
 }
+
+func (s *timeseriesService) SetBaseUrl(url string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.BaseUrl = url
+}
+
+func (s *timeseriesService) host() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.BaseUrl
+}
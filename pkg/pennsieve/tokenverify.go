@@ -0,0 +1,399 @@
+package pennsieve
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	"github.com/golang-jwt/jwt"
+)
+
+// jwksRefetchInterval is how long a fetched key set is used before a token
+// signed with an unknown key causes it to be fetched again, e.g. after
+// Cognito rotates its keys.
+const jwksRefetchInterval = time.Minute
+
+// jwksPath is the path of a user pool's key set below its issuer URL.
+const jwksPath = "/.well-known/jwks.json"
+
+// JWKS is a JSON Web Key Set: the public keys that sign the tokens of a
+// Cognito user pool, by key ID.
+type JWKS struct {
+	keys map[string]*rsa.PublicKey
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// NewJWKS returns a key set of RSA public keys by key ID. Tests that sign ID
+// tokens with a locally generated key can pass it to WithJWKS.
+func NewJWKS(keys map[string]*rsa.PublicKey) *JWKS {
+	set := &JWKS{keys: map[string]*rsa.PublicKey{}}
+	for kid, key := range keys {
+		set.keys[kid] = key
+	}
+	return set
+}
+
+// ParseJWKS parses a key set in the format of Cognito's
+// /.well-known/jwks.json. Keys other than RSA signing keys are ignored.
+func ParseJWKS(data []byte) (*JWKS, error) {
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("error parsing JWKS: %w", err)
+	}
+	set := &JWKS{keys: map[string]*rsa.PublicKey{}}
+	for _, k := range doc.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("error parsing JWKS: invalid RSA key %q", k.Kid)
+		}
+		set.keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return set, nil
+}
+
+// MarshalJSON encodes set in the JWKS format, e.g. for a test server.
+func (set *JWKS) MarshalJSON() ([]byte, error) {
+	keys := []jwk{}
+	for kid, key := range set.keys {
+		keys = append(keys, jwk{
+			Kid: kid,
+			Kty: "RSA",
+			Alg: "RS256",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+	return json.Marshal(map[string][]jwk{"keys": keys})
+}
+
+// fetchedJWKS is a key set fetched from a user pool.
+type fetchedJWKS struct {
+	set       *JWKS
+	fetchedAt time.Time
+}
+
+// cognitoIssuer identifies a user pool: the expected iss claim of its tokens
+// and the base URL of its key set.
+type cognitoIssuer struct {
+	poolID  string
+	issuers []string // accepted iss claims
+	jwksURL string
+}
+
+// verifyIdToken returns the claims of a Cognito ID token after checking its
+// signature against the key set of the pool that issued it, its expiration,
+// and that its iss, aud and token_use claims are those of an ID token of
+// the UserPool or TokenPool. Verified tokens are remembered, so that
+// verifying the token of the current session again is cheap.
+func (s *authenticationService) verifyIdToken(ctx context.Context, idToken string) (jwt.MapClaims, error) {
+	if s.skipTokenVerification {
+		return parseIdToken(idToken)
+	}
+	return s.verifyToken(ctx, idToken, tokenUseID)
+}
+
+// verifyAccessToken checks a Cognito access token like verifyIdToken checks
+// ID tokens. Access tokens have no aud claim: their client_id claim must be
+// an app client of the pool that issued them.
+func (s *authenticationService) verifyAccessToken(ctx context.Context, accessToken string) error {
+	if s.skipTokenVerification {
+		return nil
+	}
+	_, err := s.verifyToken(ctx, accessToken, tokenUseAccess)
+	return err
+}
+
+// tokenUse is the token_use claim of a kind of Cognito token.
+type tokenUse string
+
+const (
+	tokenUseID     tokenUse = "id"
+	tokenUseAccess tokenUse = "access"
+)
+
+func (u tokenUse) String() string {
+	if u == tokenUseAccess {
+		return "access token"
+	}
+	return "ID token"
+}
+
+// clientClaim is the claim naming the app client the token was issued to.
+func (u tokenUse) clientClaim() string {
+	if u == tokenUseAccess {
+		return "client_id"
+	}
+	return "aud"
+}
+
+// verifyToken verifies a token of kind use; see verifyIdToken.
+func (s *authenticationService) verifyToken(ctx context.Context, token string, use tokenUse) (jwt.MapClaims, error) {
+	if claims, ok := s.lastVerified(token, use); ok {
+		// The signature and claims were checked, but the token may have
+		// expired since.
+		if !claims.VerifyExpiresAt(time.Now().Unix(), false) {
+			return nil, &TokenError{Claim: "exp", Reason: use.String() + " has expired"}
+		}
+		return claims, nil
+	}
+
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(token, claims); err != nil {
+		return nil, &TokenError{Reason: "malformed " + use.String(), Err: err}
+	}
+	clientID, err := stringClaim(claims, use.clientClaim())
+	if err != nil {
+		return nil, err
+	}
+	issuer, err := s.issuerFor(ctx, use.clientClaim(), clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	claims = jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodRS256 {
+			return nil, &TokenError{Reason: fmt.Sprintf("unexpected signing algorithm %v", token.Header["alg"])}
+		}
+		kid, _ := token.Header["kid"].(string)
+		return s.signingKey(ctx, issuer, kid)
+	})
+	var validationErr *jwt.ValidationError
+	if errors.As(err, &validationErr) {
+		switch {
+		case validationErr.Errors&jwt.ValidationErrorUnverifiable != 0 && validationErr.Inner != nil:
+			// The key lookup failed with a *TokenError or *jwksFetchError.
+			return nil, validationErr.Inner
+		case validationErr.Errors&jwt.ValidationErrorSignatureInvalid != 0:
+			return nil, &TokenError{Reason: use.String() + " signature verification failed", Err: validationErr.Inner}
+		case validationErr.Errors&jwt.ValidationErrorExpired != 0:
+			return nil, &TokenError{Claim: "exp", Reason: use.String() + " has expired"}
+		}
+	}
+	if err != nil {
+		return nil, &TokenError{Reason: use.String() + " verification failed", Err: err}
+	}
+
+	if iss, _ := claims["iss"].(string); !containsString(issuer.issuers, iss) {
+		return nil, &TokenError{Claim: "iss", Reason: fmt.Sprintf("%q is not user pool %s", iss, issuer.poolID)}
+	}
+	if claims["token_use"] != string(use) {
+		return nil, &TokenError{Claim: "token_use", Reason: fmt.Sprintf("%q is not an %s", claims["token_use"], use)}
+	}
+
+	s.keysMu.Lock()
+	s.verifiedToken, s.verifiedClaims = token, claims
+	s.keysMu.Unlock()
+	return claims, nil
+}
+
+// resetKeys drops the fetched key sets and the last verified token, e.g.
+// when the client switches to other Cognito pools.
+func (s *authenticationService) resetKeys() {
+	s.keysMu.Lock()
+	defer s.keysMu.Unlock()
+	s.keySets = nil
+	s.verifiedToken, s.verifiedClaims = "", nil
+}
+
+// lastVerified returns the claims of token if it is the token verified last
+// and of kind use.
+func (s *authenticationService) lastVerified(token string, use tokenUse) (jwt.MapClaims, bool) {
+	s.keysMu.Lock()
+	defer s.keysMu.Unlock()
+	if token == s.verifiedToken && s.verifiedClaims["token_use"] == string(use) {
+		return s.verifiedClaims, true
+	}
+	return nil, false
+}
+
+// issuerFor returns the pool whose app client is clientID, read from the
+// token claim clientClaim.
+func (s *authenticationService) issuerFor(ctx context.Context, clientClaim, clientID string) (cognitoIssuer, error) {
+	cfg, err := s.loadCognitoConfig(ctx)
+	if err != nil {
+		return cognitoIssuer{}, err
+	}
+	var poolID, region string
+	switch clientID {
+	case "":
+	case cfg.UserPool.AppClientID:
+		poolID, region = cfg.UserPool.ID, cfg.UserPool.Region
+	case cfg.TokenPool.AppClientID:
+		poolID, region = cfg.TokenPool.ID, cfg.TokenPool.Region
+	}
+	if poolID == "" {
+		return cognitoIssuer{}, &TokenError{Claim: clientClaim, Reason: fmt.Sprintf("%q is not an app client of a known user pool", clientID)}
+	}
+	if region == "" {
+		region = cfg.Region
+	}
+	if r, _, ok := strings.Cut(poolID, "_"); region == "" && ok {
+		region = r
+	}
+
+	awsIssuer := fmt.Sprintf("https://cognito-idp.%s.amazonaws.com/%s", region, poolID)
+	issuer := cognitoIssuer{poolID: poolID, issuers: []string{awsIssuer}, jwksURL: awsIssuer + jwksPath}
+	// A custom endpoint, such as cognito-local, issues tokens and serves
+	// keys itself.
+	if endpoint := s.identityProviderEndpoint(region); endpoint != "" {
+		custom := strings.TrimSuffix(endpoint, "/") + "/" + poolID
+		issuer.issuers = append(issuer.issuers, custom)
+		issuer.jwksURL = custom + jwksPath
+	}
+	return issuer, nil
+}
+
+// identityProviderEndpoint returns the custom Cognito identity provider
+// endpoint of the AWS config, if any.
+func (s *authenticationService) identityProviderEndpoint(region string) string {
+	resolver := s.cognitoAWSConfig().EndpointResolverWithOptions
+	if resolver == nil {
+		return ""
+	}
+	endpoint, err := resolver.ResolveEndpoint(cognitoidentityprovider.ServiceID, region)
+	if err != nil {
+		return ""
+	}
+	return endpoint.URL
+}
+
+// signingKey returns the key kid of the pool's key set, fetching the set on
+// first use and again if kid is not in it. Only one fetch runs at a time;
+// callers that need the set meanwhile wait for it, while lookups in sets
+// already fetched do not.
+func (s *authenticationService) signingKey(ctx context.Context, issuer cognitoIssuer, kid string) (*rsa.PublicKey, error) {
+	if s.jwks != nil {
+		if key := s.jwks.keys[kid]; key != nil {
+			return key, nil
+		}
+		return nil, &TokenError{Reason: fmt.Sprintf("unknown signing key %q", kid)}
+	}
+
+	if key, ok, err := s.fetchedSigningKey(issuer, kid); ok {
+		return key, err
+	}
+	s.fetchKeysMu.Lock()
+	defer s.fetchKeysMu.Unlock()
+	// The set may have been fetched while we waited.
+	if key, ok, err := s.fetchedSigningKey(issuer, kid); ok {
+		return key, err
+	}
+
+	set, err := s.fetchJWKS(ctx, issuer.jwksURL)
+	if err != nil {
+		return nil, &jwksFetchError{err}
+	}
+	s.keysMu.Lock()
+	if s.keySets == nil {
+		s.keySets = map[string]*fetchedJWKS{}
+	}
+	s.keySets[issuer.jwksURL] = &fetchedJWKS{set: set, fetchedAt: time.Now()}
+	s.keysMu.Unlock()
+	if key := set.keys[kid]; key != nil {
+		return key, nil
+	}
+	return nil, &TokenError{Reason: fmt.Sprintf("unknown signing key %q", kid)}
+}
+
+// fetchedSigningKey looks kid up in the fetched key set of issuer. ok is
+// false if the set has to be fetched: it has not been, or it lacks kid and
+// may be stale.
+func (s *authenticationService) fetchedSigningKey(issuer cognitoIssuer, kid string) (key *rsa.PublicKey, ok bool, err error) {
+	s.keysMu.Lock()
+	defer s.keysMu.Unlock()
+	fetched := s.keySets[issuer.jwksURL]
+	if fetched == nil {
+		return nil, false, nil
+	}
+	if key := fetched.set.keys[kid]; key != nil {
+		return key, true, nil
+	}
+	if time.Since(fetched.fetchedAt) < jwksRefetchInterval {
+		return nil, true, &TokenError{Reason: fmt.Sprintf("unknown signing key %q", kid)}
+	}
+	return nil, false, nil
+}
+
+// fetchJWKS gets the key set at url.
+func (s *authenticationService) fetchJWKS(ctx context.Context, url string) (*JWKS, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	var raw json.RawMessage
+	if err := s.client.sendUnauthenticatedRequest(ctx, req, &raw); err != nil {
+		s.client.Logger().DebugContext(ctx, "error fetching JWKS", "url", url, "error", err)
+		return nil, err
+	}
+	return ParseJWKS(raw)
+}
+
+// jwksFetchError is an error getting a pool's key set. It is returned as is
+// rather than as an invalid token.
+type jwksFetchError struct {
+	err error
+}
+
+func (e *jwksFetchError) Error() string {
+	return "error fetching JWKS: " + e.err.Error()
+}
+
+func (e *jwksFetchError) Unwrap() error {
+	return e.err
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// verifySession checks session before it is used: its ID token, or, for
+// sessions without one, its access token. Sessions obtained by the client
+// were verified when they were issued; this catches sessions set with
+// SetSession or WithSession.
+func (c *Client) verifySession(ctx context.Context, session APISession) error {
+	if c.Authentication == nil {
+		return nil
+	}
+	var err error
+	if session.IdToken != "" {
+		_, err = c.Authentication.verifyIdToken(ctx, session.IdToken)
+	} else {
+		err = c.Authentication.verifyAccessToken(ctx, session.Token)
+	}
+	if err != nil {
+		return fmt.Errorf("rejecting session: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,380 @@
+package pennsieve
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/suite"
+)
+
+// TokenVerifyTestSuite verifies ID tokens against a mock Cognito that
+// serves testJWKS for the pools of expectedCognitoConfig.
+type TokenVerifyTestSuite struct {
+	suite.Suite
+	Cognito    MockServer
+	API        MockServer
+	fetches    atomic.Int32
+	failFetch  atomic.Bool
+	holdFetch  chan struct{} // if set, key set fetches wait for it to close
+	apiHits    atomic.Int32
+	otherKey   *rsa.PrivateKey
+	tokenAud   string
+	userAud    string
+	issuerBase string
+}
+
+func (s *TokenVerifyTestSuite) SetupSuite() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	s.Require().NoError(err)
+	s.otherKey = key
+	s.tokenAud = expectedCognitoConfig.TokenPool.AppClientID
+	s.userAud = expectedCognitoConfig.UserPool.AppClientID
+}
+
+func (s *TokenVerifyTestSuite) SetupTest() {
+	cognitoMux := http.NewServeMux()
+	s.Cognito = MockServer{Server: httptest.NewServer(cognitoMux), Mux: cognitoMux}
+	s.issuerBase = s.Cognito.Server.URL
+	s.fetches.Store(0)
+	s.failFetch.Store(false)
+	s.holdFetch = nil
+	cognitoMux.HandleFunc("GET /{pool}/.well-known/jwks.json", func(writer http.ResponseWriter, request *http.Request) {
+		s.fetches.Add(1)
+		if s.holdFetch != nil {
+			<-s.holdFetch
+		}
+		if s.failFetch.Load() {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		serveTestJWKS(writer, request)
+	})
+
+	apiMux := http.NewServeMux()
+	s.API = MockServer{Server: httptest.NewServer(apiMux), Mux: apiMux}
+	s.apiHits.Store(0)
+	apiMux.HandleFunc("/datasets/N:dataset:1", func(writer http.ResponseWriter, request *http.Request) {
+		s.apiHits.Add(1)
+		_, _ = writer.Write([]byte(`{"content": {"id": "N:dataset:1", "name": "Verified"}}`))
+	})
+}
+
+func (s *TokenVerifyTestSuite) TearDownTest() {
+	s.Cognito.Close()
+	s.API.Close()
+}
+
+func (s *TokenVerifyTestSuite) newClient(opts ...ClientOption) *Client {
+	opts = append([]ClientOption{
+		WithCredentials("key", "secret"),
+		WithBaseURLs(s.API.Server.URL, s.API.Server.URL),
+		WithAWSConfig(newTestAWSConfig(s.Cognito.Server.URL)),
+		WithCognitoConfig(expectedCognitoConfig),
+		WithRetryPolicy(NoRetryPolicy),
+	}, opts...)
+	client, err := NewClientWithOptions(opts...)
+	s.Require().NoError(err)
+	return client
+}
+
+// idToken returns a valid ID token of the app client aud with claims
+// overriding the defaults.
+func (s *TokenVerifyTestSuite) idToken(aud string, claims map[string]any) string {
+	all := map[string]any{
+		OrgNodeIdClaimKey: "N:organization:verified",
+		OrgIdClaimKey:     "5",
+	}
+	for k, v := range claims {
+		all[k] = v
+	}
+	return NewTestIdToken(s.T(), s.issuerBase, expectedCognitoConfig, aud, all, time.Hour)
+}
+
+// signWith signs claims of a valid TokenPool ID token with key.
+func (s *TokenVerifyTestSuite) signWith(method jwt.SigningMethod, key any, kid string) string {
+	token := jwt.NewWithClaims(method, jwt.MapClaims{
+		"iss":       s.issuerBase + "/" + expectedCognitoConfig.TokenPool.ID,
+		"aud":       s.tokenAud,
+		"exp":       time.Now().Add(time.Hour).Unix(),
+		"token_use": "id",
+	})
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	s.Require().NoError(err)
+	return signed
+}
+
+func (s *TokenVerifyTestSuite) TestValidTokens() {
+	client := s.newClient()
+
+	for _, aud := range []string{s.tokenAud, s.userAud} {
+		claims, err := client.Authentication.verifyIdToken(context.Background(), s.idToken(aud, nil))
+		s.Require().NoError(err, aud)
+		s.Equal("N:organization:verified", claims[OrgNodeIdClaimKey])
+	}
+	s.Equal(int32(2), s.fetches.Load(), "each pool's key set should be fetched once")
+
+	_, err := client.Authentication.verifyIdToken(context.Background(), s.idToken(s.tokenAud, map[string]any{OrgIdClaimKey: "6"}))
+	s.NoError(err)
+	s.Equal(int32(2), s.fetches.Load(), "key sets should be cached")
+}
+
+func (s *TokenVerifyTestSuite) TestRejectedTokens() {
+	valid := s.idToken(s.tokenAud, nil)
+	parts := strings.Split(valid, ".")
+	payload, err := json.Marshal(map[string]any{
+		"iss":             s.issuerBase + "/" + expectedCognitoConfig.TokenPool.ID,
+		"aud":             s.tokenAud,
+		"exp":             time.Now().Add(time.Hour).Unix(),
+		"token_use":       "id",
+		OrgNodeIdClaimKey: "N:organization:someone-else",
+		OrgIdClaimKey:     "1",
+	})
+	s.Require().NoError(err)
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+
+	for name, test := range map[string]struct {
+		token string
+		claim string
+	}{
+		"tampered claims":  {token: tampered},
+		"other key":        {token: s.signWith(jwt.SigningMethodRS256, s.otherKey, testSigningKeyID)},
+		"unknown key":      {token: s.signWith(jwt.SigningMethodRS256, s.otherKey, "other-key")},
+		"HMAC":             {token: s.signWith(jwt.SigningMethodHS256, []byte("secret"), testSigningKeyID)},
+		"expired":          {token: NewTestIdToken(s.T(), s.issuerBase, expectedCognitoConfig, s.tokenAud, nil, -time.Minute), claim: "exp"},
+		"other issuer":     {token: s.idToken(s.tokenAud, map[string]any{"iss": "https://cognito-idp.us-east-1.amazonaws.com/us-east-1_Other"}), claim: "iss"},
+		"other pool":       {token: s.idToken(s.tokenAud, map[string]any{"iss": s.issuerBase + "/" + expectedCognitoConfig.UserPool.ID}), claim: "iss"},
+		"unknown audience": {token: s.idToken("someone-elses-client", nil), claim: "aud"},
+		"access token":     {token: s.idToken(s.tokenAud, map[string]any{"token_use": "access"}), claim: "token_use"},
+	} {
+		s.Run(name, func() {
+			client := s.newClient()
+			_, err := client.Authentication.verifyIdToken(context.Background(), test.token)
+			s.ErrorIs(err, ErrInvalidToken)
+			var tokenErr *TokenError
+			if s.ErrorAs(err, &tokenErr) {
+				s.Equal(test.claim, tokenErr.Claim)
+			}
+		})
+	}
+}
+
+func (s *TokenVerifyTestSuite) TestUnknownKeyRefetched() {
+	client := s.newClient()
+	unknown := s.signWith(jwt.SigningMethodRS256, s.otherKey, "rotated-key")
+
+	_, err := client.Authentication.verifyIdToken(context.Background(), unknown)
+	s.ErrorIs(err, ErrInvalidToken)
+	_, err = client.Authentication.verifyIdToken(context.Background(), unknown)
+	s.ErrorIs(err, ErrInvalidToken)
+	s.Equal(int32(1), s.fetches.Load(), "unknown keys should not refetch a fresh key set")
+
+	auth := client.Authentication.(*authenticationService)
+	for _, fetched := range auth.keySets {
+		fetched.fetchedAt = fetched.fetchedAt.Add(-jwksRefetchInterval)
+	}
+	_, err = client.Authentication.verifyIdToken(context.Background(), unknown)
+	s.ErrorIs(err, ErrInvalidToken)
+	s.Equal(int32(2), s.fetches.Load())
+}
+
+// TestVerifiedTokenExpires checks that the last verified token is not
+// accepted from the cache once it has expired.
+func (s *TokenVerifyTestSuite) TestVerifiedTokenExpires() {
+	client := s.newClient()
+	token := s.idToken(s.tokenAud, nil)
+	_, err := client.Authentication.verifyIdToken(context.Background(), token)
+	s.Require().NoError(err)
+
+	auth := client.Authentication.(*authenticationService)
+	auth.verifiedClaims["exp"] = float64(time.Now().Add(-time.Minute).Unix())
+	_, err = client.Authentication.verifyIdToken(context.Background(), token)
+	s.ErrorIs(err, ErrInvalidToken)
+	var tokenErr *TokenError
+	if s.ErrorAs(err, &tokenErr) {
+		s.Equal("exp", tokenErr.Claim)
+	}
+}
+
+// TestFetchDoesNotBlockVerification holds a key set fetch: tokens of a pool
+// whose key set was fetched are still verified meanwhile, and concurrent
+// verifications that need the held set share its fetch.
+func (s *TokenVerifyTestSuite) TestFetchDoesNotBlockVerification() {
+	client := s.newClient()
+	_, err := client.Authentication.verifyIdToken(context.Background(), s.idToken(s.userAud, nil))
+	s.Require().NoError(err)
+
+	s.holdFetch = make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.Authentication.verifyIdToken(context.Background(), s.idToken(s.tokenAud, map[string]any{OrgIdClaimKey: strconv.Itoa(i)}))
+			s.NoError(err)
+		}()
+	}
+	for s.fetches.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	verified := make(chan error, 1)
+	go func() {
+		_, err := client.Authentication.verifyIdToken(context.Background(), s.idToken(s.userAud, map[string]any{OrgIdClaimKey: "7"}))
+		verified <- err
+	}()
+	select {
+	case err := <-verified:
+		s.NoError(err)
+	case <-time.After(5 * time.Second):
+		s.Fail("a fetched key set should be usable during another fetch")
+	}
+
+	close(s.holdFetch)
+	wg.Wait()
+	s.Equal(int32(2), s.fetches.Load(), "the held key set should be fetched once")
+}
+
+func (s *TokenVerifyTestSuite) TestFetchError() {
+	s.failFetch.Store(true)
+	client := s.newClient()
+
+	_, err := client.Authentication.verifyIdToken(context.Background(), s.idToken(s.tokenAud, nil))
+	s.ErrorContains(err, "error fetching JWKS")
+	s.NotErrorIs(err, ErrInvalidToken)
+}
+
+func (s *TokenVerifyTestSuite) TestSetSessionVerified() {
+	client := s.newClient()
+	client.SetSession(APISession{
+		Token:      "forged-access",
+		IdToken:    s.signWith(jwt.SigningMethodRS256, s.otherKey, testSigningKeyID),
+		Expiration: time.Now().Add(time.Hour),
+	})
+
+	_, err := client.Dataset.Get(context.Background(), "N:dataset:1")
+	s.ErrorIs(err, ErrInvalidToken)
+	s.ErrorContains(err, "rejecting session")
+	s.Equal(int32(0), s.apiHits.Load(), "a rejected session must not be sent")
+
+	client.SetSession(APISession{
+		Token:      "access",
+		IdToken:    s.idToken(s.tokenAud, nil),
+		Expiration: time.Now().Add(time.Hour),
+	})
+	ds, err := client.Dataset.Get(context.Background(), "N:dataset:1")
+	s.Require().NoError(err)
+	s.Equal("Verified", ds.Content.Name)
+}
+
+// accessToken returns a valid TokenPool access token with claims overriding
+// the defaults.
+func (s *TokenVerifyTestSuite) accessToken(claims map[string]any) string {
+	all := map[string]any{
+		"iss":       s.issuerBase + "/" + expectedCognitoConfig.TokenPool.ID,
+		"client_id": s.tokenAud,
+		"token_use": "access",
+	}
+	for k, v := range claims {
+		all[k] = v
+	}
+	return NewTestJWTWithClaims(s.T(), all, time.Hour)
+}
+
+func (s *TokenVerifyTestSuite) TestSetSessionWithoutIdToken() {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":       s.issuerBase + "/" + expectedCognitoConfig.TokenPool.ID,
+		"client_id": s.tokenAud,
+		"exp":       time.Now().Add(time.Hour).Unix(),
+		"token_use": "access",
+	})
+	token.Header["kid"] = testSigningKeyID
+	forged, err := token.SignedString(s.otherKey)
+	s.Require().NoError(err)
+
+	for name, test := range map[string]struct {
+		token string
+		claim string
+	}{
+		"opaque":         {token: "forged-access"},
+		"ID token":       {token: s.idToken(s.tokenAud, nil), claim: "client_id"},
+		"unknown client": {token: s.accessToken(map[string]any{"client_id": "someone-elses-client"}), claim: "client_id"},
+		"other pool":     {token: s.accessToken(map[string]any{"iss": s.issuerBase + "/" + expectedCognitoConfig.UserPool.ID}), claim: "iss"},
+		"wrong use":      {token: s.accessToken(map[string]any{"token_use": "id"}), claim: "token_use"},
+		"other key":      {token: forged},
+	} {
+		s.Run(name, func() {
+			client := s.newClient()
+			client.SetSession(APISession{Token: test.token, Expiration: time.Now().Add(time.Hour)})
+
+			_, err := client.Dataset.Get(context.Background(), "N:dataset:1")
+			s.ErrorIs(err, ErrInvalidToken)
+			s.ErrorContains(err, "rejecting session")
+			var tokenErr *TokenError
+			if s.ErrorAs(err, &tokenErr) {
+				s.Equal(test.claim, tokenErr.Claim)
+			}
+		})
+	}
+	s.Equal(int32(0), s.apiHits.Load(), "a rejected session must not be sent")
+
+	client := s.newClient()
+	client.SetSession(APISession{Token: s.accessToken(nil), Expiration: time.Now().Add(time.Hour)})
+	ds, err := client.Dataset.Get(context.Background(), "N:dataset:1")
+	s.Require().NoError(err)
+	s.Equal("Verified", ds.Content.Name)
+}
+
+func (s *TokenVerifyTestSuite) TestWithJWKS() {
+	client := s.newClient(WithJWKS(testJWKS))
+
+	_, err := client.Authentication.verifyIdToken(context.Background(), s.idToken(s.tokenAud, nil))
+	s.NoError(err)
+	_, err = client.Authentication.verifyIdToken(context.Background(), s.signWith(jwt.SigningMethodRS256, s.otherKey, testSigningKeyID))
+	s.ErrorIs(err, ErrInvalidToken)
+	_, err = client.Authentication.verifyIdToken(context.Background(), s.idToken("someone-elses-client", nil))
+	s.ErrorIs(err, ErrInvalidToken, "claims are checked in test mode too")
+	s.Equal(int32(0), s.fetches.Load())
+}
+
+func TestTokenVerifySuite(t *testing.T) {
+	suite.Run(t, new(TokenVerifyTestSuite))
+}
+
+func TestJWKSRoundTrip(t *testing.T) {
+	data, err := json.Marshal(testJWKS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, err := ParseJWKS(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !set.keys[testSigningKeyID].Equal(&testSigningKey.PublicKey) {
+		t.Errorf("key %q did not round-trip", testSigningKeyID)
+	}
+
+	set, err = ParseJWKS([]byte(`{"keys": [{"kid": "ec", "kty": "EC", "crv": "P-256"}, {"kid": "enc", "kty": "RSA", "use": "enc", "n": "AQAB", "e": "AQAB"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set.keys) != 0 {
+		t.Errorf("only RSA signing keys should be kept, got %v", set.keys)
+	}
+
+	if _, err := ParseJWKS([]byte(`{"keys": [{"kid": "bad", "kty": "RSA", "n": "!", "e": "AQAB"}]}`)); err == nil {
+		t.Error("an invalid key should be an error")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/pennsieve/pennsieve-go/pkg/pennsieve/models/user"
 	"net/http"
+	"sync"
 )
 
 type UserService interface {
@@ -16,6 +17,7 @@ type UserService interface {
 type userService struct {
 	client  PennsieveHTTPClient
 	BaseUrl string
+	mu      sync.RWMutex // guards BaseUrl
 }
 
 func NewUserService(client PennsieveHTTPClient, baseUrl string) *userService {
@@ -26,7 +28,7 @@ func NewUserService(client PennsieveHTTPClient, baseUrl string) *userService {
 }
 
 func (s *userService) GetUser(ctx context.Context) (*user.User, error) {
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/user", s.BaseUrl), nil)
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/user", s.host()), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -46,7 +48,7 @@ func (s *userService) GetUser(ctx context.Context) (*user.User, error) {
 // SwitchOrganization switches the user's active organization server-side.
 func (s *userService) SwitchOrganization(ctx context.Context, organizationId string) error {
 	req, err := http.NewRequest("PUT",
-		fmt.Sprintf("%s/session/switch-organization?organization_id=%s", s.BaseUrl, organizationId), nil)
+		fmt.Sprintf("%s/session/switch-organization?organization_id=%s", s.host(), organizationId), nil)
 	if err != nil {
 		return err
 	}
@@ -55,7 +57,7 @@ func (s *userService) SwitchOrganization(ctx context.Context, organizationId str
 		ctx = req.Context()
 	}
 
-	if err := s.client.sendRequest(ctx, req, nil); err != nil {
+	if err := s.client.sendRequest(withMutation(ctx, "UserService.SwitchOrganization", nil), req, nil); err != nil {
 		return err
 	}
 
@@ -63,5 +65,13 @@ func (s *userService) SwitchOrganization(ctx context.Context, organizationId str
 }
 
 func (s *userService) SetBaseUrl(url string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.BaseUrl = url
 }
+
+func (s *userService) host() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.BaseUrl
+}